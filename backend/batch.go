@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+// maxBatchGoals bounds how many goals one EXECUTE_TASKS message may queue
+const maxBatchGoals = 100
+
+// ExecuteTasksPayload runs Goals one after another, each as its own task,
+// without waiting for the user in between. The option fields behave as in
+// ExecuteTaskPayload and apply to every goal.
+type ExecuteTasksPayload struct {
+	Goals []string `json:"goals"`
+	// StopOnFailure skips the remaining goals once one fails, is cancelled
+	// or can't be parsed
+	StopOnFailure    bool `json:"stopOnFailure"`
+	DefaultTimeoutMs int  `json:"defaultTimeoutMs,omitempty"`
+	MaxRetries       int  `json:"maxRetries,omitempty"`
+	RetryBackoffMs   int  `json:"retryBackoffMs,omitempty"`
+}
+
+// BatchGoalResult is the outcome of one goal of a batch
+type BatchGoalResult struct {
+	Goal   string `json:"goal"`
+	TaskID string `json:"taskId,omitempty"`
+	// Status is the task's final status, or "rejected" when no task could
+	// be started for the goal and "skipped" when an earlier goal failed
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// BatchCompletePayload summarizes a batch once every goal has finished or
+// been skipped
+type BatchCompletePayload struct {
+	Message    string            `json:"message"`
+	Completed  int               `json:"completed"`
+	Failed     int               `json:"failed"`
+	Skipped    int               `json:"skipped"`
+	DurationMs int64             `json:"durationMs"`
+	Results    []BatchGoalResult `json:"results"`
+}
+
+// taskBatch tracks a running EXECUTE_TASKS. Goals run strictly one at a
+// time: the next is parsed and started from the previous task's onFinish.
+type taskBatch struct {
+	payload   ExecuteTasksPayload
+	results   []BatchGoalResult
+	startedAt time.Time
+}
+
+func handleExecuteTasks(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var batchPayload ExecuteTasksPayload
+	if err := json.Unmarshal(payloadBytes, &batchPayload); err != nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Invalid batch payload format",
+				Code:    "TASK_FORMAT_ERROR",
+			},
+		})
+	}
+
+	_, err = beginBatch(session, batchPayload)
+	return err
+}
+
+// beginBatch validates batchPayload and starts its first goal, reporting
+// whether the batch was accepted; rejections are sent to the client
+func beginBatch(session *Session, batchPayload ExecuteTasksPayload) (bool, error) {
+	goals := make([]string, 0, len(batchPayload.Goals))
+	for _, goal := range batchPayload.Goals {
+		if goal = strings.TrimSpace(goal); goal != "" {
+			goals = append(goals, goal)
+		}
+	}
+	if len(goals) == 0 || len(goals) > maxBatchGoals {
+		return false, session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: fmt.Sprintf("A batch needs between 1 and %d goals", maxBatchGoals),
+				Code:    "TASK_FORMAT_ERROR",
+			},
+		})
+	}
+	batchPayload.Goals = goals
+
+	if shuttingDown.Load() {
+		return false, session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Server is shutting down",
+				Code:    "SERVER_SHUTTING_DOWN",
+			},
+		})
+	}
+
+	batch := &taskBatch{payload: batchPayload, startedAt: time.Now()}
+	if !session.startBatch(batch) {
+		return false, session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Another goal or batch is still running on this connection",
+				Code:    "BATCH_IN_PROGRESS",
+			},
+		})
+	}
+
+	session.logger.Info("Starting batch", "goals", len(goals), "stop_on_failure", batchPayload.StopOnFailure)
+	return true, runNextBatchGoal(session, batch)
+}
+
+// runNextBatchGoal starts the next goal that parses into a valid task, or
+// sends BATCH_COMPLETE when none is left. Once the connection has closed
+// the remaining goals are skipped.
+func runNextBatchGoal(session *Session, batch *taskBatch) error {
+	for len(batch.results) < len(batch.payload.Goals) {
+		goal := batch.payload.Goals[len(batch.results)]
+
+		if batch.stopped() || shuttingDown.Load() || !session.startBatchPlanning(batch) {
+			batch.results = append(batch.results, BatchGoalResult{Goal: goal, Status: "skipped"})
+			continue
+		}
+
+		started, err := startBatchGoal(session, batch, goal)
+		session.endPlanning()
+		if err != nil {
+			session.endBatch()
+			return err
+		}
+		if started {
+			return nil
+		}
+	}
+
+	return finishBatch(session, batch)
+}
+
+// startBatchGoal plans goal and starts it as the batch's next task, holding
+// the session's planning reservation. It records the goal as rejected and
+// reports false when no task could be started.
+func startBatchGoal(session *Session, batch *taskBatch, goal string) (bool, error) {
+	session.logger.Info("Processing batch goal", "goal", goal, "index", len(batch.results))
+	sequence := parseGoalToSequence(session.logger, goal, session.getPageContext(), session.getConversationHistory(), llmProgressReporter(session, goal))
+	if sequence == nil || len(sequence.Commands) == 0 {
+		batch.results = append(batch.results, BatchGoalResult{Goal: goal, Status: "rejected", Error: "Could not understand the goal"})
+		return false, nil
+	}
+
+	session.addConversationTurns(
+		llm.ConversationTurn{Role: "user", Content: goal},
+		llm.ConversationTurn{Role: "assistant", Content: describeSequence(sequence)},
+	)
+
+	task, err := startTask(session, ExecuteTaskPayload{
+		Goal:             goal,
+		DefaultTimeoutMs: batch.payload.DefaultTimeoutMs,
+		MaxRetries:       batch.payload.MaxRetries,
+		RetryBackoffMs:   batch.payload.RetryBackoffMs,
+	}, sequence, func(task *TaskState) {
+		// Called under stepMu while the task's final message is being
+		// sent, so the next goal starts from its own goroutine
+		go batch.taskFinished(session, task)
+	})
+	if err != nil {
+		return false, err
+	}
+	if task == nil {
+		// startTask already sent the reason as an ERROR
+		batch.results = append(batch.results, BatchGoalResult{Goal: goal, Status: "rejected", Error: "Goal was rejected"})
+		return false, nil
+	}
+	return true, nil
+}
+
+// taskFinished records a batch task's outcome and moves on to the next goal
+func (b *taskBatch) taskFinished(session *Session, task *TaskState) {
+	session.stepMu.Lock()
+	result := BatchGoalResult{Goal: task.Goal, TaskID: task.TaskID, Status: task.Status, DurationMs: task.DurationMs}
+	if n := len(task.Results); n > 0 && !task.Results[n-1].Success {
+		result.Error = task.Results[n-1].Error
+	}
+	session.stepMu.Unlock()
+
+	b.results = append(b.results, result)
+	if err := runNextBatchGoal(session, b); err != nil {
+		session.logger.Error("Failed to continue batch", "error", err)
+	}
+}
+
+// stopped reports whether StopOnFailure applies to a goal that didn't complete
+func (b *taskBatch) stopped() bool {
+	if !b.payload.StopOnFailure {
+		return false
+	}
+	for _, result := range b.results {
+		if result.Status != "completed" {
+			return true
+		}
+	}
+	return false
+}
+
+func finishBatch(session *Session, batch *taskBatch) error {
+	session.endBatch()
+	if session.isClosed() {
+		session.logger.Info("Batch stopped, connection closed", "goals", len(batch.payload.Goals))
+		return nil
+	}
+
+	summary := BatchCompletePayload{
+		DurationMs: time.Since(batch.startedAt).Milliseconds(),
+		Results:    batch.results,
+	}
+	for _, result := range batch.results {
+		switch result.Status {
+		case "completed":
+			summary.Completed++
+		case "skipped":
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	summary.Message = fmt.Sprintf("Batch finished: %d completed, %d failed, %d skipped", summary.Completed, summary.Failed, summary.Skipped)
+	session.logger.Info("Batch finished", "completed", summary.Completed, "failed", summary.Failed, "skipped", summary.Skipped)
+
+	return session.send(&Message{
+		Type:    "BATCH_COMPLETE",
+		Payload: summary,
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchRunsGoalsInOrder(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go to github.com", "  ", "scroll down"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+
+	for _, want := range []string{"navigate", "scroll"} {
+		var command CommandPayload
+		readUntil(t, client, "COMMAND", &command)
+		if command.Action != want {
+			t.Fatalf("command = %+v, want %s", command, want)
+		}
+		handleCommandComplete(session, CommandResult{Action: command.Action, Success: true})
+		readUntil(t, client, "TASK_COMPLETE", nil)
+	}
+
+	var summary BatchCompletePayload
+	readUntil(t, client, "BATCH_COMPLETE", &summary)
+	if summary.Completed != 2 || summary.Failed != 0 || summary.Skipped != 0 || len(summary.Results) != 2 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if summary.Results[0].Goal != "go to github.com" || summary.Results[1].Goal != "scroll down" || summary.Results[1].TaskID == "" {
+		t.Errorf("results = %+v", summary.Results)
+	}
+	if session.busy() {
+		t.Error("session still busy after the batch")
+	}
+}
+
+func TestBatchStopsOnFailure(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	err := handleExecuteTasks(session, ExecuteTasksPayload{
+		Goals:          []string{"go to github.com", "scroll down", "go back"},
+		StopOnFailure:  true,
+		MaxRetries:     1,
+		RetryBackoffMs: 10,
+	})
+	if err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "navigate", Success: false, Error: "net::ERR_NAME_NOT_RESOLVED"})
+	readUntil(t, client, "COMMAND_RETRY", nil)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "navigate", Success: false, Error: "net::ERR_NAME_NOT_RESOLVED"})
+	readUntil(t, client, "TASK_FAILED", nil)
+
+	var summary BatchCompletePayload
+	readUntil(t, client, "BATCH_COMPLETE", &summary)
+	if summary.Completed != 0 || summary.Failed != 1 || summary.Skipped != 2 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if first := summary.Results[0]; first.Status != "failed" || first.Error != "net::ERR_NAME_NOT_RESOLVED" {
+		t.Errorf("first result = %+v", first)
+	}
+}
+
+func TestBatchKeepsGoingAfterRejectedGoal(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"xyzzy plugh", "go back"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.Action != "back" {
+		t.Fatalf("command = %+v, want the second goal's back", command)
+	}
+	handleCommandComplete(session, CommandResult{Action: "back", Success: true})
+
+	var summary BatchCompletePayload
+	readUntil(t, client, "BATCH_COMPLETE", &summary)
+	if summary.Completed != 1 || summary.Failed != 1 || summary.Results[0].Status != "rejected" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestSecondBatchIsRefused(t *testing.T) {
+	session, client := newTestSession(t)
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go to github.com"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	readUntil(t, client, "COMMAND", nil)
+
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go back"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "BATCH_IN_PROGRESS" {
+		t.Errorf("second batch got %+v, want BATCH_IN_PROGRESS", refusal)
+	}
+
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{" "}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "TASK_FORMAT_ERROR" {
+		t.Errorf("empty batch got %+v, want TASK_FORMAT_ERROR", refusal)
+	}
+}
+
+func TestExecuteTaskDuringBatchIsRefused(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go to github.com", "go back"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	readUntil(t, client, "COMMAND", nil)
+
+	if err := handleExecuteTaskWithCompletion(session, ExecuteTaskPayload{Goal: "scroll down"}); err != nil {
+		t.Fatalf("handleExecuteTaskWithCompletion: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "GOAL_IN_PROGRESS" {
+		t.Errorf("goal during batch got %+v, want GOAL_IN_PROGRESS", refusal)
+	}
+
+	// The batch carries on with its own goals only
+	handleCommandComplete(session, CommandResult{Action: "navigate", Success: true})
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.Action != "back" {
+		t.Errorf("next command = %+v, want the batch's back", command)
+	}
+}
+
+func TestBatchDuringTaskIsRefused(t *testing.T) {
+	session, client := newTestSession(t)
+	if err := handleExecuteTaskWithCompletion(session, ExecuteTaskPayload{Goal: "go to github.com"}); err != nil {
+		t.Fatalf("handleExecuteTaskWithCompletion: %v", err)
+	}
+	readUntil(t, client, "COMMAND", nil)
+
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go back"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "BATCH_IN_PROGRESS" || session.countTasks() != 1 {
+		t.Errorf("batch during task got %+v with %d tasks, want BATCH_IN_PROGRESS", refusal, session.countTasks())
+	}
+}
+
+func TestBatchStopsWhenConnectionCloses(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	if err := handleExecuteTasks(session, ExecuteTasksPayload{Goals: []string{"go to github.com", "go back"}}); err != nil {
+		t.Fatalf("handleExecuteTasks: %v", err)
+	}
+	readUntil(t, client, "COMMAND", nil)
+
+	registry.Unregister(session)
+	handleCommandComplete(session, CommandResult{Action: "navigate", Success: true})
+	readUntil(t, client, "TASK_COMPLETE", nil)
+
+	deadline := time.Now().Add(time.Second)
+	for session.busy() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if session.busy() || session.countTasks() != 0 {
+		t.Fatalf("session still busy after its connection closed")
+	}
+	if n := countMessages(client, 100*time.Millisecond)["COMMAND"]; n != 0 {
+		t.Errorf("batch sent %d more commands after the connection closed", n)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+var addrFlag = flag.String("addr", "", "Listen address, e.g. :8080 or 127.0.0.1:9000 (default :8080, or :$PORT)")
+var allowedOriginsFlag = flag.String("allowed-origins", "", "Comma-separated list of allowed WebSocket origins; a bare scheme like chrome-extension:// matches any origin with it (* allows all)")
+var tlsCertFlag = flag.String("tls-cert", "", "TLS certificate file; with -tls-key, serves wss://")
+var tlsKeyFlag = flag.String("tls-key", "", "TLS private key file; with -tls-cert, serves wss://")
+var generateSelfSignedFlag = flag.Bool("generate-self-signed", false, "Generate a self-signed localhost certificate on first run and serve wss://")
+var navDelayFlag = flag.Duration("nav-delay", 0, "Extra pause after a page-changing step, on top of waiting for the page to load (or set NAV_DELAY)")
+var stepDelayFlag = flag.Duration("step-delay", 500*time.Millisecond, "Pause between other steps (or set STEP_DELAY)")
+var commandTimeoutFlag = flag.Duration("command-timeout", 30*time.Second, "How long to wait for COMMAND_COMPLETE before a step counts as failed")
+var maxRetriesFlag = flag.Int("max-retries", 0, "Default number of times a failed step is retried before the task fails")
+var historyFileFlag = flag.String("history-file", "", "Append each finished task, with its sequence and results, to this JSON lines file and serve them at GET /api/history (loopback only unless a token is set)")
+var taskLogFlag = flag.String("task-log", "", "Append task state transitions to this JSON lines file and serve them at GET /tasks (loopback only unless a token is set)")
+var llmCacheSizeFlag = flag.Int("llm-cache-size", 128, "Maximum number of parsed goals kept in the LLM response cache (0 disables)")
+var llmCacheTTLFlag = flag.Duration("llm-cache-ttl", 10*time.Minute, "How long a cached LLM parse stays valid")
+var llmTokenBudgetFlag = flag.Int("llm-token-budget", 0, "Stop sending LLM requests once this many prompt plus completion tokens have been used (0 means unlimited)")
+var authTokenFlag = flag.String("auth-token", "", "Require this bearer token on WebSocket upgrades (or set CORTEX_AUTH_TOKEN)")
+var pingIntervalFlag = flag.Duration("ping-interval", 30*time.Second, "How often to ping WebSocket clients")
+var readTimeoutFlag = flag.Duration("read-timeout", 60*time.Second, "Close a WebSocket connection after this long without a pong or message (must exceed -ping-interval)")
+var shutdownGraceFlag = flag.Duration("shutdown-grace", 10*time.Second, "How long active tasks get to finish after SIGINT/SIGTERM before they are cancelled")
+var logLevelFlag = flag.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+var logFormatFlag = flag.String("log-format", "text", "Log output format: text or json")
+
+const defaultAddr = ":8080"
+
+// resolveAddr picks the listen address: -addr flag, then PORT env, then :8080
+func resolveAddr(flagAddr, envPort string) string {
+	if flagAddr != "" {
+		return flagAddr
+	}
+	if envPort != "" {
+		return ":" + strings.TrimPrefix(envPort, ":")
+	}
+	return defaultAddr
+}
+
+// displayHost turns a listen address into something usable in a URL
+func displayHost(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
+
+const defaultAllowedOrigins = "chrome-extension://"
+
+var allowedOrigins []string
+var authToken string
+
+// resolveAllowedOrigins picks the origin allowlist from the flag, then the
+// ALLOWED_ORIGINS env var, and finally defaults to Chrome extensions only
+func resolveAllowedOrigins() []string {
+	raw := *allowedOriginsFlag
+	if raw == "" {
+		raw = os.Getenv("ALLOWED_ORIGINS")
+	}
+	if raw == "" {
+		raw = defaultAllowedOrigins
+	}
+	return parseAllowedOrigins(raw)
+}
+
+// resolveAuthToken picks the bearer token from the flag, then CORTEX_AUTH_TOKEN
+func resolveAuthToken() string {
+	if *authTokenFlag != "" {
+		return *authTokenFlag
+	}
+	return os.Getenv("CORTEX_AUTH_TOKEN")
+}
+
+func parseAllowedOrigins(raw string) []string {
+	origins := []string{}
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimSpace(origin)
+		// Keep the "://" on bare-scheme entries so they still match as a prefix
+		if !strings.HasSuffix(origin, "://") {
+			origin = strings.TrimSuffix(origin, "/")
+		}
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func isOriginAllowed(origin string, allowed []string) bool {
+	origin = strings.TrimSuffix(origin, "/")
+	for _, candidate := range allowed {
+		if candidate == "*" || strings.EqualFold(candidate, origin) {
+			return true
+		}
+		// "chrome-extension://" style entries match the whole scheme
+		if strings.HasSuffix(candidate, "://") && strings.HasPrefix(strings.ToLower(origin), strings.ToLower(candidate)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is used by the WebSocket upgrader; a false return makes the
+// upgrade fail with 403 Forbidden
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Non-browser clients don't send an Origin header
+		return true
+	}
+
+	if isOriginAllowed(origin, allowedOrigins) {
+		return true
+	}
+
+	slog.Warn("Rejected WebSocket connection", "origin", origin)
+	return false
+}
+
+// requestToken extracts the bearer token from the Authorization header, or
+// from the token query parameter since browser WebSocket APIs can't set headers
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authorizeUpgrade validates origin and token before the WebSocket upgrade
+func authorizeUpgrade(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" && !isOriginAllowed(origin, allowedOrigins) {
+		return errors.New("origin not allowed: " + origin)
+	}
+
+	if authToken == "" {
+		return nil
+	}
+
+	token := requestToken(r)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+		return errors.New("missing or invalid bearer token")
+	}
+	return nil
+}
+
+// authorizeRead checks a request for the task log or history. Without a
+// token these would be readable by anyone who can reach the listen
+// address, so they are then only served to loopback clients.
+func authorizeRead(r *http.Request) error {
+	if err := authorizeUpgrade(r); err != nil {
+		return err
+	}
+	if authToken != "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return errors.New("set an auth token to read this from another host")
+	}
+	return nil
+}
+
+// resolveStepDelays applies NAV_DELAY and STEP_DELAY to -nav-delay and
+// -step-delay when those flags were not given on the command line
+func resolveStepDelays() {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, env := range map[string]string{"nav-delay": "NAV_DELAY", "step-delay": "STEP_DELAY"} {
+		raw := os.Getenv(env)
+		if raw == "" || explicit[name] {
+			continue
+		}
+		if d, err := time.ParseDuration(raw); err != nil || d < 0 {
+			slog.Warn("Ignoring invalid "+env, "value", raw)
+			continue
+		}
+		flag.Set(name, raw)
+	}
+}
+
+// resolveLLMModels reads the comma-separated fallback chain in LLM_MODELS,
+// falling back to the single LLM_MODEL and then defaultModel
+func resolveLLMModels(defaultModel string) []string {
+	raw := os.Getenv("LLM_MODELS")
+	if strings.TrimSpace(raw) == "" {
+		raw = os.Getenv("LLM_MODEL")
+	}
+
+	models := []string{}
+	for _, model := range strings.Split(raw, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	if len(models) == 0 {
+		models = append(models, defaultModel)
+	}
+	return models
+}
+
+// resolveLLMOptions reads LLM_TEMPERATURE, LLM_TOP_P and LLM_SEED into
+// Ollama model options, leaving out any that are unset or invalid
+func resolveLLMOptions() map[string]interface{} {
+	options := map[string]interface{}{}
+
+	if raw := os.Getenv("LLM_TEMPERATURE"); raw != "" {
+		if temperature, err := strconv.ParseFloat(raw, 64); err == nil && temperature >= 0 && temperature <= 2 {
+			options["temperature"] = temperature
+		} else {
+			slog.Warn("Ignoring invalid LLM_TEMPERATURE", "value", raw)
+		}
+	}
+
+	if raw := os.Getenv("LLM_TOP_P"); raw != "" {
+		if topP, err := strconv.ParseFloat(raw, 64); err == nil && topP > 0 && topP <= 1 {
+			options["top_p"] = topP
+		} else {
+			slog.Warn("Ignoring invalid LLM_TOP_P", "value", raw)
+		}
+	}
+
+	if raw := os.Getenv("LLM_SEED"); raw != "" {
+		if seed, err := strconv.Atoi(raw); err == nil {
+			options["seed"] = seed
+		} else {
+			slog.Warn("Ignoring invalid LLM_SEED", "value", raw)
+		}
+	}
+
+	return options
+}
+
+// resolveLLMConfig overlays LLM_CONFIDENCE_THRESHOLD, LLM_MIN_CONFIDENCE and
+// LLM_MIN_GOAL_LENGTH on the llm package defaults
+func resolveLLMConfig() llm.Config {
+	config := llm.DefaultConfig()
+
+	if raw := os.Getenv("LLM_CONFIDENCE_THRESHOLD"); raw != "" {
+		if threshold, err := strconv.ParseFloat(raw, 64); err == nil && threshold >= 0 && threshold <= 1 {
+			config.LLMConfidenceThreshold = threshold
+		} else {
+			slog.Warn("Ignoring invalid LLM_CONFIDENCE_THRESHOLD", "value", raw)
+		}
+	}
+
+	if raw := os.Getenv("LLM_MIN_CONFIDENCE"); raw != "" {
+		if confidence, err := strconv.ParseFloat(raw, 64); err == nil && confidence >= 0 && confidence <= 1 {
+			config.MinLLMConfidence = confidence
+		} else {
+			slog.Warn("Ignoring invalid LLM_MIN_CONFIDENCE", "value", raw)
+		}
+	}
+
+	if raw := os.Getenv("LLM_MIN_GOAL_LENGTH"); raw != "" {
+		if length, err := strconv.Atoi(raw); err == nil && length > 0 {
+			config.MinGoalLengthForLLM = length
+		} else {
+			slog.Warn("Ignoring invalid LLM_MIN_GOAL_LENGTH", "value", raw)
+		}
+	}
+
+	return config
+}
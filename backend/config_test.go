@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/gorilla/websocket"
+)
+
+// withAllowedOrigins sets the origin allowlist for the rest of the test
+func withAllowedOrigins(t *testing.T, origins ...string) {
+	t.Helper()
+	previous := allowedOrigins
+	allowedOrigins = origins
+	t.Cleanup(func() { allowedOrigins = previous })
+}
+
+func TestParseAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"chrome-extension://", []string{"chrome-extension://"}},
+		{" https://a.example/ , http://localhost:3000 ,,", []string{"https://a.example", "http://localhost:3000"}},
+		{"*", []string{"*"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseAllowedOrigins(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAllowedOrigins(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestIsOriginAllowed(t *testing.T) {
+	allowed := parseAllowedOrigins("chrome-extension://, https://app.example")
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"chrome-extension://abcdefghijklmnop", true},
+		{"CHROME-EXTENSION://abcdefghijklmnop", true},
+		{"https://app.example", true},
+		{"https://APP.example/", true},
+		{"https://app.example.evil.com", false},
+		{"http://app.example", false},
+		{"moz-extension://abc", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		if got := isOriginAllowed(tt.origin, allowed); got != tt.want {
+			t.Errorf("isOriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !isOriginAllowed("https://anything.example", []string{"*"}) {
+		t.Error("* did not allow every origin")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	withAllowedOrigins(t, "chrome-extension://")
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"chrome-extension://abc", true},
+		{"https://evil.example", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := checkOrigin(req); got != tt.want {
+			t.Errorf("checkOrigin with Origin %q = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	tests := []struct {
+		flagAddr, envPort, want string
+	}{
+		{"", "", ":8080"},
+		{"", "9000", ":9000"},
+		{"", ":9000", ":9000"},
+		{"127.0.0.1:7000", "9000", "127.0.0.1:7000"},
+	}
+	for _, tt := range tests {
+		if got := resolveAddr(tt.flagAddr, tt.envPort); got != tt.want {
+			t.Errorf("resolveAddr(%q, %q) = %q, want %q", tt.flagAddr, tt.envPort, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayHost(t *testing.T) {
+	if got := displayHost(":8080"); got != "localhost:8080" {
+		t.Errorf("displayHost(:8080) = %q", got)
+	}
+	if got := displayHost("0.0.0.0:9000"); got != "0.0.0.0:9000" {
+		t.Errorf("displayHost(0.0.0.0:9000) = %q", got)
+	}
+}
+
+func TestAuthorizeUpgrade(t *testing.T) {
+	withAllowedOrigins(t, "chrome-extension://")
+	withAuthToken(t, "s3cret")
+
+	tests := []struct {
+		name   string
+		target string
+		origin string
+		header string
+		ok     bool
+	}{
+		{"bearer header", "/ws", "chrome-extension://abc", "Bearer s3cret", true},
+		{"query token", "/ws?token=s3cret", "", "", true},
+		{"no token", "/ws", "chrome-extension://abc", "", false},
+		{"wrong token", "/ws?token=guess", "", "", false},
+		{"token prefix", "/ws", "", "Bearer s3cre", false},
+		{"disallowed origin", "/ws?token=s3cret", "https://evil.example", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if err := authorizeUpgrade(req); (err == nil) != tt.ok {
+				t.Errorf("authorizeUpgrade = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUpgradeWithoutTokenIsRejected(t *testing.T) {
+	withAuthToken(t, "s3cret")
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without token: err %v, response %v", err, resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=s3cret", nil)
+	if err != nil {
+		t.Fatalf("dial with token: %v", err)
+	}
+	conn.Close()
+}
+
+func TestResolveLLMOptions(t *testing.T) {
+	t.Setenv("LLM_TEMPERATURE", "0.3")
+	t.Setenv("LLM_TOP_P", "1.5")
+	t.Setenv("LLM_SEED", "7")
+
+	want := map[string]interface{}{"temperature": 0.3, "seed": 7}
+	if options := resolveLLMOptions(); !reflect.DeepEqual(options, want) {
+		t.Errorf("options = %v, want %v without the out-of-range top_p", options, want)
+	}
+
+	t.Setenv("LLM_TEMPERATURE", "")
+	t.Setenv("LLM_TOP_P", "")
+	t.Setenv("LLM_SEED", "")
+	if options := resolveLLMOptions(); len(options) != 0 {
+		t.Errorf("options with nothing set = %v", options)
+	}
+}
+
+func TestResolveLLMModels(t *testing.T) {
+	tests := []struct {
+		models, model string
+		want          []string
+	}{
+		{"mistral:latest, llama3:8b,,", "phi3", []string{"mistral:latest", "llama3:8b"}},
+		{" ", "phi3", []string{"phi3"}},
+		{"", "", []string{llm.DefaultOllamaModel}},
+	}
+	for _, tt := range tests {
+		t.Setenv("LLM_MODELS", tt.models)
+		t.Setenv("LLM_MODEL", tt.model)
+		if got := resolveLLMModels(llm.DefaultOllamaModel); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LLM_MODELS=%q LLM_MODEL=%q: got %v, want %v", tt.models, tt.model, got, tt.want)
+		}
+	}
+}
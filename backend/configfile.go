@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var configFlag = flag.String("config", "", "YAML or JSON config file; environment variables and flags override its values")
+
+// Config mirrors every flag and environment variable so a deployment can be
+// described in one file. Unset fields leave the built-in default alone.
+type Config struct {
+	Addr               string   `json:"addr" yaml:"addr"`
+	Port               int      `json:"port" yaml:"port"`
+	AllowedOrigins     []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+	AuthToken          string   `json:"authToken" yaml:"authToken"`
+	TLSCert            string   `json:"tlsCert" yaml:"tlsCert"`
+	TLSKey             string   `json:"tlsKey" yaml:"tlsKey"`
+	GenerateSelfSigned *bool    `json:"generateSelfSigned" yaml:"generateSelfSigned"`
+	CommandTimeout     string   `json:"commandTimeout" yaml:"commandTimeout"`
+	NavDelay           string   `json:"navDelay" yaml:"navDelay"`
+	StepDelay          string   `json:"stepDelay" yaml:"stepDelay"`
+	ShutdownGrace      string   `json:"shutdownGrace" yaml:"shutdownGrace"`
+	PingInterval       string   `json:"pingInterval" yaml:"pingInterval"`
+	ReadTimeout        string   `json:"readTimeout" yaml:"readTimeout"`
+	MaxRetries         *int     `json:"maxRetries" yaml:"maxRetries"`
+	TaskLogPath        string   `json:"taskLogPath" yaml:"taskLogPath"`
+	HistoryFile        string   `json:"historyFile" yaml:"historyFile"`
+	SitesConfig        string   `json:"sitesConfig" yaml:"sitesConfig"`
+	TemplatesFile      string   `json:"templatesFile" yaml:"templatesFile"`
+	LogLevel           string   `json:"logLevel" yaml:"logLevel"`
+	LogFormat          string   `json:"logFormat" yaml:"logFormat"`
+
+	UseLLM                 *bool    `json:"useLLM" yaml:"useLLM"`
+	LLMProvider            string   `json:"llmProvider" yaml:"llmProvider"`
+	LLMModel               string   `json:"llmModel" yaml:"llmModel"`
+	LLMModels              []string `json:"llmModels" yaml:"llmModels"`
+	OllamaHost             string   `json:"ollamaHost" yaml:"ollamaHost"`
+	LLMBaseURL             string   `json:"llmBaseURL" yaml:"llmBaseURL"`
+	LLMAPIKey              string   `json:"llmAPIKey" yaml:"llmAPIKey"`
+	LLMCacheSize           *int     `json:"llmCacheSize" yaml:"llmCacheSize"`
+	LLMCacheTTL            string   `json:"llmCacheTTL" yaml:"llmCacheTTL"`
+	LLMTokenBudget         *int     `json:"llmTokenBudget" yaml:"llmTokenBudget"`
+	LLMConfidenceThreshold *float64 `json:"llmConfidenceThreshold" yaml:"llmConfidenceThreshold"`
+	LLMMinConfidence       *float64 `json:"llmMinConfidence" yaml:"llmMinConfidence"`
+	LLMMinGoalLength       *int     `json:"llmMinGoalLength" yaml:"llmMinGoalLength"`
+	LLMTemperature         *float64 `json:"llmTemperature" yaml:"llmTemperature"`
+	LLMTopP                *float64 `json:"llmTopP" yaml:"llmTopP"`
+	LLMSeed                *int     `json:"llmSeed" yaml:"llmSeed"`
+}
+
+// loadConfigFile parses path as YAML (.yaml/.yml) or JSON, rejecting unknown
+// fields, and validates the result
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("config %s: unsupported extension (use .yaml, .yml or .json)", path)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	var problems []string
+	invalid := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		invalid("port %d out of range 1-65535", c.Port)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		invalid("tlsCert and tlsKey must be set together")
+	}
+	checkDuration := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			invalid("%s %q is not a positive duration like 30s or 5m", field, value)
+		}
+	}
+	checkDuration("commandTimeout", c.CommandTimeout)
+	checkDuration("llmCacheTTL", c.LLMCacheTTL)
+	checkDuration("shutdownGrace", c.ShutdownGrace)
+	checkDuration("pingInterval", c.PingInterval)
+	checkDuration("readTimeout", c.ReadTimeout)
+	for field, value := range map[string]string{"navDelay": c.NavDelay, "stepDelay": c.StepDelay} {
+		if d, err := time.ParseDuration(value); value != "" && (err != nil || d < 0) {
+			invalid("%s %q is not a duration like 0s or 500ms", field, value)
+		}
+	}
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		invalid("maxRetries must not be negative")
+	}
+	if c.LLMCacheSize != nil && *c.LLMCacheSize < 0 {
+		invalid("llmCacheSize must not be negative")
+	}
+	if c.LLMTokenBudget != nil && *c.LLMTokenBudget < 0 {
+		invalid("llmTokenBudget must not be negative")
+	}
+	if c.LLMMinGoalLength != nil && *c.LLMMinGoalLength < 0 {
+		invalid("llmMinGoalLength must not be negative")
+	}
+	checkFraction := func(field string, value *float64) {
+		if value != nil && (*value < 0 || *value > 1) {
+			invalid("%s %v must be between 0 and 1", field, *value)
+		}
+	}
+	checkFraction("llmConfidenceThreshold", c.LLMConfidenceThreshold)
+	checkFraction("llmMinConfidence", c.LLMMinConfidence)
+	checkFraction("llmTopP", c.LLMTopP)
+	if c.LLMTemperature != nil && (*c.LLMTemperature < 0 || *c.LLMTemperature > 2) {
+		invalid("llmTemperature %v must be between 0 and 2", *c.LLMTemperature)
+	}
+	switch c.LLMProvider {
+	case "", "ollama", "openai":
+	default:
+		invalid("llmProvider %q must be ollama or openai", c.LLMProvider)
+	}
+	if c.LogLevel != "" || c.LogFormat != "" {
+		level, format := c.LogLevel, c.LogFormat
+		if level == "" {
+			level = "info"
+		}
+		if format == "" {
+			format = "text"
+		}
+		if _, err := newLogger(io.Discard, level, format); err != nil {
+			invalid("%v", err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// apply fills in every flag not given on the command line and every
+// environment variable not already set, so the usual flag > env > default
+// resolution sees file values as the lowest-priority source
+func (c *Config) apply() error {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	setFlag := func(name, value string) error {
+		if value == "" || explicit[name] {
+			return nil
+		}
+		return flag.Set(name, value)
+	}
+	setEnv := func(name, value string) error {
+		if value == "" {
+			return nil
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			return nil
+		}
+		return os.Setenv(name, value)
+	}
+
+	optionalBool := func(b *bool) string {
+		if b == nil {
+			return ""
+		}
+		return strconv.FormatBool(*b)
+	}
+	optionalInt := func(i *int) string {
+		if i == nil {
+			return ""
+		}
+		return strconv.Itoa(*i)
+	}
+	optionalFloat := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+	port := ""
+	if c.Port != 0 {
+		port = strconv.Itoa(c.Port)
+	}
+
+	return errors.Join(
+		setFlag("addr", c.Addr),
+		setFlag("tls-cert", c.TLSCert),
+		setFlag("tls-key", c.TLSKey),
+		setFlag("generate-self-signed", optionalBool(c.GenerateSelfSigned)),
+		setFlag("command-timeout", c.CommandTimeout),
+		setFlag("shutdown-grace", c.ShutdownGrace),
+		setFlag("ping-interval", c.PingInterval),
+		setFlag("read-timeout", c.ReadTimeout),
+		setFlag("max-retries", optionalInt(c.MaxRetries)),
+		setFlag("task-log", c.TaskLogPath),
+		setFlag("history-file", c.HistoryFile),
+		setFlag("sites-config", c.SitesConfig),
+		setFlag("templates-file", c.TemplatesFile),
+		setFlag("log-level", c.LogLevel),
+		setFlag("log-format", c.LogFormat),
+		setFlag("llm-cache-size", optionalInt(c.LLMCacheSize)),
+		setFlag("llm-cache-ttl", c.LLMCacheTTL),
+		setFlag("llm-token-budget", optionalInt(c.LLMTokenBudget)),
+
+		setEnv("PORT", port),
+		setEnv("ALLOWED_ORIGINS", strings.Join(c.AllowedOrigins, ",")),
+		setEnv("CORTEX_AUTH_TOKEN", c.AuthToken),
+		setEnv("NAV_DELAY", c.NavDelay),
+		setEnv("STEP_DELAY", c.StepDelay),
+		setEnv("USE_LLM", optionalBool(c.UseLLM)),
+		setEnv("LLM_PROVIDER", c.LLMProvider),
+		setEnv("LLM_MODEL", c.LLMModel),
+		setEnv("LLM_MODELS", strings.Join(c.LLMModels, ",")),
+		setEnv("OLLAMA_HOST", c.OllamaHost),
+		setEnv("OPENAI_BASE_URL", c.LLMBaseURL),
+		setEnv("OPENAI_API_KEY", c.LLMAPIKey),
+		setEnv("LLM_CONFIDENCE_THRESHOLD", optionalFloat(c.LLMConfidenceThreshold)),
+		setEnv("LLM_MIN_CONFIDENCE", optionalFloat(c.LLMMinConfidence)),
+		setEnv("LLM_MIN_GOAL_LENGTH", optionalInt(c.LLMMinGoalLength)),
+		setEnv("LLM_TEMPERATURE", optionalFloat(c.LLMTemperature)),
+		setEnv("LLM_TOP_P", optionalFloat(c.LLMTopP)),
+		setEnv("LLM_SEED", optionalInt(c.LLMSeed)),
+	)
+}
+
+// applyConfigFile loads -config, if given, beneath the environment and flags
+func applyConfigFile() error {
+	if *configFlag == "" {
+		return nil
+	}
+	config, err := loadConfigFile(*configFlag)
+	if err != nil {
+		return err
+	}
+	if err := config.apply(); err != nil {
+		return fmt.Errorf("config %s: %w", *configFlag, err)
+	}
+	return nil
+}
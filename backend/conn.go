@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// safeConn wraps a websocket.Conn so data frames are written one at a time.
+// gorilla/websocket allows a single concurrent writer, and a session writes
+// from its read loop, step timeout timers and shutdown. Reads and control
+// frames (ping, close) go straight to the embedded Conn, which supports them
+// concurrently.
+type safeConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+func newSafeConn(conn *websocket.Conn) *safeConn {
+	return &safeConn{Conn: conn}
+}
+
+// WriteMessage serializes data frame writes
+func (c *safeConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// closeWithReason sends a close frame after any in-flight write and closes
+// the connection
+func (c *safeConn) closeWithReason(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.Conn.Close()
+}
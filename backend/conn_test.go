@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSendsArriveIntact(t *testing.T) {
+	session, client := newTestSession(t)
+
+	const writers, perWriter = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				message := &Message{Type: "TASK_PROGRESS", Payload: TaskProgressPayload{Partial: fmt.Sprintf("%d-%d", w, i)}}
+				if err := session.send(message); err != nil {
+					t.Errorf("send: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	seen := map[string]bool{}
+	for len(seen) < writers*perWriter {
+		msgType, payload := readMessage(t, client)
+		var progress TaskProgressPayload
+		if msgType != "TASK_PROGRESS" || json.Unmarshal(payload, &progress) != nil {
+			t.Fatalf("corrupted message %s: %s", msgType, payload)
+		}
+		seen[progress.Partial] = true
+	}
+	wg.Wait()
+}
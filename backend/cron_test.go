@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronFields(t *testing.T) {
+	tests := []struct {
+		expr   string
+		minute []int
+		hour   []int
+		dom    []int
+		month  []int
+		dow    []int
+	}{
+		{"5 4 * * *", []int{5}, []int{4}, nil, nil, nil},
+		{"*/15 9-17 * * 1-5", []int{0, 15, 30, 45}, []int{9, 10, 11, 12, 13, 14, 15, 16, 17}, nil, nil, []int{1, 2, 3, 4, 5}},
+		{"0,30 8,20 1,15 * *", []int{0, 30}, []int{8, 20}, []int{1, 15}, nil, nil},
+		{"5/20 0 * * *", []int{5, 25, 45}, []int{0}, nil, nil, nil},
+		{"0 0 1-10/3 * *", []int{0}, []int{0}, []int{1, 4, 7, 10}, nil, nil},
+		{"0 12 * jan,Jul mon-wed", []int{0}, []int{12}, nil, []int{1, 7}, []int{1, 2, 3}},
+		{"0 0 * * 7", []int{0}, []int{0}, nil, nil, []int{0, 7}},
+		{"@hourly", []int{0}, nil, nil, nil, nil},
+		{"@WEEKLY", []int{0}, []int{0}, nil, nil, []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseCron(tt.expr, time.UTC)
+			if err != nil {
+				t.Fatalf("parseCron: %v", err)
+			}
+			checkBits(t, "minute", s.minute, tt.minute, 0, 59)
+			checkBits(t, "hour", s.hour, tt.hour, 0, 23)
+			checkBits(t, "day of month", s.dom, tt.dom, 1, 31)
+			checkBits(t, "month", s.month, tt.month, 1, 12)
+			checkBits(t, "day of week", s.dow, tt.dow, 0, 7)
+		})
+	}
+}
+
+// checkBits compares a field's bit set with want, where nil means every
+// value from min to max
+func checkBits(t *testing.T, field string, bits uint64, want []int, min, max int) {
+	t.Helper()
+	var wantBits uint64
+	if want == nil {
+		for v := min; v <= max; v++ {
+			wantBits |= 1 << uint(v)
+		}
+	}
+	for _, v := range want {
+		wantBits |= 1 << uint(v)
+	}
+	if bits != wantBits {
+		t.Errorf("%s bits = %b, want %b", field, bits, wantBits)
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	} {
+		if _, err := parseCron(expr, time.UTC); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		loc   *time.Location
+		after string
+		want  string
+	}{
+		{"next minute", "* * * * *", time.UTC, "2026-05-01 10:00", "2026-05-01 10:01"},
+		{"strictly after", "30 10 * * *", time.UTC, "2026-05-01 10:30", "2026-05-02 10:30"},
+		{"later today", "0 18 * * *", time.UTC, "2026-05-01 10:00", "2026-05-01 18:00"},
+		{"skips the weekend", "0 8 * * 1-5", time.UTC, "2026-05-01 09:00", "2026-05-04 08:00"},
+		{"end of month", "0 0 31 * *", time.UTC, "2026-04-01 00:00", "2026-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", time.UTC, "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"day of month or weekday", "0 0 13 * 5", time.UTC, "2026-05-02 00:00", "2026-05-08 00:00"},
+		{"day of month before weekday", "0 0 4 * 5", time.UTC, "2026-05-02 00:00", "2026-05-04 00:00"},
+		{"weekday alone", "0 0 * * 5", time.UTC, "2026-05-09 00:00", "2026-05-15 00:00"},
+		{"sunday as 7", "0 9 * * 7", time.UTC, "2026-05-01 00:00", "2026-05-03 09:00"},
+		{"year rollover", "0 0 1 1 *", time.UTC, "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"local time", "0 9 * * *", newYork, "2026-05-01 10:00", "2026-05-02 09:00"},
+		{"hour skipped by DST", "30 2 * * *", newYork, "2026-03-07 12:00", "2026-03-09 02:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatalf("parseCron: %v", err)
+			}
+			got := s.next(at(tt.loc, tt.after))
+			if want := at(tt.loc, tt.want); !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.after, got.In(tt.loc).Format("2006-01-02 15:04 MST"), want.Format("2006-01-02 15:04 MST"))
+			}
+		})
+	}
+}
+
+func TestCronNextNeverFires(t *testing.T) {
+	s, err := parseCron("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	if next := s.next(time.Now()); !next.IsZero() {
+		t.Errorf("next = %v, want the zero time", next)
+	}
+}
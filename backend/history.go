@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHistoryEntries bounds how many finished tasks are kept in memory
+const maxHistoryEntries = 500
+
+// HistoryEntry is a finished task as written to the history file
+type HistoryEntry struct {
+	TaskID     string          `json:"taskId"`
+	Goal       string          `json:"goal"`
+	Status     string          `json:"status"`
+	Sequence   CommandSequence `json:"sequence"`
+	Results    []CommandResult `json:"results"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	DurationMs int64           `json:"durationMs"`
+}
+
+// TaskHistory appends every finished task to a JSON lines file. Unlike the
+// task log it stores one full record per task, sequence included, rather
+// than one line per status change.
+type TaskHistory struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []HistoryEntry
+}
+
+// taskHistory is nil unless -history-file is set
+var taskHistory *TaskHistory
+
+// OpenTaskHistory loads existing entries from path and opens it for appending
+func OpenTaskHistory(path string) (*TaskHistory, error) {
+	h := &TaskHistory{}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				slog.Warn("Skipping malformed history line", "error", err)
+				continue
+			}
+			h.append(entry)
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read history file: %v", err)
+		}
+	}
+
+	// Like the task log, history is readable by the owner only
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %v", err)
+	}
+	if err := file.Chmod(0600); err != nil {
+		slog.Warn("Failed to restrict history file permissions", "path", path, "error", err)
+	}
+	h.file = file
+
+	slog.Info("Task history loaded", "path", path, "entries", len(h.entries))
+	return h, nil
+}
+
+func (h *TaskHistory) append(entry HistoryEntry) {
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistoryEntries {
+		h.entries = h.entries[len(h.entries)-maxHistoryEntries:]
+	}
+}
+
+// Record appends a finished task with its secrets redacted. Each entry is a
+// single Write on an O_APPEND file under the mutex, so concurrent sessions
+// never interleave.
+func (h *TaskHistory) Record(task *TaskState) {
+	finished := time.Now()
+	entry := HistoryEntry{
+		TaskID:     task.TaskID,
+		Goal:       task.Goal,
+		Status:     task.Status,
+		Sequence:   redactSequence(task.Sequence, task.secrets),
+		Results:    redactResults(task.Results, task.secrets),
+		StartedAt:  task.StartedAt,
+		FinishedAt: finished,
+		DurationMs: finished.Sub(task.StartedAt).Milliseconds(),
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Failed to encode history entry", "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.append(entry)
+	if _, err := h.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write history file", "error", err)
+	}
+}
+
+// Recent returns up to n of the newest entries, newest first
+func (h *TaskHistory) Recent(n int) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n <= 0 || n > len(h.entries) {
+		n = len(h.entries)
+	}
+	recent := make([]HistoryEntry, 0, n)
+	for i := len(h.entries) - 1; i >= len(h.entries)-n; i-- {
+		recent = append(recent, h.entries[i])
+	}
+	return recent
+}
+
+// redactedValue stands in for secrets in persisted task records
+const redactedValue = "[redacted]"
+
+// redactSequence returns a copy of sequence that is safe to persist: cookie
+// values are dropped and each of secrets is masked wherever it was filled in
+func redactSequence(sequence CommandSequence, secrets []string) CommandSequence {
+	commands := make([]CommandPayload, len(sequence.Commands))
+	for i, cmd := range sequence.Commands {
+		if cmd.Cookie != nil {
+			cookie := *cmd.Cookie
+			if cookie.Value != "" {
+				cookie.Value = redactedValue
+			}
+			cmd.Cookie = &cookie
+		}
+		for _, field := range append(templateFields(&cmd), &cmd.URL) {
+			*field = redactSecrets(*field, secrets)
+		}
+		commands[i] = cmd
+	}
+	sequence.Commands = commands
+	return sequence
+}
+
+// redactResults returns a copy of results with get_cookies output dropped
+// and secrets masked
+func redactResults(results []CommandResult, secrets []string) []CommandResult {
+	redacted := make([]CommandResult, len(results))
+	for i, result := range results {
+		if result.Action == "get_cookies" && result.Details != "" {
+			result.Details = redactedValue
+		}
+		result.Details = redactSecrets(result.Details, secrets)
+		result.Error = redactSecrets(result.Error, secrets)
+		redacted[i] = result
+	}
+	return redacted
+}
+
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactedValue)
+		}
+	}
+	return s
+}
+
+// isFinalStatus reports whether a task in status has stopped running
+func isFinalStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// historyHandler serves GET /api/history?limit=N, newest first, behind the
+// same origin and token checks as the WebSocket
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeRead(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if taskHistory == nil {
+		http.Error(w, "Task history disabled (start with -history-file)", http.StatusNotFound)
+		return
+	}
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(taskHistory.Recent(limit))
+}
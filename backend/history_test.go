@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryRedactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := OpenTaskHistory(path)
+	if err != nil {
+		t.Fatalf("OpenTaskHistory: %v", err)
+	}
+	defer history.file.Close()
+
+	task := &TaskState{
+		TaskID: "task_1",
+		Goal:   "template login",
+		Status: "completed",
+		Sequence: CommandSequence{Commands: []CommandPayload{
+			{Action: "navigate", URL: "https://example.com/login?user=alice"},
+			{Action: "input", Selector: "#password", Text: "hunter2"},
+			{Action: "set_cookie", Cookie: &CookieParams{Name: "session", Value: "abc123"}},
+			{Action: "get_cookies"},
+		}},
+		Results: []CommandResult{
+			{Step: 1, Action: "input", Success: true, Details: "typed hunter2"},
+			{Step: 3, Action: "get_cookies", Success: true, Details: `[{"name":"session","value":"abc123"}]`},
+		},
+		StartedAt: time.Now(),
+		secrets:   []string{"alice", "hunter2"},
+	}
+	history.Record(task)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"alice", "hunter2", "abc123"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("history file contains %q: %s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), redactedValue) {
+		t.Errorf("history file has no redaction marker: %s", data)
+	}
+
+	// The running task keeps its real values
+	if task.Sequence.Commands[1].Text != "hunter2" || task.Sequence.Commands[2].Cookie.Value != "abc123" {
+		t.Error("redaction modified the task itself")
+	}
+}
+
+func TestHistoryFileIsOwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	history, err := OpenTaskHistory(path)
+	if err != nil {
+		t.Fatalf("OpenTaskHistory: %v", err)
+	}
+	defer history.file.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("history file mode = %o, want 600", mode)
+	}
+}
+
+func TestHistoryHandlerRequiresToken(t *testing.T) {
+	history, err := OpenTaskHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenTaskHistory: %v", err)
+	}
+	defer history.file.Close()
+
+	previous := taskHistory
+	taskHistory = history
+	t.Cleanup(func() { taskHistory = previous })
+	withAuthToken(t, "s3cret")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	rec := httptest.NewRecorder()
+	historyHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/history?token=s3cret", nil)
+	rec = httptest.NewRecorder()
+	historyHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with token: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestHistoryHandlerWithoutTokenIsLoopbackOnly(t *testing.T) {
+	withTaskHistory(t)
+	withAuthToken(t, "")
+
+	rec := httptest.NewRecorder()
+	historyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("remote client: status = %d, want 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	historyHandler(rec, localRequest("/api/history"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("loopback client: status = %d, want 200", rec.Code)
+	}
+}
+
+// withTaskHistory points taskHistory at a fresh file for the rest of the test
+// and returns the file's path
+func withTaskHistory(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := OpenTaskHistory(path)
+	if err != nil {
+		t.Fatalf("OpenTaskHistory: %v", err)
+	}
+	previous := taskHistory
+	taskHistory = history
+	t.Cleanup(func() {
+		taskHistory = previous
+		history.file.Close()
+	})
+	return path
+}
+
+func TestCompletedTaskIsReadBack(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	path := withTaskHistory(t)
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click buy"},
+		CommandPayload{Action: "click", Selector: "#buy"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true, Details: "clicked"})
+	readUntil(t, client, "TASK_COMPLETE", nil)
+
+	reopened, err := OpenTaskHistory(path)
+	if err != nil {
+		t.Fatalf("OpenTaskHistory: %v", err)
+	}
+	defer reopened.file.Close()
+	entries := reopened.Recent(0)
+	if len(entries) != 1 {
+		t.Fatalf("read back %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.TaskID != task.TaskID || entry.Goal != "click buy" || entry.Status != "completed" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(entry.Sequence.Commands) != 1 || len(entry.Results) != 1 || entry.Results[0].Details != "clicked" {
+		t.Errorf("entry sequence %+v, results %+v", entry.Sequence, entry.Results)
+	}
+	if entry.DurationMs < 0 || entry.FinishedAt.Before(entry.StartedAt) {
+		t.Errorf("entry timings %v to %v (%dms)", entry.StartedAt, entry.FinishedAt, entry.DurationMs)
+	}
+}
+
+func TestConcurrentRecordsStayWhole(t *testing.T) {
+	path := withTaskHistory(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			taskHistory.Record(&TaskState{
+				TaskID:    fmt.Sprintf("task_%d", i),
+				Goal:      strings.Repeat("long goal ", 500),
+				Status:    "completed",
+				StartedAt: time.Now(),
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 20 {
+		t.Fatalf("history file has %d lines, want 20", len(lines))
+	}
+	for _, line := range lines {
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Errorf("interleaved line: %v", err)
+		}
+	}
+}
+
+func TestHistoryHandlerReturnsNewestFirst(t *testing.T) {
+	withTaskHistory(t)
+	withAuthToken(t, "")
+	for _, id := range []string{"task_1", "task_2", "task_3"} {
+		taskHistory.Record(&TaskState{TaskID: id, Status: "completed", StartedAt: time.Now()})
+	}
+
+	rec := httptest.NewRecorder()
+	historyHandler(rec, localRequest("/api/history?limit=2"))
+	var entries []HistoryEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(entries) != 2 || entries[0].TaskID != "task_3" || entries[1].TaskID != "task_2" {
+		t.Errorf("entries = %+v, want task_3 then task_2", entries)
+	}
+
+	rec = httptest.NewRecorder()
+	historyHandler(rec, localRequest("/api/history?limit=0"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: status = %d, want 400", rec.Code)
+	}
+}
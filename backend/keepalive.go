@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startKeepalive pings the peer every pingInterval and expects some traffic
+// (a pong or a message) within readTimeout, or the next read fails and the
+// handler's read loop closes the connection. It returns a stop function.
+func startKeepalive(session *Session, pingInterval, readTimeout time.Duration) func() {
+	conn := session.conn
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// WriteControl may run concurrently with session.send
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+					session.logger.Info("Ping failed, closing connection", "error", err)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
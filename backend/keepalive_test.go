@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withKeepalive sets -ping-interval and -read-timeout for the rest of the test
+func withKeepalive(t *testing.T, pingInterval, readTimeout time.Duration) {
+	t.Helper()
+	previousPing, previousRead := *pingIntervalFlag, *readTimeoutFlag
+	*pingIntervalFlag, *readTimeoutFlag = pingInterval, readTimeout
+	t.Cleanup(func() { *pingIntervalFlag, *readTimeoutFlag = previousPing, previousRead })
+}
+
+func TestKeepaliveHoldsResponsiveConnections(t *testing.T) {
+	withKeepalive(t, 50*time.Millisecond, 150*time.Millisecond)
+	client := dialBackend(t)
+
+	var pings atomic.Int32
+	defaultPing := client.PingHandler()
+	client.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return defaultPing(data)
+	})
+
+	// Reading answers each ping with a pong, well past the read timeout
+	client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, _, err := client.ReadMessage()
+	if pings.Load() < 5 {
+		t.Errorf("got %d pings in 500ms, want one every 50ms", pings.Load())
+	}
+	if !isTimeout(err) {
+		t.Errorf("read = %v, want the connection still open", err)
+	}
+}
+
+func TestKeepaliveClosesSilentConnections(t *testing.T) {
+	withKeepalive(t, 50*time.Millisecond, 150*time.Millisecond)
+	client := dialBackend(t)
+
+	// Not reading means no pongs
+	time.Sleep(400 * time.Millisecond)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := client.ReadMessage(); err != nil {
+			if isTimeout(err) {
+				t.Fatal("connection without pongs was not closed")
+			}
+			return
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
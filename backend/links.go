@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LinkInfo is a link worth offering as a follow-up "click on ..." goal
+type LinkInfo struct {
+	Text       string `json:"text"`
+	Href       string `json:"href"`
+	IsExternal bool   `json:"isExternal"`
+	// Relevance runs from 0 to 1; links in the main content score highest
+	Relevance float64 `json:"relevance"`
+}
+
+// maxLinks is how many links an analysis keeps
+const maxLinks = 20
+
+const (
+	mainContentSelector = "main, article, [role='main']"
+	chromeSelector      = "nav, header, footer, aside, [role='navigation'], [role='banner'], [role='contentinfo'], [role='complementary']"
+)
+
+// extractLinks scores every <a href> in doc and returns the maxLinks most
+// relevant, most relevant first. Hrefs are resolved against pageURL when it
+// parses; a link to the same href twice keeps its best score.
+func extractLinks(doc *goquery.Document, pageURL string) []LinkInfo {
+	base, err := url.Parse(pageURL)
+	if err != nil || !base.IsAbs() {
+		base = nil
+	}
+
+	best := map[string]int{}
+	links := []LinkInfo{}
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href := strings.TrimSpace(s.AttrOr("href", ""))
+		lower := strings.ToLower(href)
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+			return
+		}
+
+		link := LinkInfo{Text: elementLabel(s), Href: href}
+		if link.Text == "" {
+			link.Text = strings.TrimSpace(s.Find("img[alt]").First().AttrOr("alt", ""))
+		}
+		if parsed, err := url.Parse(href); err == nil {
+			if base != nil {
+				parsed = base.ResolveReference(parsed)
+				link.Href = parsed.String()
+				link.IsExternal = parsed.Host != "" && !strings.EqualFold(parsed.Hostname(), base.Hostname())
+			} else {
+				link.IsExternal = parsed.IsAbs()
+			}
+		}
+		link.Relevance = linkRelevance(s, link.Text)
+
+		if i, seen := best[link.Href]; seen {
+			if link.Relevance > links[i].Relevance {
+				links[i] = link
+			}
+			return
+		}
+		best[link.Href] = len(links)
+		links = append(links, link)
+	})
+
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Relevance > links[j].Relevance })
+	if len(links) > maxLinks {
+		links = links[:maxLinks]
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return links
+}
+
+// linkRelevance scores s from where it sits on the page and how well its
+// text describes it
+func linkRelevance(s *goquery.Selection, text string) float64 {
+	score := 0.5
+	switch {
+	case s.Closest(mainContentSelector).Length() > 0:
+		score += 0.4
+	case s.Closest(chromeSelector).Length() > 0:
+		score -= 0.3
+	}
+
+	switch words := len(strings.Fields(text)); {
+	case words == 0:
+		score -= 0.2
+	case words >= 3:
+		score += 0.1
+	}
+
+	rel := strings.ToLower(s.AttrOr("rel", ""))
+	if strings.Contains(rel, "nofollow") || strings.Contains(rel, "sponsored") {
+		score -= 0.1
+	}
+
+	return math.Round(min(max(score, 0), 1)*100) / 100
+}
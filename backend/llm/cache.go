@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache is an in-process LRU of parsed command sequences with expiry
+type ResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key       string
+	sequence  *CommandSequence
+	expiresAt time.Time
+}
+
+// responseCache is used by ParseGoalWithLLM; nil disables caching
+var responseCache *ResponseCache
+
+// NewResponseCache creates a cache holding at most maxEntries sequences,
+// each expiring after ttl unless Set is given its own ttl
+func NewResponseCache(maxEntries int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// ConfigureCache enables goal parsing caching; maxEntries <= 0 disables it
+func ConfigureCache(maxEntries int, ttl time.Duration) {
+	if maxEntries <= 0 {
+		responseCache = nil
+		return
+	}
+	responseCache = NewResponseCache(maxEntries, ttl)
+}
+
+// CacheKey hashes the normalized goal together with a fingerprint of the
+// page it was issued on and the conversation turns the prompt would include,
+// so "Search for cats!" and "search for  cats" share an entry but the same
+// goal on a different page or after different goals does not
+func CacheKey(goal string, pageContext *PageContext, history []ConversationTurn) string {
+	material := NormalizeGoal(goal)
+	if pageContext != nil {
+		material += "\x00" + pageContext.URL + "\x00" + pageContext.ContentType
+	}
+	if len(history) > maxPromptTurns {
+		history = history[len(history)-maxPromptTurns:]
+	}
+	for _, turn := range history {
+		material += "\x00" + turn.Role + "\x00" + turn.Content
+	}
+	sum := sha256.Sum256([]byte(material))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeGoal lowercases a goal, collapses whitespace and drops trailing
+// punctuation
+func NormalizeGoal(goal string) string {
+	goal = strings.Join(strings.Fields(strings.ToLower(goal)), " ")
+	return strings.TrimRight(goal, ".!?")
+}
+
+// Get returns a copy of the cached sequence if present and not expired
+func (c *ResponseCache) Get(key string) (*CommandSequence, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return copySequence(entry.sequence), true
+}
+
+// Set stores a sequence, evicting the least recently used entry when full
+func (c *ResponseCache) Set(key string, seq *CommandSequence, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{
+		key:       key,
+		sequence:  copySequence(seq),
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet evicted
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func copySequence(seq *CommandSequence) *CommandSequence {
+	copied := *seq
+	copied.Commands = append([]CommandPayload(nil), seq.Commands...)
+	return &copied
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	page := &PageContext{URL: "https://shop.example/", ContentType: "ecommerce"}
+	otherPage := &PageContext{URL: "https://news.example/", ContentType: "article"}
+	history := []ConversationTurn{{Role: "user", Content: "go to shop.example"}}
+
+	if CacheKey("Search for cats!", page, nil) != CacheKey("search for  cats", page, nil) {
+		t.Error("equivalent goals got different keys")
+	}
+	if CacheKey("search for cats", page, nil) == CacheKey("search for cats", otherPage, nil) {
+		t.Error("the same goal on different pages shares a key")
+	}
+	if CacheKey("search for cats", page, nil) == CacheKey("search for cats", nil, nil) {
+		t.Error("a goal with and without page context shares a key")
+	}
+	if CacheKey("click the first one", page, nil) == CacheKey("click the first one", page, history) {
+		t.Error("a follow-up goal shares a key with the same goal without history")
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewResponseCache(2, time.Minute)
+	cache.Set("a", &CommandSequence{Commands: []CommandPayload{{Action: "navigate"}}}, 0)
+	cache.Set("b", &CommandSequence{Commands: []CommandPayload{{Action: "click"}}}, 0)
+	cache.Get("a")
+	cache.Set("c", &CommandSequence{Commands: []CommandPayload{{Action: "scroll"}}}, 0)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used entry survived")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("entry %q was evicted", key)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len = %d, want 2", cache.Len())
+	}
+}
+
+func TestResponseCacheExpiresEntries(t *testing.T) {
+	cache := NewResponseCache(10, time.Minute)
+	cache.Set("short", &CommandSequence{}, 10*time.Millisecond)
+	cache.Set("default", &CommandSequence{}, 0)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := cache.Get("short"); ok {
+		t.Error("expired entry was returned")
+	}
+	if _, ok := cache.Get("default"); !ok {
+		t.Error("entry with the cache's TTL expired early")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Len = %d, want the expired entry dropped", cache.Len())
+	}
+}
+
+func TestResponseCacheReturnsCopies(t *testing.T) {
+	cache := NewResponseCache(10, time.Minute)
+	original := &CommandSequence{Commands: []CommandPayload{{Action: "click", Selector: "#a"}}}
+	cache.Set("key", original, 0)
+	original.Commands[0].Selector = "#changed"
+
+	got, _ := cache.Get("key")
+	got.Commands[0].Selector = "#mutated"
+	again, _ := cache.Get("key")
+	if again.Commands[0].Selector != "#a" {
+		t.Errorf("cached selector = %q, want #a", again.Commands[0].Selector)
+	}
+}
+
+func TestNormalizeGoal(t *testing.T) {
+	if got := NormalizeGoal("  Search   for CATS!? "); got != "search for cats" {
+		t.Errorf("NormalizeGoal = %q", got)
+	}
+}
+
+// withResponseCache enables goal parsing caching for the rest of the test
+func withResponseCache(t *testing.T) {
+	t.Helper()
+	previous := responseCache
+	ConfigureCache(16, time.Minute)
+	t.Cleanup(func() { responseCache = previous })
+}
+
+func TestParseGoalWithLLMCachesByGoal(t *testing.T) {
+	withResponseCache(t)
+	backend := &fakeBackend{response: `{"intent":"search","confidence":0.9,"steps":[{"action":"navigate","url":"https://www.google.com/search?q=cats"}]}`}
+
+	for _, goal := range []string{"Search for cats", "search for cats!"} {
+		sequence, err := ParseGoalWithLLM(backend, goal, nil)
+		if err != nil {
+			t.Fatalf("ParseGoalWithLLM(%q): %v", goal, err)
+		}
+		if len(sequence.Commands) != 1 || sequence.Commands[0].Action != "navigate" {
+			t.Errorf("ParseGoalWithLLM(%q) = %+v", goal, sequence.Commands)
+		}
+	}
+	if len(backend.prompts) != 1 {
+		t.Errorf("backend called %d times, want 1", len(backend.prompts))
+	}
+
+	if _, err := ParseGoalWithLLM(backend, "search for dogs", nil); err != nil {
+		t.Fatalf("ParseGoalWithLLM: %v", err)
+	}
+	if len(backend.prompts) != 2 {
+		t.Errorf("a different goal was served from the cache")
+	}
+}
+
+func TestParseGoalWithLLMDoesNotCacheFailures(t *testing.T) {
+	withResponseCache(t)
+	backend := &fakeBackend{response: "no idea"}
+	for i := 0; i < 2; i++ {
+		if _, err := ParseGoalWithLLM(backend, "do the thing", nil); err == nil {
+			t.Fatal("unparseable response succeeded")
+		}
+	}
+	if len(backend.prompts) != 2 || responseCache.Len() != 0 {
+		t.Errorf("backend called %d times with %d cached entries", len(backend.prompts), responseCache.Len())
+	}
+}
@@ -5,23 +5,64 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultOllamaHost is used when OLLAMA_HOST is unset
+const DefaultOllamaHost = "http://localhost:11434"
+
+// LLMBackend is implemented by anything that can turn a prompt into a completion
+type LLMBackend interface {
+	Generate(prompt string) (string, error)
+}
+
+// LLMProvider is a backend that can also verify it is reachable at startup
+type LLMProvider interface {
+	LLMBackend
+	TestConnection() error
+}
+
+// DefaultOllamaModel is used when no Ollama model is configured
+const DefaultOllamaModel = "mistral:latest"
+
 // LLMClient handles communication with Ollama
 type LLMClient struct {
-	baseURL string
-	model   string
+	modelChain
+	host    string
 	timeout time.Duration
+	logger  *slog.Logger
+	usage   usageTracker
+	options map[string]interface{} // sent with every request
+}
+
+// modelChain is the fallback order of models a client tries
+type modelChain struct {
+	models       []string // tried in priority order
+	defaultModel string   // used when none are configured
+	// preferred indexes the model that last answered, which is tried
+	// first so a missing primary model costs one failed request, not one
+	// per goal
+	preferred atomic.Int32
 }
 
+// DefaultTemperature keeps goal parsing close to deterministic, so the same
+// goal on the same page tends to get the same plan
+const DefaultTemperature = 0.1
+
 // OllamaRequest represents the request to Ollama API
 type OllamaRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 	Stream bool   `json:"stream"`
+	Format string `json:"format,omitempty"` // "json" constrains output to valid JSON
+	// Options holds model parameters such as temperature, top_p and seed
+	Options map[string]interface{} `json:"options,omitempty"`
 }
 
 // OllamaResponse represents the response from Ollama API
@@ -38,36 +79,210 @@ type OllamaResponse struct {
 	EvalDuration       int64  `json:"eval_duration"`
 }
 
-// NewLLMClient creates a new Ollama client
-func NewLLMClient(model string) *LLMClient {
-	if model == "" {
-		model = "mistral:latest" // Default model
+// stats converts the response's counts and nanosecond durations
+func (r OllamaResponse) stats() GenerationStats {
+	return GenerationStats{
+		Model:              r.Model,
+		PromptTokens:       r.PromptEvalCount,
+		CompletionTokens:   r.EvalCount,
+		TotalDuration:      time.Duration(r.TotalDuration),
+		LoadDuration:       time.Duration(r.LoadDuration),
+		PromptEvalDuration: time.Duration(r.PromptEvalDuration),
+		EvalDuration:       time.Duration(r.EvalDuration),
+	}
+}
+
+// ResolveOllamaHost reads OLLAMA_HOST, defaulting to DefaultOllamaHost, and
+// validates it. Like the Ollama CLI, a bare host:port is treated as http.
+func ResolveOllamaHost() (string, error) {
+	host := strings.TrimSpace(os.Getenv("OLLAMA_HOST"))
+	if host == "" {
+		return DefaultOllamaHost, nil
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+
+	parsed, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid OLLAMA_HOST %q: %v", host, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("invalid OLLAMA_HOST %q: scheme must be http or https", host)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("invalid OLLAMA_HOST %q: missing host", host)
+	}
+
+	return strings.TrimSuffix(host, "/"), nil
+}
+
+// NewLLMClient creates a new Ollama client for OLLAMA_HOST (or the default).
+// A nil logger means slog.Default().
+func NewLLMClient(model string, logger *slog.Logger) *LLMClient {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	host, err := ResolveOllamaHost()
+	if err != nil {
+		logger.Warn("Invalid OLLAMA_HOST, using default", "error", err, "host", DefaultOllamaHost)
+		host = DefaultOllamaHost
+	}
+	return NewLLMClientWithHost(host, model, logger)
+}
+
+// NewLLMClientWithFallbacks creates an Ollama client that tries each model in
+// order until one returns a non-empty response
+func NewLLMClientWithFallbacks(models []string, logger *slog.Logger) *LLMClient {
+	client := NewLLMClient("", logger)
+	client.SetModels(models)
+	return client
+}
+
+// NewLLMClientWithHost creates a new Ollama client for an explicit host
+func NewLLMClientWithHost(host, model string, logger *slog.Logger) *LLMClient {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	client := &LLMClient{
+		modelChain: modelChain{defaultModel: DefaultOllamaModel},
+		host:       strings.TrimSuffix(host, "/"),
+		timeout:    30 * time.Second,
+		logger:     logger,
+		options:    map[string]interface{}{"temperature": DefaultTemperature},
+	}
+	client.SetModels([]string{model})
+	return client
+}
+
+// SetModels replaces the models tried in order, e.g. from LLM_MODELS
+func (m *modelChain) SetModels(models []string) {
+	m.models = normalizeModels(models, m.defaultModel)
+	m.preferred.Store(0)
+}
+
+// Models returns the configured models in priority order
+func (m *modelChain) Models() []string {
+	return append([]string(nil), m.models...)
+}
+
+// modelOrder lists the models to try: the preferred one, then the rest in
+// configured order
+func (m *modelChain) modelOrder() []string {
+	preferred := int(m.preferred.Load())
+	if preferred <= 0 || preferred >= len(m.models) {
+		return m.models
 	}
+	order := append([]string{m.models[preferred]}, m.models[:preferred]...)
+	return append(order, m.models[preferred+1:]...)
+}
 
-	return &LLMClient{
-		baseURL: "http://localhost:11434/api/generate",
-		model:   model,
-		timeout: 30 * time.Second,
+// prefer makes model the first one tried from now on
+func (m *modelChain) prefer(model string, logger *slog.Logger) {
+	for i, name := range m.models {
+		if name == model && int(m.preferred.Swap(int32(i))) != i {
+			logger.Info("Switching preferred model", "model", model)
+		}
 	}
 }
 
-// Generate sends a prompt to Ollama and returns the response
+// SetOptions overrides the model parameters sent with each request, e.g.
+// {"temperature": 0.2, "top_p": 0.9}. Options not named keep their current
+// values; a nil value removes one, leaving it to Ollama's default.
+func (c *LLMClient) SetOptions(options map[string]interface{}) {
+	for name, value := range options {
+		if value == nil {
+			delete(c.options, name)
+			continue
+		}
+		c.options[name] = value
+	}
+}
+
+func normalizeModels(models []string, defaultModel string) []string {
+	normalized := []string{}
+	for _, model := range models {
+		if model = strings.TrimSpace(model); model != "" {
+			normalized = append(normalized, model)
+		}
+	}
+	if len(normalized) == 0 {
+		normalized = append(normalized, defaultModel)
+	}
+	return normalized
+}
+
+// newRequest builds a generate request in JSON mode, since every prompt we
+// send asks for a JSON command plan. extractJSON still handles models that
+// ignore the format flag.
+func (c *LLMClient) newRequest(model, prompt string, stream bool) OllamaRequest {
+	return OllamaRequest{
+		Model:   model,
+		Prompt:  prompt,
+		Stream:  stream,
+		Format:  "json",
+		Options: c.options,
+	}
+}
+
+// generateURL is Ollama's completion endpoint
+func (c *LLMClient) generateURL() string {
+	return c.host + "/api/generate"
+}
+
+// tagsURL is Ollama's endpoint listing locally available models
+func (c *LLMClient) tagsURL() string {
+	return c.host + "/api/tags"
+}
+
+// Generate sends a prompt to Ollama and returns the first non-empty response,
+// falling back through the configured models on errors. It fails without
+// sending anything once the token budget is used up.
 func (c *LLMClient) Generate(prompt string) (string, error) {
-	request := OllamaRequest{
-		Model:  c.model,
-		Prompt: prompt,
-		Stream: false,
+	response, _, err := c.GenerateWithStats(prompt)
+	return response, err
+}
+
+// GenerateWithStats is Generate that also returns the token counts and
+// timings of the response that was used
+func (c *LLMClient) GenerateWithStats(prompt string) (string, GenerationStats, error) {
+	if err := c.usage.checkBudget(); err != nil {
+		return "", GenerationStats{}, err
 	}
 
+	var lastErr error
+	order := c.modelOrder()
+	for i, model := range order {
+		response, stats, err := c.generateWithModel(model, prompt)
+		if err == nil && strings.TrimSpace(response) == "" {
+			err = fmt.Errorf("model %s returned an empty response", model)
+		}
+		if err == nil {
+			c.prefer(model, c.logger)
+			return response, stats, nil
+		}
+
+		lastErr = err
+		if i < len(order)-1 {
+			c.logger.Warn("Model failed, trying next", "model", model, "error", err, "next", order[i+1])
+		}
+	}
+	return "", GenerationStats{}, lastErr
+}
+
+// generateWithModel sends a prompt to a single Ollama model
+func (c *LLMClient) generateWithModel(model, prompt string) (string, GenerationStats, error) {
+	request := c.newRequest(model, prompt, false)
+
 	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+		return "", GenerationStats{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", c.baseURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", c.generateURL(), bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return "", GenerationStats{}, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -80,23 +295,24 @@ func (c *LLMClient) Generate(prompt string) (string, error) {
 	// Send request
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request to Ollama: %v. Make sure Ollama is running (ollama serve)", err)
+		return "", GenerationStats{}, fmt.Errorf("failed to send request to Ollama: %v. Make sure Ollama is running (ollama serve)", err)
 	}
 	defer resp.Body.Close()
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama API returned status %d: %s", resp.StatusCode, string(body))
+		return "", GenerationStats{}, fmt.Errorf("ollama API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse response
 	var ollamaResp OllamaResponse
 	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return "", GenerationStats{}, fmt.Errorf("failed to decode response: %v", err)
 	}
+	c.usage.record(ollamaResp.PromptEvalCount, ollamaResp.EvalCount)
 
-	return ollamaResp.Response, nil
+	return ollamaResp.Response, ollamaResp.stats(), nil
 }
 
 // TestConnection tests if Ollama is running and accessible
@@ -105,9 +321,9 @@ func (c *LLMClient) TestConnection() error {
 		Timeout: 5 * time.Second,
 	}
 
-	resp, err := client.Get("http://localhost:11434/api/tags")
+	resp, err := client.Get(c.tagsURL())
 	if err != nil {
-		return fmt.Errorf("ollama is not running. Start it with: ollama serve")
+		return fmt.Errorf("ollama is not running at %s. Start it with: ollama serve", c.host)
 	}
 	defer resp.Body.Close()
 
@@ -115,6 +331,39 @@ func (c *LLMClient) TestConnection() error {
 		return fmt.Errorf("ollama returned status %d", resp.StatusCode)
 	}
 
-	log.Println("Ollama connection successful")
-	return nil
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("failed to decode Ollama model list: %v", err)
+	}
+	pulled := map[string]bool{}
+	available := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		pulled[withDefaultTag(m.Name)] = true
+		available = append(available, m.Name)
+	}
+
+	for _, model := range c.models {
+		if pulled[withDefaultTag(model)] {
+			c.prefer(model, c.logger)
+			c.logger.Info("Ollama connection successful", "host", c.host, "model", model)
+			return nil
+		}
+	}
+	if len(available) == 0 {
+		return fmt.Errorf("no models are pulled in Ollama. Pull one with: ollama pull %s", c.models[0])
+	}
+	return fmt.Errorf("none of the configured models (%s) is available in Ollama, which has: %s. Pull one with: ollama pull %s",
+		strings.Join(c.models, ", "), strings.Join(available, ", "), c.models[0])
+}
+
+// withDefaultTag adds ":latest" to a model name without a tag, as Ollama does
+func withDefaultTag(model string) string {
+	if strings.Contains(model, ":") {
+		return model
+	}
+	return model + ":latest"
 }
@@ -0,0 +1,241 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOllama answers /api/generate without streaming, replying to each
+// request with whatever respond returns, and records the requests
+type fakeOllama struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []OllamaRequest
+}
+
+func newFakeOllama(t *testing.T, respond func(request OllamaRequest) (int, OllamaResponse)) *fakeOllama {
+	t.Helper()
+	fake := &fakeOllama{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		fake.mu.Unlock()
+
+		status, response := respond(request)
+		if status != http.StatusOK {
+			http.Error(w, `{"error":"unavailable"}`, status)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// models lists the model of each request received so far, in order
+func (f *fakeOllama) models() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	models := []string{}
+	for _, request := range f.requests {
+		models = append(models, request.Model)
+	}
+	return models
+}
+
+func TestResolveOllamaHost(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", DefaultOllamaHost, false},
+		{"gpu-box:11434", "http://gpu-box:11434", false},
+		{"https://ollama.example/", "https://ollama.example", false},
+		{"  http://127.0.0.1:9999  ", "http://127.0.0.1:9999", false},
+		{"ftp://ollama.example", "", true},
+		{"http://", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OLLAMA_HOST", tt.env)
+		got, err := ResolveOllamaHost()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("OLLAMA_HOST=%q: got %q, %v; want %q", tt.env, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewLLMClientFallsBackOnInvalidHost(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "ftp://ollama.example")
+	if client := NewLLMClient("mistral", nil); client.host != DefaultOllamaHost {
+		t.Errorf("host = %q, want %q", client.host, DefaultOllamaHost)
+	}
+}
+
+func TestGenerateUsesJSONMode(t *testing.T) {
+	fake := newFakeOllama(t, func(OllamaRequest) (int, OllamaResponse) {
+		return http.StatusOK, OllamaResponse{Response: `{"steps":[]}`}
+	})
+	client := NewLLMClientWithHost(fake.URL, "mistral", nil)
+
+	if _, err := client.Generate("plan"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if request := fake.requests[0]; request.Format != "json" || request.Stream {
+		t.Errorf("request format %q, stream %v; want json mode without streaming", request.Format, request.Stream)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name, response, want string
+	}{
+		{"bare", `{"steps":[]}`, `{"steps":[]}`},
+		{"fenced", "Here you go:\n```json\n{\"steps\":[{\"action\":\"click\"}]}\n```", `{"steps":[{"action":"click"}]}`},
+		{"prose around", `Sure! {"intent":"navigate"} Hope that helps.`, `{"intent":"navigate"}`},
+		{"nested", `{"a":{"b":{}},"c":1} {"d":2}`, `{"a":{"b":{}},"c":1}`},
+		{"none", "I can't help with that", ""},
+	}
+	for _, tt := range tests {
+		if got := extractJSON(tt.response); got != tt.want {
+			t.Errorf("%s: extractJSON = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOptionsAreSentWithEachRequest(t *testing.T) {
+	fake := newFakeOllama(t, func(OllamaRequest) (int, OllamaResponse) {
+		return http.StatusOK, OllamaResponse{Response: `{}`}
+	})
+	client := NewLLMClientWithHost(fake.URL, "mistral", nil)
+
+	if _, err := client.Generate("plan"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	client.SetOptions(map[string]interface{}{"temperature": nil, "top_p": 0.9, "seed": 42})
+	if _, err := client.Generate("plan"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if options := fake.requests[0].Options; !reflect.DeepEqual(options, map[string]interface{}{"temperature": DefaultTemperature}) {
+		t.Errorf("default options = %v", options)
+	}
+	want := map[string]interface{}{"top_p": 0.9, "seed": float64(42)}
+	if options := fake.requests[1].Options; !reflect.DeepEqual(options, want) {
+		t.Errorf("options after SetOptions = %v, want %v", options, want)
+	}
+}
+
+func TestGenerateFallsBackToNextModel(t *testing.T) {
+	fake := newFakeOllama(t, func(request OllamaRequest) (int, OllamaResponse) {
+		if request.Model == "mistral:latest" {
+			return http.StatusNotFound, OllamaResponse{}
+		}
+		return http.StatusOK, OllamaResponse{Model: request.Model, Response: "ok"}
+	})
+	client := NewLLMClientWithHost(fake.URL, "", nil)
+	client.SetModels([]string{"mistral:latest", "llama3:8b"})
+
+	for i := 0; i < 2; i++ {
+		if response, err := client.Generate("plan"); err != nil || response != "ok" {
+			t.Fatalf("Generate %d = %q, %v", i+1, response, err)
+		}
+	}
+	// Once llama3 has answered, the missing model isn't asked again
+	if want := []string{"mistral:latest", "llama3:8b", "llama3:8b"}; !reflect.DeepEqual(fake.models(), want) {
+		t.Errorf("models tried = %v, want %v", fake.models(), want)
+	}
+}
+
+func TestGenerateSkipsEmptyResponses(t *testing.T) {
+	fake := newFakeOllama(t, func(request OllamaRequest) (int, OllamaResponse) {
+		if request.Model == "tiny:latest" {
+			return http.StatusOK, OllamaResponse{Response: "  "}
+		}
+		return http.StatusOK, OllamaResponse{Response: "ok"}
+	})
+	client := NewLLMClientWithHost(fake.URL, "", nil)
+	client.SetModels([]string{"tiny:latest", "llama3:8b"})
+
+	if response, err := client.Generate("plan"); err != nil || response != "ok" {
+		t.Errorf("Generate = %q, %v; want the second model's answer", response, err)
+	}
+}
+
+func TestGenerateFailsWhenEveryModelFails(t *testing.T) {
+	fake := newFakeOllama(t, func(OllamaRequest) (int, OllamaResponse) {
+		return http.StatusNotFound, OllamaResponse{}
+	})
+	client := NewLLMClientWithHost(fake.URL, "", nil)
+	client.SetModels([]string{"mistral:latest", "llama3:8b"})
+
+	if _, err := client.Generate("plan"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("err = %v, want the last model's 404", err)
+	}
+	if len(fake.models()) != 2 {
+		t.Errorf("models tried = %v, want both", fake.models())
+	}
+}
+
+// tagsServer answers /api/tags with models as the locally pulled models
+func tagsServer(t *testing.T, models ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var tags struct {
+			Models []map[string]string `json:"models"`
+		}
+		tags.Models = []map[string]string{}
+		for _, model := range models {
+			tags.Models = append(tags.Models, map[string]string{"name": model})
+		}
+		json.NewEncoder(w).Encode(tags)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConnectionFindsPulledModel(t *testing.T) {
+	server := tagsServer(t, "llama3:8b", "mistral:latest")
+	client := NewLLMClientWithHost(server.URL, "", nil)
+	client.SetModels([]string{"phi3", "mistral"})
+
+	if err := client.TestConnection(); err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	// "mistral" matches the pulled mistral:latest and is tried first from now on
+	if order := client.modelOrder(); order[0] != "mistral" {
+		t.Errorf("model order = %v, want mistral first", order)
+	}
+}
+
+func TestConnectionListsAvailableModels(t *testing.T) {
+	client := NewLLMClientWithHost(tagsServer(t, "llama3:8b", "qwen2:7b").URL, "mistral:latest", nil)
+	err := client.TestConnection()
+	if err == nil {
+		t.Fatal("TestConnection succeeded without the configured model")
+	}
+	for _, want := range []string{"mistral:latest", "llama3:8b, qwen2:7b", "ollama pull mistral:latest"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	client = NewLLMClientWithHost(tagsServer(t).URL, "mistral:latest", nil)
+	if err := client.TestConnection(); err == nil || !strings.Contains(err.Error(), "no models are pulled") {
+		t.Errorf("with nothing pulled: err = %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package llm
+
+import "sync"
+
+// Config tunes how goals are routed between the LLM and the rule parser
+type Config struct {
+	// LLMConfidenceThreshold is the minimum rule-parse confidence (0-1)
+	// below which the LLM is tried even if ShouldUseLLM says no
+	LLMConfidenceThreshold float64
+	// MinGoalLengthForLLM is the goal length above which the LLM is always used
+	MinGoalLengthForLLM int
+	// MinLLMConfidence is the confidence an LLM plan must report to be used;
+	// anything lower is discarded in favor of the rule parser
+	MinLLMConfidence float64
+}
+
+// DefaultConfig returns the routing defaults
+func DefaultConfig() Config {
+	return Config{
+		LLMConfidenceThreshold: 0.7,
+		MinGoalLengthForLLM:    80,
+		MinLLMConfidence:       0.5,
+	}
+}
+
+var (
+	configMu sync.RWMutex
+	config   = DefaultConfig()
+)
+
+// SetConfig replaces the routing configuration
+func SetConfig(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// GetConfig returns the current routing configuration
+func GetConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
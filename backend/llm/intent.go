@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Intent is the coarse kind of task a goal describes, used to decide whether
+// the rule parser can handle it or the LLM is needed
+type Intent int
+
+const (
+	IntentUnknown Intent = iota
+	// IntentNavigate covers moving around: opening sites, clicking, scrolling,
+	// going back and forward
+	IntentNavigate
+	IntentSearch
+	IntentForm
+	IntentExtract
+	// IntentComplex is anything long, multi-step or conversational
+	IntentComplex
+)
+
+func (i Intent) String() string {
+	switch i {
+	case IntentNavigate:
+		return "navigate"
+	case IntentSearch:
+		return "search"
+	case IntentForm:
+		return "form"
+	case IntentExtract:
+		return "extract"
+	case IntentComplex:
+		return "complex"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	stepSeparatorRegex = regexp.MustCompile(`\s+(?:and|then)\s+|,\s*`)
+	urlLikeRegex       = regexp.MustCompile(`https?://|www\.|\b[a-z0-9-]+\.(?:com|org|net|edu|gov|io|co|ai|dev|app)\b`)
+)
+
+var (
+	conversationalPhrases = []string{"what is", "tell me", "help me", "can you", "could you", "i want", "i need", "please", "select", "choose", "pick", "compare", "cheapest", "best"}
+	formPhrases           = []string{"fill", "sign up", "sign in", "log in", "login", "register", "submit", "checkout", "form", "enter my", "type "}
+	extractPhrases        = []string{"extract", "get content", "get the content", "get page content", "read the page", "scrape", "summarize", "copy the", "get the text", "get text"}
+	searchPhrases         = []string{"search", "look for", "look up", "find", "google "}
+	navigatePhrases       = []string{"go to", "navigate", "visit", "open", "browse to", "click", "press", "scroll", "go back", "go forward", "previous page", "tab"}
+)
+
+// ClassifyGoalIntent buckets a goal by what it asks for. Checks run from most
+// to least demanding, so "find the cheapest laptop" is complex, not search.
+func ClassifyGoalIntent(goal string) Intent {
+	goal = strings.ToLower(strings.TrimSpace(goal))
+	if goal == "" {
+		return IntentUnknown
+	}
+
+	if len(goal) > GetConfig().MinGoalLengthForLLM || len(stepSeparatorRegex.Split(goal, -1)) > 2 || containsAny(goal, conversationalPhrases) {
+		return IntentComplex
+	}
+	// "click the login button" is a simple click, not a form
+	if strings.HasPrefix(goal, "click ") || strings.HasPrefix(goal, "press ") {
+		return IntentNavigate
+	}
+	if containsAny(goal, formPhrases) {
+		return IntentForm
+	}
+	if containsAny(goal, extractPhrases) {
+		return IntentExtract
+	}
+	if containsAny(goal, searchPhrases) {
+		return IntentSearch
+	}
+	if containsAny(goal, navigatePhrases) || urlLikeRegex.MatchString(goal) {
+		return IntentNavigate
+	}
+	return IntentUnknown
+}
+
+func containsAny(s string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(s, phrase) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,375 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultOpenAIModel is used when no OpenAI model is configured
+const DefaultOpenAIModel = "gpt-4o-mini"
+
+// OpenAIClient talks to servers speaking the OpenAI Chat Completions API
+// (OpenAI, Azure OpenAI, vLLM, LM Studio, ...). Like LLMClient it falls
+// back through its models, asks for JSON output and enforces the token
+// budget.
+type OpenAIClient struct {
+	modelChain
+	baseURL string
+	apiKey  string
+	timeout time.Duration
+	logger  *slog.Logger
+	usage   usageTracker
+	// temperature, topP and seed are sent when set; nil leaves them to the
+	// server's default
+	temperature *float64
+	topP        *float64
+	seed        *int
+}
+
+// ChatMessage is a single message in an OpenAI chat completion request
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatCompletionRequest represents the request to an OpenAI-compatible API
+type ChatCompletionRequest struct {
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	TopP           *float64        `json:"top_p,omitempty"`
+	Seed           *int            `json:"seed,omitempty"`
+}
+
+// ResponseFormat constrains the reply; type "json_object" is JSON mode
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// StreamOptions asks a streamed completion to end with a usage chunk
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatUsage is the token count block of a completion or its last chunk
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatCompletionResponse represents the response from an OpenAI-compatible API
+type ChatCompletionResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int         `json:"index"`
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage chatUsage `json:"usage"`
+}
+
+// chatCompletionChunk is one server-sent event of a streamed completion.
+// Usage is only set on the final chunk, which has no choices.
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+// NewOpenAIClient creates an OpenAI client from OPENAI_BASE_URL and OPENAI_API_KEY
+func NewOpenAIClient(model string, logger *slog.Logger) *OpenAIClient {
+	client := NewOpenAICompatibleClient(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), model)
+	if logger != nil {
+		client.logger = logger
+	}
+	return client
+}
+
+// NewOpenAICompatibleClient creates a client for an explicit base URL and key
+func NewOpenAICompatibleClient(baseURL, apiKey, model string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+
+	temperature := DefaultTemperature
+	client := &OpenAIClient{
+		modelChain:  modelChain{defaultModel: DefaultOpenAIModel},
+		baseURL:     strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1"),
+		apiKey:      apiKey,
+		timeout:     30 * time.Second,
+		logger:      slog.Default(),
+		temperature: &temperature,
+	}
+	client.SetModels([]string{model})
+	return client
+}
+
+// SetOptions overrides the temperature, top_p and seed sent with each
+// request; a nil value leaves one to the server's default. Other Ollama
+// options have no chat completions equivalent and are ignored.
+func (c *OpenAIClient) SetOptions(options map[string]interface{}) {
+	for name, value := range options {
+		switch name {
+		case "temperature":
+			c.temperature = floatOption(value)
+		case "top_p":
+			c.topP = floatOption(value)
+		case "seed":
+			c.seed = intOption(value)
+		default:
+			c.logger.Warn("Ignoring option the chat completions API doesn't take", "option", name)
+		}
+	}
+}
+
+func floatOption(value interface{}) *float64 {
+	switch v := value.(type) {
+	case float64:
+		return &v
+	case int:
+		f := float64(v)
+		return &f
+	}
+	return nil
+}
+
+func intOption(value interface{}) *int {
+	switch v := value.(type) {
+	case int:
+		return &v
+	case float64:
+		i := int(v)
+		return &i
+	}
+	return nil
+}
+
+// newRequest builds a chat completion request in JSON mode, since every
+// prompt we send asks for a JSON command plan
+func (c *OpenAIClient) newRequest(model, prompt string, stream bool) ChatCompletionRequest {
+	request := ChatCompletionRequest{
+		Model: model,
+		Messages: []ChatMessage{
+			{Role: "user", Content: prompt},
+		},
+		Stream:         stream,
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+		Temperature:    c.temperature,
+		TopP:           c.topP,
+		Seed:           c.seed,
+	}
+	if stream {
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	return request
+}
+
+// post sends request to POST /v1/chat/completions, returning the response
+// only when its status is 200
+func (c *OpenAIClient) post(ctx context.Context, request ChatCompletionRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/v1/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	client := &http.Client{
+		Timeout: c.timeout,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to %s: %v", c.baseURL, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("chat completions API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+// Generate sends a prompt to POST /v1/chat/completions and returns the
+// first non-empty response, falling back through the configured models on
+// errors. It fails without sending anything once the token budget is used
+// up.
+func (c *OpenAIClient) Generate(prompt string) (string, error) {
+	if err := c.usage.checkBudget(); err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	order := c.modelOrder()
+	for i, model := range order {
+		response, err := c.generateWithModel(model, prompt)
+		if err == nil && strings.TrimSpace(response) == "" {
+			err = fmt.Errorf("model %s returned an empty response", model)
+		}
+		if err == nil {
+			c.prefer(model, c.logger)
+			return response, nil
+		}
+
+		lastErr = err
+		if i < len(order)-1 {
+			c.logger.Warn("Model failed, trying next", "model", model, "error", err, "next", order[i+1])
+		}
+	}
+	return "", lastErr
+}
+
+// generateWithModel sends a prompt to a single model
+func (c *OpenAIClient) generateWithModel(model, prompt string) (string, error) {
+	resp, err := c.post(context.Background(), c.newRequest(model, prompt, false))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var chatResp ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %v", err)
+	}
+	c.usage.record(chatResp.Usage.PromptTokens, chatResp.Usage.CompletionTokens)
+
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("chat completions API returned no choices")
+	}
+
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// TestConnection checks that GET /v1/models is reachable with our credentials
+func (c *OpenAIClient) TestConnection() error {
+	req, err := http.NewRequest("GET", c.baseURL+"/v1/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("OpenAI-compatible API at %s is not reachable: %v", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenAI-compatible API returned status %d", resp.StatusCode)
+	}
+
+	c.logger.Info("OpenAI-compatible API connection successful", "base_url", c.baseURL)
+	return nil
+}
+
+// GenerateStream sends a prompt with streaming enabled and writes each
+// content delta to out as it arrives. It does not close out. Like the Ollama
+// client, models are tried in order as long as the failing one has not
+// streamed anything yet, and it fails once the token budget is used up.
+func (c *OpenAIClient) GenerateStream(ctx context.Context, prompt string, out chan<- string) error {
+	if err := c.usage.checkBudget(); err != nil {
+		return err
+	}
+
+	var lastErr error
+	order := c.modelOrder()
+	for i, model := range order {
+		emitted, err := c.streamWithModel(ctx, model, prompt, out)
+		if err == nil && emitted == 0 {
+			err = fmt.Errorf("model %s returned an empty response", model)
+		}
+		if err == nil {
+			c.prefer(model, c.logger)
+		}
+		if err == nil || emitted > 0 || ctx.Err() != nil {
+			return err
+		}
+
+		lastErr = err
+		if i < len(order)-1 {
+			c.logger.Warn("Model failed, trying next", "model", model, "error", err, "next", order[i+1])
+		}
+	}
+	return lastErr
+}
+
+// streamWithModel streams a single model's response, returning how many
+// chunks were written to out
+func (c *OpenAIClient) streamWithModel(ctx context.Context, model, prompt string, out chan<- string) (int, error) {
+	resp, err := c.post(ctx, c.newRequest(model, prompt, true))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Chat completions stream server-sent events: "data: {...}" lines,
+	// ending with "data: [DONE]"
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	emitted := 0
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return emitted, nil
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return emitted, fmt.Errorf("failed to decode stream chunk: %v", err)
+		}
+		if chunk.Usage != nil {
+			c.usage.record(chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			select {
+			case out <- choice.Delta.Content:
+				emitted++
+			case <-ctx.Done():
+				return emitted, ctx.Err()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return emitted, fmt.Errorf("failed to read stream: %v", err)
+	}
+	return emitted, nil
+}
@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeOpenAI answers /v1/chat/completions. Requests for a model in replies
+// get its reply, as SSE chunks when streaming; other models get a 404.
+// Requests are recorded.
+type fakeOpenAI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []ChatCompletionRequest
+}
+
+func newFakeOpenAI(t *testing.T, replies map[string][]string) *fakeOpenAI {
+	t.Helper()
+	fake := &fakeOpenAI{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var request ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		fake.mu.Unlock()
+
+		chunks, ok := replies[request.Model]
+		if !ok {
+			http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+			return
+		}
+		usage := chatUsage{PromptTokens: 10, CompletionTokens: len(chunks)}
+
+		if !request.Stream {
+			var response ChatCompletionResponse
+			response.Model = request.Model
+			response.Choices = make([]struct {
+				Index        int         `json:"index"`
+				Message      ChatMessage `json:"message"`
+				FinishReason string      `json:"finish_reason"`
+			}, 1)
+			for _, chunk := range chunks {
+				response.Choices[0].Message.Content += chunk
+			}
+			response.Usage = usage
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"delta": map[string]string{"content": chunk}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+		data, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{}, "usage": usage})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// models lists the model of each request received so far, in order
+func (f *fakeOpenAI) models() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	models := []string{}
+	for _, request := range f.requests {
+		models = append(models, request.Model)
+	}
+	return models
+}
+
+func TestOpenAIGenerateFallsBackThroughModels(t *testing.T) {
+	fake := newFakeOpenAI(t, map[string][]string{"llama3": {`{"steps":[]}`}})
+	client := NewOpenAICompatibleClient(fake.URL+"/v1", "sk-test", "")
+	client.SetModels([]string{"gpt-4o-mini", "llama3"})
+
+	for i := 0; i < 2; i++ {
+		response, err := client.Generate("plan this as JSON")
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if response != `{"steps":[]}` {
+			t.Errorf("response = %q", response)
+		}
+	}
+	// The second goal goes straight to the model that answered
+	if want := []string{"gpt-4o-mini", "llama3", "llama3"}; !reflect.DeepEqual(fake.models(), want) {
+		t.Errorf("models tried = %v, want %v", fake.models(), want)
+	}
+}
+
+func TestOpenAIRequestsUseJSONModeAndOptions(t *testing.T) {
+	fake := newFakeOpenAI(t, map[string][]string{DefaultOpenAIModel: {`{}`}})
+	client := NewOpenAICompatibleClient(fake.URL, "sk-test", "")
+	client.SetOptions(map[string]interface{}{"top_p": 0.9, "seed": 7})
+
+	if _, err := client.Generate("plan this as JSON"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	request := fake.requests[0]
+	if request.ResponseFormat == nil || request.ResponseFormat.Type != "json_object" {
+		t.Errorf("response_format = %+v, want json_object", request.ResponseFormat)
+	}
+	if request.Temperature == nil || *request.Temperature != DefaultTemperature {
+		t.Errorf("temperature = %v, want %v", request.Temperature, DefaultTemperature)
+	}
+	if request.TopP == nil || *request.TopP != 0.9 || request.Seed == nil || *request.Seed != 7 {
+		t.Errorf("top_p = %v, seed = %v, want 0.9 and 7", request.TopP, request.Seed)
+	}
+
+	client.SetOptions(map[string]interface{}{"temperature": nil})
+	if _, err := client.Generate("plan this as JSON"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if fake.requests[1].Temperature != nil {
+		t.Errorf("temperature = %v after removing it", *fake.requests[1].Temperature)
+	}
+}
+
+func TestOpenAIGenerateStreamEmitsDeltas(t *testing.T) {
+	chunks := []string{`{"steps":`, `[]`, `}`}
+	fake := newFakeOpenAI(t, map[string][]string{"llama3": chunks})
+	client := NewOpenAICompatibleClient(fake.URL, "sk-test", "")
+	client.SetModels([]string{"gpt-4o-mini", "llama3"})
+
+	var received []string
+	response, _, err := StreamText(context.Background(), client, "plan this as JSON", func(token string) {
+		received = append(received, token)
+	})
+	if err != nil {
+		t.Fatalf("StreamText: %v", err)
+	}
+	if !reflect.DeepEqual(received, chunks) {
+		t.Errorf("chunks = %q, want %q", received, chunks)
+	}
+	if response != `{"steps":[]}` {
+		t.Errorf("response = %q", response)
+	}
+	if !fake.requests[1].Stream || fake.requests[1].StreamOptions == nil || !fake.requests[1].StreamOptions.IncludeUsage {
+		t.Errorf("request = %+v, want streaming with usage", fake.requests[1])
+	}
+	if stats := client.Stats(); stats.TotalPromptTokens != 10 || stats.TotalCompletionTokens != 3 {
+		t.Errorf("stats = %+v, want the usage chunk's counts", stats)
+	}
+}
+
+func TestOpenAITokenBudget(t *testing.T) {
+	fake := newFakeOpenAI(t, map[string][]string{DefaultOpenAIModel: {`{}`}})
+	client := NewOpenAICompatibleClient(fake.URL, "sk-test", "")
+	client.SetTokenBudget(5)
+
+	if _, err := client.Generate("plan this as JSON"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := client.Generate("plan this as JSON"); !errors.Is(err, ErrTokenBudgetExceeded) {
+		t.Errorf("Generate over budget: err = %v", err)
+	}
+	if err := client.GenerateStream(context.Background(), "plan this as JSON", make(chan string, 1)); !errors.Is(err, ErrTokenBudgetExceeded) {
+		t.Errorf("GenerateStream over budget: err = %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("requests = %d, want none sent over budget", len(fake.requests))
+	}
+}
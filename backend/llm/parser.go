@@ -1,10 +1,14 @@
 package llm
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -17,39 +21,105 @@ type ParsedGoal struct {
 
 // LLMStep represents a single step in the parsed goal
 type LLMStep struct {
-	Action   string `json:"action"`
-	URL      string `json:"url,omitempty"`
-	Selector string `json:"selector,omitempty"`
-	Text     string `json:"text,omitempty"`
+	Action          string   `json:"action"`
+	URL             string   `json:"url,omitempty"`
+	Selector        string   `json:"selector,omitempty"`
+	SelectorType    string   `json:"selectorType,omitempty"`
+	Text            string   `json:"text,omitempty"`
+	MatchText       string   `json:"matchText,omitempty"`
+	ScrollX         int      `json:"scrollX,omitempty"`
+	ScrollY         int      `json:"scrollY,omitempty"`
+	ScrollSelector  string   `json:"scrollSelector,omitempty"`
+	ExtractSelector string   `json:"extractSelector,omitempty"`
+	StoreAs         string   `json:"storeAs,omitempty"`
+	SourceSelector  string   `json:"sourceSelector,omitempty"`
+	TargetSelector  string   `json:"targetSelector,omitempty"`
+	FrameSelector   string   `json:"frameSelector,omitempty"`
+	ShadowPath      []string `json:"shadowPath,omitempty"`
+	Keys            string   `json:"keys,omitempty"`
+	DialogAction    string   `json:"dialogAction,omitempty"`
+	DialogText      string   `json:"dialogText,omitempty"`
+	FileName        string   `json:"fileName,omitempty"`
+	URLPattern      string   `json:"urlPattern,omitempty"`
+	// MockResponse may come back as a JSON string or as the object itself
+	MockResponse json.RawMessage `json:"mockResponse,omitempty"`
+	Condition    string          `json:"condition,omitempty"`
 }
 
 // CommandPayload matches the main package structure (exported for conversion)
 type CommandPayload struct {
-	Action   string
-	URL      string
-	Selector string
-	Text     string
+	Action          string
+	URL             string
+	Selector        string
+	SelectorType    string
+	Text            string
+	MatchText       string
+	ScrollX         int
+	ScrollY         int
+	ScrollSelector  string
+	ExtractSelector string
+	StoreAs         string
+	SourceSelector  string
+	TargetSelector  string
+	FrameSelector   string
+	ShadowPath      []string
+	Keys            string
+	DialogAction    string
+	DialogText      string
+	FileName        string
+	URLPattern      string
+	MockResponse    string
+	Condition       string
 }
 
 // CommandSequence matches the main package structure (exported for conversion)
 type CommandSequence struct {
-	Commands []CommandPayload
-	TaskID   string
-	Total    int
-	Current  int
+	Commands   []CommandPayload
+	TaskID     string
+	Total      int
+	Current    int
+	Confidence float64 // as reported by the LLM
 }
 
-func ParseGoalWithLLM(client *LLMClient, goal string, pageContext *PageContext) (*CommandSequence, error) {
-	prompt := BuildGoalParsingPrompt(goal, pageContext)
+func ParseGoalWithLLM(client LLMBackend, goal string, pageContext *PageContext) (*CommandSequence, error) {
+	return ParseGoalWithLLMProgress(client, goal, pageContext, nil, nil)
+}
+
+// ParseGoalWithLLMProgress is ParseGoalWithLLM that takes the connection's
+// conversation history and streams the response when the backend supports
+// it, calling onProgress with the text received so far
+func ParseGoalWithLLMProgress(client LLMBackend, goal string, pageContext *PageContext, history []ConversationTurn, onProgress func(partial string)) (*CommandSequence, error) {
+	cacheKey := CacheKey(goal, pageContext, history)
+	if responseCache != nil {
+		if cached, ok := responseCache.Get(cacheKey); ok {
+			slog.Debug("LLM cache hit", "goal", goal)
+			return cached, nil
+		}
+	}
+
+	prompt := BuildGoalParsingPrompt(goal, pageContext, history)
 
-	log.Printf("LLM Parsing goal: %s", goal)
+	slog.Info("LLM parsing goal", "goal", goal)
 
-	response, err := client.Generate(prompt)
+	var response string
+	var stats GenerationStats
+	var err error
+	if streamer, ok := client.(StreamingBackend); ok && onProgress != nil {
+		response, stats, err = generateWithProgress(streamer, prompt, onProgress)
+	} else if metered, ok := client.(StatsBackend); ok {
+		response, stats, err = metered.GenerateWithStats(prompt)
+	} else {
+		response, err = client.Generate(prompt)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("LLM generation failed: %v", err)
 	}
+	if stats.TotalDuration > 0 {
+		slog.Info("LLM generation stats", "model", stats.Model, "prompt_tokens", stats.PromptTokens, "eval_count", stats.CompletionTokens,
+			"duration", stats.TotalDuration, "load_duration", stats.LoadDuration, "tokens_per_second", math.Round(stats.TokensPerSecond()*10)/10)
+	}
 
-	log.Printf("LLM Response: %s", response)
+	slog.Debug("LLM response", "response", response)
 
 	jsonStr := extractJSON(response)
 	if jsonStr == "" {
@@ -58,7 +128,7 @@ func ParseGoalWithLLM(client *LLMClient, goal string, pageContext *PageContext)
 
 	var parsedGoal ParsedGoal
 	if err := json.Unmarshal([]byte(jsonStr), &parsedGoal); err != nil {
-		log.Printf("Failed to parse as single JSON, trying to merge multiple objects")
+		slog.Debug("Failed to parse as single JSON, trying to merge multiple objects")
 		mergedJSON := extractAndMergeJSON(response)
 		if mergedJSON != "" {
 			if err := json.Unmarshal([]byte(mergedJSON), &parsedGoal); err != nil {
@@ -69,17 +139,30 @@ func ParseGoalWithLLM(client *LLMClient, goal string, pageContext *PageContext)
 		}
 	}
 
-	sequence := convertToCommandSequence(&parsedGoal)
+	sequence := convertToCommandSequence(&parsedGoal, goal)
 
 	if sequence == nil {
 		return nil, fmt.Errorf("LLM generated no valid commands after filtering invalid actions")
 	}
+	sequence.Confidence = parsedGoal.Confidence
+
+	slog.Info("LLM parsed goal", "commands", len(sequence.Commands), "confidence", parsedGoal.Confidence)
 
-	log.Printf("LLM Parsed into %d commands with confidence %.2f", len(sequence.Commands), parsedGoal.Confidence)
+	if responseCache != nil {
+		responseCache.Set(cacheKey, sequence, 0)
+	}
 
 	return sequence, nil
 }
 
+func generateWithProgress(streamer StreamingBackend, prompt string, onProgress func(partial string)) (string, GenerationStats, error) {
+	var builder strings.Builder
+	return StreamText(context.Background(), streamer, prompt, func(token string) {
+		builder.WriteString(token)
+		onProgress(builder.String())
+	})
+}
+
 func extractJSON(response string) string {
 	codeBlockRegex := regexp.MustCompile("```(?:json)?\\s*([\\s\\S]*?)```")
 	matches := codeBlockRegex.FindStringSubmatch(response)
@@ -181,27 +264,77 @@ func extractAndMergeJSON(response string) string {
 		return ""
 	}
 
-	log.Printf("Merged %d JSON objects into one with %d total steps", len(jsonObjects), len(merged.Steps))
+	slog.Debug("Merged JSON objects", "objects", len(jsonObjects), "steps", len(merged.Steps))
 	return string(mergedJSON)
 }
 
-func convertToCommandSequence(parsed *ParsedGoal) *CommandSequence {
+// planActions are the actions a plan from outside the backend may use.
+// "evaluate" is deliberately missing: page text reaches the prompt, so
+// injected instructions could otherwise have the plan run arbitrary script.
+// It is only available from the rule parser.
+var planActions = map[string]bool{
+	"navigate":             true,
+	"input":                true,
+	"click":                true,
+	"get_content":          true,
+	"scroll":               true,
+	"back":                 true,
+	"forward":              true,
+	"extract":              true,
+	"select_option":        true,
+	"check":                true,
+	"uncheck":              true,
+	"click_text":           true,
+	"press_key":            true,
+	"hover":                true,
+	"drag":                 true,
+	"copy_to_clipboard":    true,
+	"paste_from_clipboard": true,
+	"upload_file":          true,
+	"key":                  true,
+	"handle_dialog":        true,
+	"intercept_request":    true,
+	"clear_intercepts":     true,
+}
+
+// IsPlanAction reports whether action may appear in an LLM plan or a
+// template
+func IsPlanAction(action string) bool {
+	return planActions[action]
+}
+
+func convertToCommandSequence(parsed *ParsedGoal, goal string) *CommandSequence {
 	commands := []CommandPayload{}
-	validActions := map[string]bool{
-		"navigate":    true,
-		"input":       true,
-		"click":       true,
-		"get_content": true,
-	}
 
 	for _, step := range parsed.Steps {
-		if !validActions[step.Action] {
-			log.Printf("Filtering out invalid action: %s", step.Action)
+		if step.SelectorType == "xpath" {
+			step.Selector = sanitizeStepXPath(step.Selector)
+		} else {
+			step.SelectorType = ""
+			step.Selector = sanitizeStepSelector(step.Selector)
+		}
+		step.ScrollSelector = sanitizeStepSelector(step.ScrollSelector)
+		step.ExtractSelector = sanitizeStepSelector(step.ExtractSelector)
+		step.SourceSelector = sanitizeStepSelector(step.SourceSelector)
+		step.TargetSelector = sanitizeStepSelector(step.TargetSelector)
+		step.FrameSelector = sanitizeStepSelector(step.FrameSelector)
+		for i, host := range step.ShadowPath {
+			step.ShadowPath[i] = sanitizeStepSelector(host)
+		}
+		step.Condition = sanitizeStepSelector(step.Condition)
+
+		if !planActions[step.Action] {
+			slog.Debug("Filtering out invalid action", "action", step.Action)
 			continue
 		}
 
 		cmd := CommandPayload{
-			Action: step.Action,
+			Action:        step.Action,
+			Condition:     step.Condition,
+			FrameSelector: step.FrameSelector,
+		}
+		if !slices.Contains(step.ShadowPath, "") {
+			cmd.ShadowPath = step.ShadowPath
 		}
 
 		switch step.Action {
@@ -209,22 +342,74 @@ func convertToCommandSequence(parsed *ParsedGoal) *CommandSequence {
 			cmd.URL = step.URL
 		case "input":
 			cmd.Selector = step.Selector
+			cmd.SelectorType = step.SelectorType
 			cmd.Text = step.Text
-		case "click":
+		case "click", "hover", "select_option", "check", "uncheck", "press_key", "copy_to_clipboard", "paste_from_clipboard":
 			cmd.Selector = step.Selector
-		case "get_content":
+			cmd.SelectorType = step.SelectorType
+			cmd.Text = step.Text
+		case "click_text":
+			cmd.MatchText = step.MatchText
+			if cmd.MatchText == "" {
+				cmd.MatchText = step.Text
+			}
+		case "get_content", "back", "forward", "clear_intercepts":
 			// No additional fields needed
+		case "scroll":
+			cmd.Text = step.Text
+			cmd.ScrollX = step.ScrollX
+			cmd.ScrollY = step.ScrollY
+			cmd.ScrollSelector = step.ScrollSelector
+		case "extract":
+			cmd.ExtractSelector = step.ExtractSelector
+			cmd.StoreAs = step.StoreAs
+		case "drag":
+			cmd.SourceSelector = step.SourceSelector
+			cmd.TargetSelector = step.TargetSelector
+		case "key":
+			cmd.Selector = step.Selector
+			cmd.SelectorType = step.SelectorType
+			cmd.Keys = step.Keys
+		case "handle_dialog":
+			switch step.DialogAction {
+			case "", "accept":
+				cmd.DialogAction = "accept"
+			case "dismiss":
+				cmd.DialogAction = "dismiss"
+			case "type":
+				cmd.DialogAction = "type"
+				cmd.DialogText = step.DialogText
+			default:
+				slog.Debug("Filtering out handle_dialog with unknown dialogAction", "dialog_action", step.DialogAction)
+				continue
+			}
+		case "upload_file":
+			if err := ValidateFileName(step.FileName); err != nil {
+				slog.Warn("Dropping upload step with unsafe file name", "error", err)
+				continue
+			}
+			cmd.Selector = step.Selector
+			cmd.SelectorType = step.SelectorType
+			cmd.FileName = step.FileName
+		case "intercept_request":
+			mock, ok := mockResponseJSON(step.MockResponse)
+			if step.URLPattern == "" || !ok {
+				slog.Debug("Filtering out intercept_request without a URL pattern and JSON response", "url_pattern", step.URLPattern)
+				continue
+			}
+			cmd.URLPattern = step.URLPattern
+			cmd.MockResponse = mock
 		}
 
 		commands = append(commands, cmd)
 	}
 
 	if len(commands) == 0 {
-		log.Printf("No valid commands after filtering invalid actions")
+		slog.Warn("No valid commands after filtering invalid actions")
 		return nil
 	}
 
-	commands = postProcessCommands(commands)
+	commands = postProcessCommands(commands, goal)
 
 	return &CommandSequence{
 		Commands: commands,
@@ -233,27 +418,127 @@ func convertToCommandSequence(parsed *ParsedGoal) *CommandSequence {
 	}
 }
 
-func postProcessCommands(commands []CommandPayload) []CommandPayload {
+// sanitizeStepXPath blanks out XPath selectors that fail SanitizeXPath
+func sanitizeStepXPath(xpath string) string {
+	sanitized, err := SanitizeXPath(xpath)
+	if err != nil {
+		slog.Warn("Dropping malformed XPath from LLM plan", "error", err)
+		return ""
+	}
+	return sanitized
+}
+
+// sanitizeStepSelector blanks out selectors that fail SanitizeSelector
+func sanitizeStepSelector(selector string) string {
+	sanitized, err := SanitizeSelector(selector)
+	if err != nil {
+		slog.Warn("Dropping malformed selector from LLM plan", "error", err)
+		return ""
+	}
+	return sanitized
+}
+
+// mockResponseJSON returns an intercept_request response as compact JSON
+// text, whether the model sent it as a string of JSON or inline
+func mockResponseJSON(raw json.RawMessage) (string, bool) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		raw = json.RawMessage(text)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", false
+	}
+	return compact.String(), true
+}
+
+// destructiveClickRegex matches labels and selectors of buttons that
+// usually ask for confirmation first
+var destructiveClickRegex = regexp.MustCompile(`(?i)\b(?:delete|remove|cancel[\s_-]*(?:my[\s_-]*)?(?:account|subscription|membership|order))`)
+
+// isDestructiveClick reports whether cmd likely clicks a button that opens
+// a confirm() dialog
+func isDestructiveClick(cmd CommandPayload) bool {
+	return destructiveClickRegex.MatchString(cmd.Text) || destructiveClickRegex.MatchString(cmd.Selector)
+}
+
+// submitButtonRegex matches selectors aimed at a form's submit button
+var submitButtonRegex = regexp.MustCompile(`(?i)type=['"]?submit|\bsubmit`)
+
+func postProcessCommands(commands []CommandPayload, goal string) []CommandPayload {
 	filtered := []CommandPayload{}
 
 	for i, cmd := range commands {
+		if cmd.Action == "click" && cmd.Selector == "*" {
+			// The wildcard fallback clicks whatever comes first; search by
+			// text instead, taking it from the goal when the step has none
+			matchText := cmd.Text
+			if matchText == "" {
+				matchText = clickTargetFromGoal(goal)
+			}
+			if matchText != "" {
+				slog.Debug("Converting wildcard click to click_text", "match_text", matchText)
+				cmd = CommandPayload{Action: "click_text", MatchText: matchText, Condition: cmd.Condition}
+			}
+		}
+
+		if cmd.URL != "" {
+			if err := ValidateCommandURL(cmd.URL); err != nil {
+				slog.Warn("Removing command with unsafe URL", "action", cmd.Action, "error", err)
+				continue
+			}
+		}
+
 		if cmd.Action == "navigate" && cmd.URL != "" {
 			if strings.Contains(cmd.URL, "example.com") || strings.Contains(cmd.URL, "checkout") {
-				log.Printf("Removing hallucinated navigation: %s", cmd.URL)
+				slog.Debug("Removing hallucinated navigation", "url", cmd.URL)
 				continue
 			}
 		}
 
 		if cmd.Action == "click" && cmd.Selector != "" {
 			if strings.Contains(cmd.Selector, "example") {
-				log.Printf("Removing invalid selector: %s", cmd.Selector)
+				slog.Debug("Removing invalid selector", "selector", cmd.Selector)
 				continue
 			}
 		}
 
+		if cmd.Action == "click" && cmd.Text == "" && submitButtonRegex.MatchString(cmd.Selector) && len(filtered) > 0 {
+			// Typing leaves the field focused, and Enter submits its form
+			// without depending on a guessed button selector
+			if prev := filtered[len(filtered)-1]; prev.Action == "input" && !strings.Contains(prev.Selector, "textarea") {
+				slog.Debug("Converting submit click to Enter", "selector", cmd.Selector, "input", prev.Selector)
+				cmd = CommandPayload{Action: "key", Keys: "Enter", Selector: prev.Selector, SelectorType: prev.SelectorType, Condition: cmd.Condition}
+			}
+		}
+
+		if cmd.Action == "click" && isDestructiveClick(cmd) && (len(filtered) == 0 || filtered[len(filtered)-1].Action != "handle_dialog") {
+			// Sites confirm() before deleting; the dialog would block the
+			// page, so the answer has to be armed before the click
+			slog.Debug("Inserting handle_dialog before destructive click", "selector", cmd.Selector, "text", cmd.Text)
+			filtered = append(filtered, CommandPayload{Action: "handle_dialog", DialogAction: "accept"})
+		}
+
+		if cmd.Action == "click" && cmd.SelectorType != "xpath" {
+			if trigger := hoverTargetForClick(cmd.Selector); trigger != "" && !precededByHover(filtered, trigger) {
+				slog.Debug("Inserting hover before dropdown click", "selector", cmd.Selector, "hover", trigger)
+				filtered = append(filtered, CommandPayload{Action: "hover", Selector: trigger})
+			}
+		}
+
+		if cmd.Action == "click" && isOffscreenSelector(cmd.Selector) {
+			if len(filtered) == 0 || filtered[len(filtered)-1].Action != "scroll" {
+				slog.Debug("Inserting scroll before off-screen click", "selector", cmd.Selector)
+				filtered = append(filtered, CommandPayload{
+					Action:         "scroll",
+					ScrollSelector: cmd.Selector,
+				})
+			}
+		}
+
 		if cmd.Action == "get_content" && i == 0 && len(commands) > 1 {
 			if i+1 < len(commands) && commands[i+1].Action == "click" {
-				log.Printf("Removing unnecessary get_content before click")
+				slog.Debug("Removing unnecessary get_content before click")
 				continue
 			}
 		}
@@ -262,49 +547,88 @@ func postProcessCommands(commands []CommandPayload) []CommandPayload {
 	}
 
 	if len(filtered) == 0 {
-		log.Printf("Post-processing removed all commands, using original")
+		slog.Debug("Post-processing removed all commands, using original")
 		return commands
 	}
 
 	return filtered
 }
 
-func ShouldUseLLM(goal string) bool {
-	goal = strings.ToLower(strings.TrimSpace(goal))
+var quotedGoalTermRegex = regexp.MustCompile(`"([^"]+)"|“([^”]+)”|(?:^|\s)'([^']+)'(?:\s|$)`)
 
-	ambiguousKeywords := []string{
-		"find", "get", "show", "look for", "look up",
-		"what is", "tell me", "help me", "can you",
-		"i want", "i need", "please",
-		"select", "choose", "pick",
-	}
+var clickTargetRegex = regexp.MustCompile(`(?i)\b(?:click|press|tap)\s+(?:on\s+)?(?:the\s+)?(.+?)(?:\s+(?:button|link|tab))?(?:\s+(?:and|then)\b.*)?[.!]?$`)
 
-	for _, keyword := range ambiguousKeywords {
-		if strings.Contains(goal, keyword) {
-			return true
+// clickTargetFromGoal guesses what the user wants clicked: a quoted term if
+// there is one, otherwise the words after "click", e.g. "Sign in" from
+// "click the Sign in button"
+func clickTargetFromGoal(goal string) string {
+	if m := quotedGoalTermRegex.FindStringSubmatch(goal); m != nil {
+		for _, group := range m[1:] {
+			if group != "" {
+				return group
+			}
 		}
 	}
-
-	if len(goal) > 80 {
-		return true
+	if m := clickTargetRegex.FindStringSubmatch(strings.TrimSpace(goal)); m != nil {
+		return strings.TrimSpace(m[1])
 	}
+	return ""
+}
+
+var (
+	// nestedListRegex captures the menu entry owning a nested list, like
+	// "nav > ul > li" in "nav > ul > li > ul > li > a"
+	nestedListRegex = regexp.MustCompile(`(?i)^(.*?\bli\b[^\s>]*)\s*>?\s*(?:ul|ol)\b`)
+	// submenuClassRegex captures what comes before a submenu container, like
+	// "#menu" in "#menu .dropdown-menu a"
+	submenuClassRegex = regexp.MustCompile(`(?i)^(.+?)\s*>?\s*(?:ul|ol|div)?\.(?:sub-?menu|dropdown-menu|dropdown-content)\b`)
+	// dropdownTriggerRegex matches selectors for top-level menu entries
+	dropdownTriggerRegex = regexp.MustCompile(`(?i)^nav\s*>\s*(?:ul|ol)\s*>\s*li(?:\s*>\s*a)?$|\[aria-haspopup|\.dropdown-toggle|\.has-(?:sub-?menu|dropdown)|\.menu-item-has-children`)
+)
 
-	simplePatterns := []string{
-		"navigate to", "go to", "visit",
-		"search for", "click", "type",
+// hoverTargetForClick returns what to hover before clicking selector so its
+// menu opens: the parent entry for a submenu item, or the selector itself
+// for a top-level menu trigger. It returns "" for anything else.
+func hoverTargetForClick(selector string) string {
+	selector = strings.TrimSpace(selector)
+	if strings.Contains(selector, ",") {
+		return ""
+	}
+	if m := nestedListRegex.FindStringSubmatch(selector); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	if m := submenuClassRegex.FindStringSubmatch(selector); m != nil {
+		return strings.TrimSpace(m[1])
 	}
+	if dropdownTriggerRegex.MatchString(selector) {
+		return selector
+	}
+	return ""
+}
 
-	hasSimplePattern := false
-	for _, pattern := range simplePatterns {
-		if strings.Contains(goal, pattern) {
-			hasSimplePattern = true
-			break
+// precededByHover reports whether the last command already hovers selector
+func precededByHover(commands []CommandPayload, selector string) bool {
+	return len(commands) > 0 && commands[len(commands)-1].Action == "hover" && commands[len(commands)-1].Selector == selector
+}
+
+// isOffscreenSelector reports whether a selector targets something that is
+// usually below the fold, like footer links
+func isOffscreenSelector(selector string) bool {
+	selector = strings.ToLower(selector)
+	patterns := []string{"footer", "contentinfo", "bottom", "pagination", "load-more", "load more"}
+	for _, pattern := range patterns {
+		if strings.Contains(selector, pattern) {
+			return true
 		}
 	}
+	return false
+}
 
-	if !hasSimplePattern && len(goal) > 30 {
+// ShouldUseLLM routes goals the rule parser handles poorly to the LLM
+func ShouldUseLLM(goal string) bool {
+	switch ClassifyGoalIntent(goal) {
+	case IntentComplex, IntentForm, IntentUnknown:
 		return true
 	}
-
 	return false
 }
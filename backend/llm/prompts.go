@@ -1,9 +1,33 @@
 package llm
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxPromptElements caps how many interactive elements the prompt lists
+const maxPromptElements = 40
+
+// maxPromptFormFields caps how many form fields the prompt lists
+const maxPromptFormFields = 30
+
+// maxPromptTurns caps how many earlier conversation turns the prompt includes
+const maxPromptTurns = 6
+
+// ConversationTurn is one earlier exchange on the same connection: a goal
+// the user sent or the plan the assistant made for it
+type ConversationTurn struct {
+	Role    string `json:"role"` // "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// BuildGoalParsingPrompt creates a prompt for parsing user goals into browser
+// commands. The last few turns of history are included so follow-up goals
+// like "now search for Python" can build on earlier ones.
+func BuildGoalParsingPrompt(goal string, pageContext *PageContext, history []ConversationTurn) string {
+	goal = SanitizeGoalForPrompt(goal)
 
-// BuildGoalParsingPrompt creates a prompt for parsing user goals into browser commands
-func BuildGoalParsingPrompt(goal string, pageContext *PageContext) string {
 	basePrompt := `You are an intelligent browser automation assistant. Parse the user's goal into executable browser commands.
 
 CRITICAL: Return ONLY ONE JSON object. Put ALL steps in a single "steps" array. Do NOT return multiple JSON objects.
@@ -30,8 +54,27 @@ ALL in the same JSON object's steps array.
 Available actions:
 - "navigate": Navigate to a URL (requires "url" field)
 - "input": Type text into an input field (requires "selector" and "text" fields)
-- "click": Click an element (requires "selector" field)
+- "click": Click an element (requires "selector" and/or "text"). "text" is the element's visible label; when set, the element whose text matches it is clicked among those matching "selector", e.g. {"action": "click", "selector": "a, button", "text": "Contact Us"}. Prefer "text" when the goal names a button or link by its label
+- "hover": Move the mouse over an element to open its dropdown menu or tooltip (requires "selector" and/or "text", like "click"), e.g. {"action": "hover", "selector": "nav li", "text": "Products"}. Hover a menu entry before clicking an item in its submenu
+- "drag": Drag one element onto another, e.g. a kanban card to a column or a file to a drop zone (requires "sourceSelector" and "targetSelector"), e.g. {"action": "drag", "sourceSelector": "#card-42", "targetSelector": "[data-column='done']"}
+- "key": Send a keyboard shortcut (requires "keys": modifiers Control, Shift, Alt or Meta joined to a key with "+", or a single key), to the element matching the optional "selector" or to the page, e.g. {"action": "key", "keys": "Control+k"} or {"action": "key", "keys": "c"} for Gmail's compose shortcut
+- "handle_dialog": Answer the page's alert, confirm or prompt dialog ("dialogAction" is "accept", "dismiss" or "type", with "dialogText" as the answer to a prompt). Put it BEFORE the step that opens the dialog, e.g. {"action": "handle_dialog", "dialogAction": "accept"} before clicking a Delete button
+- "intercept_request": Answer the page's fetch/XHR requests whose URL matches "urlPattern" (a glob with * wildcards, or a regex between slashes) with the JSON in "mockResponse", to test how the page handles an API response, e.g. {"action": "intercept_request", "urlPattern": "*/api/users*", "mockResponse": "{\"users\": []}"}. Put it before the step that makes the request. "clear_intercepts" (no fields) removes them all
+- "upload_file": Attach a file from the user's downloads to a file input (requires "fileName", a bare file name without any folder, and "selector" for the <input type="file">), e.g. {"action": "upload_file", "selector": "input[type='file']", "fileName": "resume.pdf"}
+- "select_option": Choose an option in a <select> dropdown (requires "selector" for the select and "text" for the option's value or visible label), e.g. {"action": "select_option", "selector": "select[name='country']", "text": "Canada"}
+- "click_text": Click the visible element whose text or aria-label contains "matchText", when no selector is reliable, e.g. {"action": "click_text", "matchText": "Accept all"}
+- "check" / "uncheck": Tick or clear a checkbox, or choose a radio button with "check" (requires "selector"; "text" optionally names the label among the matches), e.g. {"action": "check", "selector": "input[type='checkbox']", "text": "I agree to the terms"}
+- "press_key": Press a key (requires "text" with the key name: "Enter", "Tab", "Escape", "ArrowDown", ...) in the element matching the optional "selector", or in the focused element, e.g. {"action": "press_key", "selector": "input[name='q']", "text": "Enter"}
+- "copy_to_clipboard" / "paste_from_clipboard": Copy the text of the element matching "selector" to the clipboard, or paste the clipboard into the field matching "selector". Only use these when the value moves between tabs; within one tab prefer "extract" with "storeAs"
 - "get_content": Extract page content (no additional fields)
+- "scroll": Scroll the page. Set "text" to "down", "up", "bottom", "top" or a pixel count (e.g. {"action": "scroll", "text": "bottom"}), or set "scrollSelector" to scroll an element into view
+- "extract": Read the text of the element matching "extractSelector" and save it under the name in "storeAs". Later steps can use it as {{name}} in "text" or "url", e.g. {"action": "extract", "extractSelector": "#order-id", "storeAs": "orderId"} then {"action": "input", "selector": "#search", "text": "{{orderId}}"}
+- "back": Go back one page in the tab's history (no additional fields)
+- "forward": Go forward one page in the tab's history (no additional fields)
+
+Selectors are CSS by default. When a CSS selector would be too fragile, e.g. to match an element by its exact text or by an ancestor, a "click" or "input" step may set "selectorType": "xpath" and give an XPath in "selector", e.g. {"action": "click", "selector": "//button[normalize-space()='Submit']", "selectorType": "xpath"}
+
+Any step may also set "condition" to a CSS selector; the step is skipped unless that selector matches an element on the page, e.g. {"action": "click", "selector": "#accept-cookies", "condition": "#cookie-banner"}
 
 Rules:
 - For search goals like "find X" or "search for X" or "look for X": navigate to google.com → input X → click search button
@@ -44,14 +87,16 @@ Rules:
 - Use input[name='field-keywords'] for Amazon search box
 - Use button[name='btnK'] or input[type='submit'] for Google search button
 - Use input[type='submit'][value='Go'] or button for Amazon search
+- When a site's search button is unknown, submit the search with "press_key" "Enter" on the search box instead of guessing a button selector
 
 Context-Aware Commands (when page context is available):
 - Use page content to understand what elements are available and generate accurate selectors
-- "click on X" where X is mentioned in page content: Search page content for X, generate selector for that element
+- "click on X" where X is mentioned in page content: Search page content for X, generate selector for that element, or click by label with "text": "X"
 - "select X": Find X in page content, click on it
 - Generate selectors based on actual page structure visible in the context
 - NEVER use "find", "search", "locate" actions - they don't exist
-- ONLY use: "navigate", "input", "click", "get_content"
+- "select X from the Y dropdown" or "choose X": use "select_option", not "click"
+- ONLY use: "navigate", "input", "click", "click_text", "hover", "drag", "select_option", "check", "uncheck", "press_key", "key", "copy_to_clipboard", "paste_from_clipboard", "upload_file", "handle_dialog", "intercept_request", "clear_intercepts", "get_content", "scroll", "back", "forward", "extract"
 
 Return ONLY the JSON object, nothing else:`
 
@@ -75,6 +120,37 @@ CURRENT PAGE CONTEXT (You are currently on this page):
 - Page Content Preview: %s`, textPreview)
 		}
 
+		if elements := describeElements(pageContext.Elements, maxPromptElements); elements != "" {
+			contextInfo += `
+- Interactive Elements (selector: text):` + elements
+			if strings.Contains(elements, "(shadowPath ") {
+				contextInfo += `
+  Elements marked with a shadowPath are inside web components: copy it into the step as "shadowPath" along with the selector, e.g. {"action": "click", "shadowPath": ["my-app", "settings-panel"], "selector": "button.save"}`
+			}
+		}
+
+		if lang := pageContext.Language; lang != "" && !strings.HasPrefix(strings.ToLower(lang), "en") {
+			contextInfo += fmt.Sprintf(`
+- Page Language: %s. Button and link labels are in this language, so match "text" against the labels as they appear on the page rather than English ones. Keep search terms as the user wrote them unless the goal asks for a translation`, lang)
+		}
+
+		if product := pageContext.Product; product != nil && product.Price != "" {
+			contextInfo += fmt.Sprintf(`
+- Product: %s, price %s %s, availability %s. Compare against it for conditions like "if under $50", and skip steps whose condition does not hold`,
+				product.Name, product.Price, product.Currency, product.Availability)
+		}
+
+		if len(pageContext.Iframes) > 0 {
+			contextInfo += `
+- Embedded Frames (iframe src): ` + strings.Join(pageContext.Iframes, ", ") + `
+  Elements inside a frame are not in the page itself. To act on one, add "frameSelector" with a CSS selector for its <iframe> to the step, e.g. {"action": "input", "frameSelector": "iframe[src*='js.stripe.com']", "selector": "input[name='cardnumber']", "text": "4242 4242 4242 4242"}`
+		}
+
+		if fields := describeFormFields(pageContext.FormFields, maxPromptFormFields); fields != "" {
+			contextInfo += `
+- Form Fields (selector: label [type, name]); use these selectors for "input" steps:` + fields
+		}
+
 		contextInfo += `
 
 IMPORTANT: Since you have page context, use it to:
@@ -85,6 +161,13 @@ IMPORTANT: Since you have page context, use it to:
 		basePrompt += contextInfo
 	}
 
+	if turns := describeHistory(history, maxPromptTurns); turns != "" {
+		basePrompt += `
+
+PREVIOUS CONTEXT (earlier goals in this session, oldest first):` + turns + `
+Use it to resolve follow-up goals like "now search for X" or "click it"; do not repeat steps that already ran.`
+	}
+
 	basePrompt += fmt.Sprintf("\n\nUser Goal: %s\n\nReturn JSON:", goal)
 
 	return basePrompt
@@ -94,10 +177,14 @@ IMPORTANT: Since you have page context, use it to:
 type PageContext struct {
 	URL         string
 	Title       string
-	ContentType string // "search", "form", "navigation", "general", "ecommerce"
+	ContentType string // "login", "search", "form", "navigation", "general", "ecommerce"
+	Language    string // <html lang> of the page, e.g. "de"
 	Elements    []ElementInfo
-	HTML        string // Full HTML for context-aware parsing
-	Text        string // Page text content
+	FormFields  []FormFieldInfo
+	Product     *ProductInfo // set on e-commerce pages showing a price
+	Iframes     []string     // src URLs of the page's iframes
+	HTML        string       // Full HTML for context-aware parsing
+	Text        string       // Page text content
 }
 
 // ElementInfo describes a page element
@@ -108,4 +195,98 @@ type ElementInfo struct {
 	Name     string
 	Text     string
 	Selector string
+	// SelectorType is "xpath" for XPath selectors and empty for CSS
+	SelectorType string
+	// ShadowPath lists the shadow hosts enclosing the element, outermost
+	// first; Selector is relative to the innermost shadow root
+	ShadowPath []string
+}
+
+// ProductInfo is the product shown on an e-commerce page
+type ProductInfo struct {
+	Name         string
+	Price        string
+	Currency     string
+	Availability string // "in_stock", "out_of_stock" or "unknown"
+}
+
+// FormFieldInfo describes an input, textarea or select on the page
+type FormFieldInfo struct {
+	Selector     string
+	SelectorType string
+	Label        string
+	InputType    string
+	Required     bool
+	Name         string
+}
+
+// describeHistory lists the last limit turns of history, one "Role: content"
+// per line, with injection phrasing stripped like the goal itself
+func describeHistory(history []ConversationTurn, limit int) string {
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	var b strings.Builder
+	for _, turn := range history {
+		content := stripPromptInjection(turn.Content)
+		if content == "" {
+			continue
+		}
+		role := "User"
+		if turn.Role == "assistant" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&b, "\n- %s: %s", role, content)
+	}
+	return b.String()
+}
+
+// describeFormFields lists up to limit fields, one
+// "selector: label [type, name, required]" per line
+func describeFormFields(fields []FormFieldInfo, limit int) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i == limit {
+			break
+		}
+		details := []string{field.InputType}
+		if field.Name != "" {
+			details = append(details, "name="+field.Name)
+		}
+		if field.Required {
+			details = append(details, "required")
+		}
+		selector := field.Selector
+		if field.SelectorType == "xpath" {
+			selector = "xpath " + selector
+		}
+		fmt.Fprintf(&b, "\n  %s: %s [%s]", selector, field.Label, strings.Join(details, ", "))
+	}
+	return b.String()
+}
+
+// describeElements lists up to limit elements that have visible text, one
+// "selector: text" per line, so click-by-text goals can use real selectors
+func describeElements(elements []ElementInfo, limit int) string {
+	var b strings.Builder
+	count := 0
+	for _, element := range elements {
+		if element.Text == "" || element.Selector == "" {
+			continue
+		}
+		if count == limit {
+			break
+		}
+		if element.SelectorType == "xpath" {
+			fmt.Fprintf(&b, "\n  xpath %s: %s", element.Selector, element.Text)
+		} else if len(element.ShadowPath) > 0 {
+			shadowPath, _ := json.Marshal(element.ShadowPath)
+			fmt.Fprintf(&b, "\n  %s (shadowPath %s): %s", element.Selector, shadowPath, element.Text)
+		} else {
+			fmt.Fprintf(&b, "\n  %s: %s", element.Selector, element.Text)
+		}
+		count++
+	}
+	return b.String()
 }
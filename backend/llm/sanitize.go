@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// promptInjectionPatterns match phrasing that tries to override the goal
+// parsing instructions or impersonate another chat role
+var promptInjectionPatterns = []*regexp.Regexp{
+	// "ignore previous instructions", "forget all the rules above", ...
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\b(?:\s+(?:all|any|the|your|my|of|previous|prior|above|earlier|preceding|system|these|those))*\s+(?:instructions?|prompts?|rules?|context|messages?|directions?)\b(?:\s+(?:above|before))?`),
+	// "ignore ignore ignore" padding
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget)\b(?:\W+(?:ignore|disregard|forget)\b)+`),
+	regexp.MustCompile(`(?i)\b(?:system|assistant|developer)\s*:`),
+	regexp.MustCompile(`<\|[^|>]*\|>|<\||\|>`),
+	regexp.MustCompile(`(?i)\[/?(?:INST|SYS)\]|<</?SYS>>`),
+	regexp.MustCompile("```"),
+}
+
+// SanitizeGoalForPrompt strips prompt-injection phrasing and chat template
+// tokens from a user goal and collapses it onto one line, so it can't open a
+// new section of the prompt. A warning is logged when anything is removed.
+func SanitizeGoalForPrompt(goal string) string {
+	sanitized := stripPromptInjection(goal)
+	if sanitized != strings.Join(strings.Fields(goal), " ") {
+		slog.Warn("Removed possible prompt injection from goal", "goal", goal, "sanitized", sanitized)
+	}
+	return sanitized
+}
+
+// ValidateFileName rejects upload file names that could point outside the
+// downloads folder. Only a bare name is allowed: no path separators, drive
+// letters, control characters or "." and ".." entries.
+func ValidateFileName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("file name is empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("file name %q is not a file", name)
+	case strings.ContainsAny(name, `/\:`):
+		return fmt.Errorf("file name %q must not contain a path", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("file name %q contains control characters", name)
+		}
+	}
+	return nil
+}
+
+// stripPromptInjection is SanitizeGoalForPrompt without the warning
+func stripPromptInjection(text string) string {
+	for _, pattern := range promptInjectionPatterns {
+		text = pattern.ReplaceAllString(text, " ")
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// maxSelectorLength bounds selectors so a runaway LLM response can't ship
+// kilobytes of "selector" to the extension
+const maxSelectorLength = 1024
+
+// SanitizeSelector checks that sel parses as a CSS selector list before it is
+// sent to the extension. The trimmed selector is returned when valid; empty
+// input is valid and returned as is.
+func SanitizeSelector(sel string) (string, error) {
+	sel = strings.TrimSpace(sel)
+	if sel == "" {
+		return "", nil
+	}
+	if len(sel) > maxSelectorLength {
+		return "", fmt.Errorf("selector is %d bytes, limit is %d", len(sel), maxSelectorLength)
+	}
+	if strings.IndexFunc(sel, func(r rune) bool { return unicode.IsControl(r) || r == '`' }) != -1 {
+		return "", fmt.Errorf("selector %q contains control characters or backticks", sel)
+	}
+	if _, err := cascadia.ParseGroup(sel); err != nil {
+		return "", fmt.Errorf("invalid selector %q: %v", sel, err)
+	}
+	return sel, nil
+}
+
+// SanitizeXPath does for XPath selectors what SanitizeSelector does for CSS,
+// short of parsing: the expression must be a location path starting with
+// "/", "./" or "(" and have balanced brackets and quotes
+func SanitizeXPath(xpath string) (string, error) {
+	xpath = strings.TrimSpace(xpath)
+	if xpath == "" {
+		return "", nil
+	}
+	if len(xpath) > maxSelectorLength {
+		return "", fmt.Errorf("XPath is %d bytes, limit is %d", len(xpath), maxSelectorLength)
+	}
+	if strings.IndexFunc(xpath, func(r rune) bool { return unicode.IsControl(r) || r == '`' }) != -1 {
+		return "", fmt.Errorf("XPath %q contains control characters or backticks", xpath)
+	}
+	if !strings.HasPrefix(xpath, "/") && !strings.HasPrefix(xpath, "./") && !strings.HasPrefix(xpath, "(") {
+		return "", fmt.Errorf("XPath %q must start with /, ./ or (", xpath)
+	}
+
+	var open []rune
+	var quote rune
+	for _, r := range xpath {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '[' || r == '(':
+			open = append(open, r)
+		case r == ']' || r == ')':
+			want := '['
+			if r == ')' {
+				want = '('
+			}
+			if len(open) == 0 || open[len(open)-1] != want {
+				return "", fmt.Errorf("XPath %q has unbalanced %c", xpath, r)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if quote != 0 || len(open) > 0 {
+		return "", fmt.Errorf("XPath %q has an unterminated quote or bracket", xpath)
+	}
+	return xpath, nil
+}
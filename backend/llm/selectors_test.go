@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+		ok       bool
+	}{
+		{"  #login  ", "#login", true},
+		{"input[name='q'], textarea[name='q']", "input[name='q'], textarea[name='q']", true},
+		{"nav > ul li:nth-of-type(2) a", "nav > ul li:nth-of-type(2) a", true},
+		{"", "", true},
+		{"div[", "", false},
+		{"a')); alert(1); //", "", false},
+		{"button`${document.cookie}`", "", false},
+		{"#a\n#b", "", false},
+		{strings.Repeat("div ", 300), "", false},
+	}
+	for _, tt := range tests {
+		got, err := SanitizeSelector(tt.selector)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("SanitizeSelector(%q) = %q, %v; want %q, ok %v", tt.selector, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLLMPlanDropsMalformedSelectors(t *testing.T) {
+	backend := &fakeBackend{response: `{"confidence":0.9,"steps":[
+		{"action":"click","selector":"button')); alert(1); //"},
+		{"action":"input","selector":"#q","text":"cats"}
+	]}`}
+	sequence, err := ParseGoalWithLLM(backend, "search cats", nil)
+	if err != nil {
+		t.Fatalf("ParseGoalWithLLM: %v", err)
+	}
+	for _, command := range sequence.Commands {
+		if strings.Contains(command.Selector, "alert") {
+			t.Errorf("malformed selector reached the plan: %+v", command)
+		}
+	}
+}
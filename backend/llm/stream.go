@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StreamingBackend is implemented by backends that can emit partial output
+type StreamingBackend interface {
+	LLMBackend
+	GenerateStream(ctx context.Context, prompt string, out chan<- string) error
+}
+
+// StreamText streams prompt through streamer, calling onToken with each
+// chunk as it arrives, and returns the whole response along with the stats
+// when the backend reports them
+func StreamText(ctx context.Context, streamer StreamingBackend, prompt string, onToken func(token string)) (string, GenerationStats, error) {
+	out := make(chan string)
+	done := make(chan struct{})
+
+	var builder strings.Builder
+	go func() {
+		defer close(done)
+		for chunk := range out {
+			builder.WriteString(chunk)
+			onToken(chunk)
+		}
+	}()
+
+	var stats GenerationStats
+	var err error
+	if metered, ok := streamer.(StatsBackend); ok {
+		stats, err = metered.GenerateStreamWithStats(ctx, prompt, out)
+	} else {
+		err = streamer.GenerateStream(ctx, prompt, out)
+	}
+	close(out)
+	<-done
+
+	return builder.String(), stats, err
+}
+
+// GenerateStream sends a prompt with streaming enabled and writes each partial
+// response chunk to out as it arrives. It does not close out. Models are tried
+// in order as long as the failing one has not streamed anything yet. Like
+// Generate, it fails once the token budget is used up.
+func (c *LLMClient) GenerateStream(ctx context.Context, prompt string, out chan<- string) error {
+	_, err := c.GenerateStreamWithStats(ctx, prompt, out)
+	return err
+}
+
+// GenerateStreamWithStats is GenerateStream that also returns the token
+// counts and timings from the stream's final chunk
+func (c *LLMClient) GenerateStreamWithStats(ctx context.Context, prompt string, out chan<- string) (GenerationStats, error) {
+	if err := c.usage.checkBudget(); err != nil {
+		return GenerationStats{}, err
+	}
+
+	var lastErr error
+	order := c.modelOrder()
+	for i, model := range order {
+		emitted, stats, err := c.streamWithModel(ctx, model, prompt, out)
+		if err == nil && emitted == 0 {
+			err = fmt.Errorf("model %s returned an empty response", model)
+		}
+		if err == nil {
+			c.prefer(model, c.logger)
+		}
+		if err == nil || emitted > 0 || ctx.Err() != nil {
+			return stats, err
+		}
+
+		lastErr = err
+		if i < len(order)-1 {
+			c.logger.Warn("Model failed, trying next", "model", model, "error", err, "next", order[i+1])
+		}
+	}
+	return GenerationStats{}, lastErr
+}
+
+// streamWithModel streams a single model's response, returning how many
+// chunks were written to out and the final chunk's stats
+func (c *LLMClient) streamWithModel(ctx context.Context, model, prompt string, out chan<- string) (int, GenerationStats, error) {
+	request := c.newRequest(model, prompt, true)
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return 0, GenerationStats{}, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.generateURL(), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, GenerationStats{}, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: c.timeout,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, GenerationStats{}, fmt.Errorf("failed to send request to Ollama: %v. Make sure Ollama is running (ollama serve)", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, GenerationStats{}, fmt.Errorf("ollama API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Ollama streams newline-delimited JSON objects, one per chunk
+	decoder := json.NewDecoder(resp.Body)
+	emitted := 0
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return emitted, GenerationStats{}, nil
+			}
+			return emitted, GenerationStats{}, fmt.Errorf("failed to decode stream chunk: %v", err)
+		}
+
+		if chunk.Response != "" {
+			select {
+			case out <- chunk.Response:
+				emitted++
+			case <-ctx.Done():
+				return emitted, GenerationStats{}, ctx.Err()
+			}
+		}
+
+		if chunk.Done {
+			// Only the final chunk carries the token counts
+			c.usage.record(chunk.PromptEvalCount, chunk.EvalCount)
+			return emitted, chunk.stats(), nil
+		}
+	}
+}
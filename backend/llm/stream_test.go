@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// ollamaStreamServer answers /api/generate like Ollama does with stream
+// enabled: one JSON object per chunk, flushed as it is written. Requests for
+// a model not in chunks get a 404.
+func ollamaStreamServer(t *testing.T, chunks map[string][]OllamaResponse) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		if !request.Stream {
+			t.Errorf("request for %s has stream disabled", request.Model)
+		}
+		modelChunks, ok := chunks[request.Model]
+		if !ok {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		for _, chunk := range modelChunks {
+			if err := encoder.Encode(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamTextDeliversEachChunk(t *testing.T) {
+	server := ollamaStreamServer(t, map[string][]OllamaResponse{
+		"mistral:latest": {
+			{Model: "mistral:latest", Response: `{"steps":`},
+			{Model: "mistral:latest", Response: `[]`},
+			{Model: "mistral:latest", Response: `}`},
+			{Model: "mistral:latest", Done: true, PromptEvalCount: 12, EvalCount: 3, EvalDuration: 1e9},
+		},
+	})
+	client := NewLLMClientWithHost(server.URL, "mistral:latest", nil)
+
+	var tokens []string
+	text, stats, err := StreamText(context.Background(), client, "plan", func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatalf("StreamText: %v", err)
+	}
+
+	if want := []string{`{"steps":`, `[]`, `}`}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens = %q, want %q", tokens, want)
+	}
+	if text != `{"steps":[]}` {
+		t.Errorf("text = %q", text)
+	}
+	if stats.PromptTokens != 12 || stats.CompletionTokens != 3 || stats.TokensPerSecond() != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if usage := client.Stats(); usage.TotalPromptTokens != 12 || usage.TotalCompletionTokens != 3 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestStreamTextFallsBackBeforeFirstChunk(t *testing.T) {
+	server := ollamaStreamServer(t, map[string][]OllamaResponse{
+		"llama3:latest": {
+			{Model: "llama3:latest", Response: "ok"},
+			{Model: "llama3:latest", Done: true},
+		},
+	})
+	client := NewLLMClientWithHost(server.URL, "", nil)
+	client.SetModels([]string{"missing:latest", "llama3:latest"})
+
+	text, stats, err := StreamText(context.Background(), client, "plan", func(string) {})
+	if err != nil {
+		t.Fatalf("StreamText: %v", err)
+	}
+	if text != "ok" || stats.Model != "llama3:latest" {
+		t.Errorf("got %q from %q, want \"ok\" from llama3:latest", text, stats.Model)
+	}
+	if order := client.modelOrder(); order[0] != "llama3:latest" {
+		t.Errorf("model order after fallback = %v, want llama3:latest first", order)
+	}
+}
+
+func TestParseGoalWithLLMProgressReportsPartialPlans(t *testing.T) {
+	server := ollamaStreamServer(t, map[string][]OllamaResponse{
+		"mistral:latest": {
+			{Model: "mistral:latest", Response: `{"intent":"navigate","confidence":0.9,`},
+			{Model: "mistral:latest", Response: `"steps":[{"action":"navigate","url":"https://github.com"}]}`},
+			{Model: "mistral:latest", Done: true},
+		},
+	})
+	client := NewLLMClientWithHost(server.URL, "mistral:latest", nil)
+
+	var partials []string
+	sequence, err := ParseGoalWithLLMProgress(client, "open github", nil, nil, func(partial string) {
+		partials = append(partials, partial)
+	})
+	if err != nil {
+		t.Fatalf("ParseGoalWithLLMProgress: %v", err)
+	}
+	if len(partials) != 2 || partials[0] != `{"intent":"navigate","confidence":0.9,` || !json.Valid([]byte(partials[1])) {
+		t.Errorf("partials = %q, want the response so far after each chunk", partials)
+	}
+	if len(sequence.Commands) != 1 || sequence.Commands[0].URL != "https://github.com" {
+		t.Errorf("commands = %+v", sequence.Commands)
+	}
+}
@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ValidateCommandURL rejects command URLs the browser should never be sent
+// to. Only http(s) URLs with a host and about:blank are allowed, which keeps
+// javascript:, data: and file:// targets out of navigate commands.
+func ValidateCommandURL(rawURL string) error {
+	if strings.EqualFold(strings.TrimSpace(rawURL), "about:blank") {
+		return nil
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+	case "":
+		return fmt.Errorf("URL %q has no scheme", rawURL)
+	default:
+		return fmt.Errorf("URL scheme %q is not allowed in %q (only http, https and about:blank)", parsed.Scheme, rawURL)
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("URL %q has no host", rawURL)
+	}
+	return nil
+}
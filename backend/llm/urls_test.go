@@ -0,0 +1,28 @@
+package llm
+
+import "testing"
+
+func TestValidateCommandURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/path?q=1", true},
+		{"HTTP://example.com", true},
+		{"about:blank", true},
+		{" About:Blank ", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"file:///etc/passwd", false},
+		{"chrome://settings", false},
+		{"example.com", false},
+		{"https://", false},
+		{"http://[::1", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateCommandURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("ValidateCommandURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
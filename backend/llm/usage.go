@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTokenBudgetExceeded is returned by Generate once a client has used up
+// its token budget; no request is sent
+var ErrTokenBudgetExceeded = errors.New("LLM token budget exceeded")
+
+// UsageStats are cumulative token counts across a client's requests
+type UsageStats struct {
+	TotalPromptTokens     int64 `json:"totalPromptTokens"`
+	TotalCompletionTokens int64 `json:"totalCompletionTokens"`
+	RequestCount          int64 `json:"requestCount"`
+}
+
+// TotalTokens is prompt plus completion tokens
+func (u UsageStats) TotalTokens() int64 {
+	return u.TotalPromptTokens + u.TotalCompletionTokens
+}
+
+// GenerationStats are the token counts and timings of a single response, as
+// reported by Ollama in its final message
+type GenerationStats struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	// TotalDuration covers the whole request: loading the model, reading
+	// the prompt (PromptEvalDuration) and generating (EvalDuration)
+	TotalDuration      time.Duration
+	LoadDuration       time.Duration
+	PromptEvalDuration time.Duration
+	EvalDuration       time.Duration
+}
+
+// TokensPerSecond is the generation speed, or 0 when it wasn't timed
+func (s GenerationStats) TokensPerSecond() float64 {
+	if s.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(s.CompletionTokens) / s.EvalDuration.Seconds()
+}
+
+// StatsBackend is implemented by backends that report GenerationStats for
+// each response
+type StatsBackend interface {
+	GenerateWithStats(prompt string) (string, GenerationStats, error)
+	GenerateStreamWithStats(ctx context.Context, prompt string, out chan<- string) (GenerationStats, error)
+}
+
+// UsageReporter is implemented by backends that count their token usage
+type UsageReporter interface {
+	Stats() UsageStats
+}
+
+// usageTracker accumulates UsageStats and enforces an optional token budget
+type usageTracker struct {
+	mu     sync.Mutex
+	stats  UsageStats
+	budget int64 // 0 means unlimited
+}
+
+// record adds one request's token counts
+func (t *usageTracker) record(promptTokens, completionTokens int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.TotalPromptTokens += int64(promptTokens)
+	t.stats.TotalCompletionTokens += int64(completionTokens)
+	t.stats.RequestCount++
+}
+
+// checkBudget fails once the tokens used so far reach the budget
+func (t *usageTracker) checkBudget() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.budget > 0 && t.stats.TotalTokens() >= t.budget {
+		return fmt.Errorf("%w: %d of %d tokens used", ErrTokenBudgetExceeded, t.stats.TotalTokens(), t.budget)
+	}
+	return nil
+}
+
+func (t *usageTracker) snapshot() UsageStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+func (t *usageTracker) setBudget(tokens int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.budget = tokens
+}
+
+// Stats returns the client's cumulative token usage
+func (c *LLMClient) Stats() UsageStats {
+	return c.usage.snapshot()
+}
+
+// SetTokenBudget makes Generate fail with ErrTokenBudgetExceeded once prompt
+// plus completion tokens reach tokens; 0 disables the limit
+func (c *LLMClient) SetTokenBudget(tokens int64) {
+	c.usage.setBudget(tokens)
+}
+
+// Stats returns the client's cumulative token usage
+func (c *OpenAIClient) Stats() UsageStats {
+	return c.usage.snapshot()
+}
+
+// SetTokenBudget makes Generate fail with ErrTokenBudgetExceeded once prompt
+// plus completion tokens reach tokens; 0 disables the limit
+func (c *OpenAIClient) SetTokenBudget(tokens int64) {
+	c.usage.setBudget(tokens)
+}
@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGenerateRecordsUsage(t *testing.T) {
+	fake := newFakeOllama(t, func(OllamaRequest) (int, OllamaResponse) {
+		return http.StatusOK, OllamaResponse{Response: `{}`, PromptEvalCount: 120, EvalCount: 30}
+	})
+	client := NewLLMClientWithHost(fake.URL, "mistral", nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Generate("plan"); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+	}
+	stats := client.Stats()
+	if stats.TotalPromptTokens != 240 || stats.TotalCompletionTokens != 60 || stats.RequestCount != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.TotalTokens() != 300 {
+		t.Errorf("TotalTokens = %d, want 300", stats.TotalTokens())
+	}
+}
+
+func TestTokenBudgetStopsRequests(t *testing.T) {
+	fake := newFakeOllama(t, func(OllamaRequest) (int, OllamaResponse) {
+		return http.StatusOK, OllamaResponse{Response: `{}`, PromptEvalCount: 80, EvalCount: 20}
+	})
+	client := NewLLMClientWithHost(fake.URL, "mistral", nil)
+	client.SetTokenBudget(150)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Generate("plan"); err != nil {
+			t.Fatalf("request %d within budget: %v", i+1, err)
+		}
+	}
+	if _, err := client.Generate("plan"); !errors.Is(err, ErrTokenBudgetExceeded) {
+		t.Fatalf("Generate over budget: err = %v, want ErrTokenBudgetExceeded", err)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("sent %d requests, want the over-budget one held back", len(fake.requests))
+	}
+
+	client.SetTokenBudget(0)
+	if _, err := client.Generate("plan"); err != nil {
+		t.Errorf("Generate with budget disabled: %v", err)
+	}
+}
+
+func TestGenerateWithStatsReportsTimings(t *testing.T) {
+	fake := newFakeOllama(t, func(request OllamaRequest) (int, OllamaResponse) {
+		return http.StatusOK, OllamaResponse{
+			Model:              request.Model,
+			Response:           `{"steps":[]}`,
+			Done:               true,
+			TotalDuration:      int64(3 * time.Second),
+			LoadDuration:       int64(500 * time.Millisecond),
+			PromptEvalCount:    200,
+			PromptEvalDuration: int64(time.Second),
+			EvalCount:          60,
+			EvalDuration:       int64(1500 * time.Millisecond),
+		}
+	})
+	client := NewLLMClientWithHost(fake.URL, "mistral:latest", nil)
+
+	response, stats, err := client.GenerateWithStats("plan")
+	if err != nil {
+		t.Fatalf("GenerateWithStats: %v", err)
+	}
+	want := GenerationStats{
+		Model:              "mistral:latest",
+		PromptTokens:       200,
+		CompletionTokens:   60,
+		TotalDuration:      3 * time.Second,
+		LoadDuration:       500 * time.Millisecond,
+		PromptEvalDuration: time.Second,
+		EvalDuration:       1500 * time.Millisecond,
+	}
+	if response != `{"steps":[]}` || stats != want {
+		t.Errorf("got %q with %+v, want %+v", response, stats, want)
+	}
+	if tps := stats.TokensPerSecond(); tps != 40 {
+		t.Errorf("TokensPerSecond = %v, want 40", tps)
+	}
+	if (GenerationStats{CompletionTokens: 10}).TokensPerSecond() != 0 {
+		t.Error("untimed response reports a generation speed")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerFiltersByLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "text")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	logger.Info("connected")
+	logger.Warn("slow page", "url", "https://example.com")
+
+	out := buf.String()
+	if strings.Contains(out, "connected") {
+		t.Errorf("info message logged at warn level: %s", out)
+	}
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "url=https://example.com") {
+		t.Errorf("warn message missing: %s", out)
+	}
+}
+
+func TestNewLoggerWritesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "DEBUG", "JSON")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	logger.With("conn_id", "client_1").Debug("Task started", "task_id", "task_1")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	if record["msg"] != "Task started" || record["conn_id"] != "client_1" || record["task_id"] != "task_1" {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "verbose", "text"); err == nil {
+		t.Error("accepted log level verbose")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("accepted log format xml")
+	}
+}
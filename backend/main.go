@@ -1,21 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
-	"sync/atomic"
+	"syscall"
 	"time"
 
 	"cortex-browser/backend/llm"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Message types
@@ -26,13 +32,122 @@ type Message struct {
 
 type ExecuteTaskPayload struct {
 	Goal string `json:"goal"`
+	// URL is the page the goal was typed on, whose cached PAGE_CONTENT is
+	// the goal's context. When empty, the most recent page is used
+	URL              string `json:"url,omitempty"`
+	DefaultTimeoutMs int    `json:"defaultTimeoutMs,omitempty"`
+	MaxRetries       int    `json:"maxRetries,omitempty"`
+	RetryBackoffMs   int    `json:"retryBackoffMs,omitempty"`
+	// secrets are values the task log and history must not store, such as
+	// template params
+	secrets []string
+}
+
+// HandshakePayload is the first message the extension sends on connecting
+type HandshakePayload struct {
+	Client  string `json:"client"`
+	Version string `json:"version"`
+	// ClientID identifies the browser profile across reconnects; schedules
+	// belong to it
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// TaskIDPayload is the payload of messages that refer to a single task
+type TaskIDPayload struct {
+	TaskID string `json:"taskId"`
 }
 
 type CommandPayload struct {
 	Action   string `json:"action"`
 	URL      string `json:"url,omitempty"`
 	Selector string `json:"selector,omitempty"`
-	Text     string `json:"text,omitempty"`
+	// SelectorType is "css" (the default when empty) or "xpath", and says
+	// how Selector is matched; other selector fields are always CSS
+	SelectorType string `json:"selectorType,omitempty"`
+	// Text is typed by input; for click it is the visible label of the
+	// element to click among those matching Selector; for select_option it
+	// is the value or visible label of the option to choose in the <select>
+	// matching Selector; for check and uncheck it optionally names the label
+	// of the checkbox or radio button among those matching Selector; for
+	// press_key it is the key name, e.g. "Enter", "Tab" or "Escape", sent to
+	// the element matching Selector or to the focused element
+	Text string `json:"text,omitempty"`
+	// MatchText is what click_text looks for in the innerText or aria-label
+	// of every visible element; exact matches win over partial ones
+	MatchText string `json:"matchText,omitempty"`
+	// TimeoutMs overrides the delay before the next command is dispatched
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+	// Scroll by ScrollX/ScrollY pixels, or to ScrollSelector when set. With
+	// neither, Text gives the direction: "down", "up", "left", "right",
+	// "bottom", "top" or a signed vertical pixel count
+	ScrollX        int    `json:"scrollX,omitempty"`
+	ScrollY        int    `json:"scrollY,omitempty"`
+	ScrollSelector string `json:"scrollSelector,omitempty"`
+	// wait_for_element polls for WaitSelector, or for document.readyState
+	// "complete" when WaitSelector is empty, as wait_for_ready_state does.
+	// Either gives up after WaitTimeoutMs
+	WaitSelector  string `json:"waitSelector,omitempty"`
+	WaitTimeoutMs int    `json:"waitTimeoutMs,omitempty"`
+	// Script is run in the page by the evaluate action; its JSON-encoded
+	// return value comes back in CommandResult.Details
+	Script string `json:"script,omitempty"`
+	// Cookie names the cookie for set_cookie, or filters get_cookies
+	Cookie *CookieParams `json:"cookie,omitempty"`
+	// TabID targets switch_tab and close_tab; close_tab defaults to the
+	// active tab. list_tabs reports IDs in CommandResult.Details
+	TabID int `json:"tabId,omitempty"`
+	// extract reads ExtractSelector's innerText (or value) into the task
+	// variable StoreAs; later Text and URL fields can use it as {{StoreAs}}
+	ExtractSelector string `json:"extractSelector,omitempty"`
+	StoreAs         string `json:"storeAs,omitempty"`
+	// drag drops the element matching SourceSelector onto the one matching
+	// TargetSelector, with synthetic drag events carrying a DataTransfer
+	SourceSelector string `json:"sourceSelector,omitempty"`
+	TargetSelector string `json:"targetSelector,omitempty"`
+	// key sends Keys, a shortcut such as "Control+Shift+P", "Meta+k" or a
+	// single key like "c", to the element matching the optional Selector
+	// or to the focused element. Modifiers are Control, Shift, Alt and Meta
+	Keys string `json:"keys,omitempty"`
+	// handle_dialog answers the page's alert(), confirm() or prompt():
+	// DialogAction is "accept", "dismiss" or "type", which accepts a
+	// prompt() with DialogText. With no dialog open it answers the next one,
+	// so it goes before the step that opens the dialog
+	DialogAction string `json:"dialogAction,omitempty"`
+	DialogText   string `json:"dialogText,omitempty"`
+	// upload_file attaches FileName, a bare file name looked up among the
+	// browser's downloads, to the <input type="file"> matching Selector
+	FileName string `json:"fileName,omitempty"`
+	// intercept_request answers fetch and XHR requests whose URL matches
+	// URLPattern, a glob with * wildcards or a /regex/, with MockResponse as
+	// a JSON body, until clear_intercepts removes the tab's intercepts
+	URLPattern   string `json:"urlPattern,omitempty"`
+	MockResponse string `json:"mockResponse,omitempty"`
+	// ShadowPath pierces shadow roots: selectors for the shadow hosts
+	// enclosing the target, outermost first, each matched inside the shadow
+	// root of the one before. Selector is then matched in the innermost root
+	ShadowPath []string `json:"shadowPath,omitempty"`
+	// FrameSelector is a CSS selector for an <iframe> in the top page; when
+	// set, the command runs inside that frame's document instead
+	FrameSelector string `json:"frameSelector,omitempty"`
+	// Condition is a CSS selector; the command only runs when it matches
+	// something on the page. A check_element step is inserted before it
+	Condition string `json:"condition,omitempty"`
+	// assert compares AssertProperty ("innerText" by default, "value",
+	// "checked" or any other property or attribute) of the element matching
+	// AssertSelector with AssertExpected, failing the step on a mismatch
+	AssertSelector string `json:"assertSelector,omitempty"`
+	AssertProperty string `json:"assertProperty,omitempty"`
+	AssertExpected string `json:"assertExpected,omitempty"`
+}
+
+// CookieParams describes a cookie for the cookie actions. URL on the command
+// selects the cookie store entry; it defaults to the active tab's URL.
+type CookieParams struct {
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// Expiry is a Unix timestamp; zero makes a session cookie
+	Expiry int64 `json:"expiry,omitempty"`
 }
 
 // Multi-step task planning structures
@@ -41,15 +156,80 @@ type CommandSequence struct {
 	TaskID   string           `json:"taskId"`
 	Total    int              `json:"total"`
 	Current  int              `json:"current"`
+	// RepeatUntil is a CSS selector for a "next page" control. While it
+	// matches after the last step, it is clicked and the sequence runs again
+	// from RepeatFrom, at most MaxIterations times
+	RepeatUntil   string `json:"repeatUntil,omitempty"`
+	RepeatFrom    int    `json:"repeatFrom,omitempty"`
+	MaxIterations int    `json:"maxIterations,omitempty"`
 }
 
 type TaskState struct {
-	TaskID      string          `json:"taskId"`
-	Goal        string          `json:"goal"`
-	Sequence    CommandSequence `json:"sequence"`
-	Status      string          `json:"status"` // "pending", "executing", "completed", "failed"
-	CurrentStep int             `json:"currentStep"`
-	Results     []CommandResult `json:"results"`
+	TaskID           string          `json:"taskId"`
+	Goal             string          `json:"goal"`
+	Sequence         CommandSequence `json:"sequence"`
+	Status           string          `json:"status"` // "pending", "executing", "completed", "failed", "cancelled"
+	CurrentStep      int             `json:"currentStep"`
+	Results          []CommandResult `json:"results"`
+	DefaultTimeoutMs int             `json:"defaultTimeoutMs,omitempty"`
+	MaxRetries       int             `json:"maxRetries"`
+	RetryCount       int             `json:"retryCount"` // retries spent on the current step
+	RetryBackoffMs   int             `json:"retryBackoffMs"`
+	// Variables holds values captured by extract steps, keyed by StoreAs
+	Variables map[string]string `json:"variables,omitempty"`
+	// Iteration counts passes through a RepeatUntil sequence, starting at 1
+	Iteration int `json:"iteration,omitempty"`
+	// StartedAt is when the task was accepted
+	StartedAt time.Time `json:"startedAt"`
+	// DurationMs is the time spent in steps so far, the sum of every
+	// result's DurationMs, retries included
+	DurationMs int64 `json:"durationMs"`
+
+	logger           *slog.Logger // session logger tagged with task_id
+	stepTimer        *time.Timer
+	stepAttempt      int       // bumped on every dispatch/completion so stale timeouts are ignored
+	stepDispatchedAt time.Time // when the current step's COMMAND was sent
+	onFinish         func(*TaskState)
+	secrets          []string // redacted from the task log and history
+	owner            string   // Session.owner of the session that started it
+}
+
+// statusPayload snapshots the task for TASK_STATUS. Callers hold stepMu.
+func (t *TaskState) statusPayload() TaskStatusPayload {
+	return TaskStatusPayload{
+		TaskID:      t.TaskID,
+		Goal:        t.Goal,
+		Status:      t.Status,
+		CurrentStep: t.CurrentStep,
+		Total:       len(t.Sequence.Commands),
+		RetryCount:  t.RetryCount,
+		DurationMs:  t.DurationMs,
+		Results:     append([]CommandResult{}, t.Results...),
+	}
+}
+
+// recordStepTiming fills in result's timing from when the current step was
+// dispatched and adds its duration to the task total. A step is timed once,
+// so a duplicate COMMAND_COMPLETE adds nothing.
+func (t *TaskState) recordStepTiming(result *CommandResult) {
+	if t.stepDispatchedAt.IsZero() {
+		return
+	}
+	ended := time.Now()
+	result.StartedAt = t.stepDispatchedAt.Format(time.RFC3339Nano)
+	result.EndedAt = ended.Format(time.RFC3339Nano)
+	result.DurationMs = ended.Sub(t.stepDispatchedAt).Milliseconds()
+	t.DurationMs += result.DurationMs
+	t.stepDispatchedAt = time.Time{}
+}
+
+// clearStepTimeout disarms the pending COMMAND_COMPLETE timeout, if any
+func (t *TaskState) clearStepTimeout() {
+	if t.stepTimer != nil {
+		t.stepTimer.Stop()
+		t.stepTimer = nil
+	}
+	t.stepAttempt++
 }
 
 type CommandResult struct {
@@ -59,6 +239,12 @@ type CommandResult struct {
 	Details   string `json:"details,omitempty"`
 	Error     string `json:"error,omitempty"`
 	Timestamp string `json:"timestamp"`
+	// StartedAt and EndedAt bracket the step, from sending its COMMAND to
+	// the matching COMMAND_COMPLETE or timeout. Skipped steps have neither
+	// and a DurationMs of 0.
+	StartedAt  string `json:"startedAt,omitempty"`
+	EndedAt    string `json:"endedAt,omitempty"`
+	DurationMs int64  `json:"durationMs"`
 }
 
 type PageContentPayload struct {
@@ -67,16 +253,164 @@ type PageContentPayload struct {
 	URL        string `json:"url"`
 	Text       string `json:"text"`
 	ReadyState string `json:"readyState"`
+	// RequestID answers a REQUEST_PAGE_CONTENT; Error is set instead of
+	// the content when the page couldn't be captured
+	RequestID string `json:"requestId,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type ContentAnalysisResult struct {
 	Selectors   []string `json:"selectors"`
 	Suggestions []string `json:"suggestions"`
 	ContentType string   `json:"contentType"`
+	// Elements pairs each entry of Selectors with what the user sees
+	Elements []InteractiveElement `json:"elements"`
+	// Pagination is set when the page has next-page or numbered page links
+	Pagination *PaginationInfo `json:"pagination,omitempty"`
+	Tables     []TableData     `json:"tables,omitempty"`
+	// StructuredData holds the page's JSON-LD objects and microdata items,
+	// e.g. product prices, article authors and event dates
+	StructuredData []map[string]interface{} `json:"structuredData,omitempty"`
+	// FormFields describes each fillable field so input steps can tell the
+	// email box from the password box
+	FormFields []FormFieldInfo `json:"formFields,omitempty"`
+	// Links are the page's most relevant links, most relevant first
+	Links []LinkInfo `json:"links,omitempty"`
+	// Language is the <html lang> value, e.g. "de" or "pt-BR"
+	Language string `json:"language,omitempty"`
+	// AlternateLanguages are the hreflang values of <link rel="alternate">
+	// elements, in document order without duplicates
+	AlternateLanguages []string `json:"alternateLanguages,omitempty"`
+	// AntiBot names the CAPTCHA or bot challenge on the page: "recaptcha",
+	// "hcaptcha", "cloudflare" or "unknown"; empty when there is none
+	AntiBot string `json:"antiBot,omitempty"`
+	// Product is set on e-commerce pages that show a price
+	Product *ProductInfo `json:"product,omitempty"`
+	// TableOfContents lists the headings of content pages in document
+	// order, for follow-up goals like "scroll to the Installation section"
+	TableOfContents []TOCEntry `json:"tableOfContents,omitempty"`
+	// Iframes are the src URLs of the page's frames, resolved against the
+	// page URL; commands reach into one with FrameSelector
+	Iframes []string `json:"iframes,omitempty"`
+}
+
+// TOCEntry is one heading of the page
+type TOCEntry struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	// Selector is a CSS selector for the heading, usable as ScrollSelector;
+	// empty when no unique one exists
+	Selector string `json:"selector,omitempty"`
+}
+
+// FormFieldInfo is an input, textarea or select the user can fill in
+type FormFieldInfo struct {
+	Selector     string `json:"selector"`
+	SelectorType string `json:"selectorType,omitempty"`
+	// Label is the text of the field's <label>, or failing that its
+	// aria-label or placeholder
+	Label       string `json:"label,omitempty"`
+	Placeholder string `json:"placeholder,omitempty"`
+	// InputType is the type attribute for inputs ("text" when missing) and
+	// the tag name for textareas and selects
+	InputType string `json:"inputType"`
+	Required  bool   `json:"required,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// TableData is a <table> flattened into a grid of cell texts. Cells spanning
+// several columns or rows are repeated in each position they cover.
+type TableData struct {
+	Selector string     `json:"selector,omitempty"`
+	Caption  string     `json:"caption,omitempty"`
+	Headers  []string   `json:"headers,omitempty"`
+	Rows     [][]string `json:"rows"`
+}
+
+// InteractiveElement is a link, button or form field found on the page
+type InteractiveElement struct {
+	Selector string `json:"selector"`
+	// Text is the visible text, or for fields without any the value,
+	// aria-label or placeholder, whitespace-collapsed and trimmed
+	Text string `json:"text,omitempty"`
+	Tag  string `json:"tag"`
+	Type string `json:"type,omitempty"`
+	// SelectorType is "xpath" when no unique CSS selector could be built
+	SelectorType string `json:"selectorType,omitempty"`
+	// ShadowPath is set for elements inside shadow roots; Selector is then
+	// relative to the innermost one, see CommandPayload.ShadowPath
+	ShadowPath []string `json:"shadowPath,omitempty"`
 }
 
 type TaskCompletePayload struct {
 	Message string `json:"message"`
+	// TaskID, DurationMs and Results are set when a task finishes
+	TaskID     string          `json:"taskId,omitempty"`
+	DurationMs int64           `json:"durationMs,omitempty"`
+	Results    []CommandResult `json:"results,omitempty"`
+}
+
+// CaptchaDetectedPayload tells the extension a page needs a human to solve a
+// CAPTCHA before automation can go on
+type CaptchaDetectedPayload struct {
+	URL      string `json:"url"`
+	Provider string `json:"provider"`
+	// TaskID is the running task, if any, whose next steps will likely fail
+	TaskID  string `json:"taskId,omitempty"`
+	Message string `json:"message"`
+}
+
+type TaskStatusPayload struct {
+	TaskID      string          `json:"taskId"`
+	Goal        string          `json:"goal"`
+	Status      string          `json:"status"`
+	CurrentStep int             `json:"currentStep"`
+	Total       int             `json:"total"`
+	RetryCount  int             `json:"retryCount"`
+	DurationMs  int64           `json:"durationMs"`
+	Results     []CommandResult `json:"results"`
+}
+
+type TaskProgressPayload struct {
+	Goal    string `json:"goal"`
+	Stage   string `json:"stage"`
+	Chars   int    `json:"chars"`
+	Partial string `json:"partial,omitempty"`
+}
+
+// LLMThinkingPayload carries the LLM output streamed since the previous
+// LLM_THINKING message for the same goal
+type LLMThinkingPayload struct {
+	Goal  string `json:"goal"`
+	Text  string `json:"text"`
+	Chars int    `json:"chars"`
+}
+
+type TaskCancelledPayload struct {
+	TaskID  string `json:"taskId"`
+	Message string `json:"message"`
+	Step    int    `json:"step"`
+}
+
+// TaskFailedPayload carries the ErrorPayload fields plus the step results
+// collected before the task was aborted
+type TaskFailedPayload struct {
+	Message string          `json:"message"`
+	Code    string          `json:"code,omitempty"`
+	TaskID  string          `json:"taskId"`
+	Step    int             `json:"step"`
+	Error   string          `json:"error,omitempty"`
+	Results []CommandResult `json:"results"`
+}
+
+type CommandRetryPayload struct {
+	TaskID     string `json:"taskId"`
+	Step       int    `json:"step"`
+	Action     string `json:"action"`
+	Attempt    int    `json:"attempt"`
+	MaxRetries int    `json:"maxRetries"`
+	DelayMs    int64  `json:"delayMs"`
+	Error      string `json:"error,omitempty"`
 }
 
 type ErrorPayload struct {
@@ -87,51 +421,60 @@ type ErrorPayload struct {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:     checkOrigin,
 }
 
-var activeTasks = make(map[string]*TaskState)
-var taskCounter int64
-var llmClient *llm.LLMClient
+var llmClient llm.LLMProvider
 var useLLM bool
-var pageContexts = make(map[*websocket.Conn]*llm.PageContext)
 
 func handler(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeUpgrade(r); err != nil {
+		slog.Warn("Unauthorized WebSocket connection", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		slog.Error("WebSocket upgrade failed", "remote_addr", r.RemoteAddr, "error", err)
 		return
 	}
+	defer conn.Close()
+
+	session := registry.Register(conn)
 	defer func() {
-		conn.Close()
-		delete(pageContexts, conn)
+		// Tasks can't go on without the connection, and once unregistered
+		// graceful shutdown can't reach them
+		registry.Unregister(session)
+		session.cancelAllTasks("Connection closed")
 	}()
+	session.logger.Info("New client connected", "remote_addr", r.RemoteAddr)
 
-	log.Println("New client connected")
+	stopKeepalive := startKeepalive(session, *pingIntervalFlag, *readTimeoutFlag)
+	defer stopKeepalive()
 
 	for {
 		_, messageBytes, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Read error:", err)
+			session.logger.Info("Connection closed", "error", err)
 			return
 		}
+		conn.SetReadDeadline(time.Now().Add(*readTimeoutFlag))
 
-		log.Printf("Received: %s", string(messageBytes))
+		session.logger.Debug("Received", "message", string(messageBytes))
 
-		if err := handleMessageWithConnection(conn, messageBytes); err != nil {
-			log.Println("Message handling error:", err)
+		if err := handleMessageWithConnection(session, messageBytes); err != nil {
+			session.logger.Error("Message handling failed", "error", err)
 			return
 		}
 	}
 }
 
-func handleMessageWithConnection(conn *websocket.Conn, messageBytes []byte) error {
+func handleMessageWithConnection(session *Session, messageBytes []byte) error {
 	var msg Message
 	if err := json.Unmarshal(messageBytes, &msg); err != nil {
-		log.Println("JSON unmarshal error:", err)
-		return sendMessage(conn, &Message{
+		session.logger.Warn("Invalid JSON message", "error", err)
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Invalid JSON format",
@@ -142,17 +485,39 @@ func handleMessageWithConnection(conn *websocket.Conn, messageBytes []byte) erro
 
 	switch msg.Type {
 	case "HANDSHAKE":
-		log.Println("Handshake received from extension")
-		return nil
+		return handleHandshake(session, msg.Payload)
 	case "EXECUTE_TASK":
-		return handleExecuteTaskWithCompletion(conn, msg.Payload)
+		return handleExecuteTaskWithCompletion(session, msg.Payload)
+	case "EXECUTE_TASKS":
+		return handleExecuteTasks(session, msg.Payload)
+	case "LOAD_TEMPLATE":
+		return handleLoadTemplate(session, msg.Payload)
+	case "SCHEDULE_TASK":
+		return handleScheduleTask(session, msg.Payload)
+	case "LIST_SCHEDULES":
+		return handleListSchedules(session)
+	case "CANCEL_SCHEDULE":
+		return handleCancelSchedule(session, msg.Payload)
 	case "PAGE_CONTENT":
-		return handlePageContent(conn, msg.Payload)
+		return handlePageContent(session, msg.Payload)
+	case "GET_MAIN_CONTENT":
+		return handleGetMainContent(session)
 	case "COMMAND_COMPLETE":
-		return handleCommandComplete(conn, msg.Payload)
+		return handleCommandComplete(session, msg.Payload)
+	case "CANCEL_TASK":
+		return handleCancelTask(session, msg.Payload)
+	case "GET_TASK_STATUS":
+		return handleGetTaskStatus(session, msg.Payload)
+	case "CLEAR_CONTEXT":
+		session.clearConversationHistory()
+		session.logger.Info("Conversation context cleared")
+		return session.send(&Message{
+			Type:    "CONTEXT_CLEARED",
+			Payload: TaskCompletePayload{Message: "Conversation context cleared"},
+		})
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
-		return sendMessage(conn, &Message{
+		session.logger.Warn("Unknown message type", "type", msg.Type)
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Unknown message type",
@@ -162,7 +527,7 @@ func handleMessageWithConnection(conn *websocket.Conn, messageBytes []byte) erro
 	}
 }
 
-func handleCommandComplete(conn *websocket.Conn, payload interface{}) error {
+func handleCommandComplete(session *Session, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return err
@@ -170,79 +535,417 @@ func handleCommandComplete(conn *websocket.Conn, payload interface{}) error {
 
 	var result CommandResult
 	if err := json.Unmarshal(payloadBytes, &result); err != nil {
-		log.Printf("Failed to parse command result: %v", err)
+		session.logger.Warn("Failed to parse command result", "error", err)
 		return nil
 	}
 
-	var taskState *TaskState
-	for _, task := range activeTasks {
-		if task.Status == "executing" {
-			taskState = task
-			break
-		}
-	}
+	session.stepMu.Lock()
+	defer session.stepMu.Unlock()
 
-	if taskState == nil {
-		for _, task := range activeTasks {
-			if task.Status == "pending" || task.Status == "executing" {
-				taskState = task
-				if taskState.Status == "pending" {
-					taskState.Status = "executing"
-				}
-				break
-			}
-		}
-	}
+	taskState := session.findRunningTask()
 
 	if taskState == nil {
-		log.Printf("No active task found for command completion. Active tasks: %d", len(activeTasks))
+		// Completions for cancelled tasks land here and are dropped
+		session.logger.Info("No active task found for command completion", "active_tasks", session.countTasks())
 		return nil
 	}
 
-	taskState.CurrentStep++
+	recordCommandResult(taskState.Sequence.Commands[taskState.CurrentStep].Action, result.Success, taskState.stepDispatchedAt)
+	taskState.recordStepTiming(&result)
+	taskState.clearStepTimeout()
 	taskState.Results = append(taskState.Results, result)
 
+	if !result.Success {
+		return retryOrFailStep(session, taskState, result)
+	}
+
+	completed := taskState.Sequence.Commands[taskState.CurrentStep]
+	if completed.Action == "extract" && completed.StoreAs != "" {
+		taskState.Variables[completed.StoreAs] = result.Details
+		taskState.logger.Debug("Stored extracted value", "variable", completed.StoreAs, "length", len(result.Details))
+	}
+
+	taskState.CurrentStep++
+	taskState.RetryCount = 0
+
+	// A check_element that matched nothing skips the conditional step after it
+	conditionSkipped := false
+	if completed.Action == "check_element" && result.Details != "true" && taskState.CurrentStep < len(taskState.Sequence.Commands) {
+		skipped := taskState.Sequence.Commands[taskState.CurrentStep]
+		taskState.logger.Info("Condition not met, skipping step", "step", taskState.CurrentStep, "action", skipped.Action, "condition", skipped.Condition)
+		taskState.Results = append(taskState.Results, CommandResult{
+			Step:      taskState.CurrentStep,
+			Action:    skipped.Action,
+			Success:   true,
+			Details:   fmt.Sprintf("Skipped: no element matches %s", skipped.Condition),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		taskState.CurrentStep++
+		conditionSkipped = true
+	}
+
+	// The last step clicked the next-page control, so go around again
+	if taskState.CurrentStep == len(taskState.Sequence.Commands) && taskState.Sequence.RepeatUntil != "" && !conditionSkipped {
+		if taskState.Iteration < taskState.Sequence.MaxIterations {
+			taskState.Iteration++
+			taskState.CurrentStep = taskState.Sequence.RepeatFrom
+			taskState.logger.Info("Next page found, repeating sequence", "iteration", taskState.Iteration, "max_iterations", taskState.Sequence.MaxIterations)
+		} else {
+			taskState.logger.Info("Reached maximum iterations", "max_iterations", taskState.Sequence.MaxIterations)
+		}
+	}
+
 	if taskState.CurrentStep < len(taskState.Sequence.Commands) {
 		nextCommand := taskState.Sequence.Commands[taskState.CurrentStep]
 		taskState.Sequence.Current = taskState.CurrentStep
 
-		if err := sendMessage(conn, &Message{
+		if err := session.send(&Message{
 			Type:    "COMMAND_SEQUENCE_UPDATE",
 			Payload: taskState.Sequence,
 		}); err != nil {
 			return err
 		}
 
-		if taskState.CurrentStep > 0 {
-			prevCommand := taskState.Sequence.Commands[taskState.CurrentStep-1]
-			if prevCommand.Action == "navigate" {
-				time.Sleep(2 * time.Second)
-			} else {
-				time.Sleep(500 * time.Millisecond)
-			}
-		}
-
-		return sendMessage(conn, &Message{
-			Type:    "COMMAND",
-			Payload: nextCommand,
-		})
+		// After a skip this is the check_element, not the skipped step
+		return scheduleDispatch(session, taskState, nextCommand, stepDelay(completed))
 	} else {
-		taskState.Status = "completed"
-		delete(activeTasks, taskState.TaskID)
+		taskState.transition("completed")
+		session.deleteTask(taskState.TaskID)
 
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "TASK_COMPLETE",
 			Payload: TaskCompletePayload{
-				Message: fmt.Sprintf("Successfully completed multi-step task: %s", taskState.Goal),
+				Message:    fmt.Sprintf("Successfully completed multi-step task: %s", taskState.Goal),
+				TaskID:     taskState.TaskID,
+				DurationMs: taskState.DurationMs,
+				Results:    taskState.Results,
+			},
+		})
+	}
+}
+
+// minAssertRetries is how often a failed assert is retried even when the task
+// allows fewer retries
+const minAssertRetries = 3
+
+// retryOrFailStep resends the current command with exponential backoff until
+// MaxRetries is exhausted, then fails the whole task
+func retryOrFailStep(session *Session, taskState *TaskState, result CommandResult) error {
+	command := taskState.Sequence.Commands[taskState.CurrentStep]
+
+	maxRetries := taskState.MaxRetries
+	if command.Action == "assert" && maxRetries < minAssertRetries {
+		// The page may simply not have re-rendered yet
+		maxRetries = minAssertRetries
+	}
+
+	if taskState.RetryCount >= maxRetries {
+		taskState.clearStepTimeout()
+		taskState.transition("failed")
+		session.deleteTask(taskState.TaskID)
+		taskState.logger.Warn("Task failed", "step", taskState.CurrentStep, "action", command.Action, "error", result.Error)
+
+		return session.send(&Message{
+			Type: "TASK_FAILED",
+			Payload: TaskFailedPayload{
+				Message: fmt.Sprintf("Step %d (%s) failed after %d retries: %s", taskState.CurrentStep+1, command.Action, taskState.RetryCount, result.Error),
+				Code:    "STEP_FAILED",
+				TaskID:  taskState.TaskID,
+				Step:    taskState.CurrentStep,
+				Error:   result.Error,
+				Results: taskState.Results,
+			},
+		})
+	}
+
+	taskState.RetryCount++
+	backoff := time.Duration(taskState.RetryBackoffMs) * time.Millisecond << (taskState.RetryCount - 1)
+	taskState.logger.Info("Retrying step", "step", taskState.CurrentStep, "attempt", taskState.RetryCount, "max_retries", maxRetries, "backoff", backoff)
+
+	if err := session.send(&Message{
+		Type: "COMMAND_RETRY",
+		Payload: CommandRetryPayload{
+			TaskID:     taskState.TaskID,
+			Step:       taskState.CurrentStep,
+			Action:     command.Action,
+			Attempt:    taskState.RetryCount,
+			MaxRetries: maxRetries,
+			DelayMs:    backoff.Milliseconds(),
+			Error:      result.Error,
+		},
+	}); err != nil {
+		return err
+	}
+
+	return scheduleDispatch(session, taskState, command, backoff)
+}
+
+// scheduleDispatch dispatches command after delay on a timer, so the read
+// loop and stepMu are free in the meantime. The pending dispatch lives in
+// stepTimer, so cancelling or finishing the task stops it.
+func scheduleDispatch(session *Session, taskState *TaskState, command CommandPayload, delay time.Duration) error {
+	if delay <= 0 {
+		return dispatchCommand(session, taskState, command)
+	}
+
+	taskState.clearStepTimeout()
+	attempt := taskState.stepAttempt
+	taskState.stepTimer = time.AfterFunc(delay, func() {
+		session.stepMu.Lock()
+		defer session.stepMu.Unlock()
+
+		if taskState.stepAttempt != attempt {
+			return
+		}
+		if _, ok := session.getTask(taskState.TaskID); !ok {
+			taskState.logger.Info("Task cancelled before step was dispatched", "step", taskState.CurrentStep)
+			return
+		}
+		if err := dispatchCommand(session, taskState, command); err != nil {
+			taskState.logger.Error("Failed to dispatch step", "step", taskState.CurrentStep, "error", err)
+		}
+	})
+	return nil
+}
+
+// dispatchCommand sends a task's command and arms its completion timeout
+func dispatchCommand(session *Session, taskState *TaskState, command CommandPayload) error {
+	command = interpolateVariables(command, taskState.Variables)
+	if command.URL != "" {
+		if err := llm.ValidateCommandURL(command.URL); err != nil {
+			return failUnsafeStep(session, taskState, command, err)
+		}
+	}
+	command = sanitizeCommandSelectors(taskState.logger, command)
+
+	taskState.clearStepTimeout()
+	taskState.logger.Debug("Dispatching step", "step", taskState.CurrentStep, "action", command.Action, "iteration", taskState.Iteration)
+	attempt := taskState.stepAttempt
+	taskState.stepDispatchedAt = time.Now()
+	taskState.stepTimer = time.AfterFunc(commandTimeout(command), func() {
+		handleStepTimeout(session, taskState, attempt)
+	})
+
+	return session.send(&Message{
+		Type:    "COMMAND",
+		Payload: command,
+	})
+}
+
+// sanitizeCommandSelectors blanks every selector of command that fails
+// llm.SanitizeSelector, or llm.SanitizeXPath for an XPath Selector. Rule
+// parsers build selectors from goal text, so this is the one place that
+// covers them all before a command leaves the backend.
+func sanitizeCommandSelectors(logger *slog.Logger, command CommandPayload) CommandPayload {
+	command.ShadowPath = slices.Clone(command.ShadowPath)
+	for _, field := range cssSelectorFields(&command) {
+		if sanitized, err := llm.SanitizeSelector(*field); err != nil {
+			logger.Warn("Dropping malformed selector", "action", command.Action, "error", err)
+			*field = ""
+		} else {
+			*field = sanitized
+		}
+	}
+	if command.SelectorType == "xpath" {
+		if sanitized, err := llm.SanitizeXPath(command.Selector); err != nil {
+			logger.Warn("Dropping malformed XPath", "action", command.Action, "error", err)
+			command.Selector = ""
+		} else {
+			command.Selector = sanitized
+		}
+	}
+	return command
+}
+
+// failUnsafeStep fails the task when variable interpolation produced a URL
+// the extension must not be sent to
+func failUnsafeStep(session *Session, taskState *TaskState, command CommandPayload, err error) error {
+	taskState.clearStepTimeout()
+	taskState.transition("failed")
+	session.deleteTask(taskState.TaskID)
+	taskState.logger.Warn("Task failed on unsafe URL", "step", taskState.CurrentStep, "action", command.Action, "error", err)
+
+	return session.send(&Message{
+		Type: "TASK_FAILED",
+		Payload: TaskFailedPayload{
+			Message: fmt.Sprintf("Step %d (%s) has an unsafe URL: %v", taskState.CurrentStep+1, command.Action, err),
+			Code:    "UNSAFE_URL",
+			TaskID:  taskState.TaskID,
+			Step:    taskState.CurrentStep,
+			Error:   err.Error(),
+			Results: taskState.Results,
+		},
+	})
+}
+
+var variableRefRegex = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// interpolateVariables replaces {{name}} in Text and URL with values from
+// earlier extract steps. Inside a longer URL the value is query-escaped.
+// Unknown names are left in place.
+func interpolateVariables(command CommandPayload, variables map[string]string) CommandPayload {
+	if len(variables) == 0 {
+		return command
+	}
+
+	command.Text = replaceVariables(command.Text, variables, func(v string) string { return v })
+	command.URL = interpolateURL(command.URL, variables)
+	return command
+}
+
+// interpolateURL fills {{name}} references in rawURL, query-escaping values
+// unless the reference is the whole URL
+func interpolateURL(rawURL string, variables map[string]string) string {
+	if strings.TrimSpace(variableRefRegex.ReplaceAllString(rawURL, "")) == "" {
+		return replaceVariables(rawURL, variables, strings.TrimSpace)
+	}
+	return replaceVariables(rawURL, variables, url.QueryEscape)
+}
+
+// replaceVariables substitutes escape(value) for each {{name}} in s that has
+// a value, leaving the rest untouched
+func replaceVariables(s string, variables map[string]string, escape func(string) string) string {
+	return variableRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		name := variableRefRegex.FindStringSubmatch(ref)[1]
+		value, ok := variables[name]
+		if !ok {
+			return ref
+		}
+		return escape(value)
+	})
+}
+
+// handleStepTimeout fails the current step when the extension never reported
+// COMMAND_COMPLETE, feeding it through the normal retry path
+func handleStepTimeout(session *Session, taskState *TaskState, attempt int) {
+	session.stepMu.Lock()
+	defer session.stepMu.Unlock()
+
+	if taskState.stepAttempt != attempt {
+		return
+	}
+	if _, ok := session.getTask(taskState.TaskID); !ok {
+		return
+	}
+
+	command := taskState.Sequence.Commands[taskState.CurrentStep]
+	taskState.logger.Warn("Step timed out", "step", taskState.CurrentStep, "action", command.Action, "timeout", commandTimeout(command))
+
+	taskState.stepTimer = nil
+	result := CommandResult{
+		Step:      taskState.CurrentStep,
+		Action:    command.Action,
+		Success:   false,
+		Error:     fmt.Sprintf("no COMMAND_COMPLETE within %v", commandTimeout(command)),
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	taskState.recordStepTiming(&result)
+	taskState.Results = append(taskState.Results, result)
+
+	if err := retryOrFailStep(session, taskState, result); err != nil {
+		taskState.logger.Error("Failed to handle step timeout", "error", err)
+	}
+}
+
+func handleHandshake(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var handshake HandshakePayload
+	if err := json.Unmarshal(payloadBytes, &handshake); err != nil {
+		session.logger.Warn("Failed to parse handshake payload", "error", err)
+	}
+	if clientID := strings.TrimSpace(handshake.ClientID); clientID != "" {
+		session.setClientID(clientID)
+	}
+	session.logger.Info("Handshake received from extension", "client", handshake.Client, "version", handshake.Version, "client_id", handshake.ClientID)
+
+	return scheduler.RunQueued(session)
+}
+
+func handleCancelTask(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var cancelPayload TaskIDPayload
+	if err := json.Unmarshal(payloadBytes, &cancelPayload); err != nil {
+		session.logger.Warn("Failed to parse cancel payload", "error", err)
+		return nil
+	}
+
+	taskState, ok := session.getTask(cancelPayload.TaskID)
+	if !ok || !session.cancelTask(taskState) {
+		session.logger.Info("Cancel requested for unknown task", "task_id", cancelPayload.TaskID)
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: fmt.Sprintf("Unknown task: %s", cancelPayload.TaskID),
+				Code:    "TASK_NOT_FOUND",
+			},
+		})
+	}
+
+	taskState.logger.Info("Task cancelled", "step", taskState.CurrentStep)
+
+	return session.send(&Message{
+		Type: "TASK_CANCELLED",
+		Payload: TaskCancelledPayload{
+			TaskID:  taskState.TaskID,
+			Message: "Task cancelled by user",
+			Step:    taskState.CurrentStep,
+		},
+	})
+}
+
+func handleGetTaskStatus(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var statusPayload TaskIDPayload
+	if err := json.Unmarshal(payloadBytes, &statusPayload); err != nil {
+		session.logger.Warn("Failed to parse task status payload", "error", err)
+		return nil
+	}
+
+	taskState, ok := session.getTask(statusPayload.TaskID)
+	if !ok {
+		// Finished tasks leave the session right away, but a client that
+		// reconnects can still ask about the ones it started
+		if status, ok := registry.FinishedTask(session.owner(), statusPayload.TaskID); ok {
+			return session.send(&Message{
+				Type:    "TASK_STATUS",
+				Payload: status,
+			})
+		}
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: fmt.Sprintf("Unknown task: %s", statusPayload.TaskID),
+				Code:    "TASK_NOT_FOUND",
 			},
 		})
 	}
+
+	session.stepMu.Lock()
+	status := taskState.statusPayload()
+	session.stepMu.Unlock()
+
+	return session.send(&Message{
+		Type:    "TASK_STATUS",
+		Payload: status,
+	})
 }
 
-func handleExecuteTaskWithCompletion(conn *websocket.Conn, payload interface{}) error {
+func handleExecuteTaskWithCompletion(session *Session, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Failed to parse task payload",
@@ -253,7 +956,7 @@ func handleExecuteTaskWithCompletion(conn *websocket.Conn, payload interface{})
 
 	var taskPayload ExecuteTaskPayload
 	if err := json.Unmarshal(payloadBytes, &taskPayload); err != nil {
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Invalid task payload format",
@@ -262,11 +965,62 @@ func handleExecuteTaskWithCompletion(conn *websocket.Conn, payload interface{})
 		})
 	}
 
-	log.Printf("Processing goal: %s", taskPayload.Goal)
+	if shuttingDown.Load() {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Server is shutting down",
+				Code:    "SERVER_SHUTTING_DOWN",
+			},
+		})
+	}
+
+	// Planning may continue after this returns, so it's reserved up front
+	// to keep a second goal from starting alongside it
+	if !session.startPlanning() {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Another goal or batch is still running on this connection",
+				Code:    "GOAL_IN_PROGRESS",
+			},
+		})
+	}
+
+	session.logger.Info("Processing goal", "goal", taskPayload.Goal)
+
+	pageContext := session.getPageContextFor(taskPayload.URL)
+	if pageContext == nil && needsPageContext(taskPayload.Goal) {
+		// The reply arrives on the read loop, so it can't be awaited here
+		go func() {
+			defer session.endPlanning()
+			pageContext, err := session.requestPageContent(pageContentRequestTimeout)
+			if err != nil {
+				session.logger.Info("Parsing goal without page context", "error", err)
+			}
+			if err := parseAndStartGoal(session, taskPayload, pageContext); err != nil {
+				session.logger.Error("Failed to start goal", "error", err)
+				session.send(&Message{
+					Type: "TASK_FAILED",
+					Payload: TaskFailedPayload{
+						Message: fmt.Sprintf("Could not start goal: %v", err),
+						Code:    "GOAL_START_FAILED",
+						Error:   err.Error(),
+					},
+				})
+			}
+		}()
+		return nil
+	}
+	defer session.endPlanning()
+	return parseAndStartGoal(session, taskPayload, pageContext)
+}
 
-	sequence := parseGoalToSequence(taskPayload.Goal, conn)
+// parseAndStartGoal plans taskPayload.Goal against pageContext and runs it
+func parseAndStartGoal(session *Session, taskPayload ExecuteTaskPayload, pageContext *llm.PageContext) error {
+	sequence := parseGoalToSequence(session.logger, taskPayload.Goal, pageContext, session.getConversationHistory(), llmProgressReporter(session, taskPayload.Goal))
 	if sequence == nil || len(sequence.Commands) == 0 {
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Could not understand the goal",
@@ -275,119 +1029,450 @@ func handleExecuteTaskWithCompletion(conn *websocket.Conn, payload interface{})
 		})
 	}
 
-	taskID := generateTaskID()
+	session.addConversationTurns(
+		llm.ConversationTurn{Role: "user", Content: taskPayload.Goal},
+		llm.ConversationTurn{Role: "assistant", Content: describeSequence(sequence)},
+	)
+
+	_, err := startTask(session, taskPayload, sequence, nil)
+	return err
+}
+
+// startTask validates sequence, registers it as a task for taskPayload.Goal
+// with its retry and timeout options, and dispatches the first command. It
+// returns nil when the sequence was rejected. onFinish, if set, is called
+// when the task reaches a final status.
+func startTask(session *Session, taskPayload ExecuteTaskPayload, sequence *CommandSequence, onFinish func(*TaskState)) (*TaskState, error) {
+	if err := validateCommandURLs(sequence.Commands); err != nil {
+		session.logger.Warn("Rejected goal with unsafe URL", "goal", taskPayload.Goal, "error", err)
+		return nil, session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: err.Error(),
+				Code:    "UNSAFE_URL",
+			},
+		})
+	}
+	if err := validateCommandFileNames(sequence.Commands); err != nil {
+		session.logger.Warn("Rejected goal with unsafe file name", "goal", taskPayload.Goal, "error", err)
+		return nil, session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: err.Error(),
+				Code:    "UNSAFE_FILE_NAME",
+			},
+		})
+	}
+
+	if sequence.RepeatUntil != "" {
+		sequence.Commands, sequence.RepeatFrom = expandPaginationLoop(sequence.Commands, sequence.RepeatFrom, sequence.RepeatUntil)
+		if sequence.MaxIterations <= 0 {
+			sequence.MaxIterations = defaultMaxIterations
+		}
+	} else {
+		sequence.Commands = insertConditionChecks(insertNavigationWaits(sequence.Commands))
+	}
+	sequence.Total = len(sequence.Commands)
+
+	if taskPayload.MaxRetries <= 0 {
+		taskPayload.MaxRetries = *maxRetriesFlag
+	}
+	if taskPayload.RetryBackoffMs <= 0 {
+		taskPayload.RetryBackoffMs = 500
+	}
+
+	// Steps without their own timeout inherit the task default
+	if taskPayload.DefaultTimeoutMs > 0 {
+		for i := range sequence.Commands {
+			if sequence.Commands[i].TimeoutMs == 0 {
+				sequence.Commands[i].TimeoutMs = taskPayload.DefaultTimeoutMs
+			}
+		}
+	}
+
+	taskID := registry.NextTaskID()
 	taskState := &TaskState{
-		TaskID:      taskID,
-		Goal:        taskPayload.Goal,
-		Sequence:    *sequence,
-		Status:      "pending",
-		CurrentStep: 0,
-		Results:     []CommandResult{},
+		TaskID:           taskID,
+		Goal:             taskPayload.Goal,
+		Sequence:         *sequence,
+		CurrentStep:      0,
+		Results:          []CommandResult{},
+		DefaultTimeoutMs: taskPayload.DefaultTimeoutMs,
+		MaxRetries:       taskPayload.MaxRetries,
+		RetryBackoffMs:   taskPayload.RetryBackoffMs,
+		Variables:        map[string]string{},
+		Iteration:        1,
+		StartedAt:        time.Now(),
+		logger:           session.logger.With("task_id", taskID),
+		onFinish:         onFinish,
+		secrets:          taskPayload.secrets,
+		owner:            session.owner(),
+	}
+	if !session.putTask(taskState) {
+		// A goal planned while the connection closed
+		session.logger.Info("Dropping task, connection closed", "goal", taskPayload.Goal)
+		return nil, nil
 	}
-	activeTasks[taskID] = taskState
+	taskState.transition("pending")
 
 	sequence.TaskID = taskID
 
+	session.stepMu.Lock()
+	defer session.stepMu.Unlock()
+
 	if len(sequence.Commands) == 1 {
-		taskState.Status = "executing"
+		taskState.transition("executing")
 		sequence.Current = 0
 		sequence.Total = 1
 
-		command := sequence.Commands[0]
-		if err := sendMessage(conn, &Message{
-			Type:    "COMMAND",
-			Payload: command,
-		}); err != nil {
-			return err
+		if err := dispatchCommand(session, taskState, sequence.Commands[0]); err != nil {
+			return taskState, err
 		}
 
 	} else {
-		taskState.Status = "executing"
+		taskState.transition("executing")
 		sequence.TaskID = taskID
 		sequence.Current = 0
 		sequence.Total = len(sequence.Commands)
 
-		if err := sendMessage(conn, &Message{
+		if err := session.send(&Message{
 			Type:    "COMMAND_SEQUENCE",
 			Payload: sequence,
 		}); err != nil {
-			return err
+			return taskState, err
 		}
 
-		if err := sendMessage(conn, &Message{
-			Type:    "COMMAND",
-			Payload: sequence.Commands[0],
-		}); err != nil {
-			return err
+		if err := dispatchCommand(session, taskState, sequence.Commands[0]); err != nil {
+			return taskState, err
 		}
 	}
 
-	return nil
+	return taskState, nil
 }
 
-func sendMessage(conn *websocket.Conn, message *Message) error {
-	responseBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Println("JSON marshal error:", err)
-		return err
+// validateCommandURLs checks every command that carries a URL
+func validateCommandURLs(commands []CommandPayload) error {
+	for i, cmd := range commands {
+		// URLs built from extracted values are checked once filled in, when
+		// the step is dispatched
+		if cmd.URL == "" || variableRefRegex.MatchString(cmd.URL) {
+			continue
+		}
+		if err := llm.ValidateCommandURL(cmd.URL); err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, cmd.Action, err)
+		}
 	}
-
+	return nil
+}
+
+// cssSelectorFields points at the fields of cmd that hold a CSS selector:
+// every selector field except an XPath Selector, and the ShadowPath hosts
+func cssSelectorFields(cmd *CommandPayload) []*string {
+	fields := []*string{
+		&cmd.ScrollSelector, &cmd.WaitSelector, &cmd.ExtractSelector, &cmd.SourceSelector,
+		&cmd.TargetSelector, &cmd.FrameSelector, &cmd.Condition, &cmd.AssertSelector,
+	}
+	if cmd.SelectorType != "xpath" {
+		fields = append(fields, &cmd.Selector)
+	}
+	for i := range cmd.ShadowPath {
+		fields = append(fields, &cmd.ShadowPath[i])
+	}
+	return fields
+}
+
+// validateCommandFileNames checks the file name of every upload_file
+// command, so a plan can't reach outside the downloads folder
+func validateCommandFileNames(commands []CommandPayload) error {
+	for i, cmd := range commands {
+		if cmd.Action != "upload_file" {
+			continue
+		}
+		if err := llm.ValidateFileName(cmd.FileName); err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, cmd.Action, err)
+		}
+	}
+	return nil
+}
+
+// stepDelay returns how long to wait after cmd before dispatching the next command
+func stepDelay(cmd CommandPayload) time.Duration {
+	if cmd.TimeoutMs > 0 {
+		return time.Duration(cmd.TimeoutMs) * time.Millisecond
+	}
+	if isWaitAction(cmd.Action) || cmd.Action == "check_element" {
+		// The extension already waited for the page, or nothing changed
+		return 0
+	}
+	if changesPage(cmd.Action) {
+		// A wait_for_ready_state step follows and polls for the load, so
+		// this is only extra settling time for script-heavy sites
+		return *navDelayFlag
+	}
+	return *stepDelayFlag
+}
+
+// defaultPageLoadWaitMs bounds the readyState wait injected after navigation
+const defaultPageLoadWaitMs = 15000
+
+// insertNavigationWaits follows every page-changing step that has more steps
+// after it with a wait_for_ready_state step, so the next command runs once
+// the page has loaded rather than after a fixed sleep
+func insertNavigationWaits(commands []CommandPayload) []CommandPayload {
+	result := make([]CommandPayload, 0, len(commands))
+	for i, cmd := range commands {
+		result = append(result, cmd)
+		if !changesPage(cmd.Action) || i == len(commands)-1 || isWaitAction(commands[i+1].Action) {
+			continue
+		}
+		result = append(result, CommandPayload{
+			Action:        "wait_for_ready_state",
+			WaitTimeoutMs: defaultPageLoadWaitMs,
+		})
+	}
+	return result
+}
+
+// isWaitAction reports whether an action only waits on the page
+func isWaitAction(action string) bool {
+	return action == "wait_for_element" || action == "wait_for_ready_state"
+}
+
+// changesPage reports whether an action loads a new document
+func changesPage(action string) bool {
+	switch action {
+	case "navigate", "open_tab", "back", "forward":
+		return true
+	}
+	return false
+}
+
+// insertConditionChecks puts a check_element step before every command with
+// a Condition; handleCommandComplete skips the command if it finds nothing
+func insertConditionChecks(commands []CommandPayload) []CommandPayload {
+	result := make([]CommandPayload, 0, len(commands))
+	for _, cmd := range commands {
+		if cmd.Condition != "" {
+			result = append(result, CommandPayload{
+				Action:   "check_element",
+				Selector: cmd.Condition,
+			})
+		}
+		result = append(result, cmd)
+	}
+	return result
+}
+
+// commandTimeout is how long to wait for a command's COMMAND_COMPLETE,
+// stretched for waits that may legitimately take longer
+func commandTimeout(cmd CommandPayload) time.Duration {
+	timeout := *commandTimeoutFlag
+	if isWaitAction(cmd.Action) {
+		if wait := time.Duration(cmd.WaitTimeoutMs)*time.Millisecond + 5*time.Second; wait > timeout {
+			timeout = wait
+		}
+	}
+	return timeout
+}
+
+// sendMessage encodes message and writes it through the serializing conn
+func sendMessage(logger *slog.Logger, conn *safeConn, message *Message) error {
+	responseBytes, err := json.Marshal(message)
+	if err != nil {
+		logger.Error("Failed to encode message", "type", message.Type, "error", err)
+		return err
+	}
+
 	if err := conn.WriteMessage(websocket.TextMessage, responseBytes); err != nil {
-		log.Println("Write error:", err)
+		logger.Error("Write failed", "type", message.Type, "error", err)
 		return err
 	}
 
-	log.Printf("Sent: %s", string(responseBytes))
+	logger.Debug("Sent", "message", string(responseBytes))
 	return nil
 }
 
-func generateTaskID() string {
-	counter := atomic.AddInt64(&taskCounter, 1)
-	return fmt.Sprintf("task_%d_%d", time.Now().Unix(), counter)
+// llmProgressReporter returns a callback that relays streamed LLM output as
+// LLM_THINKING messages with the new text and TASK_PROGRESS messages with
+// everything so far, throttled so the extension isn't flooded
+func llmProgressReporter(session *Session, goal string) func(partial string) {
+	var lastSent time.Time
+	sentChars := 0
+	return func(partial string) {
+		if time.Since(lastSent) < 250*time.Millisecond {
+			return
+		}
+		lastSent = time.Now()
+
+		if err := session.send(&Message{
+			Type: "LLM_THINKING",
+			Payload: LLMThinkingPayload{
+				Goal:  goal,
+				Text:  partial[sentChars:],
+				Chars: len(partial),
+			},
+		}); err != nil {
+			session.logger.Warn("Failed to send LLM output", "error", err)
+		}
+		sentChars = len(partial)
+
+		if err := session.send(&Message{
+			Type: "TASK_PROGRESS",
+			Payload: TaskProgressPayload{
+				Goal:    goal,
+				Stage:   "llm_parsing",
+				Chars:   len(partial),
+				Partial: partial,
+			},
+		}); err != nil {
+			session.logger.Warn("Failed to send LLM progress", "error", err)
+		}
+	}
 }
 
-func parseGoalToSequence(goal string, conn *websocket.Conn) *CommandSequence {
-	originalGoal := goal
-	goal = strings.ToLower(strings.TrimSpace(goal))
-	log.Printf("Parsing goal to sequence: %s", goal)
+// describeSequence summarizes a plan for the conversation history, e.g.
+// `navigate https://github.com; input "python" into input[name='q']`
+func describeSequence(sequence *CommandSequence) string {
+	steps := make([]string, 0, len(sequence.Commands))
+	for _, cmd := range sequence.Commands {
+		step := cmd.Action
+		if cmd.URL != "" {
+			step += " " + cmd.URL
+		}
+		if cmd.Text != "" {
+			step += fmt.Sprintf(" %q", cmd.Text)
+		}
+		switch {
+		case cmd.Selector != "" && cmd.Action == "input":
+			step += " into " + cmd.Selector
+		case cmd.Selector != "":
+			step += " on " + cmd.Selector
+		}
+		steps = append(steps, step)
+	}
+	return strings.Join(steps, "; ")
+}
 
-	var pageContext *llm.PageContext
-	if conn != nil {
-		pageContext = pageContexts[conn]
-		if pageContext != nil {
-			log.Printf("Using stored page context: %s (Title: %s)", pageContext.URL, pageContext.Title)
-		} else {
-			log.Printf("No page context available for this connection")
+func parseGoalToSequence(logger *slog.Logger, goal string, pageContext *llm.PageContext, history []llm.ConversationTurn, onProgress func(partial string)) *CommandSequence {
+	goal = strings.TrimSpace(goal)
+
+	if rest, paginated := splitPaginationGoal(goal); paginated {
+		logger.Debug("Goal asks for pagination", "goal", rest)
+		if rest == "" {
+			return withPagination(nil)
 		}
+		return withPagination(parseGoalToSequence(logger, rest, pageContext, history, onProgress))
 	}
 
-	if useLLM && llmClient != nil && llm.ShouldUseLLM(originalGoal) {
-		log.Println("Using LLM for goal parsing with page context")
-		llmSequence, err := llm.ParseGoalWithLLM(llmClient, originalGoal, pageContext)
-		if err != nil {
-			log.Printf("LLM parsing failed: %v, falling back to rules", err)
-		} else if llmSequence != nil && len(llmSequence.Commands) > 0 {
-			// Convert LLM sequence to main package sequence
-			commands := make([]CommandPayload, len(llmSequence.Commands))
-			for i, cmd := range llmSequence.Commands {
-				commands[i] = CommandPayload{
-					Action:   cmd.Action,
-					URL:      cmd.URL,
-					Selector: cmd.Selector,
-					Text:     cmd.Text,
-				}
-			}
-			return &CommandSequence{
-				Commands: commands,
-				Total:    len(commands),
-				Current:  0,
+	// The LLM never plans evaluate steps, so a script the user spelled out
+	// goes straight to the rules
+	if command := parseEvaluateCommand(goal); command != nil {
+		goalParsesTotal.WithLabelValues("rules").Inc()
+		return &CommandSequence{Commands: []CommandPayload{*command}, Total: 1}
+	}
+
+	if pageContext != nil {
+		logger.Debug("Using stored page context", "url", pageContext.URL, "title", pageContext.Title)
+	} else {
+		logger.Debug("No page context available for this connection")
+	}
+
+	llmAvailable := useLLM && llmClient != nil
+	triedLLM := false
+
+	intent := llm.ClassifyGoalIntent(goal)
+	logger.Debug("Classified goal", "intent", intent)
+
+	if llmAvailable && llm.ShouldUseLLM(goal) {
+		logger.Info("Using LLM for goal parsing")
+		triedLLM = true
+		if sequence := parseGoalWithLLM(logger, goal, pageContext, history, onProgress); sequence != nil {
+			goalParsesTotal.WithLabelValues("llm").Inc()
+			return sequence
+		}
+	}
+
+	// Rules see the original case so URL paths and cookie values survive
+	sequence := parseGoalWithRules(goal)
+
+	if llmAvailable && !triedLLM {
+		confidence := scoreParsedSequence(sequence)
+		threshold := llm.GetConfig().LLMConfidenceThreshold
+		if confidence < threshold {
+			logger.Info("Rule-based confidence below threshold, trying LLM", "confidence", confidence, "threshold", threshold)
+			if llmSequence := parseGoalWithLLM(logger, goal, pageContext, history, onProgress); llmSequence != nil {
+				goalParsesTotal.WithLabelValues("llm").Inc()
+				return llmSequence
 			}
 		}
 	}
 
+	if sequence != nil {
+		goalParsesTotal.WithLabelValues("rules").Inc()
+	}
+	return sequence
+}
+
+// parseGoalWithLLM asks the LLM for a plan, returning nil if it fails
+func parseGoalWithLLM(logger *slog.Logger, goal string, pageContext *llm.PageContext, history []llm.ConversationTurn, onProgress func(partial string)) *CommandSequence {
+	llmSequence, err := llm.ParseGoalWithLLMProgress(llmClient, goal, pageContext, history, onProgress)
+	if err != nil {
+		logger.Warn("LLM parsing failed, falling back to rules", "error", err)
+		return nil
+	}
+	if llmSequence == nil || len(llmSequence.Commands) == 0 {
+		return nil
+	}
+	if minConfidence := llm.GetConfig().MinLLMConfidence; llmSequence.Confidence < minConfidence {
+		logger.Info("LLM confidence below minimum, falling back to rules", "confidence", llmSequence.Confidence, "min_confidence", minConfidence)
+		return nil
+	}
+
+	// Convert LLM sequence to main package sequence
+	commands := make([]CommandPayload, len(llmSequence.Commands))
+	for i, cmd := range llmSequence.Commands {
+		commands[i] = CommandPayload{
+			Action:          cmd.Action,
+			URL:             cmd.URL,
+			Selector:        cmd.Selector,
+			SelectorType:    cmd.SelectorType,
+			Text:            cmd.Text,
+			MatchText:       cmd.MatchText,
+			ScrollX:         cmd.ScrollX,
+			ScrollY:         cmd.ScrollY,
+			ScrollSelector:  cmd.ScrollSelector,
+			ExtractSelector: cmd.ExtractSelector,
+			SourceSelector:  cmd.SourceSelector,
+			TargetSelector:  cmd.TargetSelector,
+			Keys:            cmd.Keys,
+			DialogAction:    cmd.DialogAction,
+			DialogText:      cmd.DialogText,
+			FileName:        cmd.FileName,
+			URLPattern:      cmd.URLPattern,
+			MockResponse:    cmd.MockResponse,
+			StoreAs:         cmd.StoreAs,
+			Condition:       cmd.Condition,
+			FrameSelector:   cmd.FrameSelector,
+			ShadowPath:      cmd.ShadowPath,
+		}
+	}
+	if pageContext != nil {
+		commands = validateSelectors(logger, commands, pageContext.HTML)
+	}
+	return &CommandSequence{
+		Commands: commands,
+		Total:    len(commands),
+		Current:  0,
+	}
+}
+
+// parseGoalWithRules runs the keyword-based parser on goal as typed. Each
+// step lowercases its own copy for keyword matching, so URLs, quoted search
+// terms and cookie values keep their case.
+func parseGoalWithRules(goal string) *CommandSequence {
 	commands := []CommandPayload{}
 
-	if strings.Contains(goal, " and ") || strings.Contains(goal, ", then ") || strings.Contains(goal, " then ") {
+	if len(goalSteps(goal)) > 1 {
 		commands = parseMultiStepGoal(goal)
 	} else {
 		command := parseSingleCommand(goal)
@@ -407,12 +1492,87 @@ func parseGoalToSequence(goal string, conn *websocket.Conn) *CommandSequence {
 	}
 }
 
+// scoreParsedSequence rates a rule-based parse from 0 to 1 by the fraction of
+// commands whose required fields are filled in with something specific
+func scoreParsedSequence(sequence *CommandSequence) float64 {
+	if sequence == nil || len(sequence.Commands) == 0 {
+		return 0
+	}
+
+	complete := 0
+	for _, cmd := range sequence.Commands {
+		switch cmd.Action {
+		case "navigate", "open_tab":
+			if cmd.URL != "" {
+				complete++
+			}
+		case "input":
+			if cmd.Selector != "" && cmd.Text != "" {
+				complete++
+			}
+		case "click":
+			if cmd.Text != "" || (cmd.Selector != "" && cmd.Selector != "*") {
+				complete++
+			}
+		case "select_option":
+			if cmd.Text != "" {
+				complete++
+			}
+		case "check", "uncheck":
+			if cmd.Text != "" {
+				complete++
+			}
+		case "click_text":
+			if cmd.MatchText != "" {
+				complete++
+			}
+		case "press_key":
+			if cmd.Text != "" {
+				complete++
+			}
+		case "key":
+			if cmd.Keys != "" {
+				complete++
+			}
+		case "drag":
+			// Selectors guessed from descriptions are too shaky to trust
+			if isExplicitSelector(cmd.SourceSelector) && isExplicitSelector(cmd.TargetSelector) {
+				complete++
+			}
+		case "hover":
+			if cmd.Text != "" || (cmd.Selector != "" && cmd.Selector != hoverableSelector) {
+				complete++
+			}
+		case "copy_to_clipboard", "paste_from_clipboard":
+			if cmd.Selector != "" {
+				complete++
+			}
+		case "upload_file":
+			if cmd.FileName != "" {
+				complete++
+			}
+		case "intercept_request":
+			if cmd.URLPattern != "" && cmd.MockResponse != "" {
+				complete++
+			}
+		case "evaluate":
+			if cmd.Script != "" {
+				complete++
+			}
+		case "get_content", "back", "forward", "handle_dialog", "clear_intercepts":
+			complete++
+		}
+	}
+
+	return float64(complete) / float64(len(sequence.Commands))
+}
+
 func parseMultiStepGoal(goal string) []CommandPayload {
 	commands := []CommandPayload{}
 
-	parts := regexp.MustCompile(`\s+(and|then|, then|, and)\s+`).Split(goal, -1)
+	parts := goalSteps(goal)
 
-	for _, part := range parts {
+	for i, part := range parts {
 		part = strings.TrimSpace(part)
 		if part == "" {
 			continue
@@ -420,9 +1580,20 @@ func parseMultiStepGoal(goal string) []CommandPayload {
 
 		command := parseSingleCommand(part)
 		if command != nil {
+			if command.Action == "press_key" && command.Selector == "" && len(commands) > 0 && commands[len(commands)-1].Action == "input" {
+				// "search for X and press enter" sends Enter to the search box
+				command.Selector = commands[len(commands)-1].Selector
+			}
+			if command.Action == "handle_dialog" && len(commands) > 0 && commands[len(commands)-1].Action == "click" {
+				// "click Delete and accept the alert": the dialog blocks the
+				// page, so its answer is armed before the click
+				last := commands[len(commands)-1]
+				commands = append(commands[:len(commands)-1], *command, last)
+				continue
+			}
 			commands = append(commands, *command)
 
-			if command.Action == "input" && containsSearchKeywords(part) {
+			if command.Action == "input" && containsSearchKeywords(strings.ToLower(part)) && !nextPartPressesKey(parts, i) {
 				searchButtonCommand := &CommandPayload{
 					Action:   "click",
 					Selector: "input[type='submit'], button[type='submit'], button[name='btnK'], button[name='btnG'], [aria-label*='Search' i], [value*='Search' i]",
@@ -435,14 +1606,483 @@ func parseMultiStepGoal(goal string) []CommandPayload {
 	return commands
 }
 
+// goalSteps splits a goal into the parts that become separate commands:
+// list items first, then conjunctions within each item
+func goalSteps(goal string) []string {
+	steps := splitStepList(goal)
+	if steps == nil {
+		steps = []string{goal}
+	}
+	parts := []string{}
+	for _, step := range steps {
+		parts = append(parts, splitConjunctions(step)...)
+	}
+	return parts
+}
+
+// conjunctionRegex matches the "and" or "then" that may join two steps
+var conjunctionRegex = regexp.MustCompile(`(?i),?\s+(and\s+then|and|then)\s+`)
+
+// actionVerbs are the words a step of a goal starts with. An "and" only
+// separates steps when the words after it start with one of them.
+var actionVerbs = map[string]bool{
+	"go": true, "navigate": true, "open": true, "visit": true, "browse": true, "load": true,
+	"search": true, "google": true, "look": true, "find": true, "type": true, "enter": true, "input": true, "fill": true, "write": true,
+	"click": true, "tap": true, "press": true, "hit": true, "push": true, "submit": true,
+	"select": true, "choose": true, "pick": true, "check": true, "uncheck": true, "tick": true, "untick": true, "toggle": true,
+	"scroll": true, "hover": true, "drag": true, "drop": true, "move": true,
+	"upload": true, "attach": true, "accept": true, "dismiss": true, "confirm": true, "cancel": true, "answer": true,
+	"copy": true, "paste": true, "wait": true, "extract": true, "get": true, "read": true, "save": true,
+	"back": true, "forward": true, "refresh": true, "reload": true, "close": true, "switch": true, "list": true,
+	"login": true, "log": true, "sign": true, "mock": true, "stub": true, "intercept": true, "clear": true,
+	"set": true, "delete": true, "remove": true, "add": true, "show": true,
+}
+
+// splitConjunctions splits step at every "then", and at an "and" only when
+// a new action follows, so "search for cats and dogs" stays one step while
+// "go to google and search for cats" becomes two
+func splitConjunctions(step string) []string {
+	parts := []string{}
+	start := 0
+	for _, m := range conjunctionRegex.FindAllStringSubmatchIndex(step, -1) {
+		if strings.EqualFold(step[m[2]:m[3]], "and") && !startsWithActionVerb(step[m[1]:]) {
+			continue
+		}
+		parts = append(parts, step[start:m[0]])
+		start = m[1]
+	}
+	return append(parts, step[start:])
+}
+
+func startsWithActionVerb(s string) bool {
+	words := strings.Fields(strings.ToLower(s))
+	return len(words) > 0 && actionVerbs[strings.Trim(words[0], ".,;:!?\"'")]
+}
+
+var (
+	// stepMarkerRegex finds "1." or "2)" list numbers inside a one-line goal
+	stepMarkerRegex = regexp.MustCompile(`(?:^|\s)(\d{1,2})[.)]\s+`)
+	// bulletRegex strips a list bullet or number from the start of a line
+	bulletRegex = regexp.MustCompile(`^(?:[-*•]|\d{1,2}[.)])\s+`)
+)
+
+// splitStepList splits a goal written as a list into its steps: one step
+// per line, or "1. go to google.com 2. search for cats" on a single line.
+// Bullets, numbers and trailing punctuation are stripped. A one-line goal only counts as a list
+// when it starts at 1 and counts up, so "buy 2) items" is left alone. It
+// returns nil when the goal isn't a list of at least two steps.
+func splitStepList(goal string) []string {
+	goal = strings.TrimSpace(goal)
+
+	var steps []string
+	if strings.Contains(goal, "\n") {
+		for _, line := range strings.Split(goal, "\n") {
+			if line = strings.TrimRight(bulletRegex.ReplaceAllString(strings.TrimSpace(line), ""), " .;,"); line != "" {
+				steps = append(steps, line)
+			}
+		}
+	} else {
+		markers := stepMarkerRegex.FindAllStringSubmatchIndex(goal, -1)
+		if len(markers) < 2 || markers[0][0] != 0 {
+			return nil
+		}
+		for i, m := range markers {
+			if n, _ := strconv.Atoi(goal[m[2]:m[3]]); n != i+1 {
+				return nil
+			}
+			end := len(goal)
+			if i+1 < len(markers) {
+				end = markers[i+1][0]
+			}
+			if step := strings.TrimRight(strings.TrimSpace(goal[m[1]:end]), " .;,"); step != "" {
+				steps = append(steps, step)
+			}
+		}
+	}
+
+	if len(steps) < 2 {
+		return nil
+	}
+	return steps
+}
+
+var dragRegex = regexp.MustCompile(`(?i)^(?:drag|drop|move)\s+(?:and\s+drop\s+)?(?:the\s+)?(.+?)\s+(?:to|onto|into|over\s+to)\s+(?:the\s+)?(.+?)\.?$`)
+
+// parseDragCommand handles "drag #card-1 to #done" and "drag the Fix login
+// card to the Done column". CSS selectors are used as given; descriptions
+// become selector guesses, which scoreParsedSequence rates low so the LLM
+// gets a chance at the goal.
+func parseDragCommand(goal string) *CommandPayload {
+	m := dragRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	// "move to the next page" and the like aren't drags
+	if strings.EqualFold(strings.Fields(goal)[0], "move") && !strings.Contains(strings.ToLower(m[1]), "card") && !isExplicitSelector(m[1]) {
+		return nil
+	}
+	return &CommandPayload{
+		Action:         "drag",
+		SourceSelector: dragTargetSelector(m[1]),
+		TargetSelector: dragTargetSelector(m[2]),
+	}
+}
+
+var uploadRegex = regexp.MustCompile(`(?i)^(?:upload|attach)\s+(?:the\s+)?(?:file\s+)?["']?([^"'\s]+\.\w{1,8})["']?(?:\s+(?:to|into|in|on|using)\s+(?:the\s+)?(.+?))?\.?$`)
+
+// fileInputSelector matches any file input when the goal doesn't say which
+const fileInputSelector = "input[type='file']"
+
+// parseUploadCommand handles "upload resume.pdf" and "attach report.xlsx
+// to #attachment". The file name is kept as typed; startTask rejects names
+// with path separators.
+func parseUploadCommand(goal string) *CommandPayload {
+	m := uploadRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	selector := fileInputSelector
+	if isExplicitSelector(m[2]) {
+		selector = strings.TrimSpace(m[2])
+	}
+	return &CommandPayload{Action: "upload_file", Selector: selector, FileName: m[1]}
+}
+
+var (
+	dialogNoun         = `(?:the\s+)?(?:alert|confirm(?:ation)?|prompt|dialog|popup|pop-up)(?:\s+(?:box|dialog|window))?`
+	dialogAnswerRegex  = regexp.MustCompile(`(?i)^(?:answer|fill(?:\s+in)?|respond\s+to)\s+` + dialogNoun + `\s+with\s+["']?(.+?)["']?\.?$`)
+	dialogTypeRegex    = regexp.MustCompile(`(?i)^(?:type|enter)\s+["']?(.+?)["']?\s+(?:in|into)\s+` + dialogNoun + `\.?$`)
+	dialogAcceptRegex  = regexp.MustCompile(`(?i)^(?:accept|confirm|ok|click\s+ok\s+(?:on|in)|close)\s+` + dialogNoun + `\.?$`)
+	dialogDismissRegex = regexp.MustCompile(`(?i)^(?:dismiss|cancel|reject|decline|click\s+cancel\s+(?:on|in))\s+` + dialogNoun + `\.?$`)
+)
+
+// parseDialogCommand handles "accept the alert", "dismiss the confirm
+// dialog" and "answer the prompt with Alice"
+func parseDialogCommand(goal string) *CommandPayload {
+	goal = strings.TrimSpace(goal)
+	switch {
+	case dialogAnswerRegex.MatchString(goal):
+		return &CommandPayload{Action: "handle_dialog", DialogAction: "type", DialogText: dialogAnswerRegex.FindStringSubmatch(goal)[1]}
+	case dialogTypeRegex.MatchString(goal):
+		return &CommandPayload{Action: "handle_dialog", DialogAction: "type", DialogText: dialogTypeRegex.FindStringSubmatch(goal)[1]}
+	case dialogAcceptRegex.MatchString(goal):
+		return &CommandPayload{Action: "handle_dialog", DialogAction: "accept"}
+	case dialogDismissRegex.MatchString(goal):
+		return &CommandPayload{Action: "handle_dialog", DialogAction: "dismiss"}
+	}
+	return nil
+}
+
+var (
+	interceptRegex       = regexp.MustCompile(`(?i)^(?:mock|stub|intercept)\s+(?:(?:the\s+)?(?:requests?|calls?|responses?)\s+(?:to|for|from)\s+)?["']?(\S+?)["']?\s+(?:with|returning|to\s+return)\s+(.+?)$`)
+	clearInterceptsRegex = regexp.MustCompile(`(?i)^(?:clear|remove|reset|stop)\s+(?:all\s+)?(?:the\s+)?(?:request\s+)?(?:intercepts?|interceptions?|mocks?|mocking|stubs?)\.?$`)
+)
+
+// parseInterceptCommand handles "mock requests to */api/users with
+// {"users": []}" and "clear all mocks". The response must be valid JSON;
+// anything else is left to the other parsers.
+func parseInterceptCommand(goal string) *CommandPayload {
+	goal = strings.TrimSpace(goal)
+	if clearInterceptsRegex.MatchString(goal) {
+		return &CommandPayload{Action: "clear_intercepts"}
+	}
+	m := interceptRegex.FindStringSubmatch(goal)
+	if m == nil || !json.Valid([]byte(m[2])) {
+		return nil
+	}
+	return &CommandPayload{Action: "intercept_request", URLPattern: m[1], MockResponse: m[2]}
+}
+
+// dragTargetSelector keeps an explicit selector and otherwise guesses one
+// from a description like "Fix login card" or "Done column"
+func dragTargetSelector(description string) string {
+	description = strings.TrimSpace(description)
+	if isExplicitSelector(description) {
+		return description
+	}
+	description = strings.ToLower(description)
+	for _, noun := range []string{" card", " column", " list", " item", " lane", " box", " area", " zone"} {
+		description = strings.TrimSuffix(description, noun)
+	}
+	return scrollTargetSelector(description)
+}
+
+// isExplicitSelector reports whether s reads as a single CSS selector rather
+// than a description or a list of guesses
+func isExplicitSelector(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, ",") || strings.ContainsAny(s, " ") && !strings.ContainsAny(s, "#.[>") {
+		return false
+	}
+	if _, err := llm.SanitizeSelector(s); err != nil {
+		return false
+	}
+	return strings.ContainsAny(s[:1], "#.[")
+}
+
+var hoverRegex = regexp.MustCompile(`(?i)^(?:hover(?:\s+(?:over|on))?|mouse\s*over|move\s+the\s+mouse\s+over|reveal)\s+(?:the\s+)?(.*?)\s*(menu|dropdown|tooltip)?\.?$`)
+
+// hoverableSelector is what a hover by label searches: clickable elements
+// plus the list items and popup triggers menus are built from
+const hoverableSelector = clickableSelector + ", li, [aria-haspopup], [title]"
+
+// parseHoverCommand handles "hover over Products", "mouse over the account
+// icon" and "reveal the Products menu". The label goes in Text, like a click;
+// a bare "reveal menu" targets the first popup trigger.
+func parseHoverCommand(goal string) *CommandPayload {
+	m := hoverRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	verb := strings.ToLower(strings.Fields(goal)[0])
+	label, noun := strings.TrimSpace(m[1]), m[2]
+	if verb == "reveal" && noun == "" {
+		return nil
+	}
+
+	if q := quotedTermRegex.FindStringSubmatch(label); q != nil {
+		for _, group := range q[1:] {
+			if group != "" {
+				label = group
+				break
+			}
+		}
+	}
+	if label == "" {
+		return &CommandPayload{Action: "hover", Selector: "[aria-haspopup='true'], [aria-haspopup='menu'], .dropdown-toggle"}
+	}
+	return &CommandPayload{Action: "hover", Selector: hoverableSelector, Text: clickNounRegex.ReplaceAllString(label, "")}
+}
+
+// evaluateRegex matches "evaluate `document.title`" and "run script
+// 'window.scrollY'"; the script must be quoted so it is taken verbatim
+var evaluateRegex = regexp.MustCompile("(?is)^(?:evaluate|run\\s+(?:the\\s+)?(?:script|javascript|js))\\s+(?:`([^`]+)`|\"([^\"]+)\"|'([^']+)')\\.?$")
+
+// parseEvaluateCommand turns an explicitly quoted script into an evaluate
+// step. This is the only way a goal yields one: plans from the LLM can't.
+func parseEvaluateCommand(goal string) *CommandPayload {
+	m := evaluateRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	return &CommandPayload{Action: "evaluate", Script: m[1] + m[2] + m[3]}
+}
+
+var (
+	copyRegex  = regexp.MustCompile(`(?i)^copy\s+(?:the\s+)?(?:text\s+(?:of|in|from)\s+(?:the\s+)?)?(.+?)(?:\s+to\s+(?:the\s+)?clipboard)?\.?$`)
+	pasteRegex = regexp.MustCompile(`(?i)^paste\s+(?:it\s+|that\s+|the\s+clipboard\s+|from\s+(?:the\s+)?clipboard\s+)?(?:into|in)\s+(?:the\s+)?(.+?)(?:\s+(?:field|box|input))?\.?$`)
+)
+
+// parseClipboardCommand handles "copy the tracking number" and "paste it
+// into the search field". The copy target becomes a selector guess like a
+// scroll target; the paste target is matched against field attributes.
+func parseClipboardCommand(goal string) *CommandPayload {
+	if m := copyRegex.FindStringSubmatch(goal); m != nil {
+		return &CommandPayload{Action: "copy_to_clipboard", Selector: scrollTargetSelector(strings.ToLower(m[1]))}
+	}
+	if m := pasteRegex.FindStringSubmatch(goal); m != nil {
+		target := strings.ToLower(strings.TrimSpace(m[1]))
+		selector := fmt.Sprintf("input[name*='%[1]s' i], input[id*='%[1]s' i], input[placeholder*='%[1]s' i], input[aria-label*='%[1]s' i], textarea[name*='%[1]s' i], textarea[placeholder*='%[1]s' i]", strings.ReplaceAll(target, "'", ""))
+		if target == "search" {
+			selector = "input[name='q'], textarea[name='q'], input[type='search'], [role='searchbox']"
+		}
+		return &CommandPayload{Action: "paste_from_clipboard", Selector: selector}
+	}
+	return nil
+}
+
+// nextPartPressesKey reports whether the goal part after parts[i] is a key
+// press, in which case it submits the search instead of a guessed button
+func nextPartPressesKey(parts []string, i int) bool {
+	return i+1 < len(parts) && parsePressKeyCommand(strings.TrimSpace(parts[i+1])) != nil
+}
+
+var pressKeyRegex = regexp.MustCompile(`(?i)^(?:press|hit|push|tap|type)\s+(?:the\s+)?(enter|return|tab|escape|esc|space|spacebar|backspace|delete|home|end|page ?up|page ?down|(?:arrow ?)?(?:up|down|left|right)(?: arrow)?)(?:\s+key)?(?:\s+(?:again|once))?\.?$`)
+
+// keyNames maps spoken key names to the KeyboardEvent.key values the
+// extension dispatches
+var keyNames = map[string]string{
+	"enter":     "Enter",
+	"return":    "Enter",
+	"tab":       "Tab",
+	"escape":    "Escape",
+	"esc":       "Escape",
+	"space":     " ",
+	"spacebar":  " ",
+	"backspace": "Backspace",
+	"delete":    "Delete",
+	"home":      "Home",
+	"end":       "End",
+	"pageup":    "PageUp",
+	"pagedown":  "PageDown",
+	"up":        "ArrowUp",
+	"down":      "ArrowDown",
+	"left":      "ArrowLeft",
+	"right":     "ArrowRight",
+}
+
+// parsePressKeyCommand handles "press Enter", "hit the escape key" and
+// "press the down arrow"
+func parsePressKeyCommand(goal string) *CommandPayload {
+	m := pressKeyRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	name := strings.ToLower(m[1])
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "arrow"), "arrow")
+	name = strings.ReplaceAll(name, " ", "")
+	key, ok := keyNames[name]
+	if !ok {
+		return nil
+	}
+	return &CommandPayload{Action: "press_key", Text: key}
+}
+
+var keyShortcutRegex = regexp.MustCompile(`(?i)^(?:(?:press|hit|push|tap|use)\s+(?:the\s+)?(?:keyboard\s+)?(?:shortcut\s+|keys?\s+)?|(?:keyboard\s+)?shortcut\s+)["']?([^\s"']+)["']?(?:\s+(?:shortcut|keys?))?\.?$`)
+
+// modifierNames maps spoken modifier names to KeyboardEvent modifier keys
+var modifierNames = map[string]string{
+	"ctrl": "Control", "control": "Control", "shift": "Shift", "alt": "Alt", "option": "Alt",
+	"cmd": "Meta", "command": "Meta", "meta": "Meta", "win": "Meta", "super": "Meta",
+}
+
+var functionKeyRegex = regexp.MustCompile(`(?i)^f(?:[1-9]|1[0-2])$`)
+
+// parseKeyCommand handles shortcuts: "press Ctrl+K", "keyboard shortcut
+// cmd+shift+p" and single-letter keys like "press c". Named keys without
+// modifiers are left to parsePressKeyCommand.
+func parseKeyCommand(goal string) *CommandPayload {
+	m := keyShortcutRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	keys := normalizeKeys(m[1])
+	if keys == "" {
+		return nil
+	}
+	return &CommandPayload{Action: "key", Keys: keys}
+}
+
+// normalizeKeys turns "ctrl+shift+p" into "Control+Shift+p", returning ""
+// unless every part before the last is a modifier and the last is a key
+func normalizeKeys(combo string) string {
+	parts := strings.Split(combo, "+")
+	normalized := make([]string, 0, len(parts))
+	for _, part := range parts[:len(parts)-1] {
+		modifier, ok := modifierNames[strings.ToLower(part)]
+		if !ok {
+			return ""
+		}
+		normalized = append(normalized, modifier)
+	}
+
+	last := parts[len(parts)-1]
+	switch lower := strings.ToLower(last); {
+	case keyNames[lower] != "":
+		last = keyNames[lower]
+	case functionKeyRegex.MatchString(last):
+		last = strings.ToUpper(last)
+	case len([]rune(last)) == 1:
+		last = lower
+	default:
+		return ""
+	}
+	return strings.Join(append(normalized, last), "+")
+}
+
+var frameSuffixRegex = regexp.MustCompile(`(?i)^(.+?)\s+(?:in|inside|within)\s+(?:the\s+)?(.+?)\s+i?frame\.?$`)
+
+// parseFrameSuffix splits "click Pay in the payment iframe" into the goal
+// to run and a selector for the frame it runs in
+func parseFrameSuffix(goal string) (string, string) {
+	m := frameSuffixRegex.FindStringSubmatch(goal)
+	if m == nil {
+		return goal, ""
+	}
+	frame := strings.TrimSpace(m[2])
+	if isExplicitSelector(frame) {
+		return m[1], frame
+	}
+	frame = strings.ReplaceAll(strings.ToLower(frame), "'", "")
+	return m[1], fmt.Sprintf("iframe[name*='%[1]s' i], iframe[id*='%[1]s' i], iframe[title*='%[1]s' i], iframe[src*='%[1]s' i]", frame)
+}
+
 func parseSingleCommand(goal string) *CommandPayload {
-	goal = strings.ToLower(strings.TrimSpace(goal))
-	log.Printf("Parsing goal: %s", goal)
+	original := strings.TrimSpace(goal)
+	if rest, frame := parseFrameSuffix(original); frame != "" {
+		command := parseSingleCommand(rest)
+		if command != nil {
+			command.FrameSelector = frame
+		}
+		return command
+	}
+	if command := parseEvaluateCommand(original); command != nil {
+		return command
+	}
+	goal = strings.ToLower(original)
+	slog.Debug("Parsing goal", "goal", goal)
+
+	if containsScrollKeywords(goal) {
+		return parseScrollCommand(goal)
+	}
+
+	if containsCookieKeywords(goal) {
+		// Cookie names and values are case sensitive, so parse the original
+		return parseCookieCommand(original)
+	}
+
+	if command := parseTabCommand(goal); command != nil {
+		return command
+	}
+
+	if action := historyAction(goal); action != "" {
+		return &CommandPayload{Action: action}
+	}
+
+	if command := parsePressKeyCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseKeyCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseClipboardCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseHoverCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseDragCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseUploadCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseDialogCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseInterceptCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseToggleCommand(original); command != nil {
+		return command
+	}
+
+	if command := parseSelectOptionCommand(original); command != nil {
+		return command
+	}
 
 	if containsNavigationKeywords(goal) {
 		return &CommandPayload{
-			Action: "navigate",
-			URL:    extractURLFromGoal(goal),
+			Action: navigationAction(goal),
+			URL:    extractURLFromGoal(original),
 		}
 	}
 
@@ -456,90 +2096,451 @@ func parseSingleCommand(goal string) *CommandPayload {
 		return &CommandPayload{
 			Action:   "input",
 			Selector: "input[name='q'], textarea[name='q'], input[type='search'], input[type='text'][name='q'], #search, [role='searchbox']",
-			Text:     extractSearchTermFromGoal(goal),
+			Text:     extractSearchTermFromGoal(original),
 		}
 	}
 
 	if containsClickKeywords(goal) {
-		return &CommandPayload{
+		command := &CommandPayload{
 			Action:   "click",
 			Selector: extractSelectorFromGoal(goal),
+			Text:     extractClickLabel(original),
+		}
+		if command.Selector == "*" && command.Text != "" {
+			command.Selector = clickableSelector
 		}
+		return command
 	}
 
 	if containsNavigationKeywords(goal) && containsSearchKeywords(goal) {
 		return &CommandPayload{
 			Action: "navigate",
-			URL:    extractURLFromGoal(goal),
+			URL:    extractURLFromGoal(original),
 		}
 	}
 
 	if containsURL(goal) {
 		return &CommandPayload{
-			Action: "navigate",
-			URL:    extractURLFromGoal(goal),
+			Action: navigationAction(goal),
+			URL:    extractURLFromGoal(original),
+		}
+	}
+
+	return nil
+}
+
+// historyAction maps "go back"/"previous page" and "go forward"/"next page in
+// history" phrasing to the back and forward actions
+func historyAction(goal string) string {
+	goal = strings.TrimSuffix(strings.TrimSpace(goal), ".")
+
+	for _, phrase := range []string{"go back", "navigate back", "back to the previous page", "previous page", "back one page"} {
+		if strings.Contains(goal, phrase) {
+			return "back"
+		}
+	}
+	if goal == "back" {
+		return "back"
+	}
+
+	for _, phrase := range []string{"go forward", "navigate forward", "forward one page"} {
+		if strings.Contains(goal, phrase) {
+			return "forward"
+		}
+	}
+	if goal == "forward" {
+		return "forward"
+	}
+
+	return ""
+}
+
+var switchTabRegex = regexp.MustCompile(`^switch to tab (?:id )?(\d+)$`)
+
+// navigationAction is open_tab for "open X in a new tab", navigate otherwise
+func navigationAction(goal string) string {
+	if strings.Contains(goal, "new tab") {
+		return "open_tab"
+	}
+	return "navigate"
+}
+
+// parseTabCommand handles "list tabs", "close tab", "close tab <id>" and
+// "switch to tab <id>"; opening tabs goes through navigationAction
+func parseTabCommand(goal string) *CommandPayload {
+	goal = strings.TrimSuffix(strings.TrimSpace(goal), ".")
+
+	switch goal {
+	case "list tabs", "list open tabs", "list all tabs", "show tabs", "show open tabs":
+		return &CommandPayload{Action: "list_tabs"}
+	case "close tab", "close this tab", "close the tab", "close current tab", "close the current tab":
+		return &CommandPayload{Action: "close_tab"}
+	}
+
+	if id, ok := strings.CutPrefix(goal, "close tab "); ok {
+		if tabID, err := strconv.Atoi(strings.TrimPrefix(id, "id ")); err == nil {
+			return &CommandPayload{Action: "close_tab", TabID: tabID}
+		}
+	}
+
+	if m := switchTabRegex.FindStringSubmatch(goal); m != nil {
+		tabID, _ := strconv.Atoi(m[1])
+		return &CommandPayload{Action: "switch_tab", TabID: tabID}
+	}
+
+	return nil
+}
+
+var scrollPixelsRegex = regexp.MustCompile(`(\d+)\s*(?:px|pixels?)\b`)
+
+func containsScrollKeywords(goal string) bool {
+	if strings.HasPrefix(goal, "scroll") || strings.HasPrefix(goal, "page down") || strings.HasPrefix(goal, "page up") {
+		return true
+	}
+	for _, phrase := range []string{"go to the bottom of the page", "go to the top of the page", "jump to the bottom", "jump to the top"} {
+		if strings.Contains(goal, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScrollCommand puts the direction ("down", "up", "left", "right",
+// "bottom", "top") or a signed vertical pixel count in Text, or a CSS selector
+// in ScrollSelector for "scroll to <element>"
+func parseScrollCommand(goal string) *CommandPayload {
+	command := &CommandPayload{Action: "scroll"}
+	words := strings.Fields(goal)
+
+	target := ""
+	for _, prefix := range []string{"scroll to ", "go to ", "jump to "} {
+		if idx := strings.Index(goal, prefix); idx != -1 {
+			target = strings.TrimSpace(goal[idx+len(prefix):])
+			target = strings.TrimSuffix(strings.TrimPrefix(target, "the "), " of the page")
+			break
+		}
+	}
+
+	switch {
+	case target == "bottom" || target == "end" || containsWord(words, "bottom"):
+		command.Text = "bottom"
+	case target == "top" || target == "beginning" || containsWord(words, "top"):
+		command.Text = "top"
+	case target != "":
+		command.ScrollSelector = scrollTargetSelector(target)
+	case scrollPixelsRegex.MatchString(goal):
+		pixels := scrollPixelsRegex.FindStringSubmatch(goal)[1]
+		if containsWord(words, "up") {
+			pixels = "-" + pixels
+		}
+		command.Text = pixels
+	case containsWord(words, "up"):
+		command.Text = "up"
+	case containsWord(words, "left"):
+		command.Text = "left"
+	case containsWord(words, "right"):
+		command.Text = "right"
+	default:
+		command.Text = "down"
+	}
+
+	return command
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// scrollTargetSelector turns "the footer" or "#comments" into a CSS selector
+func scrollTargetSelector(target string) string {
+	target = strings.TrimSpace(strings.TrimSuffix(target, " section"))
+
+	if strings.HasPrefix(target, "#") || strings.HasPrefix(target, ".") || strings.HasPrefix(target, "[") {
+		return target
+	}
+
+	switch target {
+	case "footer", "header", "nav", "form", "table", "main", "article":
+		return target
+	}
+
+	target = strings.ReplaceAll(target, "'", "")
+	return fmt.Sprintf("#%s, [id*='%s'], [class*='%s']", strings.ReplaceAll(target, " ", "-"), target, target)
+}
+
+var (
+	setCookieRegex  = regexp.MustCompile(`(?i)^set (?:a |the )?cookie\s+([^\s=]+)\s*(?:=|\s+to\s+)\s*(\S+)(?:\s+(?:on|for)\s+(\S+))?`)
+	readCookieRegex = regexp.MustCompile(`(?i)^(?:read|get|show) (?:the )?cookies?(?:\s+(?:from|on|for)\s+(\S+)|\s+(\S+))?`)
+)
+
+func containsCookieKeywords(goal string) bool {
+	for _, prefix := range []string{"set cookie", "set a cookie", "set the cookie", "clear cookies", "clear the cookies", "delete cookies", "read cookie", "read the cookie", "get cookie", "get the cookie", "show cookie", "show the cookie"} {
+		if strings.HasPrefix(goal, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCookieCommand handles "set cookie <name> to <value> [on <domain>]",
+// "read cookie[s] [<name> | from <site>]" and "clear cookies [on <site>]"
+func parseCookieCommand(goal string) *CommandPayload {
+	lower := strings.ToLower(goal)
+
+	if m := setCookieRegex.FindStringSubmatch(goal); m != nil {
+		cookie := &CookieParams{Name: m[1], Value: m[2], Domain: m[3]}
+		command := &CommandPayload{Action: "set_cookie", Cookie: cookie}
+		if cookie.Domain != "" {
+			command.URL = "https://" + strings.TrimPrefix(cookie.Domain, ".")
+		}
+		return command
+	}
+
+	if strings.HasPrefix(lower, "clear") || strings.HasPrefix(lower, "delete") {
+		command := &CommandPayload{Action: "clear_cookies"}
+		if containsURL(lower) {
+			command.URL = extractURLFromGoal(lower)
+		}
+		return command
+	}
+
+	if m := readCookieRegex.FindStringSubmatch(goal); m != nil {
+		command := &CommandPayload{Action: "get_cookies"}
+		if site := m[1]; site != "" {
+			command.URL = extractURLFromGoal(strings.ToLower(site))
+		}
+		if name := m[2]; name != "" {
+			command.Cookie = &CookieParams{Name: name}
+		}
+		return command
+	}
+
+	return nil
+}
+
+var goalURLRegex = regexp.MustCompile(`(?i)(?:https?://)?(?:(?:[a-z0-9-]+\.)+(?:co\.uk|com|org|net|edu|gov|io|co|ai|dev|app)\b|localhost\b|\d{1,3}(?:\.\d{1,3}){3}\b)(?::\d+)?(?:[/?#][^\s]*)?`)
+
+// extractURLFromGoal returns the first URL-like token in goal with its path,
+// query string and port intact, adding https:// only when no scheme is given.
+// Pass the goal in its original case, since paths are case sensitive.
+func extractURLFromGoal(goal string) string {
+	if match := goalURLRegex.FindString(goal); match != "" {
+		return withScheme(strings.TrimRight(match, ".,;:!?)'\""))
+	}
+
+	words := strings.Fields(goal)
+	for _, word := range words {
+		if containsURL(strings.ToLower(word)) || urlSchemeRegex.MatchString(word) {
+			return withScheme(strings.TrimRight(word, ".,;:!?)'\""))
+		}
+	}
+
+	if url, ok := lookupSiteAlias(goal); ok {
+		return url
+	}
+
+	return "https://google.com"
+}
+
+// urlSchemeRegex spots an explicit scheme, telling "javascript:alert(1)"
+// apart from "localhost:3000"
+var urlSchemeRegex = regexp.MustCompile(`(?i)^[a-z][a-z0-9+.-]*:(?:[^0-9]|$)`)
+
+var localHostRegex = regexp.MustCompile(`^(?:localhost|\d{1,3}(?:\.\d{1,3}){3})(?:[:/?#]|$)`)
+
+// withScheme prefixes https:// unless the URL already has a scheme, which is
+// left for validateCommandURLs to judge. localhost and IP literals get
+// http://, since they rarely serve TLS.
+func withScheme(rawURL string) string {
+	lower := strings.ToLower(rawURL)
+	if urlSchemeRegex.MatchString(lower) {
+		return rawURL
+	}
+	if localHostRegex.MatchString(lower) {
+		return "http://" + rawURL
+	}
+	return "https://" + rawURL
+}
+
+func extractSelectorFromGoal(goal string) string {
+	if strings.Contains(goal, "button") {
+		return "button"
+	}
+	if strings.Contains(goal, "link") {
+		return "a"
+	}
+	return "*"
+}
+
+// clickableSelector narrows a click by label to elements users think of as
+// clickable, rather than every element whose text happens to match
+const clickableSelector = "a, button, [role='button'], [role='link'], [role='tab'], [role='menuitem'], input[type='submit'], input[type='button'], summary, label"
+
+var (
+	clickKeywordRegex = regexp.MustCompile(`(?i)^.*?\b(?:click|press|tap|select)(?:\s+on)?\s+`)
+	clickNounRegex    = regexp.MustCompile(`(?i)\s+(?:button|link|tab|menu item|icon|checkbox)$`)
+)
+
+// extractClickLabel returns the visible label a click goal refers to: a
+// quoted phrase verbatim, otherwise whatever follows the click keyword minus
+// a leading "the" and a trailing element noun. "click the Login button" and
+// "click on Contact Us" give "Login" and "Contact Us"; "click the button"
+// gives "".
+func extractClickLabel(goal string) string {
+	if m := quotedTermRegex.FindStringSubmatch(goal); m != nil {
+		for _, group := range m[1:] {
+			if group != "" {
+				return group
+			}
+		}
+	}
+
+	loc := clickKeywordRegex.FindStringIndex(goal)
+	if loc == nil {
+		return ""
+	}
+	label := strings.TrimSuffix(strings.TrimSpace(goal[loc[1]:]), ".")
+
+	lower := strings.ToLower(label)
+	for _, article := range []string{"the ", "a ", "an "} {
+		if strings.HasPrefix(lower, article) {
+			label = strings.TrimSpace(label[len(article):])
+			break
+		}
+	}
+	label = strings.TrimSpace(clickNounRegex.ReplaceAllString(label, ""))
+
+	switch strings.ToLower(label) {
+	case "", "button", "link", "tab", "it", "here", "this", "that":
+		return ""
+	}
+	return label
+}
+
+var toggleRegex = regexp.MustCompile(`(?i)^(check|tick|enable|select|choose|mark|uncheck|untick|disable|unmark|clear)\s+(?:the\s+)?(.*?)\s*(checkbox|check box|tickbox|box|radio button|radio|toggle|switch)?\.?$`)
+
+// toggleSelectors maps the noun of a toggle goal to what it may target
+var toggleSelectors = map[string]string{
+	"checkbox":     "input[type='checkbox'], [role='checkbox']",
+	"check box":    "input[type='checkbox'], [role='checkbox']",
+	"tickbox":      "input[type='checkbox'], [role='checkbox']",
+	"box":          "input[type='checkbox'], [role='checkbox']",
+	"radio button": "input[type='radio'], [role='radio']",
+	"radio":        "input[type='radio'], [role='radio']",
+	"toggle":       "input[type='checkbox'], [role='switch'], [role='checkbox']",
+	"switch":       "input[type='checkbox'], [role='switch'], [role='checkbox']",
+	"":             "input[type='checkbox'], input[type='radio'], [role='checkbox'], [role='radio'], [role='switch']",
+}
+
+// parseToggleCommand handles "check the terms box", "tick newsletter",
+// "uncheck the remember me checkbox" and "select the Express shipping
+// radio". The remaining words become Text, the label to look for. Only
+// tick, untick and uncheck work without a checkbox or radio noun, so "check
+// the price" or "select the first result" are left alone.
+func parseToggleCommand(goal string) *CommandPayload {
+	m := toggleRegex.FindStringSubmatch(strings.TrimSpace(goal))
+	if m == nil {
+		return nil
+	}
+	verb, label, noun := strings.ToLower(m[1]), strings.TrimSpace(m[2]), strings.ToLower(m[3])
+
+	if noun == "" && verb != "tick" && verb != "untick" && verb != "uncheck" {
+		return nil
+	}
+	if label == "" && noun == "" {
+		return nil
+	}
+
+	action := "check"
+	switch verb {
+	case "uncheck", "untick", "disable", "unmark", "clear":
+		action = "uncheck"
+	}
+
+	if q := quotedTermRegex.FindStringSubmatch(label); q != nil {
+		for _, group := range q[1:] {
+			if group != "" {
+				label = group
+				break
+			}
 		}
 	}
 
-	return nil
+	return &CommandPayload{Action: action, Selector: toggleSelectors[noun], Text: label}
 }
 
-func extractURLFromGoal(goal string) string {
-	urlRegex := regexp.MustCompile(`(?i)(?:https?://)?(?:www\.)?([a-zA-Z0-9-]+\.(?:com|org|net|edu|gov|io|co))(?:/[^\s]*)?`)
-	match := urlRegex.FindString(goal)
-	if match != "" {
-		if !strings.HasPrefix(match, "http") {
-			return "https://" + match
-		}
-		return match
+var selectOptionRegex = regexp.MustCompile(`(?i)^(?:select|choose|pick)\s+(?:the\s+)?(?:option\s+)?(.+?)\s+(?:from|in|on)\s+(?:the\s+)?(.*?)\s*(?:dropdown|drop-down|drop down|select box|select|menu|list)\.?$`)
+
+// parseSelectOptionCommand handles "select X from the dropdown", "choose X
+// in the country dropdown" and plain "choose X", which the click keywords
+// would otherwise turn into a click. A quoted option is used verbatim.
+func parseSelectOptionCommand(goal string) *CommandPayload {
+	option, field := "", ""
+	if m := selectOptionRegex.FindStringSubmatch(goal); m != nil {
+		option, field = m[1], strings.TrimSpace(m[2])
+	} else if rest, ok := cutPrefixFold(goal, "choose "); ok {
+		option = rest
+	} else {
+		return nil
 	}
 
-	words := strings.Fields(goal)
-	for _, word := range words {
-		if containsURL(word) {
-			if !strings.HasPrefix(word, "http") {
-				return "https://" + word
+	if m := quotedTermRegex.FindStringSubmatch(option); m != nil {
+		for _, group := range m[1:] {
+			if group != "" {
+				option = group
+				break
 			}
-			return word
 		}
+	} else {
+		option = strings.Trim(strings.TrimSuffix(strings.TrimSpace(option), "."), "'\"")
 	}
 
-	siteMap := map[string]string{
-		"google":   "https://google.com",
-		"github":   "https://github.com",
-		"youtube":  "https://youtube.com",
-		"facebook": "https://facebook.com",
-		"twitter":  "https://twitter.com",
-		"linkedin": "https://linkedin.com",
+	selector := "select"
+	if field != "" {
+		field = strings.ToLower(strings.ReplaceAll(field, "'", ""))
+		selector = fmt.Sprintf("select[name*='%s' i], select[id*='%s' i], select[aria-label*='%s' i]", field, field, field)
 	}
+	return &CommandPayload{Action: "select_option", Selector: selector, Text: option}
+}
 
-	for site, url := range siteMap {
-		if strings.Contains(goal, site) {
-			return url
-		}
+// cutPrefixFold is strings.CutPrefix ignoring ASCII case
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
 	}
-
-	return "https://google.com"
+	return s[len(prefix):], true
 }
 
-func extractSelectorFromGoal(goal string) string {
-	if strings.Contains(goal, "button") {
-		return "button"
-	}
-	if strings.Contains(goal, "link") {
-		return "a"
+var (
+	quotedTermRegex = regexp.MustCompile(`"([^"]+)"|“([^”]+)”|(?:^|\s)'([^']+)'(?:\s|$)`)
+	searchPrefixes  = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)search for `),
+		regexp.MustCompile(`(?i)search `),
+		regexp.MustCompile(`(?i)find `),
+		regexp.MustCompile(`(?i)look for `),
 	}
-	return "*"
-}
+)
 
+// extractSearchTermFromGoal returns a quoted phrase verbatim when the goal
+// has one, otherwise whatever follows "search for", "find" or "look for".
+// Case is preserved either way.
 func extractSearchTermFromGoal(goal string) string {
-	goal = strings.ToLower(goal)
+	if m := quotedTermRegex.FindStringSubmatch(goal); m != nil {
+		for _, group := range m[1:] {
+			if group != "" {
+				return group
+			}
+		}
+	}
 
-	patterns := []string{"search for ", "search ", "find ", "look for "}
-	for _, pattern := range patterns {
-		if idx := strings.Index(goal, pattern); idx != -1 {
-			term := goal[idx+len(pattern):]
-			return strings.TrimSpace(term)
+	for _, prefix := range searchPrefixes {
+		if loc := prefix.FindStringIndex(goal); loc != nil {
+			return strings.TrimSpace(goal[loc[1]:])
 		}
 	}
 
@@ -587,19 +2588,16 @@ func containsClickKeywords(goal string) bool {
 }
 
 func containsURL(goal string) bool {
-	urlPatterns := []string{".com", ".org", ".net", ".edu", ".gov", "http", "www."}
-	for _, pattern := range urlPatterns {
-		if strings.Contains(goal, pattern) {
-			return true
-		}
+	if strings.Contains(goal, "http") || strings.Contains(goal, "www.") {
+		return true
 	}
-	return false
+	return goalURLRegex.MatchString(goal)
 }
 
-func handlePageContent(conn *websocket.Conn, payload interface{}) error {
+func handlePageContent(session *Session, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Failed to parse page content payload",
@@ -610,7 +2608,7 @@ func handlePageContent(conn *websocket.Conn, payload interface{}) error {
 
 	var contentPayload PageContentPayload
 	if err := json.Unmarshal(payloadBytes, &contentPayload); err != nil {
-		return sendMessage(conn, &Message{
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Invalid page content format",
@@ -619,20 +2617,19 @@ func handlePageContent(conn *websocket.Conn, payload interface{}) error {
 		})
 	}
 
-	log.Printf("Analyzing page content from: %s", contentPayload.URL)
-
-	pageContexts[conn] = &llm.PageContext{
-		URL:         contentPayload.URL,
-		Title:       contentPayload.Title,
-		ContentType: determineContentTypeFromHTML(contentPayload.HTML),
-		HTML:        contentPayload.HTML,
-		Text:        contentPayload.Text,
+	if contentPayload.Error != "" {
+		session.logger.Info("Extension could not capture page content", "request_id", contentPayload.RequestID, "error", contentPayload.Error)
+		session.resolvePageRequest(contentPayload.RequestID, nil)
+		return nil
 	}
 
-	analysis, err := analyzePageContent(contentPayload.HTML)
+	session.logger.Debug("Analyzing page content", "url", contentPayload.URL)
+
+	analysis, err := analyzePageContent(contentPayload.HTML, contentPayload.URL)
 	if err != nil {
-		log.Printf("Failed to analyze page content: %v", err)
-		return sendMessage(conn, &Message{
+		session.logger.Warn("Failed to analyze page content", "url", contentPayload.URL, "error", err)
+		session.resolvePageRequest(contentPayload.RequestID, nil)
+		return session.send(&Message{
 			Type: "ERROR",
 			Payload: ErrorPayload{
 				Message: "Failed to analyze page content",
@@ -641,27 +2638,103 @@ func handlePageContent(conn *websocket.Conn, payload interface{}) error {
 		})
 	}
 
-	return sendMessage(conn, &Message{
+	pageContext := buildPageContext(&contentPayload, analysis)
+	session.setPageContent(&contentPayload, pageContext)
+	session.resolvePageRequest(contentPayload.RequestID, pageContext)
+
+	if analysis.AntiBot != "" {
+		if err := sendCaptchaDetected(session, contentPayload.URL, analysis.AntiBot); err != nil {
+			return err
+		}
+	}
+
+	return session.send(&Message{
 		Type:    "CONTENT_ANALYSIS",
 		Payload: analysis,
 	})
 }
 
-func determineContentTypeFromHTML(htmlContent string) string {
-	htmlLower := strings.ToLower(htmlContent)
-	if strings.Contains(htmlLower, "amazon.com") || strings.Contains(htmlLower, "field-keywords") {
-		return "ecommerce"
+// sendCaptchaDetected asks the user to step in, naming the running task
+// so a failure that follows can be put down to the CAPTCHA
+func sendCaptchaDetected(session *Session, pageURL, provider string) error {
+	payload := CaptchaDetectedPayload{
+		URL:      pageURL,
+		Provider: provider,
+		Message:  "This page is showing a CAPTCHA; solve it in the browser to continue",
 	}
-	if strings.Contains(htmlLower, "input[name='q']") || strings.Contains(htmlLower, "search") {
-		return "search"
+	session.stepMu.Lock()
+	if task := session.findRunningTask(); task != nil {
+		payload.TaskID = task.TaskID
 	}
-	if strings.Contains(htmlLower, "form") {
-		return "form"
+	session.stepMu.Unlock()
+
+	session.logger.Warn("CAPTCHA detected", "url", pageURL, "provider", provider, "task_id", payload.TaskID)
+	return session.send(&Message{
+		Type:    "CAPTCHA_DETECTED",
+		Payload: payload,
+	})
+}
+
+// buildPageContext turns the latest PAGE_CONTENT into the context handed to
+// the LLM, reusing the content type from the goquery analysis
+func buildPageContext(content *PageContentPayload, analysis *ContentAnalysisResult) *llm.PageContext {
+	return &llm.PageContext{
+		URL:         content.URL,
+		Title:       content.Title,
+		ContentType: analysis.ContentType,
+		Language:    analysis.Language,
+		HTML:        content.HTML,
+		Text:        content.Text,
+		Elements:    elementInfos(analysis.Elements),
+		FormFields:  formFieldInfos(analysis.FormFields),
+		Product:     productInfo(analysis.Product),
+		Iframes:     analysis.Iframes,
 	}
-	return "general"
 }
 
-func analyzePageContent(htmlContent string) (*ContentAnalysisResult, error) {
+func productInfo(product *ProductInfo) *llm.ProductInfo {
+	if product == nil {
+		return nil
+	}
+	return &llm.ProductInfo{
+		Name:         product.Name,
+		Price:        product.Price,
+		Currency:     product.Currency,
+		Availability: product.Availability,
+	}
+}
+
+func formFieldInfos(fields []FormFieldInfo) []llm.FormFieldInfo {
+	infos := make([]llm.FormFieldInfo, 0, len(fields))
+	for _, field := range fields {
+		infos = append(infos, llm.FormFieldInfo{
+			Selector:     field.Selector,
+			SelectorType: field.SelectorType,
+			Label:        field.Label,
+			InputType:    field.InputType,
+			Required:     field.Required,
+			Name:         field.Name,
+		})
+	}
+	return infos
+}
+
+func elementInfos(elements []InteractiveElement) []llm.ElementInfo {
+	infos := make([]llm.ElementInfo, 0, len(elements))
+	for _, element := range elements {
+		infos = append(infos, llm.ElementInfo{
+			Tag:          element.Tag,
+			Type:         element.Type,
+			Text:         element.Text,
+			Selector:     element.Selector,
+			SelectorType: element.SelectorType,
+			ShadowPath:   element.ShadowPath,
+		})
+	}
+	return infos
+}
+
+func analyzePageContent(htmlContent, pageURL string) (*ContentAnalysisResult, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse HTML: %v", err)
@@ -670,22 +2743,310 @@ func analyzePageContent(htmlContent string) (*ContentAnalysisResult, error) {
 	result := &ContentAnalysisResult{
 		Selectors:   []string{},
 		Suggestions: []string{},
+		Elements:    []InteractiveElement{},
 	}
 
+	labelsFor := fieldLabels(doc)
 	doc.Find("input, button, a, select, textarea").Each(func(i int, s *goquery.Selection) {
-		selector := generateSmartSelector(s)
-		if selector != "" {
-			result.Selectors = append(result.Selectors, selector)
+		var selector, selectorType string
+		shadowPath := shadowHostPath(doc, s)
+		if shadowPath != nil {
+			selector = shadowScopedSelector(s.ParentsFiltered(shadowRootSelector).First(), s)
+		} else if selector = generateSmartSelector(doc, s); selector == "" {
+			selector, selectorType = generateXPathSelector(s), "xpath"
+		}
+		// Fields in shadow roots are listed in Elements, which carry the path
+		if field, ok := formFieldInfo(s, labelsFor); ok && shadowPath == nil {
+			field.Selector, field.SelectorType = selector, selectorType
+			result.FormFields = append(result.FormFields, field)
 		}
+		result.Selectors = append(result.Selectors, selector)
+		tagType, _ := s.Attr("type")
+		result.Elements = append(result.Elements, InteractiveElement{
+			Selector:     selector,
+			Text:         elementLabel(s),
+			Tag:          goquery.NodeName(s),
+			Type:         tagType,
+			SelectorType: selectorType,
+			ShadowPath:   shadowPath,
+		})
 	})
 
+	result.Language = strings.TrimSpace(doc.Find("html").AttrOr("lang", ""))
+	result.AlternateLanguages = alternateLanguages(doc)
+	result.Iframes = iframeSources(doc, pageURL)
 	result.ContentType = determineContentType(doc)
+	result.AntiBot = detectAntiBot(doc)
+	if result.ContentType == "ecommerce" {
+		result.Product = extractProductInfo(doc)
+	}
+	if result.ContentType == "general" || result.ContentType == "navigation" {
+		// Articles and docs pages land in these two, as most have a nav bar
+		result.TableOfContents = extractTableOfContents(doc)
+	}
+	result.Pagination = detectPagination(doc)
+	result.Tables = extractTables(doc)
+	result.StructuredData = extractStructuredData(doc)
+	result.Links = extractLinks(doc, pageURL)
 	result.Suggestions = generateActionSuggestions(doc)
+	if result.AntiBot != "" {
+		result.Suggestions = append([]string{"captcha_detected"}, result.Suggestions...)
+	}
 
 	return result, nil
 }
 
-func generateSmartSelector(s *goquery.Selection) string {
+// antiBotSelectors identifies CAPTCHA providers, most specific first; the
+// last entry catches anything else calling itself a captcha
+var antiBotSelectors = []struct{ provider, selector string }{
+	{"recaptcha", "iframe[src*='recaptcha'], .g-recaptcha, script[src*='recaptcha']"},
+	{"hcaptcha", "iframe[src*='hcaptcha'], .h-captcha"},
+	{"cloudflare", ".cf-turnstile, iframe[src*='challenges.cloudflare.com'], #challenge-form, #cf-challenge-running"},
+	{"unknown", "[class*='captcha' i], [id*='captcha' i]"},
+}
+
+// detectAntiBot names the CAPTCHA or bot challenge on the page, or returns ""
+func detectAntiBot(doc *goquery.Document) string {
+	for _, candidate := range antiBotSelectors {
+		if doc.Find(candidate.selector).Length() > 0 {
+			return candidate.provider
+		}
+	}
+	return ""
+}
+
+// maxIframes bounds Iframes; ad-heavy pages embed dozens
+const maxIframes = 20
+
+// iframeSources collects the distinct src URLs of the page's iframes,
+// skipping blank and script frames
+func iframeSources(doc *goquery.Document, pageURL string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil || !base.IsAbs() {
+		base = nil
+	}
+
+	var sources []string
+	seen := map[string]bool{}
+	doc.Find("iframe[src]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		src := strings.TrimSpace(s.AttrOr("src", ""))
+		lower := strings.ToLower(src)
+		if src == "" || lower == "about:blank" || strings.HasPrefix(lower, "javascript:") {
+			return true
+		}
+		if parsed, err := url.Parse(src); err == nil && base != nil {
+			src = base.ResolveReference(parsed).String()
+		}
+		if !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+		return len(sources) < maxIframes
+	})
+	return sources
+}
+
+// alternateLanguages collects the hreflang values of the page's alternate
+// links
+func alternateLanguages(doc *goquery.Document) []string {
+	var languages []string
+	seen := map[string]bool{}
+	doc.Find("link[hreflang]").Each(func(_ int, s *goquery.Selection) {
+		lang := strings.TrimSpace(s.AttrOr("hreflang", ""))
+		if lang != "" && !seen[strings.ToLower(lang)] {
+			seen[strings.ToLower(lang)] = true
+			languages = append(languages, lang)
+		}
+	})
+	return languages
+}
+
+// maxTOCEntries bounds TableOfContents
+const maxTOCEntries = 100
+
+// extractTableOfContents walks the h1-h6 headings in document order,
+// skipping empty ones
+func extractTableOfContents(doc *goquery.Document) []TOCEntry {
+	var entries []TOCEntry
+	doc.Find("h1, h2, h3, h4, h5, h6").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if len(entries) == maxTOCEntries {
+			return false
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" {
+			return true
+		}
+		if runes := []rune(text); len(runes) > maxElementLabelLength {
+			text = string(runes[:maxElementLabelLength])
+		}
+		entries = append(entries, TOCEntry{
+			Level:    int(goquery.NodeName(s)[1] - '0'),
+			Text:     text,
+			Selector: generateSmartSelector(doc, s),
+		})
+		return true
+	})
+	return entries
+}
+
+// maxElementLabelLength caps InteractiveElement.Text
+const maxElementLabelLength = 100
+
+// elementLabel returns the text a user would read on s
+func elementLabel(s *goquery.Selection) string {
+	label := strings.Join(strings.Fields(s.Text()), " ")
+	for _, attr := range []string{"value", "aria-label", "placeholder", "title"} {
+		if label != "" {
+			break
+		}
+		if value, exists := s.Attr(attr); exists {
+			label = strings.Join(strings.Fields(value), " ")
+		}
+	}
+
+	if runes := []rune(label); len(runes) > maxElementLabelLength {
+		label = string(runes[:maxElementLabelLength])
+	}
+	return label
+}
+
+// nonFieldInputTypes are input types that are buttons or invisible rather
+// than something to fill in
+var nonFieldInputTypes = map[string]bool{
+	"hidden": true, "submit": true, "button": true, "reset": true, "image": true,
+}
+
+// fieldLabels maps element ids to the text of the <label for="..."> naming
+// them
+func fieldLabels(doc *goquery.Document) map[string]string {
+	labels := map[string]string{}
+	doc.Find("label[for]").Each(func(_ int, s *goquery.Selection) {
+		id, _ := s.Attr("for")
+		if text := strings.Join(strings.Fields(s.Text()), " "); text != "" && labels[id] == "" {
+			labels[id] = text
+		}
+	})
+	return labels
+}
+
+// formFieldInfo describes s if it is a fillable field. The label comes from
+// a <label for> naming its id, then an enclosing <label>, then aria-label,
+// then placeholder.
+func formFieldInfo(s *goquery.Selection, labelsFor map[string]string) (FormFieldInfo, bool) {
+	tag := goquery.NodeName(s)
+	field := FormFieldInfo{InputType: tag}
+	switch tag {
+	case "input":
+		field.InputType = strings.ToLower(s.AttrOr("type", "text"))
+		if nonFieldInputTypes[field.InputType] {
+			return FormFieldInfo{}, false
+		}
+	case "textarea", "select":
+	default:
+		return FormFieldInfo{}, false
+	}
+
+	field.Name = s.AttrOr("name", "")
+	field.Placeholder = strings.TrimSpace(s.AttrOr("placeholder", ""))
+	_, field.Required = s.Attr("required")
+	if s.AttrOr("aria-required", "") == "true" {
+		field.Required = true
+	}
+
+	if id, ok := s.Attr("id"); ok && labelsFor[id] != "" {
+		field.Label = labelsFor[id]
+	} else if wrapper := s.Closest("label"); wrapper.Length() > 0 {
+		// The enclosing label's own text, without the options of a select
+		clone := wrapper.Clone()
+		clone.Find("select, textarea").Remove()
+		field.Label = strings.Join(strings.Fields(clone.Text()), " ")
+	}
+	if field.Label == "" {
+		field.Label = strings.TrimSpace(s.AttrOr("aria-label", ""))
+	}
+	if field.Label == "" {
+		field.Label = field.Placeholder
+	}
+	if runes := []rune(field.Label); len(runes) > maxElementLabelLength {
+		field.Label = string(runes[:maxElementLabelLength])
+	}
+
+	return field, true
+}
+
+// generateSmartSelector builds a selector that matches s and nothing else in
+// doc, preferring stable attributes and falling back to an nth-of-type path.
+// It returns "" when neither yields a valid, unique selector.
+func generateSmartSelector(doc *goquery.Document, s *goquery.Selection) string {
+	if selector, ok := uniqueSelector(doc, buildSmartSelector(s)); ok {
+		return selector
+	}
+	if selector, ok := uniqueSelector(doc, buildPathSelector(doc, s)); ok {
+		return selector
+	}
+	slog.Debug("No unique selector for element", "tag", goquery.NodeName(s))
+	return ""
+}
+
+// uniqueSelector sanitizes selector and reports whether it matches exactly
+// one node in doc
+func uniqueSelector(doc *goquery.Document, selector string) (string, bool) {
+	selector, err := llm.SanitizeSelector(selector)
+	if err != nil {
+		slog.Debug("Skipping malformed selector", "error", err)
+		return "", false
+	}
+	if selector == "" {
+		return "", false
+	}
+	return selector, doc.Find(selector).Length() == 1
+}
+
+// generateXPathSelector returns the absolute XPath of s, like
+// /html/body/div[2]/button, indexing a step only when the element has
+// siblings with the same tag. It is the fallback for elements without a
+// usable CSS selector.
+func generateXPathSelector(s *goquery.Selection) string {
+	var steps []string
+	for node := s; node.Length() > 0; node = node.Parent() {
+		tagName := goquery.NodeName(node)
+		step := tagName
+		if node.Siblings().Filter(tagName).Length() > 0 {
+			step = fmt.Sprintf("%s[%d]", tagName, node.PrevAll().Filter(tagName).Length()+1)
+		}
+		steps = append([]string{step}, steps...)
+	}
+	return "/" + strings.Join(steps, "/")
+}
+
+// buildPathSelector walks from s up to the nearest ancestor with a unique id,
+// or to <html>, joining one "tag:nth-of-type(n)" step per level with " > ".
+// The index is left off for elements with no same-tag siblings.
+func buildPathSelector(doc *goquery.Document, s *goquery.Selection) string {
+	var steps []string
+	for node, depth := s, 0; node.Length() > 0; node, depth = node.Parent(), depth+1 {
+		if id, exists := node.Attr("id"); exists && id != "" && depth > 0 {
+			if selector, ok := uniqueSelector(doc, "#"+id); ok {
+				steps = append(steps, selector)
+				break
+			}
+		}
+
+		tagName := goquery.NodeName(node)
+		step := tagName
+		if tagName != "html" && node.Siblings().Filter(tagName).Length() > 0 {
+			step = fmt.Sprintf("%s:nth-of-type(%d)", tagName, node.PrevAll().Filter(tagName).Length()+1)
+		}
+		steps = append(steps, step)
+	}
+
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return strings.Join(steps, " > ")
+}
+
+func buildSmartSelector(s *goquery.Selection) string {
 	if id, exists := s.Attr("id"); exists && id != "" {
 		return "#" + id
 	}
@@ -713,7 +3074,151 @@ func generateSmartSelector(s *goquery.Selection) string {
 	return tagName
 }
 
+const (
+	// maxTables and maxTableRows bound how much table data an analysis carries
+	maxTables    = 20
+	maxTableRows = 200
+	// maxCellSpan guards against colspan="10000" blowing up a row
+	maxCellSpan = 50
+)
+
+// extractTables converts each <table> in doc into a TableData. A leading row
+// made only of <th> cells, or the rows of <thead>, becomes the headers.
+// Tables with no cell text, typically layout tables, are skipped.
+func extractTables(doc *goquery.Document) []TableData {
+	var tables []TableData
+	doc.Find("table").EachWithBreak(func(i int, table *goquery.Selection) bool {
+		if len(tables) == maxTables {
+			return false
+		}
+
+		// Skip rows belonging to tables nested inside this one
+		rows := table.Find("tr").FilterFunction(func(i int, row *goquery.Selection) bool {
+			return row.Closest("table").IsSelection(table)
+		})
+
+		data := TableData{
+			Selector: generateSmartSelector(doc, table),
+			Caption:  strings.Join(strings.Fields(table.ChildrenFiltered("caption").Text()), " "),
+			Rows:     [][]string{},
+		}
+
+		grid := tableGrid(rows)
+		if len(grid) > 0 && grid[0].header {
+			data.Headers = grid[0].cells
+			grid = grid[1:]
+		}
+		for _, row := range grid {
+			if len(data.Rows) == maxTableRows {
+				break
+			}
+			data.Rows = append(data.Rows, row.cells)
+		}
+
+		if data.Headers != nil || hasCellText(data.Rows) {
+			tables = append(tables, data)
+		}
+		return true
+	})
+	return tables
+}
+
+// tableRow is one <tr> laid out on the table grid
+type tableRow struct {
+	cells  []string
+	header bool // every cell is a <th> or the row is in <thead>
+}
+
+// tableGrid lays rows out column by column, copying colspan cells to the
+// right and rowspan cells into the rows below
+func tableGrid(rows *goquery.Selection) []tableRow {
+	var grid []tableRow
+	// pending[col] is a rowspan cell still covering col in later rows
+	type spanned struct {
+		text string
+		rows int
+	}
+	pending := map[int]spanned{}
+
+	rows.Each(func(i int, tr *goquery.Selection) {
+		row := tableRow{header: tr.ParentsFiltered("thead").Length() > 0}
+		onlyTH := true
+		col := 0
+
+		// fill copies rowspan cells from earlier rows into col onwards
+		fill := func() {
+			for {
+				span, ok := pending[col]
+				if !ok {
+					return
+				}
+				row.cells = append(row.cells, span.text)
+				if span.rows--; span.rows == 0 {
+					delete(pending, col)
+				} else {
+					pending[col] = span
+				}
+				col++
+			}
+		}
+
+		tr.ChildrenFiltered("th, td").Each(func(j int, cell *goquery.Selection) {
+			fill()
+			if goquery.NodeName(cell) != "th" {
+				onlyTH = false
+			}
+			text := strings.Join(strings.Fields(cell.Text()), " ")
+			colspan := cellSpan(cell, "colspan")
+			rowspan := cellSpan(cell, "rowspan")
+			for k := 0; k < colspan; k++ {
+				row.cells = append(row.cells, text)
+				if rowspan > 1 {
+					pending[col] = spanned{text: text, rows: rowspan - 1}
+				}
+				col++
+			}
+		})
+		fill()
+
+		if len(row.cells) == 0 {
+			return
+		}
+		row.header = row.header || onlyTH
+		grid = append(grid, row)
+	})
+	return grid
+}
+
+// cellSpan reads a colspan or rowspan attribute, defaulting to 1
+func cellSpan(cell *goquery.Selection, attr string) int {
+	span, err := strconv.Atoi(strings.TrimSpace(cell.AttrOr(attr, "1")))
+	if err != nil || span < 1 {
+		return 1
+	}
+	return min(span, maxCellSpan)
+}
+
+// hasCellText reports whether any cell in rows is non-empty
+func hasCellText(rows [][]string) bool {
+	for _, row := range rows {
+		for _, cell := range row {
+			if cell != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func determineContentType(doc *goquery.Document) string {
+	if hasLoginForm(doc) {
+		return "login"
+	}
+
+	if doc.Find("input[name='field-keywords'], [id*='add-to-cart'], [name='add-to-cart'], [class*='add-to-cart'], [itemprop='price'], .price, #price").Length() > 0 {
+		return "ecommerce"
+	}
+
 	if doc.Find("input[type='search'], input[name='q'], [role='searchbox']").Length() > 0 {
 		return "search"
 	}
@@ -729,9 +3234,40 @@ func determineContentType(doc *goquery.Document) string {
 	return "general"
 }
 
+// loginIdentifierSelector matches the username or email field of a login form
+const loginIdentifierSelector = "input[type='email'], input[type='text'], input:not([type]), input[autocomplete='username'], input[name*='user' i], input[name*='email' i], input[name*='login' i], input[id*='user' i], input[id*='email' i]"
+
+// hasLoginForm reports whether the page has a password field with a username
+// or email field in the same form. Without a form element the password
+// field's grandparent stands in for it. A second password field ("confirm
+// password") marks a sign-up form, which doesn't count.
+func hasLoginForm(doc *goquery.Document) bool {
+	found := false
+	doc.Find("input[type='password']").EachWithBreak(func(i int, password *goquery.Selection) bool {
+		container := password.Closest("form")
+		if container.Length() == 0 {
+			container = password.Parent().Parent()
+		}
+		if container.Find("input[type='password']").Length() > 1 {
+			return true
+		}
+		found = container.Find(loginIdentifierSelector).Not("[type='hidden']").Length() > 0
+		return !found
+	})
+	return found
+}
+
 func generateActionSuggestions(doc *goquery.Document) []string {
 	var suggestions []string
 
+	if hasLoginForm(doc) {
+		suggestions = append(suggestions, "Log in with credentials")
+	}
+
+	if detectPagination(doc) != nil {
+		suggestions = append(suggestions, "Go to next page")
+	}
+
 	if doc.Find("input[type='search'], input[name='q']").Length() > 0 {
 		suggestions = append(suggestions, "Search for something")
 	}
@@ -746,36 +3282,176 @@ func generateActionSuggestions(doc *goquery.Document) []string {
 		suggestions = append(suggestions, fmt.Sprintf("Click on one of %d buttons", buttonCount))
 	}
 
+	if checkboxCount := doc.Find("input[type='checkbox'], [role='checkbox']").Length(); checkboxCount > 0 {
+		suggestions = append(suggestions, fmt.Sprintf("Check or uncheck one of %d checkboxes", checkboxCount))
+	}
+
+	if selectCount := doc.Find("select").Length(); selectCount > 0 {
+		suggestions = append(suggestions, fmt.Sprintf("Select an option from one of %d dropdowns", selectCount))
+	}
+
 	return suggestions
 }
 
 func main() {
-	useLLM = os.Getenv("USE_LLM") == "true" || os.Getenv("USE_LLM") == "1"
-	llmModel := os.Getenv("LLM_MODEL")
-	if llmModel == "" {
-		llmModel = "mistral:latest"
+	flag.Parse()
+
+	if err := applyConfigFile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	resolveStepDelays()
+
+	if *pingIntervalFlag <= 0 || *readTimeoutFlag <= *pingIntervalFlag {
+		fmt.Fprintln(os.Stderr, "-read-timeout must be longer than a positive -ping-interval")
+		os.Exit(2)
+	}
+
+	logger, err := newLogger(os.Stderr, *logLevelFlag, *logFormatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	if *configFlag != "" {
+		slog.Info("Loaded config file", "path", *configFlag)
+	}
+
+	allowedOrigins = resolveAllowedOrigins()
+	slog.Info("Allowed WebSocket origins", "origins", strings.Join(allowedOrigins, ", "))
+
+	authToken = resolveAuthToken()
+	if authToken != "" {
+		slog.Info("WebSocket token authentication enabled")
 	}
 
+	useLLM = os.Getenv("USE_LLM") == "true" || os.Getenv("USE_LLM") == "1"
+	var llmModels []string
+
 	if useLLM {
-		log.Println("Initializing LLM client...")
-		llmClient = llm.NewLLMClient(llmModel)
+		slog.Info("Initializing LLM client")
+		llm.ConfigureCache(*llmCacheSizeFlag, *llmCacheTTLFlag)
+		llm.SetConfig(resolveLLMConfig())
+		switch provider := os.Getenv("LLM_PROVIDER"); provider {
+		case "openai":
+			llmModels = resolveLLMModels(llm.DefaultOpenAIModel)
+			openai := llm.NewOpenAIClient("", logger)
+			openai.SetModels(llmModels)
+			llmClient = openai
+		case "", "ollama":
+			host, err := llm.ResolveOllamaHost()
+			if err != nil {
+				fatal("Invalid Ollama configuration", "error", err)
+			}
+			slog.Info("Ollama endpoint", "host", host)
+			llmModels = resolveLLMModels(llm.DefaultOllamaModel)
+			ollama := llm.NewLLMClientWithHost(host, "", logger)
+			ollama.SetModels(llmModels)
+			llmClient = ollama
+		default:
+			fatal("Unknown LLM_PROVIDER (expected ollama or openai)", "provider", provider)
+		}
+
+		if budgeted, ok := llmClient.(interface{ SetTokenBudget(int64) }); ok && *llmTokenBudgetFlag > 0 {
+			budgeted.SetTokenBudget(int64(*llmTokenBudgetFlag))
+			slog.Info("LLM token budget enabled", "tokens", *llmTokenBudgetFlag)
+		}
+
+		if tuned, ok := llmClient.(interface{ SetOptions(map[string]interface{}) }); ok {
+			if options := resolveLLMOptions(); len(options) > 0 {
+				tuned.SetOptions(options)
+				slog.Info("LLM options set", "options", options)
+			}
+		}
+
+		llmClient = instrumentedProvider{llmClient}
 
 		if err := llmClient.TestConnection(); err != nil {
-			log.Printf("LLM not available: %v", err)
-			log.Println("Continuing with rule-based parsing only")
-			log.Println("To enable LLM: Start Ollama (ollama serve) and set USE_LLM=true")
+			slog.Warn("LLM not available, continuing with rule-based parsing only", "error", err)
+			slog.Info("To enable LLM: Start Ollama (ollama serve) and set USE_LLM=true")
 			useLLM = false
 		} else {
-			log.Printf("LLM enabled with model: %s", llmModel)
+			slog.Info("LLM enabled", "models", strings.Join(llmModels, ", "))
 		}
 	} else {
-		log.Println("Using rule-based parsing (set USE_LLM=true to enable AI)")
+		slog.Info("Using rule-based parsing (set USE_LLM=true to enable AI)")
 	}
 
-	flag.Parse()
+	if *sitesConfigFlag != "" {
+		aliases, err := loadSiteAliases(*sitesConfigFlag)
+		if err != nil {
+			fatal("Failed to load site aliases", "error", err)
+		}
+		siteAliases = aliases
+		slog.Info("Loaded site aliases", "path", *sitesConfigFlag, "aliases", len(siteAliases))
+	}
+
+	if *templatesFileFlag != "" {
+		if err := templates.LoadTemplates(*templatesFileFlag); err != nil {
+			fatal("Failed to load templates", "error", err)
+		}
+		slog.Info("Loaded templates", "path", *templatesFileFlag, "templates", len(templates.List()))
+	}
+
+	if *taskLogFlag != "" {
+		if taskLog, err = OpenTaskLog(*taskLogFlag); err != nil {
+			fatal("Failed to open task log", "error", err)
+		}
+	}
+
+	if *historyFileFlag != "" {
+		if taskHistory, err = OpenTaskHistory(*historyFileFlag); err != nil {
+			fatal("Failed to open history file", "error", err)
+		}
+	}
+
+	addr := resolveAddr(*addrFlag, os.Getenv("PORT"))
+
+	certFile, keyFile := *tlsCertFlag, *tlsKeyFlag
+	if (certFile == "") != (keyFile == "") && !*generateSelfSignedFlag {
+		fatal("-tls-cert and -tls-key must be given together")
+	}
+	if *generateSelfSignedFlag {
+		if certFile == "" {
+			certFile = defaultSelfSignedCert
+		}
+		if keyFile == "" {
+			keyFile = defaultSelfSignedKey
+		}
+		if err := ensureSelfSignedCert(certFile, keyFile); err != nil {
+			fatal("Failed to prepare self-signed certificate", "error", err)
+		}
+	}
 
 	http.HandleFunc("/ws", handler)
-	log.Println("Cortex Backend started on port 8080")
-	log.Println("WebSocket endpoint: ws://localhost:8080/ws")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/tasks", tasksHandler)
+	http.HandleFunc("/api/history", historyHandler)
+	http.HandleFunc("/templates", templatesHandler)
+	http.Handle("/metrics", promhttp.Handler())
+	slog.Info("Cortex Backend started", "addr", addr)
+
+	server := &http.Server{Addr: addr}
+	serveErr := make(chan error, 1)
+	if certFile != "" && keyFile != "" {
+		slog.Info("WebSocket endpoint", "url", fmt.Sprintf("wss://%s/ws", displayHost(addr)))
+		go func() { serveErr <- server.ListenAndServeTLS(certFile, keyFile) }()
+	} else {
+		slog.Info("WebSocket endpoint", "url", fmt.Sprintf("ws://%s/ws", displayHost(addr)))
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
+		fatal("Server stopped", "error", err)
+	case <-ctx.Done():
+		// A second signal kills the process instead of waiting out the grace period
+		stop()
+		slog.Info("Shutting down", "grace", *shutdownGraceFlag)
+		gracefulShutdown(server, *shutdownGraceFlag)
+	}
 }
@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gorilla/websocket"
+)
+
+// dialBackend serves the /ws handler and returns a client connected to it,
+// standing in for the extension
+func dialBackend(t *testing.T) *websocket.Conn {
+	t.Helper()
+	_, conn := serveBackend(t)
+	return conn
+}
+
+// serveBackend is dialBackend that also returns the server
+func serveBackend(t *testing.T) (*httptest.Server, *websocket.Conn) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, conn
+}
+
+// newTestSession returns a Session for the server end of a WebSocket and
+// the client end, for tests that drive handlers directly
+func newTestSession(t *testing.T) (*Session, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	serverConn := <-conns
+	t.Cleanup(func() {
+		client.Close()
+		serverConn.Close()
+	})
+	return NewSession("test", serverConn), client
+}
+
+// sendJSON writes a message to the backend as the extension would
+func sendJSON(t *testing.T, conn *websocket.Conn, msgType string, payload interface{}) {
+	t.Helper()
+	if err := conn.WriteJSON(Message{Type: msgType, Payload: payload}); err != nil {
+		t.Fatalf("write %s: %v", msgType, err)
+	}
+}
+
+// readMessage reads the next message, with its payload left as raw JSON
+func readMessage(t *testing.T, conn *websocket.Conn) (string, json.RawMessage) {
+	t.Helper()
+	var message struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return message.Type, message.Payload
+}
+
+// readUntil reads messages until one of type msgType arrives and decodes its
+// payload into v
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string, v interface{}) {
+	t.Helper()
+	for {
+		gotType, payload := readMessage(t, conn)
+		if gotType != msgType {
+			continue
+		}
+		if v != nil {
+			if err := json.Unmarshal(payload, v); err != nil {
+				t.Fatalf("decode %s: %v", msgType, err)
+			}
+		}
+		return
+	}
+}
+
+// countMessages counts the messages of each type that arrive within wait
+func countMessages(conn *websocket.Conn, wait time.Duration) map[string]int {
+	counts := map[string]int{}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			return counts
+		}
+		counts[message.Type]++
+	}
+}
+
+func TestLLMProgressReporterSendsNewText(t *testing.T) {
+	session, client := newTestSession(t)
+	report := llmProgressReporter(session, "find shoes")
+
+	report(`{"steps":`)
+	var thinking LLMThinkingPayload
+	readUntil(t, client, "LLM_THINKING", &thinking)
+	if thinking.Text != `{"steps":` || thinking.Chars != 9 || thinking.Goal != "find shoes" {
+		t.Errorf("first LLM_THINKING = %+v", thinking)
+	}
+	var progress TaskProgressPayload
+	readUntil(t, client, "TASK_PROGRESS", &progress)
+	if progress.Partial != `{"steps":` {
+		t.Errorf("TASK_PROGRESS partial = %q", progress.Partial)
+	}
+
+	// Chunks inside the throttle window are folded into the next message
+	report(`{"steps":[`)
+	time.Sleep(300 * time.Millisecond)
+	report(`{"steps":[]}`)
+	readUntil(t, client, "LLM_THINKING", &thinking)
+	if thinking.Text != `[]}` || thinking.Chars != 12 {
+		t.Errorf("second LLM_THINKING = %+v, want the text since the first", thinking)
+	}
+}
+
+// startTestTask starts a task running commands on session and returns it
+// once its first COMMAND has been sent
+func startTestTask(t *testing.T, session *Session, payload ExecuteTaskPayload, commands ...CommandPayload) *TaskState {
+	t.Helper()
+	task, err := startTask(session, payload, &CommandSequence{Commands: commands}, nil)
+	if err != nil || task == nil {
+		t.Fatalf("startTask = %v, %v", task, err)
+	}
+	return task
+}
+
+func TestCancelTaskIsIdempotentUnderConcurrency(t *testing.T) {
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice"},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	const cancels = 8
+	var wg sync.WaitGroup
+	for i := 0; i < cancels; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := handleCancelTask(session, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+				t.Errorf("handleCancelTask: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	for i := 0; i < cancels; i++ {
+		msgType, _ := readMessage(t, client)
+		counts[msgType]++
+	}
+	if counts["TASK_CANCELLED"] != 1 || counts["ERROR"] != cancels-1 {
+		t.Errorf("replies = %v, want one TASK_CANCELLED and %d ERRORs", counts, cancels-1)
+	}
+	if task.Status != "cancelled" || session.countTasks() != 0 {
+		t.Errorf("status %q with %d active tasks, want cancelled and none", task.Status, session.countTasks())
+	}
+
+	// The in-flight step's completion finds nothing to advance
+	if err := handleCommandComplete(session, CommandResult{Step: 0, Action: "click", Success: true}); err != nil {
+		t.Fatalf("handleCommandComplete: %v", err)
+	}
+	if task.CurrentStep != 0 {
+		t.Errorf("cancelled task advanced to step %d", task.CurrentStep)
+	}
+}
+
+func TestCancelAllTasksRacesClientCancel(t *testing.T) {
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click"},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		session.cancelAllTasks("Server shutting down")
+	}()
+	go func() {
+		defer wg.Done()
+		handleCancelTask(session, TaskIDPayload{TaskID: task.TaskID})
+	}()
+	wg.Wait()
+
+	// Whichever runs second finds nothing to cancel: the client gets an
+	// ERROR, shutdown stays quiet
+	if counts := countMessages(client, 300*time.Millisecond); counts["TASK_CANCELLED"] != 1 || counts["ERROR"] > 1 {
+		t.Errorf("replies = %v, want one TASK_CANCELLED", counts)
+	}
+}
+
+func TestCancelStopsPendingRetry(t *testing.T) {
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click", MaxRetries: 2, RetryBackoffMs: 200},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	if err := handleCommandComplete(session, CommandResult{Action: "click", Success: false, Error: "not found"}); err != nil {
+		t.Fatalf("handleCommandComplete: %v", err)
+	}
+	readUntil(t, client, "COMMAND_RETRY", nil)
+	if err := handleCancelTask(session, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+		t.Fatalf("handleCancelTask: %v", err)
+	}
+	readUntil(t, client, "TASK_CANCELLED", nil)
+
+	// The retry was due 200ms after the failure
+	if counts := countMessages(client, 500*time.Millisecond); counts["COMMAND"] != 0 {
+		t.Errorf("retry was dispatched after cancellation: %v", counts)
+	}
+}
+
+func TestParseEvaluateCommand(t *testing.T) {
+	tests := []struct {
+		goal   string
+		script string
+	}{
+		{"evaluate `document.title`", "document.title"},
+		{`run script "window.scrollY"`, "window.scrollY"},
+		{"Run the JavaScript 'document.querySelector(\"#price\").innerText'", `document.querySelector("#price").innerText`},
+		{"evaluate the reviews", ""},
+		{"run script document.title", ""},
+	}
+	for _, tt := range tests {
+		command := parseEvaluateCommand(tt.goal)
+		if tt.script == "" {
+			if command != nil {
+				t.Errorf("parseEvaluateCommand(%q) = %+v, want nil", tt.goal, command)
+			}
+			continue
+		}
+		if command == nil || command.Action != "evaluate" || command.Script != tt.script {
+			t.Errorf("parseEvaluateCommand(%q) = %+v, want script %q", tt.goal, command, tt.script)
+		}
+	}
+
+	// Scripts mentioning "scroll" or "and" still reach evaluate whole
+	sequence := parseGoalToSequence(slog.Default(), "evaluate `window.scrollY > 0 && document.hidden`", nil, nil, nil)
+	if sequence == nil || len(sequence.Commands) != 1 || sequence.Commands[0].Script != "window.scrollY > 0 && document.hidden" {
+		t.Errorf("parseGoalToSequence = %+v", sequence)
+	}
+}
+
+func TestExecuteTaskWhilePlanningIsRefused(t *testing.T) {
+	session, client := newTestSession(t)
+	if err := handleExecuteTaskWithCompletion(session, ExecuteTaskPayload{Goal: "click on Pricing"}); err != nil {
+		t.Fatalf("handleExecuteTaskWithCompletion: %v", err)
+	}
+	var request RequestPageContentPayload
+	readUntil(t, client, "REQUEST_PAGE_CONTENT", &request)
+	if !session.busy() {
+		t.Error("session is not busy while the goal waits for page content")
+	}
+
+	if err := handleExecuteTaskWithCompletion(session, ExecuteTaskPayload{Goal: "click on Docs"}); err != nil {
+		t.Fatalf("handleExecuteTaskWithCompletion: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "GOAL_IN_PROGRESS" {
+		t.Errorf("second goal got %+v, want GOAL_IN_PROGRESS", refusal)
+	}
+
+	// The first goal goes ahead once the page request fails
+	session.resolvePageRequest(request.RequestID, nil)
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if session.countTasks() != 1 {
+		t.Errorf("active tasks = %d, want 1", session.countTasks())
+	}
+}
+
+// withCommandTimeout shortens -command-timeout for the rest of the test
+func withCommandTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	previous := *commandTimeoutFlag
+	*commandTimeoutFlag = timeout
+	t.Cleanup(func() { *commandTimeoutFlag = previous })
+}
+
+// withStepDelays sets -nav-delay and -step-delay for the rest of the test
+func withStepDelays(t *testing.T, nav, step time.Duration) {
+	t.Helper()
+	previousNav, previousStep := *navDelayFlag, *stepDelayFlag
+	*navDelayFlag, *stepDelayFlag = nav, step
+	t.Cleanup(func() { *navDelayFlag, *stepDelayFlag = previousNav, previousStep })
+}
+
+func TestMissingCompletionFailsStep(t *testing.T) {
+	withCommandTimeout(t, 100*time.Millisecond)
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click"},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+
+	var failed TaskFailedPayload
+	readUntil(t, client, "TASK_FAILED", &failed)
+	if failed.TaskID != task.TaskID || !strings.Contains(failed.Error, "no COMMAND_COMPLETE") {
+		t.Errorf("TASK_FAILED = %+v", failed)
+	}
+	if session.countTasks() != 0 {
+		t.Error("timed-out task is still active")
+	}
+}
+
+func TestLateCompletionAfterTimeoutIsIgnored(t *testing.T) {
+	withCommandTimeout(t, 100*time.Millisecond)
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice"},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "TASK_FAILED", nil)
+
+	if err := handleCommandComplete(session, CommandResult{Action: "click", Success: true}); err != nil {
+		t.Fatalf("handleCommandComplete: %v", err)
+	}
+	if task.CurrentStep != 0 || task.Status != "failed" {
+		t.Errorf("late completion moved the task to step %d, status %q", task.CurrentStep, task.Status)
+	}
+}
+
+func TestCommandTimeoutCoversWaits(t *testing.T) {
+	withCommandTimeout(t, 10*time.Second)
+	if got := commandTimeout(CommandPayload{Action: "click"}); got != 10*time.Second {
+		t.Errorf("click timeout = %v", got)
+	}
+	if got := commandTimeout(CommandPayload{Action: "wait_for_element", WaitTimeoutMs: 20000}); got != 25*time.Second {
+		t.Errorf("wait_for_element timeout = %v, want the wait plus 5s", got)
+	}
+}
+
+func TestFailedStepRetriesWithBackoff(t *testing.T) {
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click", MaxRetries: 2, RetryBackoffMs: 50},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	for attempt, wantDelay := range []int64{50, 100} {
+		if err := handleCommandComplete(session, CommandResult{Action: "click", Success: false, Error: "not found"}); err != nil {
+			t.Fatalf("handleCommandComplete: %v", err)
+		}
+		var retry CommandRetryPayload
+		readUntil(t, client, "COMMAND_RETRY", &retry)
+		if retry.Attempt != attempt+1 || retry.MaxRetries != 2 || retry.DelayMs != wantDelay || retry.Error != "not found" {
+			t.Errorf("retry %d = %+v, want delay %dms", attempt+1, retry, wantDelay)
+		}
+		var command CommandPayload
+		readUntil(t, client, "COMMAND", &command)
+		if command.Selector != "#a" {
+			t.Errorf("retried %+v, want the same step", command)
+		}
+	}
+
+	if err := handleCommandComplete(session, CommandResult{Action: "click", Success: false, Error: "not found"}); err != nil {
+		t.Fatalf("handleCommandComplete: %v", err)
+	}
+	var failed TaskFailedPayload
+	readUntil(t, client, "TASK_FAILED", &failed)
+	if failed.Code != "STEP_FAILED" || len(failed.Results) != 3 || task.RetryCount != 2 {
+		t.Errorf("TASK_FAILED = %+v after %d retries", failed, task.RetryCount)
+	}
+}
+
+func TestRetryThatSucceedsContinuesTheTask(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice", MaxRetries: 1, RetryBackoffMs: 10},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	handleCommandComplete(session, CommandResult{Action: "click", Success: false, Error: "not found"})
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true})
+
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.Selector != "#b" || task.RetryCount != 0 {
+		t.Errorf("next command %+v with retry count %d, want #b and a reset count", command, task.RetryCount)
+	}
+}
+
+func TestGetTaskStatusReportsProgress(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice"},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true, Details: "clicked #a"})
+	readUntil(t, client, "COMMAND", nil)
+
+	if err := handleGetTaskStatus(session, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+		t.Fatalf("handleGetTaskStatus: %v", err)
+	}
+	var status TaskStatusPayload
+	readUntil(t, client, "TASK_STATUS", &status)
+	if status.TaskID != task.TaskID || status.Status != "executing" || status.CurrentStep != 1 || status.Total != 2 || len(status.Results) != 1 {
+		t.Errorf("TASK_STATUS = %+v", status)
+	}
+
+	if err := handleGetTaskStatus(session, TaskIDPayload{TaskID: "task_unknown"}); err != nil {
+		t.Fatalf("handleGetTaskStatus: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "TASK_NOT_FOUND" {
+		t.Errorf("unknown task got %+v", refusal)
+	}
+}
+
+func TestGetTaskStatusAfterReconnect(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	session.setClientID("profile_1")
+	task := startTestTask(t, session, ExecuteTaskPayload{Goal: "click"},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true, Details: "clicked #a"})
+	readUntil(t, client, "TASK_COMPLETE", nil)
+
+	// The same browser profile on a new connection
+	reconnected, reconnectedClient := newTestSession(t)
+	reconnected.setClientID("profile_1")
+	if err := handleGetTaskStatus(reconnected, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+		t.Fatalf("handleGetTaskStatus: %v", err)
+	}
+	var status TaskStatusPayload
+	readUntil(t, reconnectedClient, "TASK_STATUS", &status)
+	if status.TaskID != task.TaskID || status.Status != "completed" || status.Total != 1 || len(status.Results) != 1 || status.Results[0].Details != "clicked #a" {
+		t.Errorf("TASK_STATUS = %+v", status)
+	}
+
+	// Other clients can't see it
+	stranger, strangerClient := newTestSession(t)
+	stranger.setClientID("profile_2")
+	if err := handleGetTaskStatus(stranger, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+		t.Fatalf("handleGetTaskStatus: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, strangerClient, "ERROR", &refusal)
+	if refusal.Code != "TASK_NOT_FOUND" {
+		t.Errorf("another client's task got %+v", refusal)
+	}
+}
+
+func TestPageContentReachesTheGoalPrompt(t *testing.T) {
+	session, client := newTestSession(t)
+	err := handlePageContent(session, PageContentPayload{
+		URL:   "https://shop.example/cart",
+		Title: "Your cart",
+		Text:  "Your cart has 2 items",
+		HTML:  `<html><body><button id="checkout">Proceed to checkout</button></body></html>`,
+	})
+	if err != nil {
+		t.Fatalf("handlePageContent: %v", err)
+	}
+	readUntil(t, client, "CONTENT_ANALYSIS", nil)
+
+	pageContext := session.getPageContextFor("https://shop.example/cart")
+	if pageContext == nil || pageContext.Title != "Your cart" || len(pageContext.Elements) == 0 {
+		t.Fatalf("page context = %+v", pageContext)
+	}
+	prompt := llm.BuildGoalParsingPrompt("click on checkout", pageContext, nil)
+	for _, want := range []string{"CURRENT PAGE CONTEXT", "https://shop.example/cart", "#checkout", "Your cart has 2 items"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q", want)
+		}
+	}
+}
+
+// fakeLLM answers every prompt with the same response
+type fakeLLM struct {
+	mu       sync.Mutex
+	response string
+	calls    int
+}
+
+func (f *fakeLLM) Generate(prompt string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.response, nil
+}
+
+func (f *fakeLLM) TestConnection() error { return nil }
+
+// withLLM routes goal parsing through an LLM answering response for the
+// rest of the test
+func withLLM(t *testing.T, response string) *fakeLLM {
+	t.Helper()
+	fake := &fakeLLM{response: response}
+	previousClient, previousUse := llmClient, useLLM
+	llmClient, useLLM = fake, true
+	t.Cleanup(func() { llmClient, useLLM = previousClient, previousUse })
+	return fake
+}
+
+func TestLowConfidenceLLMPlanFallsBackToRules(t *testing.T) {
+	fake := withLLM(t, `{"intent":"navigate","confidence":0.2,"steps":[{"action":"navigate","url":"https://wrong.example"}]}`)
+
+	sequence := parseGoalToSequence(slog.Default(), "please go to github.com", nil, nil, nil)
+	if fake.calls != 1 {
+		t.Fatalf("LLM called %d times, want 1", fake.calls)
+	}
+	if sequence == nil || len(sequence.Commands) == 0 || !strings.Contains(sequence.Commands[0].URL, "github.com") {
+		t.Errorf("sequence = %+v, want the rule parser's navigation to github.com", sequence)
+	}
+}
+
+func TestConfidentLLMPlanIsUsed(t *testing.T) {
+	withLLM(t, `{"intent":"navigate","confidence":0.9,"steps":[{"action":"navigate","url":"https://github.com/trending"}]}`)
+
+	sequence := parseGoalToSequence(slog.Default(), "please show me what's trending on github", nil, nil, nil)
+	if sequence == nil || len(sequence.Commands) != 1 || sequence.Commands[0].URL != "https://github.com/trending" {
+		t.Errorf("sequence = %+v, want the LLM plan", sequence)
+	}
+}
+
+func TestParseScrollGoals(t *testing.T) {
+	tests := []struct {
+		goal     string
+		text     string
+		selector string
+	}{
+		{"scroll down", "down", ""},
+		{"scroll up", "up", ""},
+		{"Scroll down 500 pixels", "500", ""},
+		{"scroll up 300px", "-300", ""},
+		{"scroll to the bottom", "bottom", ""},
+		{"go to the top of the page", "top", ""},
+		{"scroll to the footer", "", "footer"},
+		{"scroll to #comments", "", "#comments"},
+	}
+	for _, tt := range tests {
+		sequence := parseGoalWithRules(tt.goal)
+		if sequence == nil || len(sequence.Commands) != 1 {
+			t.Errorf("%q: sequence = %+v", tt.goal, sequence)
+			continue
+		}
+		command := sequence.Commands[0]
+		if command.Action != "scroll" || command.Text != tt.text || command.ScrollSelector != tt.selector {
+			t.Errorf("%q: got %+v, want text %q selector %q", tt.goal, command, tt.text, tt.selector)
+		}
+	}
+}
+
+func TestParseHistoryGoals(t *testing.T) {
+	tests := []struct {
+		goal, action string
+	}{
+		{"go back", "back"},
+		{"Go back to the previous page.", "back"},
+		{"back", "back"},
+		{"go forward", "forward"},
+		{"forward", "forward"},
+		{"go to github.com", "navigate"},
+	}
+	for _, tt := range tests {
+		sequence := parseGoalWithRules(tt.goal)
+		if sequence == nil || len(sequence.Commands) != 1 || sequence.Commands[0].Action != tt.action {
+			t.Errorf("%q: sequence = %+v, want one %s", tt.goal, sequence, tt.action)
+		}
+	}
+}
+
+func TestExtractURLFromGoal(t *testing.T) {
+	tests := []struct {
+		goal, want string
+	}{
+		{"go to github.com/golang/go/issues?q=is%3Aopen", "https://github.com/golang/go/issues?q=is%3Aopen"},
+		{"Open https://Example.com/Docs/Intro#setup.", "https://Example.com/Docs/Intro#setup"},
+		{"visit news.bbc.co.uk/sport", "https://news.bbc.co.uk/sport"},
+		{"open localhost:3000/admin", "http://localhost:3000/admin"},
+		{"go to 192.168.1.1", "http://192.168.1.1"},
+		{"navigate to (example.org)", "https://example.org"},
+		{"go to github", "https://github.com"},
+		{"go somewhere nice", "https://google.com"},
+	}
+	for _, tt := range tests {
+		if got := extractURLFromGoal(tt.goal); got != tt.want {
+			t.Errorf("extractURLFromGoal(%q) = %q, want %q", tt.goal, got, tt.want)
+		}
+	}
+}
+
+func TestNavigateGoalKeepsPathCase(t *testing.T) {
+	sequence := parseGoalWithRules("Go to github.com/Golang/Go/wiki")
+	if sequence == nil || sequence.Commands[0].URL != "https://github.com/Golang/Go/wiki" {
+		t.Errorf("sequence = %+v", sequence)
+	}
+}
+
+func TestExtractSearchTermFromGoal(t *testing.T) {
+	tests := []struct {
+		goal, want string
+	}{
+		{`search for "Go Programming Language" on google`, "Go Programming Language"},
+		{"find “rock and roll” records", "rock and roll"},
+		{"look for 'C++ books' please", "C++ books"},
+		{"don't search for it's a trap", "it's a trap"},
+		{"Search for iPhone 15 Pro", "iPhone 15 Pro"},
+		{"find cheap flights", "cheap flights"},
+	}
+	for _, tt := range tests {
+		if got := extractSearchTermFromGoal(tt.goal); got != tt.want {
+			t.Errorf("extractSearchTermFromGoal(%q) = %q, want %q", tt.goal, got, tt.want)
+		}
+	}
+}
+
+func TestUnsafeNavigateIsRejected(t *testing.T) {
+	session, client := newTestSession(t)
+	task, err := startTask(session, ExecuteTaskPayload{Goal: "go to javascript:alert(1)"},
+		&CommandSequence{Commands: []CommandPayload{{Action: "navigate", URL: "javascript:alert(1)"}}}, nil)
+	if err != nil || task != nil {
+		t.Fatalf("startTask = %v, %v; want the sequence rejected", task, err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "UNSAFE_URL" || session.countTasks() != 0 {
+		t.Errorf("refusal = %+v with %d tasks", refusal, session.countTasks())
+	}
+}
+
+func TestInterpolatedUnsafeURLFailsTheTask(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "follow the link"},
+		CommandPayload{Action: "extract", ExtractSelector: "#link", StoreAs: "target"},
+		CommandPayload{Action: "navigate", URL: "{{target}}"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "extract", Success: true, Details: "javascript:alert(1)"})
+
+	var failed TaskFailedPayload
+	readUntil(t, client, "TASK_FAILED", &failed)
+	if failed.Code != "UNSAFE_URL" {
+		t.Errorf("TASK_FAILED = %+v", failed)
+	}
+}
+
+func TestExtractedURLCanBeNavigated(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "follow the link"},
+		CommandPayload{Action: "extract", ExtractSelector: "#link", StoreAs: "target"},
+		CommandPayload{Action: "navigate", URL: "{{target}}"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "extract", Success: true, Details: " https://example.com/next "})
+
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.Action != "navigate" || command.URL != "https://example.com/next" {
+		t.Errorf("navigate = %+v", command)
+	}
+}
+
+func TestExtractClickLabel(t *testing.T) {
+	tests := []struct {
+		goal, want string
+	}{
+		{"click on Contact Us", "Contact Us"},
+		{"Click the Login button.", "Login"},
+		{`click "Add to Cart"`, "Add to Cart"},
+		{"tap the Settings icon", "Settings"},
+		{"click the button", ""},
+		{"click here", ""},
+		{"scroll down", ""},
+	}
+	for _, tt := range tests {
+		if got := extractClickLabel(tt.goal); got != tt.want {
+			t.Errorf("extractClickLabel(%q) = %q, want %q", tt.goal, got, tt.want)
+		}
+	}
+}
+
+func TestClickGoalTargetsVisibleText(t *testing.T) {
+	sequence := parseGoalWithRules("click the Sign Up button")
+	if sequence == nil || len(sequence.Commands) != 1 {
+		t.Fatalf("sequence = %+v", sequence)
+	}
+	if command := sequence.Commands[0]; command.Action != "click" || command.Text != "Sign Up" {
+		t.Errorf("command = %+v, want a click on the text Sign Up", command)
+	}
+}
+
+// analyze runs analyzePageContent on html as if it came from pageURL
+func analyze(t *testing.T, html string) *ContentAnalysisResult {
+	t.Helper()
+	analysis, err := analyzePageContent(html, "https://example.com/page")
+	if err != nil {
+		t.Fatalf("analyzePageContent: %v", err)
+	}
+	return analysis
+}
+
+func TestAnalysisPairsSelectorsWithText(t *testing.T) {
+	analysis := analyze(t, `<html><body>
+		<a href="/pricing">  Pricing
+			plans </a>
+		<button id="buy">Buy now</button>
+		<input name="q" placeholder="Search products">
+	</body></html>`)
+
+	if len(analysis.Elements) != len(analysis.Selectors) {
+		t.Fatalf("%d elements for %d selectors", len(analysis.Elements), len(analysis.Selectors))
+	}
+	want := []InteractiveElement{
+		{Tag: "a", Text: "Pricing plans"},
+		{Tag: "button", Text: "Buy now", Selector: "#buy"},
+		{Tag: "input", Text: "Search products"},
+	}
+	for i, element := range analysis.Elements {
+		if element.Selector != analysis.Selectors[i] {
+			t.Errorf("element %d selector %q, Selectors has %q", i, element.Selector, analysis.Selectors[i])
+		}
+		if element.Tag != want[i].Tag || element.Text != want[i].Text || (want[i].Selector != "" && element.Selector != want[i].Selector) {
+			t.Errorf("element %d = %+v, want %+v", i, element, want[i])
+		}
+	}
+}
+
+func TestGeneratedSelectorsAreUnique(t *testing.T) {
+	html := `<html><body>
+		<div id="cart">
+			<ul>
+				<li><button class="remove">Remove</button></li>
+				<li><button class="remove">Remove</button></li>
+			</ul>
+		</div>
+		<div><button class="remove">Remove</button></div>
+		<input id="email" name="email">
+		<input name="q">
+		<a href="/a">A</a><a href="/b">B</a>
+	</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elements := doc.Find("input, button, a")
+	elements.Each(func(i int, s *goquery.Selection) {
+		selector := generateSmartSelector(doc, s)
+		matches := doc.Find(selector)
+		if matches.Length() != 1 || matches.Get(0) != s.Get(0) {
+			t.Errorf("element %d: selector %q matches %d elements, not just this one", i, selector, matches.Length())
+		}
+	})
+
+	if got := generateSmartSelector(doc, doc.Find("#email")); got != "#email" {
+		t.Errorf("element with an id got %q", got)
+	}
+	if got := generateSmartSelector(doc, doc.Find("[name='q']")); got != "[name='q']" {
+		t.Errorf("element with a unique name got %q", got)
+	}
+	if got := generateSmartSelector(doc, doc.Find("#cart li").Eq(1).Find("button")); !strings.HasPrefix(got, "#cart > ") {
+		t.Errorf("repeated button inside #cart got %q, want a path from #cart", got)
+	}
+}
+
+func TestLoginFormDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		html        string
+		contentType string
+	}{
+		{"email and password", `<form><input type="email" name="email"><input type="password" name="pw"><button>Sign in</button></form>`, "login"},
+		{"username without type", `<form><input name="username"><input type="password"></form>`, "login"},
+		{"no form element", `<div><div><input id="user"></div><div><input type="password"></div></div>`, "login"},
+		{"sign-up form", `<form><input type="email"><input type="password"><input type="password" name="confirm"></form>`, "form"},
+		{"password alone", `<form><input type="hidden" name="user"><input type="password"></form>`, "form"},
+		{"search page", `<form><input type="search" name="q"></form>`, "search"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyze(t, "<html><body>"+tt.html+"</body></html>")
+			if analysis.ContentType != tt.contentType {
+				t.Errorf("content type = %q, want %q", analysis.ContentType, tt.contentType)
+			}
+			suggestsLogin := slices.Contains(analysis.Suggestions, "Log in with credentials")
+			if suggestsLogin != (tt.contentType == "login") {
+				t.Errorf("suggestions = %q", analysis.Suggestions)
+			}
+		})
+	}
+}
+
+func TestExtractTables(t *testing.T) {
+	analysis := analyze(t, `<html><body>
+		<table id="prices">
+			<caption> Plan   prices </caption>
+			<thead><tr><th>Plan</th><th>Monthly</th><th>Yearly</th></tr></thead>
+			<tbody>
+				<tr><td>Basic</td><td colspan="2">Free</td></tr>
+				<tr><td rowspan="2">Pro</td><td>$10</td><td>$100</td></tr>
+				<tr><td>$12</td><td>$120</td></tr>
+				<tr><td>Nested</td><td><table><tr><td>inner</td></tr></table></td><td>x</td></tr>
+			</tbody>
+		</table>
+		<table><tr><td> </td><td></td></tr></table>
+		<table><tr><th>Name</th><th>Role</th></tr><tr><td>Ada</td><td>Engineer</td></tr></table>
+	</body></html>`)
+
+	if len(analysis.Tables) != 3 {
+		t.Fatalf("got %d tables, want the layout table skipped: %+v", len(analysis.Tables), analysis.Tables)
+	}
+
+	prices := analysis.Tables[0]
+	if prices.Selector != "#prices" || prices.Caption != "Plan prices" {
+		t.Errorf("selector %q, caption %q", prices.Selector, prices.Caption)
+	}
+	if !slices.Equal(prices.Headers, []string{"Plan", "Monthly", "Yearly"}) {
+		t.Errorf("headers = %q", prices.Headers)
+	}
+	wantRows := [][]string{
+		{"Basic", "Free", "Free"},
+		{"Pro", "$10", "$100"},
+		{"Pro", "$12", "$120"},
+		{"Nested", "inner", "x"},
+	}
+	if len(prices.Rows) != len(wantRows) {
+		t.Fatalf("rows = %q", prices.Rows)
+	}
+	for i, want := range wantRows {
+		if !slices.Equal(prices.Rows[i], want) {
+			t.Errorf("row %d = %q, want %q", i, prices.Rows[i], want)
+		}
+	}
+
+	// A leading row of <th> cells counts as the header without <thead>
+	people := analysis.Tables[2]
+	if !slices.Equal(people.Headers, []string{"Name", "Role"}) || len(people.Rows) != 1 {
+		t.Errorf("table without thead = %+v", people)
+	}
+}
+
+func TestParseSelectOptionGoals(t *testing.T) {
+	tests := []struct {
+		goal, option, selector string
+	}{
+		{"select Canada from the country dropdown", "Canada", "select[name*='country' i], select[id*='country' i], select[aria-label*='country' i]"},
+		{"choose 'Large' from the dropdown", "Large", "select"},
+		{"pick the option Blue in the colour menu", "Blue", "select[name*='colour' i], select[id*='colour' i], select[aria-label*='colour' i]"},
+		{"choose Express delivery", "Express delivery", "select"},
+	}
+	for _, tt := range tests {
+		sequence := parseGoalWithRules(tt.goal)
+		if sequence == nil || len(sequence.Commands) != 1 {
+			t.Errorf("%q: sequence = %+v", tt.goal, sequence)
+			continue
+		}
+		command := sequence.Commands[0]
+		if command.Action != "select_option" || command.Text != tt.option || command.Selector != tt.selector {
+			t.Errorf("%q: got %+v, want option %q selector %q", tt.goal, command, tt.option, tt.selector)
+		}
+	}
+}
+
+func TestParseToggleGoals(t *testing.T) {
+	tests := []struct {
+		goal, action, label, selector string
+	}{
+		{"check the terms box", "check", "terms", toggleSelectors["box"]},
+		{"tick newsletter", "check", "newsletter", toggleSelectors[""]},
+		{"uncheck the remember me checkbox", "uncheck", "remember me", toggleSelectors["checkbox"]},
+		{"select the Express shipping radio", "check", "Express shipping", toggleSelectors["radio"]},
+		{"enable the 'Dark mode' switch", "check", "Dark mode", toggleSelectors["switch"]},
+	}
+	for _, tt := range tests {
+		sequence := parseGoalWithRules(tt.goal)
+		if sequence == nil || len(sequence.Commands) != 1 {
+			t.Errorf("%q: sequence = %+v", tt.goal, sequence)
+			continue
+		}
+		command := sequence.Commands[0]
+		if command.Action != tt.action || command.Text != tt.label || command.Selector != tt.selector {
+			t.Errorf("%q: got %+v, want %s %q on %q", tt.goal, command, tt.action, tt.label, tt.selector)
+		}
+	}
+
+	// Without a checkbox or radio noun, "check" reads as looking at something
+	for _, goal := range []string{"check the price", "select the first result"} {
+		if command := parseToggleCommand(goal); command != nil {
+			t.Errorf("%q parsed as a toggle: %+v", goal, command)
+		}
+	}
+}
+
+func TestParsePressKeyGoals(t *testing.T) {
+	tests := []struct {
+		goal, key string
+	}{
+		{"press Enter", "Enter"},
+		{"hit the escape key", "Escape"},
+		{"press the down arrow", "ArrowDown"},
+		{"tap arrow left", "ArrowLeft"},
+		{"press page down", "PageDown"},
+		{"press return again", "Enter"},
+	}
+	for _, tt := range tests {
+		command := parsePressKeyCommand(tt.goal)
+		if command == nil || command.Action != "press_key" || command.Text != tt.key {
+			t.Errorf("%q: got %+v, want press_key %q", tt.goal, command, tt.key)
+		}
+	}
+	if command := parsePressKeyCommand("press the big red button"); command != nil {
+		t.Errorf("unknown key parsed: %+v", command)
+	}
+}
+
+func TestSearchThenEnterSkipsSubmitClick(t *testing.T) {
+	commands := parseMultiStepGoal("search for golang and press enter")
+	if len(commands) != 2 {
+		t.Fatalf("commands = %+v, want input then press_key", commands)
+	}
+	input, enter := commands[0], commands[1]
+	if input.Action != "input" || input.Text != "golang" {
+		t.Errorf("first command = %+v", input)
+	}
+	if enter.Action != "press_key" || enter.Text != "Enter" || enter.Selector != input.Selector {
+		t.Errorf("second command = %+v, want Enter sent to %q", enter, input.Selector)
+	}
+
+	commands = parseMultiStepGoal("search for golang")
+	if len(commands) != 2 || commands[1].Action != "click" {
+		t.Errorf("without a key press, commands = %+v, want a submit click", commands)
+	}
+}
+
+func TestStepDelay(t *testing.T) {
+	withStepDelays(t, 2*time.Second, 300*time.Millisecond)
+	tests := []struct {
+		command CommandPayload
+		want    time.Duration
+	}{
+		{CommandPayload{Action: "navigate", URL: "https://example.com"}, 2 * time.Second},
+		{CommandPayload{Action: "back"}, 2 * time.Second},
+		{CommandPayload{Action: "click", Selector: "#a"}, 300 * time.Millisecond},
+		{CommandPayload{Action: "click", Selector: "#a", TimeoutMs: 50}, 50 * time.Millisecond},
+		{CommandPayload{Action: "wait_for_ready_state"}, 0},
+		{CommandPayload{Action: "check_element", Selector: "#a"}, 0},
+	}
+	for _, tt := range tests {
+		if got := stepDelay(tt.command); got != tt.want {
+			t.Errorf("stepDelay(%+v) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestInsertNavigationWaits(t *testing.T) {
+	commands := insertNavigationWaits([]CommandPayload{
+		{Action: "navigate", URL: "https://example.com"},
+		{Action: "click", Selector: "#a"},
+		{Action: "back"},
+		{Action: "wait_for_element", Selector: "#b"},
+		{Action: "navigate", URL: "https://example.org"},
+	})
+	actions := []string{}
+	for _, command := range commands {
+		actions = append(actions, command.Action)
+	}
+	want := []string{"navigate", "wait_for_ready_state", "click", "back", "wait_for_element", "navigate"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+	if commands[1].WaitTimeoutMs != defaultPageLoadWaitMs {
+		t.Errorf("injected wait = %+v", commands[1])
+	}
+}
+
+func TestStepDelayDoesNotBlockCompletion(t *testing.T) {
+	withStepDelays(t, 0, 200*time.Millisecond)
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice"},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+
+	start := time.Now()
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("handleCommandComplete blocked for %v", elapsed)
+	}
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("next command sent after %v, want the 200ms step delay", elapsed)
+	}
+	if command.Selector != "#b" {
+		t.Errorf("next command = %+v", command)
+	}
+}
+
+func TestTaskCompleteReportsStepTimings(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "click twice", MaxRetries: 1, RetryBackoffMs: 10},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	time.Sleep(20 * time.Millisecond)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: false, Error: "not found"})
+	readUntil(t, client, "COMMAND", nil)
+	time.Sleep(20 * time.Millisecond)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true})
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true})
+
+	var complete TaskCompletePayload
+	readUntil(t, client, "TASK_COMPLETE", &complete)
+	if len(complete.Results) != 3 {
+		t.Fatalf("results = %+v, want the retried step twice", complete.Results)
+	}
+	var sum int64
+	for _, result := range complete.Results {
+		started, err1 := time.Parse(time.RFC3339Nano, result.StartedAt)
+		ended, err2 := time.Parse(time.RFC3339Nano, result.EndedAt)
+		if err1 != nil || err2 != nil || ended.Before(started) || result.DurationMs < 0 {
+			t.Errorf("step %d timing %q to %q (%dms)", result.Step, result.StartedAt, result.EndedAt, result.DurationMs)
+		}
+		sum += result.DurationMs
+	}
+	if complete.Results[0].DurationMs < 20 {
+		t.Errorf("first step took %dms, want at least 20", complete.Results[0].DurationMs)
+	}
+	if complete.DurationMs != sum {
+		t.Errorf("task duration %dms, want the sum of its steps, %dms", complete.DurationMs, sum)
+	}
+}
+
+func TestDuplicateCompletionIsTimedOnce(t *testing.T) {
+	task := &TaskState{stepDispatchedAt: time.Now().Add(-30 * time.Millisecond)}
+	var first, duplicate CommandResult
+	task.recordStepTiming(&first)
+	task.recordStepTiming(&duplicate)
+
+	if first.DurationMs < 30 || task.DurationMs != first.DurationMs {
+		t.Errorf("first result %dms, task %dms", first.DurationMs, task.DurationMs)
+	}
+	if duplicate.StartedAt != "" || duplicate.DurationMs != 0 {
+		t.Errorf("duplicate was timed: %+v", duplicate)
+	}
+}
+
+func TestSplitStepList(t *testing.T) {
+	tests := []struct {
+		goal string
+		want []string
+	}{
+		{"1. go to google.com 2. search for cats 3. click the first result",
+			[]string{"go to google.com", "search for cats", "click the first result"}},
+		{"1) open github.com; 2) scroll down.", []string{"open github.com", "scroll down"}},
+		{"go to google.com\nsearch for cats\n\nclick the first result", []string{"go to google.com", "search for cats", "click the first result"}},
+		{"- go to google.com\n* search for cats\n3. press enter", []string{"go to google.com", "search for cats", "press enter"}},
+		{"buy 2) items", nil},
+		{"1. go to google.com 3. search for cats", nil},
+		{"1. go to google.com", nil},
+	}
+	for _, tt := range tests {
+		if got := splitStepList(tt.goal); !slices.Equal(got, tt.want) {
+			t.Errorf("splitStepList(%q) = %q, want %q", tt.goal, got, tt.want)
+		}
+	}
+}
+
+func TestNumberedGoalBecomesSeparateCommands(t *testing.T) {
+	commands := parseMultiStepGoal("1. go to github.com 2. scroll down 3. go back")
+	actions := []string{}
+	for _, command := range commands {
+		actions = append(actions, command.Action)
+	}
+	if want := []string{"navigate", "scroll", "back"}; !slices.Equal(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+}
+
+func TestSplitConjunctions(t *testing.T) {
+	tests := []struct {
+		step string
+		want []string
+	}{
+		{"search for cats and dogs", []string{"search for cats and dogs"}},
+		{"go to google and search for cats", []string{"go to google", "search for cats"}},
+		{"go to google, and then search for salt and pepper", []string{"go to google", "search for salt and pepper"}},
+		{"type rock and roll then press enter", []string{"type rock and roll", "press enter"}},
+		{"scroll down and click Terms and Conditions", []string{"scroll down", "click Terms and Conditions"}},
+	}
+	for _, tt := range tests {
+		if got := splitConjunctions(tt.step); !slices.Equal(got, tt.want) {
+			t.Errorf("splitConjunctions(%q) = %q, want %q", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestSearchTermKeepsItsAnd(t *testing.T) {
+	commands := parseMultiStepGoal("search for cats and dogs")
+	if len(commands) == 0 || commands[0].Action != "input" || commands[0].Text != "cats and dogs" {
+		t.Errorf("commands = %+v, want one search for \"cats and dogs\"", commands)
+	}
+}
+
+func TestDispatchBlanksMalformedSelectors(t *testing.T) {
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "scroll"},
+		CommandPayload{Action: "scroll", ScrollSelector: "#x']; alert(1); //", ShadowPath: []string{"my-app", "a[href`"}},
+	)
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.ScrollSelector != "" || !slices.Equal(command.ShadowPath, []string{"my-app", ""}) {
+		t.Errorf("command = %+v, want the malformed selectors blanked", command)
+	}
+}
+
+func TestRuleSelectorsSurviveSanitizing(t *testing.T) {
+	goals := []string{
+		"click on Contact Us", "hover over the menu", "select Canada from the country dropdown",
+		"check the terms box", "select the Express shipping radio", "scroll to O'Brien's section",
+		"scroll to the footer", "type hello into the search box", "search for cats",
+		"drag the Fix login card to the Done column", "press enter",
+	}
+	for _, goal := range goals {
+		sequence := parseGoalWithRules(goal)
+		if sequence == nil {
+			t.Errorf("%q did not parse", goal)
+			continue
+		}
+		for _, command := range sequence.Commands {
+			sanitized := sanitizeCommandSelectors(slog.Default(), command)
+			for i, field := range cssSelectorFields(&command) {
+				if got := *cssSelectorFields(&sanitized)[i]; got != strings.TrimSpace(*field) {
+					t.Errorf("%q: selector %q was dropped", goal, *field)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// version is stamped at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	tasksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_tasks_total",
+		Help: "Tasks accepted for execution.",
+	})
+	tasksFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_tasks_failed_total",
+		Help: "Tasks that failed after exhausting step retries.",
+	})
+	tasksCompletedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_tasks_completed_total",
+		Help: "Tasks that ran every step successfully.",
+	})
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_commands_total",
+		Help: "Command completions reported by the extension, by action and result.",
+	}, []string{"action", "result"})
+	stepDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cortex_step_duration_seconds",
+		Help:    "Time from sending a command to its COMMAND_COMPLETE, by action.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+	}, []string{"action"})
+	goalParsesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_goal_parses_total",
+		Help: "Goals turned into a command sequence, by the parser that produced it (llm or rules).",
+	}, []string{"parser"})
+	llmRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_llm_requests_total",
+		Help: "Requests sent to the LLM backend, including failed ones.",
+	})
+	llmLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cortex_llm_latency_seconds",
+		Help:    "Time taken by LLM backend requests.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	})
+	llmTokensPerSecond = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cortex_llm_tokens_per_second",
+		Help:    "Generation speed of LLM responses that reported timings.",
+		Buckets: []float64{5, 10, 20, 40, 60, 100, 200},
+	})
+	llmLoadSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cortex_llm_model_load_seconds",
+		Help:    "Time the LLM backend spent loading the model before answering.",
+		Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 10, 30},
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_llm_prompt_tokens",
+		Help: "Prompt tokens used by the LLM backend since startup.",
+	}, func() float64 {
+		return float64(llmUsage().TotalPromptTokens)
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_llm_completion_tokens",
+		Help: "Completion tokens generated by the LLM backend since startup.",
+	}, func() float64 {
+		return float64(llmUsage().TotalCompletionTokens)
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_llm_usage_requests",
+		Help: "Successful LLM requests whose token usage was recorded.",
+	}, func() float64 {
+		return float64(llmUsage().RequestCount)
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cortex_active_connections",
+		Help: "Open WebSocket connections.",
+	}, func() float64 {
+		return float64(registry.Stats().Connections)
+	})
+)
+
+// recordTaskTransition updates the task counters for a status change
+func recordTaskTransition(status string) {
+	switch status {
+	case "pending":
+		tasksTotal.Inc()
+	case "failed":
+		tasksFailedTotal.Inc()
+	case "completed":
+		tasksCompletedTotal.Inc()
+	}
+}
+
+// recordCommandResult counts a COMMAND_COMPLETE for action and, when the
+// dispatch time is known, how long the extension took
+func recordCommandResult(action string, success bool, dispatchedAt time.Time) {
+	if !dispatchedAt.IsZero() {
+		stepDurationSeconds.WithLabelValues(action).Observe(time.Since(dispatchedAt).Seconds())
+	}
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	commandsTotal.WithLabelValues(action, result).Inc()
+}
+
+// llmUsage returns the token usage of the configured LLM backend, or zeros
+// when there is none or it doesn't count tokens
+func llmUsage() llm.UsageStats {
+	if reporter, ok := llmClient.(llm.UsageReporter); ok {
+		return reporter.Stats()
+	}
+	return llm.UsageStats{}
+}
+
+// HealthStatus is the body served at GET /health
+type HealthStatus struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	RegistryStats
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthStatus{
+		Status:        "ok",
+		Version:       version,
+		RegistryStats: registry.Stats(),
+	})
+}
+
+// instrumentedProvider counts and times every request to the wrapped provider
+type instrumentedProvider struct {
+	llm.LLMProvider
+}
+
+func (p instrumentedProvider) Generate(prompt string) (string, error) {
+	defer observeLLMRequest(time.Now())
+	return p.LLMProvider.Generate(prompt)
+}
+
+// GenerateStream streams when the wrapped provider can, and otherwise
+// delivers the whole response as a single chunk
+func (p instrumentedProvider) GenerateStream(ctx context.Context, prompt string, out chan<- string) error {
+	defer observeLLMRequest(time.Now())
+
+	if streamer, ok := p.LLMProvider.(llm.StreamingBackend); ok {
+		return streamer.GenerateStream(ctx, prompt, out)
+	}
+
+	response, err := p.LLMProvider.Generate(prompt)
+	if err != nil {
+		return err
+	}
+	select {
+	case out <- response:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GenerateWithStats also records the response's timings when the wrapped
+// provider reports them
+func (p instrumentedProvider) GenerateWithStats(prompt string) (string, llm.GenerationStats, error) {
+	metered, ok := p.LLMProvider.(llm.StatsBackend)
+	if !ok {
+		response, err := p.Generate(prompt)
+		return response, llm.GenerationStats{}, err
+	}
+
+	defer observeLLMRequest(time.Now())
+	response, stats, err := metered.GenerateWithStats(prompt)
+	observeLLMStats(stats)
+	return response, stats, err
+}
+
+// GenerateStreamWithStats is GenerateStream that also records timings
+func (p instrumentedProvider) GenerateStreamWithStats(ctx context.Context, prompt string, out chan<- string) (llm.GenerationStats, error) {
+	metered, ok := p.LLMProvider.(llm.StatsBackend)
+	if !ok {
+		return llm.GenerationStats{}, p.GenerateStream(ctx, prompt, out)
+	}
+
+	defer observeLLMRequest(time.Now())
+	stats, err := metered.GenerateStreamWithStats(ctx, prompt, out)
+	observeLLMStats(stats)
+	return stats, err
+}
+
+// Stats forwards to the wrapped provider's token usage, if it tracks any
+func (p instrumentedProvider) Stats() llm.UsageStats {
+	if reporter, ok := p.LLMProvider.(llm.UsageReporter); ok {
+		return reporter.Stats()
+	}
+	return llm.UsageStats{}
+}
+
+func observeLLMRequest(start time.Time) {
+	llmRequestsTotal.Inc()
+	llmLatencySeconds.Observe(time.Since(start).Seconds())
+}
+
+// observeLLMStats records a response's timings; backends that report none
+// are skipped
+func observeLLMStats(stats llm.GenerationStats) {
+	if stats.TotalDuration <= 0 {
+		return
+	}
+	if tps := stats.TokensPerSecond(); tps > 0 {
+		llmTokensPerSecond.Observe(tps)
+	}
+	llmLoadSeconds.Observe(stats.LoadDuration.Seconds())
+}
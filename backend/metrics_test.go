@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricValue scrapes /metrics and returns the sample for series, e.g.
+// `cortex_commands_total{action="click",result="success"}`, or 0 when it
+// hasn't been recorded yet
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || name != series {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("parse %s: %v", scanner.Text(), err)
+		}
+		return f
+	}
+	return 0
+}
+
+func TestTaskMetricsFollowTheTask(t *testing.T) {
+	withStepDelays(t, 0, 0)
+	const clickSuccess = `cortex_commands_total{action="click",result="success"}`
+	started := metricValue(t, "cortex_tasks_total")
+	completed := metricValue(t, "cortex_tasks_completed_total")
+	clicks := metricValue(t, clickSuccess)
+
+	session, client := newTestSession(t)
+	startTestTask(t, session, ExecuteTaskPayload{Goal: "click"},
+		CommandPayload{Action: "click", Selector: "#a"},
+	)
+	readUntil(t, client, "COMMAND", nil)
+	handleCommandComplete(session, CommandResult{Action: "click", Success: true})
+	readUntil(t, client, "TASK_COMPLETE", nil)
+
+	if got := metricValue(t, "cortex_tasks_total") - started; got != 1 {
+		t.Errorf("cortex_tasks_total grew by %v, want 1", got)
+	}
+	if got := metricValue(t, "cortex_tasks_completed_total") - completed; got != 1 {
+		t.Errorf("cortex_tasks_completed_total grew by %v, want 1", got)
+	}
+	if got := metricValue(t, clickSuccess) - clicks; got != 1 {
+		t.Errorf("%s grew by %v, want 1", clickSuccess, got)
+	}
+}
+
+func TestRecordTaskTransitionCountsFailures(t *testing.T) {
+	failed := metricValue(t, "cortex_tasks_failed_total")
+	recordTaskTransition("running")
+	recordTaskTransition("failed")
+	if got := metricValue(t, "cortex_tasks_failed_total") - failed; got != 1 {
+		t.Errorf("cortex_tasks_failed_total grew by %v, want 1", got)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var health HealthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if health.Status != "ok" || health.Version != version {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestLLMTimingsReachMetrics(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(llm.OllamaResponse{
+			Model:         "mistral:latest",
+			Response:      `{"steps":[]}`,
+			Done:          true,
+			TotalDuration: int64(2 * time.Second),
+			EvalCount:     50,
+			EvalDuration:  int64(time.Second),
+		})
+	}))
+	defer ollama.Close()
+	provider := instrumentedProvider{llm.NewLLMClientWithHost(ollama.URL, "mistral:latest", nil)}
+
+	requests := metricValue(t, "cortex_llm_requests_total")
+	speeds := metricValue(t, "cortex_llm_tokens_per_second_count")
+	if _, _, err := provider.GenerateWithStats("plan"); err != nil {
+		t.Fatalf("GenerateWithStats: %v", err)
+	}
+	if got := metricValue(t, "cortex_llm_requests_total") - requests; got != 1 {
+		t.Errorf("cortex_llm_requests_total grew by %v, want 1", got)
+	}
+	if got := metricValue(t, "cortex_llm_tokens_per_second_count") - speeds; got != 1 {
+		t.Errorf("tokens per second observations grew by %v, want 1", got)
+	}
+}
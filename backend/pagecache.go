@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+const (
+	// maxCachedPages bounds how many pages a connection remembers
+	maxCachedPages = 10
+	// pageCacheTTL is how long a page's content stays usable as goal context
+	pageCacheTTL = 15 * time.Minute
+)
+
+// cachedPage is one PAGE_CONTENT payload with the context built from it
+type cachedPage struct {
+	content  *PageContentPayload
+	context  *llm.PageContext
+	storedAt time.Time
+}
+
+// pageCache keeps the analyzed content of the pages a connection has sent,
+// one entry per URL, so a goal can be resolved against the tab it was
+// typed in. Entries are ordered oldest first; the oldest go once there are
+// more than maxCachedPages, and entries older than pageCacheTTL are
+// ignored and dropped on the next put. The owning Session's mu guards it.
+type pageCache struct {
+	pages []cachedPage
+}
+
+// pageCacheKey drops the fragment, which doesn't change the page
+func pageCacheKey(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	parsed.Fragment, parsed.RawFragment = "", ""
+	return parsed.String()
+}
+
+// put stores content as the most recent page, replacing any entry for the
+// same URL
+func (c *pageCache) put(content *PageContentPayload, ctx *llm.PageContext, now time.Time) {
+	key := pageCacheKey(content.URL)
+	kept := c.pages[:0]
+	for _, page := range c.pages {
+		if pageCacheKey(page.content.URL) != key && now.Sub(page.storedAt) < pageCacheTTL {
+			kept = append(kept, page)
+		}
+	}
+	kept = append(kept, cachedPage{content: content, context: ctx, storedAt: now})
+	if excess := len(kept) - maxCachedPages; excess > 0 {
+		kept = kept[excess:]
+	}
+	c.pages = kept
+}
+
+// get returns the fresh entry for rawURL, or nil
+func (c *pageCache) get(rawURL string, now time.Time) *cachedPage {
+	key := pageCacheKey(rawURL)
+	for i := len(c.pages) - 1; i >= 0; i-- {
+		if page := &c.pages[i]; pageCacheKey(page.content.URL) == key {
+			if now.Sub(page.storedAt) >= pageCacheTTL {
+				return nil
+			}
+			return page
+		}
+	}
+	return nil
+}
+
+// latest returns the most recently stored fresh entry, or nil
+func (c *pageCache) latest(now time.Time) *cachedPage {
+	if len(c.pages) == 0 {
+		return nil
+	}
+	if page := &c.pages[len(c.pages)-1]; now.Sub(page.storedAt) < pageCacheTTL {
+		return page
+	}
+	return nil
+}
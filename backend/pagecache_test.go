@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+func TestPageCacheKey(t *testing.T) {
+	tests := []struct{ url, want string }{
+		{"https://example.com/docs#install", "https://example.com/docs"},
+		{"https://example.com/search?q=go#top", "https://example.com/search?q=go"},
+		{"https://example.com/", "https://example.com/"},
+		{"::not a url", "::not a url"},
+	}
+	for _, tt := range tests {
+		if got := pageCacheKey(tt.url); got != tt.want {
+			t.Errorf("pageCacheKey(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPageCacheStoresPagesByURL(t *testing.T) {
+	var cache pageCache
+	now := time.Now()
+	cache.put(&PageContentPayload{URL: "https://shop.example/item", Title: "Item"}, &llm.PageContext{Title: "Item"}, now)
+	cache.put(&PageContentPayload{URL: "https://docs.example/", Title: "Docs"}, &llm.PageContext{Title: "Docs"}, now)
+
+	if page := cache.get("https://shop.example/item#reviews", now); page == nil || page.context.Title != "Item" {
+		t.Errorf("get(item) = %+v", page)
+	}
+	if page := cache.latest(now); page == nil || page.content.Title != "Docs" {
+		t.Errorf("latest = %+v, want docs", page)
+	}
+	if page := cache.get("https://other.example/", now); page != nil {
+		t.Errorf("get(unknown) = %+v", page)
+	}
+
+	// Sending the same page again replaces it and makes it the latest
+	cache.put(&PageContentPayload{URL: "https://shop.example/item#top", Title: "Item, sold out"}, nil, now)
+	if len(cache.pages) != 2 || cache.latest(now).content.Title != "Item, sold out" {
+		t.Errorf("pages after resend = %d, latest %+v", len(cache.pages), cache.latest(now).content)
+	}
+}
+
+func TestPageCacheEvictsOldestAndStale(t *testing.T) {
+	var cache pageCache
+	now := time.Now()
+	for i := 0; i < maxCachedPages+2; i++ {
+		cache.put(&PageContentPayload{URL: fmt.Sprintf("https://example.com/%d", i)}, nil, now)
+	}
+	if len(cache.pages) != maxCachedPages {
+		t.Errorf("cache holds %d pages, want %d", len(cache.pages), maxCachedPages)
+	}
+	if cache.get("https://example.com/0", now) != nil || cache.get("https://example.com/2", now) == nil {
+		t.Error("evicted the wrong pages")
+	}
+
+	later := now.Add(pageCacheTTL)
+	if cache.get("https://example.com/2", later) != nil || cache.latest(later) != nil {
+		t.Error("stale pages are still returned")
+	}
+	cache.put(&PageContentPayload{URL: "https://example.com/new"}, nil, later)
+	if len(cache.pages) != 1 {
+		t.Errorf("cache holds %d pages after a put, want the stale ones dropped", len(cache.pages))
+	}
+}
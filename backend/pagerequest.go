@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+// pageContentRequestTimeout is how long a goal waits for the extension to
+// answer REQUEST_PAGE_CONTENT before it is parsed without page context
+const pageContentRequestTimeout = 3 * time.Second
+
+// RequestPageContentPayload asks the extension to capture the active tab
+// and send it back as PAGE_CONTENT carrying the same RequestID
+type RequestPageContentPayload struct {
+	RequestID string `json:"requestId"`
+}
+
+var errPageContentUnavailable = errors.New("extension could not capture the page")
+
+// needsPageContext reports whether goal picks something out of the page
+// that is already open, like "click on Pricing" or "select the blue one",
+// rather than first going somewhere else
+func needsPageContext(goal string) bool {
+	first := strings.ToLower(goalSteps(goal)[0])
+	if containsNavigationKeywords(first) {
+		return false
+	}
+	return strings.Contains(first, "click on") || strings.Contains(first, "select ")
+}
+
+// requestPageContent asks the extension for the current page and waits for
+// the matching PAGE_CONTENT. It must not run on the read loop, which is
+// what delivers the reply.
+func (s *Session) requestPageContent(timeout time.Duration) (*llm.PageContext, error) {
+	requestID := fmt.Sprintf("page_%d", s.pageRequestCounter.Add(1))
+	reply := make(chan *llm.PageContext, 1)
+
+	s.mu.Lock()
+	s.pageRequests[requestID] = reply
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pageRequests, requestID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(&Message{
+		Type:    "REQUEST_PAGE_CONTENT",
+		Payload: RequestPageContentPayload{RequestID: requestID},
+	}); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case pageContext := <-reply:
+		if pageContext == nil {
+			return nil, errPageContentUnavailable
+		}
+		return pageContext, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no PAGE_CONTENT for %s within %v", requestID, timeout)
+	}
+}
+
+// resolvePageRequest hands pageContext, nil on failure, to the goal waiting
+// on requestID. Replies nobody waits for any more are dropped.
+func (s *Session) resolvePageRequest(requestID string, pageContext *llm.PageContext) {
+	if requestID == "" {
+		return
+	}
+	s.mu.Lock()
+	reply, ok := s.pageRequests[requestID]
+	delete(s.pageRequests, requestID)
+	s.mu.Unlock()
+
+	if ok {
+		reply <- pageContext
+	}
+}
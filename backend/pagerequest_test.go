@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cortex-browser/backend/llm"
+)
+
+func TestNeedsPageContext(t *testing.T) {
+	tests := []struct {
+		goal string
+		want bool
+	}{
+		{"click on Pricing", true},
+		{"select the blue one", true},
+		{"click on Pricing and then scroll down", true},
+		{"go to github.com and click on Explore", false},
+		{"scroll down", false},
+		{"click #submit", false},
+	}
+	for _, tt := range tests {
+		if got := needsPageContext(tt.goal); got != tt.want {
+			t.Errorf("needsPageContext(%q) = %v, want %v", tt.goal, got, tt.want)
+		}
+	}
+}
+
+func TestPageRequestsAreMatchedByID(t *testing.T) {
+	session, client := newTestSession(t)
+
+	type reply struct {
+		context *llm.PageContext
+		err     error
+	}
+	replies := make(chan reply, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			pageContext, err := session.requestPageContent(time.Second)
+			replies <- reply{pageContext, err}
+		}()
+	}
+	var first, second RequestPageContentPayload
+	readUntil(t, client, "REQUEST_PAGE_CONTENT", &first)
+	readUntil(t, client, "REQUEST_PAGE_CONTENT", &second)
+	if first.RequestID == second.RequestID {
+		t.Fatalf("both requests have ID %q", first.RequestID)
+	}
+
+	// Answer out of order; each waiter gets the page sent for its own ID
+	for _, request := range []RequestPageContentPayload{second, first} {
+		err := handlePageContent(session, PageContentPayload{
+			RequestID: request.RequestID,
+			URL:       "https://example.com/" + request.RequestID,
+			HTML:      "<html><body><a href='/pricing'>Pricing</a></body></html>",
+		})
+		if err != nil {
+			t.Fatalf("handlePageContent: %v", err)
+		}
+	}
+	urls := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		r := <-replies
+		if r.err != nil {
+			t.Fatalf("requestPageContent: %v", r.err)
+		}
+		urls[r.context.URL] = true
+	}
+	for _, request := range []RequestPageContentPayload{first, second} {
+		if !urls["https://example.com/"+request.RequestID] {
+			t.Errorf("no waiter got the page for %s: %v", request.RequestID, urls)
+		}
+	}
+}
+
+func TestPageRequestFailsWhenCaptureFails(t *testing.T) {
+	session, client := newTestSession(t)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := session.requestPageContent(time.Second)
+		errs <- err
+	}()
+
+	var request RequestPageContentPayload
+	readUntil(t, client, "REQUEST_PAGE_CONTENT", &request)
+	if err := handlePageContent(session, PageContentPayload{RequestID: request.RequestID, Error: "chrome:// pages can't be scripted"}); err != nil {
+		t.Fatalf("handlePageContent: %v", err)
+	}
+	if err := <-errs; !errors.Is(err, errPageContentUnavailable) {
+		t.Errorf("err = %v, want errPageContentUnavailable", err)
+	}
+}
+
+func TestPageRequestTimesOut(t *testing.T) {
+	session, client := newTestSession(t)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := session.requestPageContent(50 * time.Millisecond)
+		errs <- err
+	}()
+
+	var request RequestPageContentPayload
+	readUntil(t, client, "REQUEST_PAGE_CONTENT", &request)
+	if err := <-errs; err == nil {
+		t.Fatal("requestPageContent succeeded without a reply")
+	}
+
+	// A reply that arrives too late is dropped rather than blocking
+	done := make(chan struct{})
+	go func() {
+		session.resolvePageRequest(request.RequestID, &llm.PageContext{URL: "https://example.com/"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("late reply blocked")
+	}
+	session.mu.Lock()
+	pending := len(session.pageRequests)
+	session.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d page requests still pending", pending)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// defaultNextPageSelector matches the usual "next page" controls
+const defaultNextPageSelector = "a[rel='next'], .next-page, [aria-label='Next']"
+
+// defaultMaxIterations caps a RepeatUntil loop whose sequence sets no limit
+const defaultMaxIterations = 20
+
+// paginationPhraseRegex matches "go through all pages", "on every page",
+// "and the next page" and similar, including a leading "and"/"then"
+var paginationPhraseRegex = regexp.MustCompile(`(?i)(?:,?\s*\b(?:and|then)\b)?\s*(?:\b(?:go|page|click|continue|move|repeat)\s+(?:through|on\s+to|onto|to)\s+|\b(?:on|for|across|through)\s+)?(?:\ball\s+(?:the\s+)?pages\b|\b(?:each|every)\s+page\b|\b(?:the\s+)?next\s+pages?\b)`)
+
+// splitPaginationGoal strips pagination phrasing from goal, reporting whether
+// there was any. "get the titles and go through all pages" gives
+// "get the titles", true.
+func splitPaginationGoal(goal string) (string, bool) {
+	if !paginationPhraseRegex.MatchString(goal) {
+		return goal, false
+	}
+	rest := paginationPhraseRegex.ReplaceAllString(goal, "")
+	return strings.Trim(strings.TrimSpace(rest), ",."), true
+}
+
+// paginationLoopStart returns the index of the first command repeated on
+// every page: everything up to the last navigation, search input or search
+// button click or key press only needs to run once
+func paginationLoopStart(commands []CommandPayload) int {
+	start := 0
+	for i, cmd := range commands {
+		switch {
+		case changesPage(cmd.Action), cmd.Action == "input":
+			start = i + 1
+		case (cmd.Action == "click" || cmd.Action == "press_key" || cmd.Action == "key") && i > 0 && commands[i-1].Action == "input":
+			start = i + 1
+		}
+	}
+	return start
+}
+
+// withPagination turns sequence into a RepeatUntil loop over the next-page
+// control, reading the page content when nothing else is repeated
+func withPagination(sequence *CommandSequence) *CommandSequence {
+	if sequence == nil {
+		sequence = &CommandSequence{}
+	}
+
+	sequence.RepeatFrom = paginationLoopStart(sequence.Commands)
+	if sequence.RepeatFrom == len(sequence.Commands) {
+		sequence.Commands = append(sequence.Commands, CommandPayload{Action: "get_content"})
+	}
+	sequence.RepeatUntil = defaultNextPageSelector
+	sequence.Total = len(sequence.Commands)
+	return sequence
+}
+
+// expandPaginationLoop prepares commands for a RepeatUntil sequence. The loop
+// body starts with a readyState wait so each new page has loaded, and ends
+// with a click on the RepeatUntil control conditioned on it existing. It
+// returns the commands and the index a new iteration restarts at.
+func expandPaginationLoop(commands []CommandPayload, repeatFrom int, repeatUntil string) ([]CommandPayload, int) {
+	if repeatFrom < 0 || repeatFrom > len(commands) {
+		repeatFrom = 0
+	}
+
+	setup := insertConditionChecks(insertNavigationWaits(commands[:repeatFrom]))
+
+	body := []CommandPayload{{Action: "wait_for_ready_state", WaitTimeoutMs: defaultPageLoadWaitMs}}
+	body = append(body, commands[repeatFrom:]...)
+	body = append(body, CommandPayload{Action: "click", Selector: repeatUntil, Condition: repeatUntil})
+	body = insertConditionChecks(insertNavigationWaits(body))
+
+	return append(setup, body...), len(setup)
+}
+
+// PaginationInfo describes the pagination controls found on a page
+type PaginationInfo struct {
+	// Kind is how the controls were recognized: "rel_next", "next_text" or
+	// "numbered"
+	Kind string `json:"kind"`
+	// NextSelector uniquely matches the next-page element; it is empty for
+	// numbered pagination whose current page can't be told apart
+	NextSelector string `json:"nextSelector,omitempty"`
+}
+
+// nextPageLabels are the visible texts or aria-labels of "next page" controls
+var nextPageLabels = map[string]bool{
+	"next": true, "next page": true, "next »": true, "next ›": true, "next >": true,
+	"»": true, "›": true, ">": true, "older posts": true, "more results": true,
+}
+
+var pageNumberRegex = regexp.MustCompile(`^\d+$`)
+
+// detectPagination looks for a rel="next" link, then a control labelled
+// "Next" or "»", then a run of numbered page links. It returns nil when the
+// page has none of them.
+func detectPagination(doc *goquery.Document) *PaginationInfo {
+	if next := doc.Find("a[rel~='next']").First(); next.Length() > 0 {
+		return &PaginationInfo{Kind: "rel_next", NextSelector: generateSmartSelector(doc, next)}
+	}
+
+	var next *goquery.Selection
+	doc.Find("a, button, [role='button'], [role='link']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		label := strings.ToLower(strings.Join(strings.Fields(s.Text()), " "))
+		if ariaLabel, exists := s.Attr("aria-label"); exists && !nextPageLabels[label] {
+			label = strings.ToLower(strings.TrimSpace(ariaLabel))
+		}
+		if nextPageLabels[label] {
+			next = s
+			return false
+		}
+		return true
+	})
+	if next != nil {
+		return &PaginationInfo{Kind: "next_text", NextSelector: generateSmartSelector(doc, next)}
+	}
+
+	return detectNumberedPagination(doc)
+}
+
+// detectNumberedPagination finds two or more sibling links labelled with page
+// numbers. The next page is the link numbered one past the current page,
+// marked by aria-current or an "active"/"current" class.
+func detectNumberedPagination(doc *goquery.Document) *PaginationInfo {
+	var info *PaginationInfo
+	doc.Find("a").EachWithBreak(func(i int, link *goquery.Selection) bool {
+		if !pageNumberRegex.MatchString(strings.TrimSpace(link.Text())) {
+			return true
+		}
+
+		// Numbered links usually sit in their own <li>, so compare cousins
+		group := link.Parent()
+		if goquery.NodeName(group) == "li" {
+			group = group.Parent()
+		}
+		pages := group.Find("a, [aria-current='page'], .active, .current").FilterFunction(func(i int, s *goquery.Selection) bool {
+			return pageNumberRegex.MatchString(strings.TrimSpace(s.Text()))
+		})
+		if pages.Length() < 2 {
+			return true
+		}
+
+		info = &PaginationInfo{Kind: "numbered"}
+		current := pages.Filter("[aria-current='page'], .active, .current").First()
+		if current.Length() == 0 {
+			current = group.Find("[aria-current='page'], .active, .current").First()
+		}
+		if currentPage, err := strconv.Atoi(strings.TrimSpace(current.Text())); err == nil {
+			pages.Filter("a").EachWithBreak(func(i int, s *goquery.Selection) bool {
+				if strings.TrimSpace(s.Text()) == strconv.Itoa(currentPage+1) {
+					info.NextSelector = generateSmartSelector(doc, s)
+					return false
+				}
+				return true
+			})
+		}
+		return false
+	})
+	return info
+}
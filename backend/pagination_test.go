@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestDetectPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		kind     string
+		selector string
+	}{
+		{"rel next", `<a href="/p1">1</a><a href="/p2" id="older" rel="next">Next</a>`, "rel_next", "#older"},
+		{"next text", `<nav><a href="/a">About</a><a href="/page/3" class="pager-link">Next page</a></nav>`, "next_text", ""},
+		{"aria label", `<button aria-label="Next" id="more">→</button>`, "next_text", "#more"},
+		{"numbered", `<ul class="pages"><li><a href="?p=1">1</a></li><li class="active"><a href="?p=2">2</a></li><li><a id="p3" href="?p=3">3</a></li></ul>`, "numbered", "#p3"},
+		{"numbered without current", `<div><a href="?p=1">1</a><a href="?p=2">2</a></div>`, "numbered", ""},
+		{"none", `<a href="/docs">Docs</a><a href="/blog">Blog</a>`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyze(t, "<html><body>"+tt.html+"</body></html>")
+			pagination := analysis.Pagination
+			if tt.kind == "" {
+				if pagination != nil {
+					t.Errorf("pagination = %+v, want none", pagination)
+				}
+				return
+			}
+			if pagination == nil || pagination.Kind != tt.kind {
+				t.Fatalf("pagination = %+v, want kind %q", pagination, tt.kind)
+			}
+			if tt.selector != "" && pagination.NextSelector != tt.selector {
+				t.Errorf("next selector = %q, want %q", pagination.NextSelector, tt.selector)
+			}
+			if tt.kind != "numbered" && pagination.NextSelector == "" {
+				t.Error("next selector is empty")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ProductInfo is what an e-commerce page says about the product it shows
+type ProductInfo struct {
+	Name string `json:"name,omitempty"`
+	// Price is the amount with thousands separators removed and a "."
+	// decimal point, e.g. "1234.50"
+	Price string `json:"price,omitempty"`
+	// Currency is an ISO 4217 code such as "USD", empty when unknown
+	Currency string `json:"currency,omitempty"`
+	// Availability is "in_stock", "out_of_stock" or "unknown"
+	Availability string `json:"availability"`
+}
+
+const (
+	priceSelector        = "[itemprop='price'], #priceblock_ourprice, #price, .price, .a-price .a-offscreen, [data-testid*='price' i]"
+	productNameSelector  = "[itemprop='name'], #productTitle, .product-title, .product-name, h1"
+	availabilitySelector = "[itemprop='availability'], #availability, .availability, .stock, [class*='stock-status']"
+)
+
+// currencySymbols maps price prefixes and suffixes to ISO codes. Multi-
+// character symbols come first so "C$" isn't read as "$".
+var currencySymbols = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"C$", "CAD"}, {"CA$", "CAD"}, {"A$", "AUD"}, {"AU$", "AUD"},
+	{"NZ$", "NZD"}, {"R$", "BRL"}, {"HK$", "HKD"}, {"CHF", "CHF"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"},
+	{"₩", "KRW"}, {"₽", "RUB"}, {"₺", "TRY"}, {"zł", "PLN"}, {"kr", "SEK"},
+}
+
+var (
+	priceAmountRegex  = regexp.MustCompile(`\d[\d.,\s]*`)
+	isoCurrencyRegex  = regexp.MustCompile(`\b[A-Z]{3}\b`)
+	outOfStockRegex   = regexp.MustCompile(`(?i)out of stock|outofstock|sold out|currently unavailable|no longer available`)
+	inStockRegex      = regexp.MustCompile(`(?i)\bin stock\b|instock|available now|ships (?:today|in)|only \d+ left`)
+	addToCartSelector = "[id*='add-to-cart' i], [name='add-to-cart'], [class*='add-to-cart' i], button[name*='cart' i]"
+)
+
+// extractProductInfo reads the product name, first price and stock status.
+// It returns nil when the page shows no price.
+func extractProductInfo(doc *goquery.Document) *ProductInfo {
+	priceEl := doc.Find(priceSelector).FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return strings.TrimSpace(s.AttrOr("content", s.Text())) != ""
+	}).First()
+	if priceEl.Length() == 0 {
+		return nil
+	}
+
+	raw := strings.TrimSpace(priceEl.AttrOr("content", ""))
+	text := strings.Join(strings.Fields(priceEl.Text()), " ")
+	if raw == "" {
+		raw = text
+	}
+	info := &ProductInfo{Price: normalizePrice(raw)}
+
+	if currency, ok := doc.Find("[itemprop='priceCurrency']").First().Attr("content"); ok {
+		info.Currency = strings.ToUpper(strings.TrimSpace(currency))
+	} else {
+		info.Currency = currencyCode(text)
+	}
+
+	if name := doc.Find(productNameSelector).First(); name.Length() > 0 {
+		info.Name = strings.TrimSpace(name.AttrOr("content", strings.Join(strings.Fields(name.Text()), " ")))
+		if runes := []rune(info.Name); len(runes) > maxElementLabelLength {
+			info.Name = string(runes[:maxElementLabelLength])
+		}
+	}
+
+	info.Availability = productAvailability(doc)
+	return info
+}
+
+// normalizePrice pulls the amount out of raw, treating a final separator
+// followed by exactly two digits as the decimal point, so "$1,234.50" and
+// "1.234,50 €" both give "1234.50"
+func normalizePrice(raw string) string {
+	amount := strings.Join(strings.Fields(priceAmountRegex.FindString(raw)), "")
+	amount = strings.TrimRight(amount, ".,")
+	if amount == "" {
+		return ""
+	}
+
+	decimal := ""
+	if i := strings.LastIndexAny(amount, ".,"); i != -1 && len(amount)-i-1 == 2 {
+		amount, decimal = amount[:i], amount[i+1:]
+	}
+	amount = strings.NewReplacer(",", "", ".", "").Replace(amount)
+	if decimal != "" {
+		return amount + "." + decimal
+	}
+	return amount
+}
+
+// currencyCode finds an ISO code or known symbol in a price's text
+func currencyCode(text string) string {
+	if code := isoCurrencyRegex.FindString(text); code != "" {
+		return code
+	}
+	for _, c := range currencySymbols {
+		if strings.Contains(text, c.symbol) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// productAvailability checks schema.org availability first, then stock
+// wording near the product, then whether an add-to-cart control exists
+func productAvailability(doc *goquery.Document) string {
+	stock := doc.Find(availabilitySelector).First()
+	signal := stock.AttrOr("href", stock.AttrOr("content", "")) + " " + stock.Text()
+	switch {
+	case outOfStockRegex.MatchString(signal):
+		return "out_of_stock"
+	case inStockRegex.MatchString(signal):
+		return "in_stock"
+	}
+
+	body := doc.Find("body").Text()
+	switch {
+	case outOfStockRegex.MatchString(body):
+		return "out_of_stock"
+	case doc.Find(addToCartSelector).Length() > 0, inStockRegex.MatchString(body):
+		return "in_stock"
+	}
+	return "unknown"
+}
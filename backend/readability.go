@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// MainContentPayload is the reply to GET_MAIN_CONTENT
+type MainContentPayload struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// Text is the article body, one paragraph or heading per line
+	Text string `json:"text"`
+}
+
+// boilerplateSelector matches nodes removed before scoring
+const boilerplateSelector = "script, style, noscript, template, iframe, svg, nav, aside, footer, header, form, " +
+	"[role='navigation'], [role='banner'], [role='contentinfo'], [role='complementary'], [aria-hidden='true']"
+
+// unlikelyCandidateRegex matches class and id values of boilerplate blocks
+var unlikelyCandidateRegex = regexp.MustCompile(`(?i)\b(?:ad|ads|advert\w*|banner|breadcrumbs?|comments?|cookie\w*|footer|menu|nav\w*|popup|promo\w*|related|share|sidebar|social|sponsor\w*)\b`)
+
+// mainContentBlockSelector matches the blocks whose text makes up the output
+const mainContentBlockSelector = "h1, h2, h3, h4, h5, h6, p, li, pre, blockquote, td"
+
+// minParagraphLength is how long a paragraph must be to count towards a score
+const minParagraphLength = 25
+
+// handleGetMainContent replies with the main content of the page last sent
+// in PAGE_CONTENT
+func handleGetMainContent(session *Session) error {
+	content := session.getPageContent()
+	if content == nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "No page content received yet",
+				Code:    "NO_PAGE_CONTENT",
+			},
+		})
+	}
+
+	text, err := extractMainContent(content.HTML)
+	if err != nil {
+		session.logger.Warn("Failed to extract main content", "url", content.URL, "error", err)
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Failed to extract main content",
+				Code:    "ANALYSIS_ERROR",
+			},
+		})
+	}
+
+	return session.send(&Message{
+		Type: "MAIN_CONTENT",
+		Payload: MainContentPayload{
+			URL:   content.URL,
+			Title: content.Title,
+			Text:  text,
+		},
+	})
+}
+
+// extractMainContent returns the text of the article-like part of a page. It
+// strips boilerplate, scores each paragraph's parent and grandparent by the
+// length and comma count of the paragraph, favors <article> and <main>,
+// penalizes link-heavy blocks and returns the text of the best one.
+func extractMainContent(htmlContent string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %v", err)
+	}
+
+	doc.Find(boilerplateSelector).Remove()
+	doc.Find("div, section, span, ul, table").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return unlikelyCandidateRegex.MatchString(s.AttrOr("class", "") + " " + s.AttrOr("id", ""))
+	}).Remove()
+
+	type candidate struct {
+		node  *goquery.Selection
+		score float64
+	}
+	var candidates []*candidate
+	byNode := map[any]*candidate{} // keyed by the underlying *html.Node
+	addScore := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		c, ok := byNode[s.Get(0)]
+		if !ok {
+			c = &candidate{node: s}
+			byNode[s.Get(0)] = c
+			candidates = append(candidates, c)
+		}
+		c.score += score
+	}
+
+	doc.Find("article, main, [role='main']").Each(func(i int, s *goquery.Selection) {
+		addScore(s, 25)
+	})
+
+	doc.Find("p, pre, blockquote").Each(func(i int, p *goquery.Selection) {
+		text := strings.TrimSpace(p.Text())
+		if len(text) < minParagraphLength {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		addScore(p.Parent(), score)
+		addScore(p.Parent().Parent(), score/2)
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, c := range candidates {
+		if score := c.score * (1 - linkDensity(c.node)); score > bestScore {
+			best, bestScore = c.node, score
+		}
+	}
+
+	if best == nil {
+		best = doc.Find("body")
+	}
+	return blockText(best), nil
+}
+
+// linkDensity is the fraction of s's text that sits inside links
+func linkDensity(s *goquery.Selection) float64 {
+	textLength := len(strings.TrimSpace(s.Text()))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		linkLength += len(strings.TrimSpace(a.Text()))
+	})
+	return min(float64(linkLength)/float64(textLength), 1)
+}
+
+// blockText joins the text of s's outermost headings, paragraphs and list
+// items with newlines, or returns its collapsed text when it has none
+func blockText(s *goquery.Selection) string {
+	var lines []string
+	s.Find(mainContentBlockSelector).Each(func(i int, block *goquery.Selection) {
+		if block.ParentsUntilSelection(s).Filter(mainContentBlockSelector).Length() > 0 {
+			return
+		}
+		if line := strings.Join(strings.Fields(block.Text()), " "); line != "" {
+			lines = append(lines, line)
+		}
+	})
+
+	if len(lines) == 0 {
+		return strings.Join(strings.Fields(s.Text()), " ")
+	}
+	return strings.Join(lines, "\n")
+}
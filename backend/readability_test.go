@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const articlePage = `<html><head><script>var tracking = 1;</script></head><body>
+	<header><h1>Site name</h1></header>
+	<nav><a href="/">Home</a> <a href="/news">News</a></nav>
+	<div class="sidebar"><p>Subscribe to our newsletter, get deals, offers, and more every week.</p></div>
+	<article>
+		<h2>Go 1.23 released</h2>
+		<p>The Go team is happy to announce the release of Go 1.23, with range-over-func iterators, telemetry, and many improvements.</p>
+		<p>As always, the release maintains the Go 1 promise of compatibility, so almost all programs will keep working.</p>
+	</article>
+	<div class="comments"><p>Great post, thanks for sharing, this is very useful for my work!</p></div>
+	<footer><p>Copyright 2024, all rights reserved, terms and conditions apply.</p></footer>
+</body></html>`
+
+func TestExtractMainContent(t *testing.T) {
+	text, err := extractMainContent(articlePage)
+	if err != nil {
+		t.Fatalf("extractMainContent: %v", err)
+	}
+	for _, want := range []string{"Go 1.23 released", "range-over-func iterators", "Go 1 promise"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("main content is missing %q:\n%s", want, text)
+		}
+	}
+	for _, unwanted := range []string{"Site name", "Home", "newsletter", "Great post", "Copyright", "tracking"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("main content includes boilerplate %q:\n%s", unwanted, text)
+		}
+	}
+	if lines := strings.Split(text, "\n"); len(lines) != 3 {
+		t.Errorf("got %d lines, want one per heading and paragraph:\n%s", len(lines), text)
+	}
+}
+
+func TestGetMainContentNeedsPageContent(t *testing.T) {
+	session, client := newTestSession(t)
+	if err := handleGetMainContent(session); err != nil {
+		t.Fatalf("handleGetMainContent: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "NO_PAGE_CONTENT" {
+		t.Errorf("refusal = %+v", refusal)
+	}
+
+	session.setPageContent(&PageContentPayload{URL: "https://blog.example/go", Title: "Go blog", HTML: articlePage}, nil)
+	if err := handleGetMainContent(session); err != nil {
+		t.Fatalf("handleGetMainContent: %v", err)
+	}
+	var content MainContentPayload
+	readUntil(t, client, "MAIN_CONTENT", &content)
+	if content.URL != "https://blog.example/go" || !strings.Contains(content.Text, "Go 1.23 released") {
+		t.Errorf("MAIN_CONTENT = %+v", content)
+	}
+}
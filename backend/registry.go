@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxFinishedTasks bounds how many finished tasks the registry remembers
+// for GET_TASK_STATUS
+const maxFinishedTasks = 200
+
+// ConnectionRegistry tracks live sessions and hands out task IDs
+type ConnectionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	// finished holds the final status of recent tasks, oldest first
+	finished []finishedTask
+
+	sessionCounter int64
+	taskCounter    int64
+}
+
+// RegistryStats is a point-in-time snapshot of the registry
+type RegistryStats struct {
+	Connections int `json:"connections"`
+	ActiveTasks int `json:"activeTasks"`
+}
+
+var registry = NewConnectionRegistry()
+
+// NewConnectionRegistry creates an empty registry
+func NewConnectionRegistry() *ConnectionRegistry {
+	return &ConnectionRegistry{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Register creates a session for conn and starts tracking it
+func (r *ConnectionRegistry) Register(conn *websocket.Conn) *Session {
+	id := fmt.Sprintf("conn_%d", atomic.AddInt64(&r.sessionCounter, 1))
+	session := NewSession(id, conn)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[id] = session
+	return session
+}
+
+// Unregister stops tracking a session once its connection closes and marks
+// it closed
+func (r *ConnectionRegistry) Unregister(session *Session) {
+	session.markClosed()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, session.id)
+}
+
+// finishedTask is a task's final status and the owner that may query it
+type finishedTask struct {
+	owner  string
+	status TaskStatusPayload
+}
+
+// RecordFinished remembers a task's final status for its owner, dropping
+// the oldest once there are more than maxFinishedTasks
+func (r *ConnectionRegistry) RecordFinished(owner string, status TaskStatusPayload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = append(r.finished, finishedTask{owner: owner, status: status})
+	if excess := len(r.finished) - maxFinishedTasks; excess > 0 {
+		r.finished = r.finished[excess:]
+	}
+}
+
+// FinishedTask returns the final status of owner's task taskID, if it is
+// still remembered
+func (r *ConnectionRegistry) FinishedTask(owner, taskID string) (TaskStatusPayload, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.finished) - 1; i >= 0; i-- {
+		if f := r.finished[i]; f.owner == owner && f.status.TaskID == taskID {
+			return f.status, true
+		}
+	}
+	return TaskStatusPayload{}, false
+}
+
+// Sessions returns a snapshot of the live sessions
+func (r *ConnectionRegistry) Sessions() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// NextTaskID returns a process-wide unique task ID
+func (r *ConnectionRegistry) NextTaskID() string {
+	counter := atomic.AddInt64(&r.taskCounter, 1)
+	return fmt.Sprintf("task_%d_%d", time.Now().Unix(), counter)
+}
+
+// Stats counts live connections and their active tasks
+func (r *ConnectionRegistry) Stats() RegistryStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := RegistryStats{Connections: len(r.sessions)}
+	for _, session := range r.sessions {
+		stats.ActiveTasks += session.countTasks()
+	}
+	return stats
+}
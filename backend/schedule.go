@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // schedules name IANA zones, which slim images lack
+)
+
+// ScheduleTaskPayload registers Goal to run whenever Cron fires in Timezone
+// (an IANA name, UTC when empty). The option fields behave as in
+// ExecuteTaskPayload.
+type ScheduleTaskPayload struct {
+	Goal             string `json:"goal"`
+	Cron             string `json:"cron"`
+	Timezone         string `json:"timezone,omitempty"`
+	DefaultTimeoutMs int    `json:"defaultTimeoutMs,omitempty"`
+	MaxRetries       int    `json:"maxRetries,omitempty"`
+	RetryBackoffMs   int    `json:"retryBackoffMs,omitempty"`
+}
+
+// ScheduleInfo describes a registered schedule
+type ScheduleInfo struct {
+	ScheduleID string     `json:"scheduleId"`
+	Goal       string     `json:"goal"`
+	Cron       string     `json:"cron"`
+	Timezone   string     `json:"timezone"`
+	NextRun    time.Time  `json:"nextRun"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// ScheduleIDPayload is the payload of messages that refer to one schedule
+type ScheduleIDPayload struct {
+	ScheduleID string `json:"scheduleId"`
+}
+
+// SchedulesPayload answers LIST_SCHEDULES
+type SchedulesPayload struct {
+	Schedules []ScheduleInfo `json:"schedules"`
+}
+
+// maxSchedulesPerClient bounds how many schedules one client may register
+const maxSchedulesPerClient = 50
+
+// scheduledTask is a registered schedule and the timer for its next run
+type scheduledTask struct {
+	info    ScheduleInfo
+	payload ExecuteTaskPayload
+	cron    *cronSchedule
+	timer   *time.Timer
+	// owner is the Session.owner that created the schedule; only its
+	// sessions see the schedule and run it
+	owner string
+}
+
+// TaskScheduler runs goals on cron schedules. Schedules outlive the
+// connection that created them and keep firing across reconnects; they are
+// kept in memory only. A run whose owner has no connection is written to
+// the task log as queued and starts at the owner's next handshake.
+type TaskScheduler struct {
+	mu        sync.Mutex
+	schedules map[string]*scheduledTask
+	// queued holds the IDs of schedules that fired while disconnected, at
+	// most once each
+	queued  []string
+	counter atomic.Int64
+}
+
+var scheduler = NewTaskScheduler()
+
+// NewTaskScheduler creates a scheduler with no schedules
+func NewTaskScheduler() *TaskScheduler {
+	return &TaskScheduler{schedules: make(map[string]*scheduledTask)}
+}
+
+// Add registers goal for owner under a new ID and arms its first run
+func (s *TaskScheduler) Add(owner string, p ScheduleTaskPayload) (ScheduleInfo, error) {
+	goal := strings.TrimSpace(p.Goal)
+	if goal == "" {
+		return ScheduleInfo{}, fmt.Errorf("goal is required")
+	}
+	timezone := strings.TrimSpace(p.Timezone)
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return ScheduleInfo{}, fmt.Errorf("unknown timezone %q", timezone)
+	}
+	cron, err := parseCron(p.Cron, loc)
+	if err != nil {
+		return ScheduleInfo{}, err
+	}
+
+	now := time.Now()
+	next := cron.next(now)
+	if next.IsZero() {
+		return ScheduleInfo{}, fmt.Errorf("cron expression %q never fires", p.Cron)
+	}
+
+	task := &scheduledTask{
+		info: ScheduleInfo{
+			ScheduleID: fmt.Sprintf("schedule_%d", s.counter.Add(1)),
+			Goal:       goal,
+			Cron:       strings.TrimSpace(p.Cron),
+			Timezone:   timezone,
+			NextRun:    next,
+			CreatedAt:  now,
+		},
+		payload: ExecuteTaskPayload{
+			Goal:             goal,
+			DefaultTimeoutMs: p.DefaultTimeoutMs,
+			MaxRetries:       p.MaxRetries,
+			RetryBackoffMs:   p.RetryBackoffMs,
+		},
+		cron:  cron,
+		owner: owner,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owned := 0
+	for _, existing := range s.schedules {
+		if existing.owner == owner {
+			owned++
+		}
+	}
+	if owned >= maxSchedulesPerClient {
+		return ScheduleInfo{}, fmt.Errorf("at most %d schedules are allowed per client", maxSchedulesPerClient)
+	}
+	s.schedules[task.info.ScheduleID] = task
+	s.arm(task)
+	return task.info, nil
+}
+
+// Cancel removes one of owner's schedules, reporting whether it existed
+func (s *TaskScheduler) Cancel(owner, scheduleID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.schedules[scheduleID]
+	if !ok || task.owner != owner {
+		return false
+	}
+	task.timer.Stop()
+	delete(s.schedules, scheduleID)
+	return true
+}
+
+// List returns owner's schedules, soonest first
+func (s *TaskScheduler) List(owner string) []ScheduleInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := []ScheduleInfo{}
+	for _, task := range s.schedules {
+		if task.owner == owner {
+			infos = append(infos, task.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].NextRun.Before(infos[j].NextRun) })
+	return infos
+}
+
+// arm sets the timer for task's NextRun; s.mu must be held
+func (s *TaskScheduler) arm(task *scheduledTask) {
+	scheduleID := task.info.ScheduleID
+	task.timer = time.AfterFunc(time.Until(task.info.NextRun), func() {
+		s.fire(scheduleID)
+	})
+}
+
+// fire runs a schedule that is due and arms its next run
+func (s *TaskScheduler) fire(scheduleID string) {
+	s.mu.Lock()
+	task, ok := s.schedules[scheduleID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	task.info.LastRun = &now
+	task.info.NextRun = task.cron.next(now)
+	if task.info.NextRun.IsZero() {
+		delete(s.schedules, scheduleID)
+	} else {
+		s.arm(task)
+	}
+	payload, owner := task.payload, task.owner
+	s.mu.Unlock()
+
+	session := connectedSession(owner)
+	if session == nil {
+		s.queue(scheduleID, payload.Goal)
+		return
+	}
+	runScheduledGoal(session, scheduleID, payload)
+}
+
+// queue notes a run whose owner has no connection, once per schedule
+func (s *TaskScheduler) queue(scheduleID, goal string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.queued {
+		if id == scheduleID {
+			return
+		}
+	}
+	s.queued = append(s.queued, scheduleID)
+	slog.Info("No extension connected, queued scheduled goal", "schedule_id", scheduleID, "goal", goal)
+	if taskLog != nil {
+		taskLog.RecordQueued(scheduleID, goal)
+	}
+}
+
+// RunQueued starts the runs of session's owner queued while it was
+// disconnected, as a batch so they run one after another with default
+// options. They stay queued if the batch is refused, e.g. because one is
+// already running.
+func (s *TaskScheduler) RunQueued(session *Session) error {
+	owner := session.owner()
+
+	s.mu.Lock()
+	var ids, goals []string
+	for _, id := range s.queued {
+		if task, ok := s.schedules[id]; ok && task.owner == owner {
+			ids = append(ids, id)
+			goals = append(goals, task.payload.Goal)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(goals) == 0 {
+		return nil
+	}
+	session.logger.Info("Running goals queued while disconnected", "goals", len(goals))
+	started, err := beginBatch(session, ExecuteTasksPayload{Goals: goals})
+	if started {
+		s.dequeue(ids)
+	}
+	return err
+}
+
+// dequeue drops ids from the queued runs
+func (s *TaskScheduler) dequeue(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.queued[:0]
+	for _, id := range s.queued {
+		if !slices.Contains(ids, id) {
+			kept = append(kept, id)
+		}
+	}
+	s.queued = kept
+}
+
+// connectedSession returns a live session of owner, or nil when it has
+// none. Runs never move to another client's browser.
+func connectedSession(owner string) *Session {
+	for _, session := range registry.Sessions() {
+		if session.owner() == owner {
+			return session
+		}
+	}
+	return nil
+}
+
+// runScheduledGoal runs a due goal as if the extension had sent it. Runs
+// don't overlap other tasks: completions can't tell tasks apart, so a run
+// that finds the connection busy is skipped.
+func runScheduledGoal(session *Session, scheduleID string, payload ExecuteTaskPayload) {
+	logger := session.logger.With("schedule_id", scheduleID)
+	if session.busy() {
+		logger.Warn("Skipping scheduled goal, a task is already running", "goal", payload.Goal)
+		return
+	}
+	logger.Info("Running scheduled goal", "goal", payload.Goal)
+	if err := handleExecuteTaskWithCompletion(session, payload); err != nil {
+		logger.Error("Scheduled goal failed to start", "error", err)
+	}
+}
+
+func handleScheduleTask(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var schedulePayload ScheduleTaskPayload
+	if err := json.Unmarshal(payloadBytes, &schedulePayload); err != nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Invalid schedule payload format",
+				Code:    "TASK_FORMAT_ERROR",
+			},
+		})
+	}
+
+	info, err := scheduler.Add(session.owner(), schedulePayload)
+	if err != nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: err.Error(),
+				Code:    "INVALID_SCHEDULE",
+			},
+		})
+	}
+
+	session.logger.Info("Scheduled goal", "schedule_id", info.ScheduleID, "goal", info.Goal, "cron", info.Cron, "timezone", info.Timezone, "next_run", info.NextRun)
+	return session.send(&Message{
+		Type:    "SCHEDULE_CREATED",
+		Payload: info,
+	})
+}
+
+func handleListSchedules(session *Session) error {
+	return session.send(&Message{
+		Type:    "SCHEDULES",
+		Payload: SchedulesPayload{Schedules: scheduler.List(session.owner())},
+	})
+}
+
+func handleCancelSchedule(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var cancelPayload ScheduleIDPayload
+	if err := json.Unmarshal(payloadBytes, &cancelPayload); err != nil {
+		session.logger.Warn("Failed to parse cancel schedule payload", "error", err)
+		return nil
+	}
+
+	if !scheduler.Cancel(session.owner(), cancelPayload.ScheduleID) {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: fmt.Sprintf("Unknown schedule: %s", cancelPayload.ScheduleID),
+				Code:    "SCHEDULE_NOT_FOUND",
+			},
+		})
+	}
+
+	session.logger.Info("Schedule cancelled", "schedule_id", cancelPayload.ScheduleID)
+	return session.send(&Message{
+		Type:    "SCHEDULE_CANCELLED",
+		Payload: cancelPayload,
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// yearly never fires during a test
+const yearly = "0 0 1 1 *"
+
+func newTestScheduler(t *testing.T) *TaskScheduler {
+	t.Helper()
+	s := NewTaskScheduler()
+	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, task := range s.schedules {
+			task.timer.Stop()
+		}
+	})
+	return s
+}
+
+func TestSchedulesAreScopedToTheirOwner(t *testing.T) {
+	s := newTestScheduler(t)
+	mine, err := s.Add("profile-a", ScheduleTaskPayload{Goal: "check inbox", Cron: yearly})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := s.Add("profile-b", ScheduleTaskPayload{Goal: "check news", Cron: yearly}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if list := s.List("profile-a"); len(list) != 1 || list[0].ScheduleID != mine.ScheduleID {
+		t.Errorf("List(profile-a) = %+v, want only %s", list, mine.ScheduleID)
+	}
+	if s.Cancel("profile-b", mine.ScheduleID) {
+		t.Error("another client cancelled profile-a's schedule")
+	}
+	if !s.Cancel("profile-a", mine.ScheduleID) {
+		t.Error("owner could not cancel its schedule")
+	}
+	if list := s.List("profile-a"); len(list) != 0 {
+		t.Errorf("List after cancel = %+v", list)
+	}
+}
+
+func TestAddValidatesPayload(t *testing.T) {
+	s := newTestScheduler(t)
+	tests := []struct {
+		name    string
+		payload ScheduleTaskPayload
+		wantErr string
+	}{
+		{"missing goal", ScheduleTaskPayload{Cron: yearly}, "goal is required"},
+		{"bad cron", ScheduleTaskPayload{Goal: "x", Cron: "* * *"}, "needs 5 fields"},
+		{"bad timezone", ScheduleTaskPayload{Goal: "x", Cron: yearly, Timezone: "Mars/Olympus"}, "unknown timezone"},
+		{"never fires", ScheduleTaskPayload{Goal: "x", Cron: "0 0 30 2 *"}, "never fires"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Add("profile", tt.payload); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Add error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddLimitsSchedulesPerClient(t *testing.T) {
+	s := newTestScheduler(t)
+	for i := 0; i < maxSchedulesPerClient; i++ {
+		if _, err := s.Add("busy", ScheduleTaskPayload{Goal: "refresh", Cron: yearly}); err != nil {
+			t.Fatalf("Add %d: %v", i, err)
+		}
+	}
+	if _, err := s.Add("busy", ScheduleTaskPayload{Goal: "refresh", Cron: yearly}); err == nil {
+		t.Error("Add beyond the limit succeeded")
+	}
+	if _, err := s.Add("other", ScheduleTaskPayload{Goal: "refresh", Cron: yearly}); err != nil {
+		t.Errorf("limit applied to another client: %v", err)
+	}
+}
+
+func TestConnectedSessionMatchesOwnerOnly(t *testing.T) {
+	client := dialBackend(t)
+	sendJSON(t, client, "HANDSHAKE", HandshakePayload{Client: "extension", ClientID: "profile-1"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for connectedSession("profile-1") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("session with client ID profile-1 never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if session := connectedSession("profile-2"); session != nil {
+		t.Errorf("profile-2 has no connection but got session %s", session.id)
+	}
+}
+
+func TestRunQueuedKeepsRunsWhenBatchIsRefused(t *testing.T) {
+	s := newTestScheduler(t)
+	session, client := newTestSession(t)
+	info, err := s.Add(session.owner(), ScheduleTaskPayload{Goal: "go to example.com", Cron: yearly})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s.queue(info.ScheduleID, info.Goal)
+
+	session.startBatch(&taskBatch{})
+	if err := s.RunQueued(session); err != nil {
+		t.Fatalf("RunQueued: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "BATCH_IN_PROGRESS" {
+		t.Errorf("refusal code = %q", refusal.Code)
+	}
+	if !slices.Contains(s.queued, info.ScheduleID) {
+		t.Fatal("queued run was dropped when the batch was refused")
+	}
+
+	session.endBatch()
+	if err := s.RunQueued(session); err != nil {
+		t.Fatalf("RunQueued: %v", err)
+	}
+	var command CommandPayload
+	readUntil(t, client, "COMMAND", &command)
+	if command.Action != "navigate" || !strings.Contains(command.URL, "example.com") {
+		t.Errorf("queued goal ran as %+v", command)
+	}
+	if len(s.queued) != 0 {
+		t.Errorf("queue after a started batch = %v", s.queued)
+	}
+}
+
+func TestRunQueuedLeavesOtherClientsRuns(t *testing.T) {
+	s := newTestScheduler(t)
+	session, _ := newTestSession(t)
+	info, err := s.Add("someone-else", ScheduleTaskPayload{Goal: "go to example.com", Cron: yearly})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s.queue(info.ScheduleID, info.Goal)
+
+	if err := s.RunQueued(session); err != nil {
+		t.Fatalf("RunQueued: %v", err)
+	}
+	if session.busy() || !slices.Contains(s.queued, info.ScheduleID) {
+		t.Error("another client's queued run was started on this session")
+	}
+}
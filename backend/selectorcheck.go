@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// selectorWordRegex picks the words out of a selector that might name an
+// element, such as "email" in "input[name='email']"
+var selectorWordRegex = regexp.MustCompile(`[A-Za-z][A-Za-z0-9]{2,}`)
+
+// selectorNoiseWords are tag, attribute and pseudo-class names that say
+// nothing about which element a selector means
+var selectorNoiseWords = map[string]bool{
+	"input": true, "button": true, "textarea": true, "select": true, "form": true, "div": true, "span": true,
+	"type": true, "name": true, "class": true, "aria": true, "label": true, "role": true, "data": true,
+	"placeholder": true, "value": true, "text": true, "nth": true, "child": true, "first": true, "last": true,
+	"not": true, "contains": true, "href": true, "title": true, "main": true,
+}
+
+// validateSelectors checks the click and input selectors of an LLM plan
+// against the HTML of the page it was made for. A selector that matches
+// nothing is swapped for the closest generateSmartSelector candidate, or
+// for a search by label when the step has one; steps with neither are
+// dropped. Checking stops at the first step that may leave the page, since
+// later steps run against a page we haven't seen; steps inside an iframe
+// or a shadow root are never checked, as their selectors are scoped.
+func validateSelectors(logger *slog.Logger, commands []CommandPayload, html string) []CommandPayload {
+	if html == "" {
+		return commands
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return commands
+	}
+
+	validated := make([]CommandPayload, 0, len(commands))
+	checking := true
+	for i, cmd := range commands {
+		if checking && (cmd.Action == "click" || cmd.Action == "input") && cmd.Selector != "" && cmd.SelectorType != "xpath" && cmd.FrameSelector == "" && len(cmd.ShadowPath) == 0 && doc.Find(cmd.Selector).Length() == 0 {
+			if repaired := closestSelector(doc, cmd); repaired != "" {
+				logger.Info("Repaired LLM selector that matches nothing", "step", i, "selector", cmd.Selector, "repaired", repaired)
+				cmd.Selector = repaired
+			} else if cmd.Action == "click" && cmd.Text != "" {
+				logger.Info("LLM selector matches nothing, clicking by label", "step", i, "selector", cmd.Selector, "text", cmd.Text)
+				cmd.Selector = clickableSelector
+			} else {
+				logger.Warn("Dropping step whose selector matches nothing", "step", i, "action", cmd.Action, "selector", cmd.Selector)
+				continue
+			}
+		}
+
+		if changesPage(cmd.Action) || cmd.Action == "click" || cmd.Action == "press_key" || cmd.Action == "key" {
+			checking = false
+		}
+		validated = append(validated, cmd)
+	}
+
+	if len(validated) == 0 {
+		return commands
+	}
+	return validated
+}
+
+// closestSelector finds the element cmd most plausibly meant: among elements
+// of the right kind, the one sharing the most words with the selector, with
+// an exact label match for clicks counting most. It returns a unique
+// selector for it, or "" when nothing shares a word.
+func closestSelector(doc *goquery.Document, cmd CommandPayload) string {
+	var words []string
+	for _, word := range selectorWordRegex.FindAllString(cmd.Selector, -1) {
+		if word = strings.ToLower(word); !selectorNoiseWords[word] {
+			words = append(words, word)
+		}
+	}
+	label := strings.ToLower(strings.TrimSpace(cmd.Text))
+
+	candidates := "input:not([type='hidden']), textarea, [contenteditable='true'], [role='searchbox'], [role='textbox']"
+	if cmd.Action == "click" {
+		candidates = clickableSelector
+		words = append(words, strings.Fields(label)...)
+	}
+
+	var best *goquery.Selection
+	bestScore := 0
+	doc.Find(candidates).Each(func(_ int, s *goquery.Selection) {
+		haystack := strings.ToLower(strings.Join([]string{
+			s.AttrOr("id", ""), s.AttrOr("name", ""), s.AttrOr("class", ""), s.AttrOr("placeholder", ""),
+			s.AttrOr("aria-label", ""), s.AttrOr("type", ""), s.AttrOr("value", ""), s.AttrOr("title", ""),
+			elementLabel(s),
+		}, " "))
+
+		score := 0
+		for _, word := range words {
+			if strings.Contains(haystack, word) {
+				score++
+			}
+		}
+		if cmd.Action == "click" && label != "" && strings.ToLower(elementLabel(s)) == label {
+			score += len(words) + 1
+		}
+		if score > bestScore {
+			best, bestScore = s, score
+		}
+	})
+
+	if best == nil {
+		return ""
+	}
+	return generateSmartSelector(doc, best)
+}
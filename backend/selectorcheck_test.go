@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const signupPage = `<html><body>
+	<nav><a href="/pricing">Pricing</a> <a href="/docs">Docs</a></nav>
+	<form>
+		<input id="email-address" name="email" type="email">
+		<input id="password" name="password" type="password">
+		<button id="signup" type="submit">Sign up</button>
+	</form>
+</body></html>`
+
+func TestValidateSelectorsRepairsAndDrops(t *testing.T) {
+	commands := validateSelectors(slog.Default(), []CommandPayload{
+		{Action: "input", Selector: "#email", Text: "a@example.com"},
+		{Action: "input", Selector: "#password", Text: "hunter2"},
+		{Action: "input", Selector: "#zzzqqq", Text: "lost"},
+		{Action: "click", Selector: "#signup"},
+		{Action: "click", Selector: "#welcome-tour"},
+	}, signupPage)
+
+	selectors := []string{}
+	for _, command := range commands {
+		selectors = append(selectors, command.Selector)
+	}
+	// After the click the page may change, so #welcome-tour isn't checked
+	want := []string{"#email-address", "#password", "#signup", "#welcome-tour"}
+	if strings.Join(selectors, " ") != strings.Join(want, " ") {
+		t.Errorf("selectors = %v, want %v", selectors, want)
+	}
+}
+
+func TestValidateSelectorsFallsBackToClickLabel(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(signupPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := validateSelectors(slog.Default(), []CommandPayload{
+		{Action: "click", Selector: "#pricing-link", Text: "Pricing"},
+	}, signupPage)
+	if len(commands) != 1 || doc.Find(commands[0].Selector).Text() != "Pricing" {
+		t.Errorf("commands = %+v, want a selector for the Pricing link", commands)
+	}
+
+	commands = validateSelectors(slog.Default(), []CommandPayload{
+		{Action: "click", Selector: ".zzzqqq", Text: "Wombat"},
+	}, signupPage)
+	if len(commands) != 1 || commands[0].Selector != clickableSelector || commands[0].Text != "Wombat" {
+		t.Errorf("commands = %+v, want a click by label", commands)
+	}
+}
+
+func TestValidateSelectorsLeavesUncheckableStepsAlone(t *testing.T) {
+	original := []CommandPayload{
+		{Action: "input", Selector: "#search", Text: "go", FrameSelector: "iframe#app"},
+		{Action: "click", Selector: "//button[1]", SelectorType: "xpath"},
+	}
+	if commands := validateSelectors(slog.Default(), original, signupPage); len(commands) != 2 {
+		t.Errorf("commands = %+v, want both steps unchanged", commands)
+	}
+	if commands := validateSelectors(slog.Default(), original[:1], ""); commands[0].Selector != "#search" {
+		t.Errorf("without page HTML, commands = %+v", commands)
+	}
+
+	// A plan that would be dropped entirely is kept as it was
+	lost := []CommandPayload{{Action: "input", Selector: "#zzzqqq", Text: "lost"}}
+	if commands := validateSelectors(slog.Default(), lost, signupPage); len(commands) != 1 || commands[0].Selector != "#zzzqqq" {
+		t.Errorf("commands = %+v, want the original plan", commands)
+	}
+}
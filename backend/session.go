@@ -0,0 +1,301 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session holds the state owned by a single WebSocket connection
+type Session struct {
+	id     string
+	conn   *safeConn
+	logger *slog.Logger // tagged with conn_id
+
+	mu sync.RWMutex
+	// clientID is the extension's stable ID from HANDSHAKE, which outlives
+	// the connection
+	clientID    string
+	activeTasks map[string]*TaskState
+	pages       pageCache
+	// conversationHistory holds recent goals and the plans made for them,
+	// oldest first, so follow-up goals can refer back to them
+	conversationHistory []llm.ConversationTurn
+	// batch is the EXECUTE_TASKS run in progress, if any
+	batch *taskBatch
+	// planning is set while a goal is being parsed, which can outlast the
+	// read loop's handling of it when page content is requested
+	planning bool
+	// closed is set once the connection is gone and the session unregistered
+	closed bool
+	// pageRequests maps REQUEST_PAGE_CONTENT IDs to the goals awaiting them
+	pageRequests       map[string]chan *llm.PageContext
+	pageRequestCounter atomic.Int64
+
+	// stepMu serializes step transitions between the read loop and timeouts
+	stepMu sync.Mutex
+}
+
+// NewSession creates a session for a freshly upgraded connection
+func NewSession(id string, conn *websocket.Conn) *Session {
+	return &Session{
+		id:           id,
+		conn:         newSafeConn(conn),
+		logger:       slog.Default().With("conn_id", id),
+		activeTasks:  make(map[string]*TaskState),
+		pageRequests: make(map[string]chan *llm.PageContext),
+	}
+}
+
+// send writes a message to the session's connection
+func (s *Session) send(message *Message) error {
+	return sendMessage(s.logger, s.conn, message)
+}
+
+// getTask returns the active task with the given ID, if any
+func (s *Session) getTask(taskID string) (*TaskState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	task, ok := s.activeTasks[taskID]
+	return task, ok
+}
+
+// putTask registers a task in the session's active task set, reporting
+// false once the connection has closed, as nothing would cancel it then
+func (s *Session) putTask(task *TaskState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.activeTasks[task.TaskID] = task
+	return true
+}
+
+// deleteTask removes a task from the session's active task set
+func (s *Session) deleteTask(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activeTasks, taskID)
+}
+
+// cancelTask marks taskState cancelled and removes it from the active set,
+// reporting false when it had already finished or been cancelled. It holds
+// stepMu, so a step completing or timing out can't interleave with it.
+func (s *Session) cancelTask(taskState *TaskState) bool {
+	s.stepMu.Lock()
+	defer s.stepMu.Unlock()
+
+	if _, ok := s.getTask(taskState.TaskID); !ok || isFinalStatus(taskState.Status) {
+		return false
+	}
+	// Stopping the timer drops a dispatch waiting out a retry backoff or
+	// navigation delay, and removing the task means a COMMAND_COMPLETE for
+	// the in-flight step finds nothing to advance and is ignored
+	taskState.clearStepTimeout()
+	taskState.transition("cancelled")
+	s.deleteTask(taskState.TaskID)
+	return true
+}
+
+// countTasks returns the number of active tasks in the session
+func (s *Session) countTasks() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.activeTasks)
+}
+
+// findRunningTask returns the first executing task, promoting a pending
+// task to executing if none is running yet
+func (s *Session) findRunningTask() *TaskState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range s.activeTasks {
+		if task.Status == "executing" {
+			return task
+		}
+	}
+
+	for _, task := range s.activeTasks {
+		if task.Status == "pending" {
+			task.transition("executing")
+			return task
+		}
+	}
+
+	return nil
+}
+
+// getPageContext returns the most recent page context sent by the client
+func (s *Session) getPageContext() *llm.PageContext {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if page := s.pages.latest(time.Now()); page != nil {
+		return page.context
+	}
+	return nil
+}
+
+// getPageContextFor returns the context of the page at pageURL, or the
+// most recent one when pageURL is empty. It is nil when the page hasn't
+// been sent or its content has expired.
+func (s *Session) getPageContextFor(pageURL string) *llm.PageContext {
+	if pageURL == "" {
+		return s.getPageContext()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if page := s.pages.get(pageURL, time.Now()); page != nil {
+		return page.context
+	}
+	return nil
+}
+
+// getPageContent returns the most recent raw PAGE_CONTENT payload
+func (s *Session) getPageContent() *PageContentPayload {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if page := s.pages.latest(time.Now()); page != nil {
+		return page.content
+	}
+	return nil
+}
+
+// maxConversationTurns caps how many turns a session remembers
+const maxConversationTurns = 20
+
+// getConversationHistory returns a copy of the session's conversation history
+func (s *Session) getConversationHistory() []llm.ConversationTurn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]llm.ConversationTurn(nil), s.conversationHistory...)
+}
+
+// addConversationTurns appends turns, dropping the oldest beyond
+// maxConversationTurns
+func (s *Session) addConversationTurns(turns ...llm.ConversationTurn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversationHistory = append(s.conversationHistory, turns...)
+	if excess := len(s.conversationHistory) - maxConversationTurns; excess > 0 {
+		s.conversationHistory = append([]llm.ConversationTurn(nil), s.conversationHistory[excess:]...)
+	}
+}
+
+// clearConversationHistory forgets all earlier turns
+func (s *Session) clearConversationHistory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversationHistory = nil
+}
+
+// setClientID records the client ID the extension sent in HANDSHAKE
+func (s *Session) setClientID(clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientID = clientID
+}
+
+// owner identifies whose schedules the session runs: the client ID, so
+// they come back after a reconnect, or the connection ID for clients that
+// don't send one
+func (s *Session) owner() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.clientID != "" {
+		return s.clientID
+	}
+	return s.id
+}
+
+// busy reports whether a task or batch is running or a goal is being
+// planned
+func (s *Session) busy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.busyLocked()
+}
+
+// busyLocked is busy for callers holding mu. COMMAND_COMPLETE can't tell
+// tasks apart, so a session runs one thing at a time.
+func (s *Session) busyLocked() bool {
+	return len(s.activeTasks) > 0 || s.batch != nil || s.planning
+}
+
+// startPlanning reserves the session for planning a goal, unless it is busy
+func (s *Session) startPlanning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busyLocked() {
+		return false
+	}
+	s.planning = true
+	return true
+}
+
+// startBatchPlanning reserves the session for planning the next goal of
+// batch, unless batch is no longer running, the connection has closed or a
+// goal is already being planned
+func (s *Session) startBatchPlanning(batch *taskBatch) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.batch != batch || s.closed || s.planning {
+		return false
+	}
+	s.planning = true
+	return true
+}
+
+// endPlanning releases the reservation taken by startPlanning
+func (s *Session) endPlanning() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.planning = false
+}
+
+// startBatch makes batch the session's running batch, unless the session
+// is busy
+func (s *Session) startBatch(batch *taskBatch) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busyLocked() {
+		return false
+	}
+	s.batch = batch
+	return true
+}
+
+// endBatch clears the running batch
+func (s *Session) endBatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = nil
+}
+
+// markClosed records that the connection is gone
+func (s *Session) markClosed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// isClosed reports whether the connection is gone
+func (s *Session) isClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+// setPageContent caches a PAGE_CONTENT payload and the page context
+// derived from it for goal parsing, as the most recent page
+func (s *Session) setPageContent(content *PageContentPayload, ctx *llm.PageContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages.put(content, ctx, time.Now())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSessionTaskSetIsSafeForConcurrentUse(t *testing.T) {
+	session := NewSession("test", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task := &TaskState{TaskID: fmt.Sprintf("task_%d", i), Status: "pending"}
+			session.putTask(task)
+			if got, ok := session.getTask(task.TaskID); !ok || got != task {
+				t.Errorf("getTask(%s) = %v, %v", task.TaskID, got, ok)
+			}
+			session.countTasks()
+			if i%2 == 0 {
+				session.deleteTask(task.TaskID)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := session.countTasks(); n != 8 {
+		t.Errorf("countTasks = %d, want 8", n)
+	}
+}
+
+func TestTasksAreScopedToTheirSession(t *testing.T) {
+	owner, ownerClient := newTestSession(t)
+	other, otherClient := newTestSession(t)
+	task := startTestTask(t, owner, ExecuteTaskPayload{Goal: "click twice"},
+		CommandPayload{Action: "click", Selector: "#a"},
+		CommandPayload{Action: "click", Selector: "#b"},
+	)
+	readUntil(t, ownerClient, "COMMAND", nil)
+
+	// Another connection's completion doesn't advance the task
+	if err := handleCommandComplete(other, CommandResult{Action: "click", Success: true}); err != nil {
+		t.Fatalf("handleCommandComplete: %v", err)
+	}
+	if task.CurrentStep != 0 {
+		t.Errorf("task advanced to step %d from another session", task.CurrentStep)
+	}
+
+	// Nor can it see or cancel the task
+	if err := handleCancelTask(other, TaskIDPayload{TaskID: task.TaskID}); err != nil {
+		t.Fatalf("handleCancelTask: %v", err)
+	}
+	var refusal ErrorPayload
+	readUntil(t, otherClient, "ERROR", &refusal)
+	if refusal.Code != "TASK_NOT_FOUND" || task.Status != "executing" {
+		t.Errorf("cancel from another session: %+v, task status %q", refusal, task.Status)
+	}
+	if owner.countTasks() != 1 || other.countTasks() != 0 {
+		t.Errorf("tasks: owner %d, other %d", owner.countTasks(), other.countTasks())
+	}
+}
+
+func TestRegistryForgetsOldestFinishedTasks(t *testing.T) {
+	r := NewConnectionRegistry()
+	for i := 0; i <= maxFinishedTasks; i++ {
+		r.RecordFinished("profile_1", TaskStatusPayload{TaskID: fmt.Sprintf("task_%d", i), Status: "completed"})
+	}
+	if _, ok := r.FinishedTask("profile_1", "task_0"); ok {
+		t.Error("oldest finished task is still remembered")
+	}
+	if status, ok := r.FinishedTask("profile_1", "task_1"); !ok || status.Status != "completed" {
+		t.Errorf("task_1 = %+v, %v", status, ok)
+	}
+}
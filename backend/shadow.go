@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"cortex-browser/backend/llm"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// shadowRootSelector matches declarative shadow roots, which is how the
+// extension serializes open shadow roots into the page HTML
+const shadowRootSelector = "template[shadowrootmode], template[shadowroot]"
+
+// shadowHostPath returns the selectors of the shadow hosts enclosing s,
+// outermost first, or nil for an element in the light DOM. Each selector is
+// matched inside the shadow root of the host before it, or in the document
+// for the first.
+func shadowHostPath(doc *goquery.Document, s *goquery.Selection) []string {
+	roots := s.ParentsFiltered(shadowRootSelector)
+	if roots.Length() == 0 {
+		return nil
+	}
+
+	// ParentsFiltered lists the innermost root first
+	path := make([]string, roots.Length())
+	roots.Each(func(i int, root *goquery.Selection) {
+		host := root.Parent()
+		if outer := host.ParentsFiltered(shadowRootSelector).First(); outer.Length() > 0 {
+			path[len(path)-1-i] = shadowScopedSelector(outer, host)
+		} else {
+			path[len(path)-1-i] = generateSmartSelector(doc, host)
+		}
+	})
+	return path
+}
+
+// shadowScopedSelector builds a selector for s that is matched with
+// querySelector on the shadow root serialized as root. Attribute selectors
+// are used when unique within the root, else a tag:nth-of-type path from
+// the root down.
+func shadowScopedSelector(root, s *goquery.Selection) string {
+	if selector, err := llm.SanitizeSelector(buildSmartSelector(s)); err == nil && selector != "" && root.Find(selector).Length() == 1 {
+		return selector
+	}
+
+	var steps []string
+	for node := s; node.Length() > 0 && !node.IsSelection(root); node = node.Parent() {
+		tagName := goquery.NodeName(node)
+		step := tagName
+		if node.Siblings().Filter(tagName).Length() > 0 {
+			step = fmt.Sprintf("%s:nth-of-type(%d)", tagName, node.PrevAll().Filter(tagName).Length()+1)
+		}
+		steps = append([]string{step}, steps...)
+	}
+	return strings.Join(steps, " > ")
+}
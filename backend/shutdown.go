@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shuttingDown makes new EXECUTE_TASK requests fail once shutdown has begun
+var shuttingDown atomic.Bool
+
+// ServerShuttingDownPayload warns clients that the server is going away
+type ServerShuttingDownPayload struct {
+	Message       string `json:"message"`
+	GracePeriodMs int64  `json:"gracePeriodMs"`
+}
+
+// gracefulShutdown stops accepting connections, tells clients, gives active
+// tasks up to grace to finish, then cancels whatever is left and closes
+// every connection
+func gracefulShutdown(server *http.Server, grace time.Duration) {
+	shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	for _, session := range registry.Sessions() {
+		if err := session.send(&Message{
+			Type: "SERVER_SHUTTING_DOWN",
+			Payload: ServerShuttingDownPayload{
+				Message:       "Server is shutting down",
+				GracePeriodMs: grace.Milliseconds(),
+			},
+		}); err != nil {
+			session.logger.Warn("Failed to send shutdown notice", "error", err)
+		}
+	}
+
+	// WebSocket connections are hijacked, so Shutdown only closes the
+	// listener and idle HTTP connections here
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("HTTP server shutdown incomplete", "error", err)
+	}
+
+	waitForActiveTasks(ctx)
+
+	for _, session := range registry.Sessions() {
+		session.cancelAllTasks("Server shutting down")
+		session.close()
+	}
+	slog.Info("Shutdown complete")
+}
+
+// waitForActiveTasks polls until no session has an active task or ctx ends
+func waitForActiveTasks(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		active := registry.Stats().ActiveTasks
+		if active == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			slog.Warn("Grace period over, cancelling remaining tasks", "active_tasks", active)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cancelAllTasks cancels every active task and, while the connection is
+// open, tells the client about each
+func (s *Session) cancelAllTasks(reason string) {
+	s.mu.RLock()
+	tasks := make([]*TaskState, 0, len(s.activeTasks))
+	for _, task := range s.activeTasks {
+		tasks = append(tasks, task)
+	}
+	s.mu.RUnlock()
+
+	for _, taskState := range tasks {
+		// The client may have cancelled it, or it may have finished, since
+		// we collected it
+		if !s.cancelTask(taskState) {
+			continue
+		}
+		taskState.logger.Info("Task cancelled", "reason", reason, "step", taskState.CurrentStep)
+		if s.isClosed() {
+			continue
+		}
+
+		if err := s.send(&Message{
+			Type: "TASK_CANCELLED",
+			Payload: TaskCancelledPayload{
+				TaskID:  taskState.TaskID,
+				Message: reason,
+				Step:    taskState.CurrentStep,
+			},
+		}); err != nil {
+			s.logger.Warn("Failed to send task cancellation", "task_id", taskState.TaskID, "error", err)
+		}
+	}
+}
+
+// close sends a going-away close frame and closes the connection, which
+// ends the session's read loop
+func (s *Session) close() {
+	s.conn.closeWithReason(websocket.CloseGoingAway, "server shutting down")
+}
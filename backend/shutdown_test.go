@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestGracefulShutdownCancelsUnfinishedTasks(t *testing.T) {
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	server, client := serveBackend(t)
+	sendJSON(t, client, "EXECUTE_TASK", ExecuteTaskPayload{Goal: "go to example.com"})
+	readUntil(t, client, "COMMAND", nil)
+
+	done := make(chan struct{})
+	go func() {
+		gracefulShutdown(server.Config, 200*time.Millisecond)
+		close(done)
+	}()
+
+	var notice ServerShuttingDownPayload
+	readUntil(t, client, "SERVER_SHUTTING_DOWN", &notice)
+	if notice.GracePeriodMs != 200 {
+		t.Errorf("grace period = %dms", notice.GracePeriodMs)
+	}
+
+	// New goals are refused during the grace period
+	sendJSON(t, client, "EXECUTE_TASK", ExecuteTaskPayload{Goal: "go to example.org"})
+	var refusal ErrorPayload
+	readUntil(t, client, "ERROR", &refusal)
+	if refusal.Code != "SERVER_SHUTTING_DOWN" {
+		t.Errorf("goal during shutdown got %+v", refusal)
+	}
+
+	var cancelled TaskCancelledPayload
+	readUntil(t, client, "TASK_CANCELLED", &cancelled)
+	if cancelled.Message != "Server shutting down" {
+		t.Errorf("TASK_CANCELLED = %+v", cancelled)
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after shutdown = %v, want a going-away close", err)
+	}
+	<-done
+}
+
+func TestGracefulShutdownWaitsForTasksToFinish(t *testing.T) {
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	server, client := serveBackend(t)
+	sendJSON(t, client, "EXECUTE_TASK", ExecuteTaskPayload{Goal: "go to example.com"})
+	readUntil(t, client, "COMMAND", nil)
+
+	go gracefulShutdown(server.Config, 5*time.Second)
+	readUntil(t, client, "SERVER_SHUTTING_DOWN", nil)
+	sendJSON(t, client, "COMMAND_COMPLETE", CommandResult{Action: "navigate", Success: true})
+
+	var complete TaskCompletePayload
+	readUntil(t, client, "TASK_COMPLETE", &complete)
+	if counts := countMessages(client, 300*time.Millisecond); counts["TASK_CANCELLED"] != 0 {
+		t.Errorf("finished task was cancelled: %v", counts)
+	}
+}
+
+func TestDisconnectCancelsTasks(t *testing.T) {
+	_, client := serveBackend(t)
+	sendJSON(t, client, "EXECUTE_TASK", ExecuteTaskPayload{Goal: "go to example.com"})
+	readUntil(t, client, "COMMAND", nil)
+	var session *Session
+	for _, s := range registry.Sessions() {
+		if s.countTasks() > 0 {
+			session = s
+		}
+	}
+	if session == nil {
+		t.Fatal("task is not tracked")
+	}
+
+	// Unregistered sessions drop out of the registry's count, so watch the
+	// session itself
+	client.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for session.countTasks() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tasks still active after the connection closed", session.countTasks())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClosedSessionStartsNoTasks(t *testing.T) {
+	session, _ := newTestSession(t)
+	session.markClosed()
+
+	task, err := startTask(session, ExecuteTaskPayload{Goal: "click"}, &CommandSequence{Commands: []CommandPayload{{Action: "click", Selector: "#a"}}}, nil)
+	if task != nil || err != nil || session.countTasks() != 0 {
+		t.Errorf("startTask on a closed session = %v, %v with %d tasks", task, err, session.countTasks())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var sitesConfigFlag = flag.String("sites-config", "", `JSON file of extra site aliases, e.g. {"amazon": "https://amazon.com"}`)
+
+// defaultSiteAliases map bare site names in goals to URLs
+var defaultSiteAliases = map[string]string{
+	"google":    "https://google.com",
+	"github":    "https://github.com",
+	"youtube":   "https://youtube.com",
+	"facebook":  "https://facebook.com",
+	"twitter":   "https://twitter.com",
+	"linkedin":  "https://linkedin.com",
+	"amazon":    "https://amazon.com",
+	"ebay":      "https://ebay.com",
+	"reddit":    "https://reddit.com",
+	"wikipedia": "https://wikipedia.org",
+}
+
+var siteAliases = defaultSiteAliases
+
+// loadSiteAliases reads a JSON object of alias → URL and merges it over the
+// defaults; file entries win on conflict
+func loadSiteAliases(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read sites config: %w", err)
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("parse sites config %s: %w", path, err)
+	}
+
+	return mergeSiteAliases(defaultSiteAliases, custom), nil
+}
+
+func mergeSiteAliases(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	for alias, url := range base {
+		merged[alias] = url
+	}
+	for alias, url := range overrides {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if alias == "" || url == "" {
+			continue
+		}
+		merged[alias] = withScheme(url)
+	}
+	return merged
+}
+
+// lookupSiteAlias finds the longest alias mentioned as a whole word (or
+// phrase) in goal, so "google maps" can win over "google"
+func lookupSiteAlias(goal string) (string, bool) {
+	padded := " " + strings.Join(strings.FieldsFunc(strings.ToLower(goal), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-')
+	}), " ") + " "
+
+	aliases := make([]string, 0, len(siteAliases))
+	for alias := range siteAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		if len(aliases[i]) != len(aliases[j]) {
+			return len(aliases[i]) > len(aliases[j])
+		}
+		return aliases[i] < aliases[j]
+	})
+
+	for _, alias := range aliases {
+		if strings.Contains(padded, " "+alias+" ") {
+			return siteAliases[alias], true
+		}
+	}
+	return "", false
+}
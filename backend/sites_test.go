@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSiteAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.json")
+	config := `{"Jira": "mycompany.atlassian.net", "google maps": "https://maps.google.com", "github": "https://github.example.com", "": "x"}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := loadSiteAliases(path)
+	if err != nil {
+		t.Fatalf("loadSiteAliases: %v", err)
+	}
+	for alias, want := range map[string]string{
+		"jira":        "https://mycompany.atlassian.net",
+		"google maps": "https://maps.google.com",
+		"github":      "https://github.example.com",
+		"reddit":      "https://reddit.com",
+	} {
+		if got := aliases[alias]; got != want {
+			t.Errorf("alias %q = %q, want %q", alias, got, want)
+		}
+	}
+	if _, ok := aliases[""]; ok {
+		t.Error("empty alias was loaded")
+	}
+	if defaultSiteAliases["github"] != "https://github.com" {
+		t.Error("loading changed the defaults")
+	}
+}
+
+func TestLoadSiteAliasesRejectsInvalidJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.json")
+	if err := os.WriteFile(path, []byte(`["amazon"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSiteAliases(path); err == nil {
+		t.Error("loadSiteAliases accepted a JSON array")
+	}
+}
+
+func TestLookupSiteAlias(t *testing.T) {
+	previous := siteAliases
+	siteAliases = mergeSiteAliases(defaultSiteAliases, map[string]string{"google maps": "https://maps.google.com"})
+	t.Cleanup(func() { siteAliases = previous })
+
+	tests := []struct {
+		goal string
+		want string
+		ok   bool
+	}{
+		{"open Google Maps", "https://maps.google.com", true},
+		{"search google for cats", "https://google.com", true},
+		{"go to wikipedia.", "https://wikipedia.org", true},
+		{"read my ebooks", "", false},
+		{"open googler", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := lookupSiteAlias(tt.goal)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("lookupSiteAlias(%q) = %q, %v; want %q, %v", tt.goal, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxStructuredItems bounds how many JSON-LD objects and microdata items an
+// analysis carries
+const maxStructuredItems = 50
+
+// extractStructuredData collects the page's JSON-LD objects and microdata
+// items. JSON-LD arrays and @graph containers are flattened into their
+// objects; each top-level itemscope becomes a flat map of itemprop names to
+// values, with its itemtype under "@type". Blocks that fail to parse are
+// skipped.
+func extractStructuredData(doc *goquery.Document) []map[string]interface{} {
+	items := []map[string]interface{}{}
+
+	doc.Find("script[type='application/ld+json']").Each(func(_ int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(s.Text())), &data); err != nil {
+			slog.Debug("Skipping invalid JSON-LD block", "error", err)
+			return
+		}
+		items = appendJSONLD(items, data)
+	})
+
+	doc.Find("[itemscope]").Each(func(_ int, s *goquery.Selection) {
+		// Nested items are kept inside their parent's map
+		if _, isProp := s.Attr("itemprop"); isProp && s.ParentsFiltered("[itemscope]").Length() > 0 {
+			return
+		}
+		items = append(items, microdataItem(s))
+	})
+
+	if len(items) > maxStructuredItems {
+		items = items[:maxStructuredItems]
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return items
+}
+
+// appendJSONLD adds the objects in a decoded JSON-LD block to items
+func appendJSONLD(items []map[string]interface{}, data interface{}) []map[string]interface{} {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, entry := range v {
+			items = appendJSONLD(items, entry)
+		}
+	case map[string]interface{}:
+		if graph, ok := v["@graph"].([]interface{}); ok {
+			return appendJSONLD(items, graph)
+		}
+		items = append(items, v)
+	}
+	return items
+}
+
+// microdataItem reads the itemprops belonging to the itemscope s. A property
+// that appears more than once becomes a list.
+func microdataItem(s *goquery.Selection) map[string]interface{} {
+	item := map[string]interface{}{}
+	if itemType, ok := s.Attr("itemtype"); ok {
+		item["@type"] = itemType
+	}
+
+	s.Find("[itemprop]").Each(func(_ int, prop *goquery.Selection) {
+		// Skip properties of nested items; the nested item itself is a value
+		if prop.ParentsUntilSelection(s).Filter("[itemscope]").Length() > 0 {
+			return
+		}
+
+		var value interface{}
+		if _, nested := prop.Attr("itemscope"); nested {
+			value = microdataItem(prop)
+		} else {
+			value = microdataValue(prop)
+		}
+
+		for _, name := range strings.Fields(prop.AttrOr("itemprop", "")) {
+			switch existing := item[name].(type) {
+			case nil:
+				item[name] = value
+			case []interface{}:
+				item[name] = append(existing, value)
+			default:
+				item[name] = []interface{}{existing, value}
+			}
+		}
+	})
+
+	return item
+}
+
+// microdataValue is the value of a non-item property, following the
+// microdata rules for which attribute holds it
+func microdataValue(prop *goquery.Selection) string {
+	if content, ok := prop.Attr("content"); ok {
+		return strings.TrimSpace(content)
+	}
+
+	attr := ""
+	switch goquery.NodeName(prop) {
+	case "a", "area", "link":
+		attr = "href"
+	case "img", "audio", "video", "source", "iframe", "embed", "track":
+		attr = "src"
+	case "object":
+		attr = "data"
+	case "time":
+		attr = "datetime"
+	case "data", "meter":
+		attr = "value"
+	}
+	if value, ok := prop.Attr(attr); attr != "" && ok {
+		return strings.TrimSpace(value)
+	}
+
+	return strings.Join(strings.Fields(prop.Text()), " ")
+}
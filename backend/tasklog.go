@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTaskLogEntries bounds how many entries are kept in memory for /tasks
+const maxTaskLogEntries = 1000
+
+// TaskLogEntry is one line of the task log, written on every status change
+type TaskLogEntry struct {
+	TaskID string `json:"taskId,omitempty"`
+	// ScheduleID is set on "queued" entries for scheduled runs that found
+	// no extension connected
+	ScheduleID  string          `json:"scheduleId,omitempty"`
+	Goal        string          `json:"goal"`
+	Status      string          `json:"status"`
+	CurrentStep int             `json:"currentStep"`
+	Total       int             `json:"total"`
+	Results     []CommandResult `json:"results,omitempty"`
+	Timestamp   string          `json:"timestamp"`
+}
+
+// TaskLog appends task transitions to a JSON lines file and keeps the most
+// recent entries in memory so they survive client disconnects
+type TaskLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []TaskLogEntry
+}
+
+// taskLog is nil unless -task-log is set
+var taskLog *TaskLog
+
+// OpenTaskLog loads existing entries from path and opens it for appending
+func OpenTaskLog(path string) (*TaskLog, error) {
+	l := &TaskLog{}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			var entry TaskLogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				slog.Warn("Skipping malformed task log line", "error", err)
+				continue
+			}
+			l.append(entry)
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read task log: %v", err)
+		}
+	}
+
+	// Results can hold cookie values and script output, so only the owner
+	// may read the log, including one created before this was tightened
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open task log: %v", err)
+	}
+	if err := file.Chmod(0600); err != nil {
+		slog.Warn("Failed to restrict task log permissions", "path", path, "error", err)
+	}
+	l.file = file
+
+	slog.Info("Task log loaded", "path", path, "entries", len(l.entries))
+	return l, nil
+}
+
+func (l *TaskLog) append(entry TaskLogEntry) {
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > maxTaskLogEntries {
+		l.entries = l.entries[len(l.entries)-maxTaskLogEntries:]
+	}
+}
+
+// Record appends the task's current state to the log
+func (l *TaskLog) Record(task *TaskState) {
+	entry := TaskLogEntry{
+		TaskID:      task.TaskID,
+		Goal:        task.Goal,
+		Status:      task.Status,
+		CurrentStep: task.CurrentStep,
+		Total:       len(task.Sequence.Commands),
+		Results:     redactResults(task.Results, task.secrets),
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+	l.write(entry)
+}
+
+// RecordQueued logs a scheduled run waiting for an extension to connect
+func (l *TaskLog) RecordQueued(scheduleID, goal string) {
+	l.write(TaskLogEntry{
+		ScheduleID: scheduleID,
+		Goal:       goal,
+		Status:     "queued",
+		Timestamp:  time.Now().Format(time.RFC3339),
+	})
+}
+
+func (l *TaskLog) write(entry TaskLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Failed to encode task log entry", "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.append(entry)
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write task log", "error", err)
+	}
+}
+
+// Recent returns up to n of the newest entries, newest first, like
+// TaskHistory.Recent
+func (l *TaskLog) Recent(n int) []TaskLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n <= 0 || n > len(l.entries) {
+		n = len(l.entries)
+	}
+	recent := make([]TaskLogEntry, 0, n)
+	for i := len(l.entries) - 1; i >= len(l.entries)-n; i-- {
+		recent = append(recent, l.entries[i])
+	}
+	return recent
+}
+
+// transition updates the task status, its metrics and the task log, and
+// records the task in the history and the registry once it has finished
+func (t *TaskState) transition(status string) {
+	t.Status = status
+	recordTaskTransition(status)
+	if taskLog != nil {
+		taskLog.Record(t)
+	}
+	if isFinalStatus(status) {
+		registry.RecordFinished(t.owner, t.statusPayload())
+	}
+	if taskHistory != nil && isFinalStatus(status) {
+		taskHistory.Record(t)
+	}
+	if t.onFinish != nil && isFinalStatus(status) {
+		t.onFinish(t)
+	}
+}
+
+// tasksHandler serves GET /tasks?limit=N from the task log. It needs the
+// same token as the WebSocket, since results may include cookie values.
+func tasksHandler(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeRead(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if taskLog == nil {
+		http.Error(w, "Task log disabled (start with -task-log)", http.StatusNotFound)
+		return
+	}
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(taskLog.Recent(limit))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withAuthToken sets the server's bearer token for the rest of the test
+func withAuthToken(t *testing.T, token string) {
+	t.Helper()
+	previous := authToken
+	authToken = token
+	t.Cleanup(func() { authToken = previous })
+}
+
+func TestTaskLogSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.jsonl")
+	log, err := OpenTaskLog(path)
+	if err != nil {
+		t.Fatalf("OpenTaskLog: %v", err)
+	}
+	task := &TaskState{TaskID: "task_1", Goal: "go to example.com", Status: "executing"}
+	log.Record(task)
+	task.Status = "completed"
+	log.Record(task)
+	log.file.Close()
+
+	reopened, err := OpenTaskLog(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.file.Close()
+
+	recent := reopened.Recent(10)
+	if len(recent) != 2 || recent[0].Status != "completed" || recent[0].TaskID != "task_1" {
+		t.Errorf("entries after reopen = %+v", recent)
+	}
+}
+
+// localRequest is a GET for target from a loopback client
+func localRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = "127.0.0.1:54321"
+	return req
+}
+
+func TestTasksHandlerReturnsNewestFirst(t *testing.T) {
+	log, err := OpenTaskLog(filepath.Join(t.TempDir(), "tasks.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenTaskLog: %v", err)
+	}
+	defer log.file.Close()
+	for _, id := range []string{"task_1", "task_2", "task_3"} {
+		log.Record(&TaskState{TaskID: id, Status: "completed"})
+	}
+
+	previous := taskLog
+	taskLog = log
+	t.Cleanup(func() { taskLog = previous })
+	withAuthToken(t, "")
+
+	rec := httptest.NewRecorder()
+	tasksHandler(rec, localRequest("/tasks?limit=2"))
+	var entries []TaskLogEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(entries) != 2 || entries[0].TaskID != "task_3" || entries[1].TaskID != "task_2" {
+		t.Errorf("entries = %+v, want task_3 then task_2", entries)
+	}
+
+	rec = httptest.NewRecorder()
+	tasksHandler(rec, httptest.NewRequest(http.MethodGet, "/tasks", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("remote client without a token: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestTaskLogIsOwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.jsonl")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	log, err := OpenTaskLog(path)
+	if err != nil {
+		t.Fatalf("OpenTaskLog: %v", err)
+	}
+	defer log.file.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("task log mode = %o, want 600", mode)
+	}
+}
+
+func TestTasksHandlerRequiresToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.jsonl")
+	log, err := OpenTaskLog(path)
+	if err != nil {
+		t.Fatalf("OpenTaskLog: %v", err)
+	}
+	defer log.file.Close()
+	log.Record(&TaskState{TaskID: "task_1", Goal: "read cookies", Status: "completed"})
+
+	previous := taskLog
+	taskLog = log
+	t.Cleanup(func() { taskLog = previous })
+	withAuthToken(t, "s3cret")
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			tasksHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var entries []TaskLogEntry
+			if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil || len(entries) != 1 {
+				t.Errorf("body = %v entries, error %v", len(entries), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"cortex-browser/backend/llm"
+)
+
+var templatesFileFlag = flag.String("templates-file", "", `JSON file of named command templates, e.g. {"github-login": {"commands": [...]}}`)
+
+// TemplateStore holds named command sequences whose fields may contain
+// {{param}} placeholders, filled in by LOAD_TEMPLATE
+type TemplateStore struct {
+	mu        sync.RWMutex
+	templates map[string]CommandSequence
+}
+
+// LoadTemplatePayload asks for the named template to be expanded with Params
+// and run as a task. The option fields behave as in ExecuteTaskPayload.
+type LoadTemplatePayload struct {
+	Name             string            `json:"name"`
+	Params           map[string]string `json:"params,omitempty"`
+	DefaultTimeoutMs int               `json:"defaultTimeoutMs,omitempty"`
+	MaxRetries       int               `json:"maxRetries,omitempty"`
+	RetryBackoffMs   int               `json:"retryBackoffMs,omitempty"`
+}
+
+// TemplateDefinition is the body of POST /templates
+type TemplateDefinition struct {
+	Name     string           `json:"name"`
+	Commands []CommandPayload `json:"commands"`
+}
+
+var templates = NewTemplateStore()
+
+// NewTemplateStore creates an empty store
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{templates: make(map[string]CommandSequence)}
+}
+
+// LoadTemplates reads a JSON object of name → sequence into the store,
+// replacing templates with the same name
+func (s *TemplateStore) LoadTemplates(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read templates: %w", err)
+	}
+
+	var loaded map[string]CommandSequence
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parse templates %s: %w", path, err)
+	}
+
+	for name, sequence := range loaded {
+		if err := s.Set(name, sequence.Commands); err != nil {
+			return fmt.Errorf("templates %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Set adds or replaces a template
+func (s *TemplateStore) Set(name string, commands []CommandPayload) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("template name is required")
+	}
+	if len(commands) == 0 {
+		return fmt.Errorf("template %q has no commands", name)
+	}
+	for i, cmd := range commands {
+		if err := validateTemplateStep(cmd); err != nil {
+			return fmt.Errorf("template %q step %d: %w", name, i+1, err)
+		}
+	}
+	if err := validateCommandURLs(commands); err != nil {
+		return fmt.Errorf("template %q %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[name] = CommandSequence{
+		Commands: append([]CommandPayload(nil), commands...),
+		Total:    len(commands),
+	}
+	return nil
+}
+
+// validateTemplateStep holds a template step to the rules for LLM plans:
+// only plan actions, so no evaluate, and selectors that parse. Placeholders
+// stand for a plain word while checking; the filled-in selectors are
+// checked again when each step is dispatched.
+func validateTemplateStep(cmd CommandPayload) error {
+	if cmd.Action == "" {
+		return errors.New("no action")
+	}
+	if !llm.IsPlanAction(cmd.Action) {
+		return fmt.Errorf("action %q is not allowed in templates", cmd.Action)
+	}
+
+	placeholder := func(s string) string { return variableRefRegex.ReplaceAllString(s, "x") }
+	for _, field := range cssSelectorFields(&cmd) {
+		if _, err := llm.SanitizeSelector(placeholder(*field)); err != nil {
+			return err
+		}
+	}
+	if cmd.SelectorType == "xpath" {
+		if _, err := llm.SanitizeXPath(placeholder(cmd.Selector)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the named template unexpanded
+func (s *TemplateStore) Get(name string) (CommandSequence, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sequence, ok := s.templates[name]
+	return sequence, ok
+}
+
+// List returns every template as a definition, sorted by name
+func (s *TemplateStore) List() []TemplateDefinition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	definitions := make([]TemplateDefinition, 0, len(s.templates))
+	for name, sequence := range s.templates {
+		definitions = append(definitions, TemplateDefinition{Name: name, Commands: sequence.Commands})
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Name < definitions[j].Name })
+	return definitions
+}
+
+// Expand returns a copy of the named template with every {{param}} replaced.
+// Placeholders naming an extract step's StoreAs are left for the task to fill
+// in at run time; any other placeholder without a param is an error.
+func (s *TemplateStore) Expand(name string, params map[string]string) (*CommandSequence, error) {
+	template, ok := s.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+
+	extracted := map[string]bool{}
+	commands := make([]CommandPayload, len(template.Commands))
+	for i, cmd := range template.Commands {
+		commands[i] = expandTemplateCommand(cmd, params)
+		if cmd.StoreAs != "" {
+			extracted[cmd.StoreAs] = true
+		}
+	}
+
+	var missing []string
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		for _, field := range append(templateFields(&cmd), &cmd.URL) {
+			for _, ref := range variableRefRegex.FindAllStringSubmatch(*field, -1) {
+				if name := ref[1]; !extracted[name] && !seen[name] {
+					seen[name] = true
+					missing = append(missing, name)
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %q is missing params: %s", name, strings.Join(missing, ", "))
+	}
+
+	return &CommandSequence{Commands: commands, Total: len(commands)}, nil
+}
+
+// expandTemplateCommand fills params into every string field of cmd. URLs
+// get the same escaping as interpolateVariables.
+func expandTemplateCommand(cmd CommandPayload, params map[string]string) CommandPayload {
+	if cmd.Cookie != nil {
+		cookie := *cmd.Cookie
+		cmd.Cookie = &cookie
+	}
+
+	cmd.URL = interpolateURL(cmd.URL, params)
+	for _, field := range templateFields(&cmd) {
+		*field = replaceVariables(*field, params, func(v string) string { return v })
+	}
+	return cmd
+}
+
+// templateFields points at the fields of cmd other than URL that may hold
+// placeholders
+func templateFields(cmd *CommandPayload) []*string {
+	fields := []*string{
+		&cmd.Selector, &cmd.Text, &cmd.ScrollSelector, &cmd.WaitSelector,
+		&cmd.Script, &cmd.ExtractSelector, &cmd.Condition,
+		&cmd.AssertSelector, &cmd.AssertExpected,
+	}
+	if cmd.Cookie != nil {
+		fields = append(fields, &cmd.Cookie.Name, &cmd.Cookie.Value, &cmd.Cookie.Domain)
+	}
+	return fields
+}
+
+func handleLoadTemplate(session *Session, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Failed to parse template payload",
+				Code:    "PAYLOAD_ERROR",
+			},
+		})
+	}
+
+	var templatePayload LoadTemplatePayload
+	if err := json.Unmarshal(payloadBytes, &templatePayload); err != nil {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Invalid template payload format",
+				Code:    "TEMPLATE_FORMAT_ERROR",
+			},
+		})
+	}
+
+	if shuttingDown.Load() {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Server is shutting down",
+				Code:    "SERVER_SHUTTING_DOWN",
+			},
+		})
+	}
+
+	if !session.startPlanning() {
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: "Another goal or batch is still running on this connection",
+				Code:    "GOAL_IN_PROGRESS",
+			},
+		})
+	}
+	defer session.endPlanning()
+
+	sequence, err := templates.Expand(templatePayload.Name, templatePayload.Params)
+	if err != nil {
+		session.logger.Warn("Template expansion failed", "template", templatePayload.Name, "error", err)
+		return session.send(&Message{
+			Type: "ERROR",
+			Payload: ErrorPayload{
+				Message: err.Error(),
+				Code:    "TEMPLATE_ERROR",
+			},
+		})
+	}
+
+	session.logger.Info("Running template", "template", templatePayload.Name)
+
+	// Params are often credentials, so keep them out of the task log and
+	// history
+	secrets := make([]string, 0, len(templatePayload.Params))
+	for _, value := range templatePayload.Params {
+		secrets = append(secrets, value)
+	}
+
+	_, err = startTask(session, ExecuteTaskPayload{
+		Goal:             "template " + templatePayload.Name,
+		DefaultTimeoutMs: templatePayload.DefaultTimeoutMs,
+		MaxRetries:       templatePayload.MaxRetries,
+		RetryBackoffMs:   templatePayload.RetryBackoffMs,
+		secrets:          secrets,
+	}, sequence, nil)
+	return err
+}
+
+// templatesHandler lists templates on GET and adds or replaces one on POST,
+// behind the same origin and token checks as the WebSocket. A POST must be
+// sent as application/json, which a web page can't do cross-origin without a
+// CORS preflight, so without a token other sites still can't store templates.
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := authorizeUpgrade(r); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(templates.List())
+	case http.MethodPost:
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var definition TemplateDefinition
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&definition); err != nil {
+			http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := templates.Set(definition.Name, definition.Commands); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		slog.Info("Template saved", "template", definition.Name, "commands", len(definition.Commands))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
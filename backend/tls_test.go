@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsureSelfSignedCertReusesValidCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := ensureSelfSignedCert(certPath, keyPath); err != nil {
+		t.Fatalf("ensureSelfSignedCert: %v", err)
+	}
+	first, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensureSelfSignedCert(certPath, keyPath); err != nil {
+		t.Fatalf("ensureSelfSignedCert: %v", err)
+	}
+	second, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Error("valid certificate was regenerated")
+	}
+}
+
+func TestEnsureSelfSignedCertRegeneratesExpiredCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().AddDate(-2, 0, 0),
+		NotAfter:     time.Now().AddDate(-1, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(keyPath, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensureSelfSignedCert(certPath, keyPath); err != nil {
+		t.Fatalf("ensureSelfSignedCert: %v", err)
+	}
+	notAfter, err := certExpiry(certPath)
+	if err != nil {
+		t.Fatalf("certExpiry: %v", err)
+	}
+	if !notAfter.After(time.Now()) {
+		t.Errorf("certificate still expires at %v", notAfter)
+	}
+}
+
+func TestEnsureSelfSignedCertRejectsUnreadableCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ensureSelfSignedCert(certPath, keyPath); err == nil {
+		t.Error("expected an error for a file that isn't a certificate")
+	}
+}
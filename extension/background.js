@@ -18,6 +18,24 @@ let ws = null;
 let reconnectInterval = null;
 let isConnected = false;
 
+// Backend auth token, if the user configured one in storage
+let backendAuthToken = '';
+chrome.storage.local.get('authToken').then(({ authToken }) => {
+  backendAuthToken = authToken || '';
+}).catch(() => {});
+
+// Stable ID for this browser profile, so the backend can hand scheduled
+// goals back to the profile that created them after a reconnect
+async function getClientId() {
+  const { clientId } = await chrome.storage.local.get('clientId');
+  if (clientId) {
+    return clientId;
+  }
+  const newId = crypto.randomUUID();
+  await chrome.storage.local.set({ clientId: newId });
+  return newId;
+}
+
 // Active tasks tracking
 let activeTasks = new Map();
 let currentSequence = null;
@@ -48,7 +66,7 @@ function connectWebSocket() {
       ws = null;
     }
     
-    ws = new WebSocket('ws://localhost:8080/ws');
+    ws = new WebSocket(buildBackendURL());
     
     ws.onopen = function(event) {
       console.log('WebSocket Connection Opened!');
@@ -62,17 +80,18 @@ function connectWebSocket() {
       notifyConnectionStatus('connected', 'Connected to backend');
       
       // Send initial handshake
-      try {
+      getClientId().then((clientId) => {
         sendToBackend({
           type: 'HANDSHAKE',
           payload: { 
             client: 'extension',
-            version: chrome.runtime.getManifest().version
+            version: chrome.runtime.getManifest().version,
+            clientId
           }
         });
-      } catch (error) {
+      }).catch((error) => {
         console.error('Failed to send handshake:', error);
-      }
+      });
     };
     
     ws.onmessage = function(event) {
@@ -112,6 +131,12 @@ function connectWebSocket() {
   }
 }
 
+function buildBackendURL() {
+  const url = 'ws://localhost:8080/ws';
+  // Browsers can't set an Authorization header on WebSocket upgrades
+  return backendAuthToken ? `${url}?token=${encodeURIComponent(backendAuthToken)}` : url;
+}
+
 function attemptReconnect() {
   // Clear any existing reconnect interval to prevent duplicates
   if (reconnectInterval) {
@@ -182,12 +207,51 @@ function handleBackendMessage(message) {
       case 'TASK_COMPLETE':
         handleTaskComplete(message.payload);
         break;
+      case 'TASK_PROGRESS':
+        notifySidepanel('TASK_PROGRESS', message.payload);
+        break;
+      case 'LLM_THINKING':
+        notifySidepanel('LLM_THINKING', message.payload);
+        break;
+      case 'BATCH_COMPLETE':
+        notifySidepanel('BATCH_COMPLETE', message.payload);
+        break;
+      case 'SCHEDULE_CREATED':
+      case 'SCHEDULES':
+      case 'SCHEDULE_CANCELLED':
+        notifySidepanel(message.type, message.payload);
+        break;
+      case 'COMMAND_RETRY':
+        notifySidepanel('COMMAND_RETRY', message.payload);
+        break;
+      case 'TASK_FAILED':
+        handleTaskFailed(message.payload);
+        break;
+      case 'TASK_CANCELLED':
+        handleTaskCancelled(message.payload);
+        break;
       case 'ERROR':
         handleBackendError(message.payload);
         break;
+      case 'SERVER_SHUTTING_DOWN':
+        console.warn('Backend is shutting down:', message.payload);
+        notifyConnectionStatus('connecting', message.payload?.message || 'Backend is shutting down');
+        break;
       case 'CONTENT_ANALYSIS':
         handleContentAnalysis(message.payload);
         break;
+      case 'REQUEST_PAGE_CONTENT':
+        handlePageContentRequest(message.payload);
+        break;
+      case 'CONTEXT_CLEARED':
+        notifySidepanel('CONTEXT_CLEARED', message.payload);
+        break;
+      case 'CAPTCHA_DETECTED':
+        notifySidepanel('CAPTCHA_DETECTED', message.payload);
+        break;
+      case 'MAIN_CONTENT':
+        notifySidepanel('MAIN_CONTENT', message.payload);
+        break;
       default:
         console.log('Unknown backend message type:', message.type);
     }
@@ -223,9 +287,53 @@ async function executeCommand(command) {
           // Navigation is allowed even from restricted pages (we're navigating away)
           result = await handleNavigateCommand(activeTab, command);
           break;
+        case 'evaluate':
+          result = await handleEvaluateCommand(activeTab, command);
+          break;
+        case 'get_cookies':
+        case 'set_cookie':
+        case 'clear_cookies':
+          result = await handleCookieCommand(activeTab, command);
+          break;
+        case 'back':
+        case 'forward':
+          result = await handleHistoryCommand(activeTab, command);
+          break;
+        case 'open_tab':
+        case 'switch_tab':
+        case 'close_tab':
+        case 'list_tabs':
+          result = await handleTabCommand(activeTab, command);
+          break;
+        case 'upload_file':
+          result = await handleUploadCommand(activeTab, command);
+          break;
+        case 'handle_dialog':
+          result = await handleDialogCommand(activeTab, command);
+          break;
+        case 'intercept_request':
+        case 'clear_intercepts':
+          result = await handleInterceptCommand(activeTab, command);
+          break;
         case 'click':
+        case 'click_text':
+        case 'press_key':
+        case 'key':
+        case 'hover':
+        case 'drag':
+        case 'copy_to_clipboard':
+        case 'paste_from_clipboard':
         case 'input':
         case 'get_content':
+        case 'scroll':
+        case 'wait_for_element':
+        case 'wait_for_ready_state':
+        case 'extract':
+        case 'check_element':
+        case 'assert':
+        case 'select_option':
+        case 'check':
+        case 'uncheck':
           // Refresh tab info in case we just navigated
           const [refreshedTab] = await chrome.tabs.query({ active: true, currentWindow: true });
           const tabToUse = refreshedTab || activeTab;
@@ -260,7 +368,7 @@ async function executeCommand(command) {
       // Don't fail the command if notification fails
     }
 
-    if (command.action === 'navigate' || command.action === 'click') {
+    if (['navigate', 'click', 'click_text', 'press_key', 'key', 'open_tab', 'switch_tab', 'back', 'forward'].includes(command.action)) {
       setTimeout(async () => {
         try {
           const [tab] = await chrome.tabs.query({ active: true, currentWindow: true });
@@ -286,22 +394,22 @@ async function executeCommand(command) {
       }, 3000);
     }
 
-    if (currentSequence) {
-      try {
-        sendToBackend({
-          type: 'COMMAND_COMPLETE',
-          payload: {
-            step: currentSequence.current || 0,
-            action: command.action,
-            success: true,
-            details: result?.details || 'Command executed successfully',
-            timestamp: new Date().toISOString()
-          }
-        });
-      } catch (backendError) {
-        console.warn('Failed to send completion to backend:', backendError);
-        // Don't fail the command if backend notification fails
-      }
+    // Always report back so the backend can advance or close out the task
+    try {
+      sendToBackend({
+        type: 'COMMAND_COMPLETE',
+        payload: {
+          step: currentSequence?.current || 0,
+          action: command.action,
+          success: true,
+          // ?? keeps empty extract results and check_element's "false"
+          details: result?.details ?? 'Command executed successfully',
+          timestamp: new Date().toISOString()
+        }
+      });
+    } catch (backendError) {
+      console.warn('Failed to send completion to backend:', backendError);
+      // Don't fail the command if backend notification fails
     }
 
   } catch (error) {
@@ -318,21 +426,19 @@ async function executeCommand(command) {
     }
 
     // Send failure to backend
-    if (currentSequence) {
-      try {
-        sendToBackend({
-          type: 'COMMAND_COMPLETE',
-          payload: {
-            step: currentSequence.current || 0,
-            action: command?.action || 'unknown',
-            success: false,
-            error: error.message || 'Unknown error occurred',
-            timestamp: new Date().toISOString()
-          }
-        });
-      } catch (backendError) {
-        console.warn('Failed to send error to backend:', backendError);
-      }
+    try {
+      sendToBackend({
+        type: 'COMMAND_COMPLETE',
+        payload: {
+          step: currentSequence?.current || 0,
+          action: command?.action || 'unknown',
+          success: false,
+          error: error.message || 'Unknown error occurred',
+          timestamp: new Date().toISOString()
+        }
+      });
+    } catch (backendError) {
+      console.warn('Failed to send error to backend:', backendError);
     }
   }
 }
@@ -341,7 +447,12 @@ async function handleNavigateCommand(tab, command) {
   // Update the tab URL
   await chrome.tabs.update(tab.id, { url: command.url });
   
-  // Wait for the page to finish loading
+  await waitForTabLoad(tab.id);
+  return { details: `Navigated to ${command.url}` };
+}
+
+// Resolves once the tab reports status "complete" on a non-restricted page
+function waitForTabLoad(targetTabId) {
   return new Promise((resolve, reject) => {
     const timeout = setTimeout(() => {
       chrome.tabs.onUpdated.removeListener(listener);
@@ -351,7 +462,7 @@ async function handleNavigateCommand(tab, command) {
     // Listen for tab update to detect when page loads
     const listener = (tabId, changeInfo, updatedTab) => {
       // Only process updates for our tab
-      if (tabId !== tab.id) return;
+      if (tabId !== targetTabId) return;
       
       // Check if page is fully loaded
       if (changeInfo.status === 'complete') {
@@ -369,9 +480,7 @@ async function handleNavigateCommand(tab, command) {
         }
         
         // Small delay to ensure page is ready and content script can attach
-        setTimeout(() => {
-          resolve({ details: `Navigated to ${command.url}` });
-        }, 1000); // Increased delay to ensure page is ready
+        setTimeout(resolve, 1000); // Increased delay to ensure page is ready
       }
     };
     
@@ -379,10 +488,355 @@ async function handleNavigateCommand(tab, command) {
   });
 }
 
+async function handleHistoryCommand(tab, command) {
+  const loaded = waitForTabLoad(tab.id);
+  if (command.action === 'back') {
+    await chrome.tabs.goBack(tab.id);
+  } else {
+    await chrome.tabs.goForward(tab.id);
+  }
+  await loaded;
+
+  const [current] = await chrome.tabs.query({ active: true, currentWindow: true });
+  return { details: `Went ${command.action} to ${current?.url || 'previous page'}` };
+}
+
+async function handleTabCommand(tab, command) {
+  switch (command.action) {
+    case 'open_tab': {
+      if (!command.url) {
+        throw new Error('open_tab command requires url');
+      }
+      const created = await chrome.tabs.create({ url: command.url, active: true });
+      await waitForTabLoad(created.id);
+      return { details: `Opened ${command.url} in tab ${created.id}`, tabId: created.id };
+    }
+    case 'switch_tab': {
+      if (!command.tabId) {
+        throw new Error('switch_tab command requires tabId');
+      }
+      const target = await chrome.tabs.update(command.tabId, { active: true });
+      await chrome.windows.update(target.windowId, { focused: true });
+      return { details: `Switched to tab ${target.id}: ${target.url}` };
+    }
+    case 'close_tab': {
+      const tabId = command.tabId || tab.id;
+      await chrome.tabs.remove(tabId);
+      return { details: `Closed tab ${tabId}` };
+    }
+    case 'list_tabs': {
+      const tabs = await chrome.tabs.query({});
+      const summary = tabs.map(t => ({ id: t.id, url: t.url, title: t.title, active: t.active }));
+      return { details: JSON.stringify(summary), elementsFound: summary.length };
+    }
+  }
+}
+
+// Run the script through the DevTools protocol: eval in the page would be
+// blocked on every site whose Content-Security-Policy lacks 'unsafe-eval'
+async function handleEvaluateCommand(tab, command) {
+  if (!command.script) {
+    throw new Error('Evaluate command requires script');
+  }
+
+  await attachDebugger(tab.id, 'evaluate scripts');
+  try {
+    const { result, exceptionDetails } = await chrome.debugger.sendCommand({ tabId: tab.id }, 'Runtime.evaluate', {
+      expression: command.script,
+      returnByValue: true,
+      awaitPromise: true
+    });
+    if (exceptionDetails) {
+      throw new Error(`Script threw: ${exceptionDetails.exception?.description || exceptionDetails.text}`);
+    }
+    return { details: JSON.stringify(result?.value ?? null) };
+  } finally {
+    await detachDebugger(tab.id);
+  }
+}
+
+async function handleCookieCommand(tab, command) {
+  const url = command.url || tab.url;
+  if (!url || !/^https?:/.test(url)) {
+    throw new Error(`Cookie commands need an http(s) URL, got ${url || 'none'}`);
+  }
+
+  const cookie = command.cookie || {};
+
+  switch (command.action) {
+    case 'get_cookies': {
+      const query = { url };
+      if (cookie.name) {
+        query.name = cookie.name;
+      }
+      const cookies = await chrome.cookies.getAll(query);
+      return { details: JSON.stringify(cookies), elementsFound: cookies.length };
+    }
+    case 'set_cookie': {
+      if (!cookie.name) {
+        throw new Error('set_cookie command requires cookie.name');
+      }
+      const details = { url, name: cookie.name, value: cookie.value || '' };
+      if (cookie.domain) {
+        details.domain = cookie.domain;
+      }
+      if (cookie.expiry) {
+        details.expirationDate = cookie.expiry;
+      }
+      const saved = await chrome.cookies.set(details);
+      if (!saved) {
+        throw new Error(`Failed to set cookie ${cookie.name}`);
+      }
+      return { details: JSON.stringify(saved) };
+    }
+    case 'clear_cookies': {
+      const cookies = await chrome.cookies.getAll({ url });
+      await Promise.all(cookies.map(c => chrome.cookies.remove({ url, name: c.name, storeId: c.storeId })));
+      return { details: `Removed ${cookies.length} cookies for ${new URL(url).hostname}`, elementsFound: cookies.length };
+    }
+  }
+}
+
+// Look command.fileName up among completed downloads, newest first, and
+// fetch it again from where it was downloaded, since extensions can't read
+// local files. The bytes go to the content script base64-encoded.
+async function handleUploadCommand(tab, command) {
+  if (!command.fileName) {
+    throw new Error('upload_file command requires fileName');
+  }
+  const escaped = command.fileName.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+  const downloads = await chrome.downloads.search({
+    filenameRegex: `(^|[\\\\/])${escaped}$`,
+    state: 'complete',
+    exists: true,
+    orderBy: ['-startTime'],
+    limit: 1
+  });
+  if (downloads.length === 0) {
+    throw new Error(`${command.fileName} not found in downloads; download it first`);
+  }
+
+  const response = await fetch(downloads[0].finalUrl || downloads[0].url);
+  if (!response.ok) {
+    throw new Error(`Failed to read ${command.fileName}: HTTP ${response.status}`);
+  }
+  const bytes = new Uint8Array(await response.arrayBuffer());
+  let binary = '';
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+
+  return sendCommandToContent(tab, {
+    ...command,
+    fileData: btoa(binary),
+    mimeType: downloads[0].mime || response.headers.get('Content-Type') || ''
+  });
+}
+
+// How long a handle_dialog step waits for the dialog it was armed for
+const DIALOG_WAIT_MS = 15000;
+
+// Tab ID -> { params, timer } for handle_dialog steps waiting on a dialog
+const pendingDialogs = new Map();
+
+// Answer the tab's open alert/confirm/prompt through the DevTools protocol,
+// since a dialog blocks the content script. With no dialog open, keep the
+// debugger attached and answer the next one the page opens.
+async function handleDialogCommand(tab, command) {
+  const dialogAction = command.dialogAction || 'accept';
+  if (!['accept', 'dismiss', 'type'].includes(dialogAction)) {
+    throw new Error(`Unknown dialogAction: ${dialogAction}`);
+  }
+  const params = { accept: dialogAction !== 'dismiss' };
+  if (dialogAction === 'type') {
+    params.promptText = command.dialogText || '';
+  }
+
+  const target = { tabId: tab.id };
+  await releaseDialogHandler(tab.id);
+  await attachDebugger(tab.id, 'handle dialogs');
+  await chrome.debugger.sendCommand(target, 'Page.enable');
+
+  try {
+    await chrome.debugger.sendCommand(target, 'Page.handleJavaScriptDialog', params);
+    await detachDebugger(tab.id);
+    return { details: `Dialog ${params.accept ? 'accepted' : 'dismissed'}` };
+  } catch (error) {
+    // No dialog is showing yet
+  }
+
+  pendingDialogs.set(tab.id, {
+    params,
+    timer: setTimeout(() => releaseDialogHandler(tab.id), DIALOG_WAIT_MS)
+  });
+  return { details: `Will ${params.accept ? 'accept' : 'dismiss'} the next dialog` };
+}
+
+function releaseDialogHandler(tabId) {
+  const pending = pendingDialogs.get(tabId);
+  if (!pending) {
+    return Promise.resolve();
+  }
+  clearTimeout(pending.timer);
+  pendingDialogs.delete(tabId);
+  return detachDebugger(tabId);
+}
+
+async function attachDebugger(tabId, purpose) {
+  try {
+    await chrome.debugger.attach({ tabId }, '1.3');
+  } catch (error) {
+    // Already attached by an earlier step is fine
+    if (!/already attached/i.test(error.message)) {
+      throw new Error(`Cannot attach to tab to ${purpose}: ${error.message}`);
+    }
+  }
+}
+
+// Detach unless a waiting dialog handler or active intercepts still need
+// the debugger
+function detachDebugger(tabId) {
+  if (pendingDialogs.has(tabId) || activeIntercepts.has(tabId)) {
+    return Promise.resolve();
+  }
+  return chrome.debugger.detach({ tabId }).catch(() => {});
+}
+
+// Tab ID -> [{ urlPattern, matches, body }] for intercept_request steps,
+// most recent last so it wins when patterns overlap
+const activeIntercepts = new Map();
+
+// Stub fetch/XHR responses through the DevTools protocol's Fetch domain.
+// Every XHR and fetch request is paused and either answered from the
+// first matching intercept or let through unchanged.
+async function handleInterceptCommand(tab, command) {
+  const target = { tabId: tab.id };
+
+  if (command.action === 'clear_intercepts') {
+    const count = activeIntercepts.get(tab.id)?.length || 0;
+    if (count > 0) {
+      activeIntercepts.delete(tab.id);
+      await chrome.debugger.sendCommand(target, 'Fetch.disable').catch(() => {});
+      await detachDebugger(tab.id);
+    }
+    return { details: `Cleared ${count} request intercept(s)` };
+  }
+
+  if (!command.urlPattern) {
+    throw new Error('intercept_request requires urlPattern');
+  }
+  try {
+    JSON.parse(command.mockResponse);
+  } catch (error) {
+    throw new Error(`mockResponse is not valid JSON: ${error.message}`);
+  }
+
+  const intercept = {
+    urlPattern: command.urlPattern,
+    matches: urlPatternMatcher(command.urlPattern),
+    body: utf8ToBase64(command.mockResponse)
+  };
+
+  await attachDebugger(tab.id, 'intercept requests');
+  activeIntercepts.set(tab.id, [...(activeIntercepts.get(tab.id) || []), intercept]);
+  await chrome.debugger.sendCommand(target, 'Fetch.enable', {
+    patterns: [
+      { urlPattern: '*', resourceType: 'XHR', requestStage: 'Request' },
+      { urlPattern: '*', resourceType: 'Fetch', requestStage: 'Request' }
+    ]
+  });
+  return { details: `Mocking requests matching ${command.urlPattern}` };
+}
+
+// A pattern between slashes is a regular expression; anything else is a
+// glob where * matches any run of characters and ? a single one
+function urlPatternMatcher(pattern) {
+  const regex = pattern.length > 2 && pattern.startsWith('/') && pattern.endsWith('/')
+    ? new RegExp(pattern.slice(1, -1))
+    : new RegExp('^' + pattern.replace(/[.+^${}()|[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.') + '$');
+  return url => regex.test(url);
+}
+
+function utf8ToBase64(text) {
+  const bytes = new TextEncoder().encode(text);
+  let binary = '';
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return btoa(binary);
+}
+
+chrome.debugger.onEvent.addListener((source, method, params) => {
+  if (method !== 'Fetch.requestPaused') {
+    return;
+  }
+  const intercept = (activeIntercepts.get(source.tabId) || []).findLast(i => i.matches(params.request.url));
+  const reply = intercept
+    ? chrome.debugger.sendCommand(source, 'Fetch.fulfillRequest', {
+        requestId: params.requestId,
+        responseCode: 200,
+        responseHeaders: [
+          { name: 'Content-Type', value: 'application/json' },
+          { name: 'Access-Control-Allow-Origin', value: '*' }
+        ],
+        body: intercept.body
+      })
+    : chrome.debugger.sendCommand(source, 'Fetch.continueRequest', { requestId: params.requestId });
+  reply.catch(error => console.warn('Failed to answer intercepted request:', error));
+});
+
+chrome.debugger.onEvent.addListener((source, method) => {
+  const pending = pendingDialogs.get(source.tabId);
+  if (method !== 'Page.javascriptDialogOpening' || !pending) {
+    return;
+  }
+  chrome.debugger.sendCommand(source, 'Page.handleJavaScriptDialog', pending.params)
+    .catch(error => console.warn('Failed to answer dialog:', error))
+    .finally(() => releaseDialogHandler(source.tabId));
+});
+
+chrome.debugger.onDetach.addListener((source) => {
+  activeIntercepts.delete(source.tabId);
+  const pending = pendingDialogs.get(source.tabId);
+  if (pending) {
+    clearTimeout(pending.timer);
+    pendingDialogs.delete(source.tabId);
+  }
+});
+
+// Find the frame ID of the <iframe> matching frameSelector in the top page.
+// Content scripts in different frames can't reach each other, so the frame
+// is matched by URL against the frames Chrome knows about.
+async function resolveFrameId(tab, frameSelector) {
+  const [injection] = await chrome.scripting.executeScript({
+    target: { tabId: tab.id },
+    func: (selector) => {
+      const frame = document.querySelector(selector);
+      return frame && frame.tagName === 'IFRAME' ? frame.src : null;
+    },
+    args: [frameSelector]
+  });
+  const src = injection?.result;
+  if (!src) {
+    throw new Error(`Frame not found: ${frameSelector}`);
+  }
+
+  const frames = await chrome.webNavigation.getAllFrames({ tabId: tab.id });
+  const frame = frames.find(f => f.parentFrameId === 0 && f.url === src)
+    || frames.find(f => f.frameId !== 0 && f.url === src);
+  if (!frame) {
+    throw new Error(`Frame ${frameSelector} (${src}) has not loaded`);
+  }
+  return frame.frameId;
+}
+
 async function sendCommandToContent(tab, command) {
   try {
+    // Commands run in the top frame unless they name an iframe
+    const frameId = command.frameSelector ? await resolveFrameId(tab, command.frameSelector) : 0;
+
     // First, ensure content script is injected
-    await ensureContentScriptInjected(tab.id);
+    await ensureContentScriptInjected(tab.id, frameId);
     
     return new Promise((resolve, reject) => {
       // Set timeout to prevent hanging indefinitely
@@ -394,7 +848,7 @@ async function sendCommandToContent(tab, command) {
         chrome.tabs.sendMessage(tab.id, {
           type: 'EXECUTE_COMMAND',
           payload: command
-        }, (response) => {
+        }, { frameId }, (response) => {
           clearTimeout(timeout);
           
           if (chrome.runtime.lastError) {
@@ -419,12 +873,12 @@ async function sendCommandToContent(tab, command) {
   }
 }
 
-async function ensureContentScriptInjected(tabId) {
+async function ensureContentScriptInjected(tabId, frameId = 0) {
   try {
     // Test if content script is already available (it should be via manifest.json)
     // Use a timeout to prevent hanging if content script isn't responding
     const response = await Promise.race([
-      chrome.tabs.sendMessage(tabId, { type: 'PING' }),
+      chrome.tabs.sendMessage(tabId, { type: 'PING' }, { frameId }),
       new Promise((_, reject) => setTimeout(() => reject(new Error('Timeout')), 2000))
     ]);
     
@@ -446,7 +900,7 @@ async function ensureContentScriptInjected(tabId) {
       
       console.log('Content script not found, attempting to inject...');
       await chrome.scripting.executeScript({
-        target: { tabId: tabId },
+        target: { tabId: tabId, frameIds: [frameId] },
         files: ['content.js']
       });
       
@@ -455,7 +909,7 @@ async function ensureContentScriptInjected(tabId) {
       
       // Verify it's now available
       const verifyResponse = await Promise.race([
-        chrome.tabs.sendMessage(tabId, { type: 'PING' }),
+        chrome.tabs.sendMessage(tabId, { type: 'PING' }, { frameId }),
         new Promise((_, reject) => setTimeout(() => reject(new Error('Timeout')), 1000))
       ]);
       
@@ -476,6 +930,19 @@ function handleTaskComplete(payload) {
   notifySidepanel('EXECUTION_COMPLETE', payload);
 }
 
+function handleTaskFailed(payload) {
+  currentSequence = null;
+  notifySidepanel('COMMAND_FAILED', {
+    action: 'task',
+    error: payload.message || 'Task failed'
+  });
+}
+
+function handleTaskCancelled(payload) {
+  currentSequence = null;
+  notifySidepanel('EXECUTION_CANCELLED', payload);
+}
+
 function handleBackendError(payload) {
   notifySidepanel('COMMAND_FAILED', {
     action: 'backend_processing',
@@ -488,6 +955,36 @@ function handleContentAnalysis(payload) {
   notifySidepanel('CONTENT_ANALYSIS', payload);
 }
 
+// Capture the active tab for the backend, echoing requestId so it can match
+// the reply to the goal waiting for it
+async function handlePageContentRequest(payload) {
+  const requestId = payload?.requestId;
+  try {
+    const [tab] = await chrome.tabs.query({ active: true, currentWindow: true });
+    if (!tab || !tab.url || tab.url.startsWith('chrome://') || tab.url.startsWith('chrome-extension://')) {
+      throw new Error('No page to capture in the active tab');
+    }
+    const contentResult = await sendCommandToContent(tab, { action: 'get_content' });
+    if (!contentResult || !contentResult.html) {
+      throw new Error('Content script returned no HTML');
+    }
+    sendToBackend({
+      type: 'PAGE_CONTENT',
+      payload: {
+        requestId,
+        html: contentResult.html,
+        title: contentResult.title || tab.title,
+        url: contentResult.url || tab.url,
+        text: contentResult.text || '',
+        readyState: contentResult.readyState || 'complete'
+      }
+    });
+  } catch (error) {
+    console.log('Could not capture requested page content:', error.message);
+    sendToBackend({ type: 'PAGE_CONTENT', payload: { requestId, error: error.message } });
+  }
+}
+
 function handleCommandSequence(sequence) {
   console.log('Command sequence received:', sequence);
   currentSequence = sequence;
@@ -562,6 +1059,7 @@ chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
                     }
                   });
                   console.log('Auto-captured page content for context-aware goal');
+                  message.payload.url = contentResult.url || tab.url;
                   
                   await new Promise(resolve => setTimeout(resolve, 500));
                 }
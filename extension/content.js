@@ -15,6 +15,10 @@ window.addEventListener('unhandledrejection', (event) => {
 // Check if listener is already registered
 let messageListenerRegistered = false;
 
+// Root that selectors are matched in: the document, or the innermost shadow
+// root of the running command's shadowPath
+let queryRoot = document;
+
 // Listen for messages from background script
 if (!messageListenerRegistered) {
   chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
@@ -85,8 +89,8 @@ async function executeCommand(command) {
       throw new Error('Invalid command: missing action');
     }
     
-    // Ensure document is ready with timeout
-    if (document.readyState === 'loading') {
+    // Ensure document is ready with timeout; wait_for_ready_state does its own waiting
+    if (document.readyState === 'loading' && command.action !== 'wait_for_ready_state') {
       await Promise.race([
         new Promise(resolve => {
           document.addEventListener('DOMContentLoaded', resolve, { once: true });
@@ -96,6 +100,8 @@ async function executeCommand(command) {
         )
       ]);
     }
+
+    queryRoot = resolveShadowRoot(command.shadowPath);
     
     switch (command.action) {
       case 'click':
@@ -104,6 +110,39 @@ async function executeCommand(command) {
         return await executeInputCommand(command);
       case 'get_content':
         return await executeGetContentCommand(command);
+      case 'scroll':
+        return await executeScrollCommand(command);
+      case 'wait_for_element':
+        return await executeWaitForElementCommand(command);
+      case 'wait_for_ready_state':
+        return await executeWaitForReadyStateCommand(command);
+      case 'extract':
+        return await executeExtractCommand(command);
+      case 'check_element':
+        return executeCheckElementCommand(command);
+      case 'assert':
+        return executeAssertCommand(command);
+      case 'select_option':
+        return executeSelectOptionCommand(command);
+      case 'click_text':
+        return executeClickTextCommand(command);
+      case 'press_key':
+        return executePressKeyCommand(command);
+      case 'key':
+        return executeKeyCommand(command);
+      case 'hover':
+        return await executeHoverCommand(command);
+      case 'drag':
+        return await executeDragCommand(command);
+      case 'copy_to_clipboard':
+        return await executeCopyCommand(command);
+      case 'paste_from_clipboard':
+        return await executePasteCommand(command);
+      case 'upload_file':
+        return executeUploadCommand(command);
+      case 'check':
+      case 'uncheck':
+        return executeToggleCommand(command, command.action === 'check');
       default:
         throw new Error(`Unknown command action: ${command.action}`);
     }
@@ -112,12 +151,92 @@ async function executeCommand(command) {
     console.error('Command details:', command);
     // Re-throw with more context
     throw new Error(`Command execution failed: ${error.message}`);
+  } finally {
+    queryRoot = document;
+  }
+}
+
+// Walk shadowPath, selectors for nested shadow hosts outermost first, down
+// to the innermost open shadow root
+function resolveShadowRoot(shadowPath) {
+  let root = document;
+  for (const hostSelector of shadowPath || []) {
+    const host = root.querySelector(hostSelector);
+    if (!host) {
+      throw new Error(`Shadow host not found: ${hostSelector}`);
+    }
+    if (!host.shadowRoot) {
+      throw new Error(`Element has no open shadow root: ${hostSelector}`);
+    }
+    root = host.shadowRoot;
   }
+  return root;
+}
+
+// Serialize the page with open shadow roots as declarative
+// <template shadowrootmode> elements, so the backend can see inside them
+function serializeDocument() {
+  const root = document.documentElement;
+  if (typeof root.getHTML !== 'function') {
+    return root.outerHTML;
+  }
+  const attributes = Array.from(root.attributes)
+    .map(attr => ` ${attr.name}="${attr.value.replace(/&/g, '&amp;').replace(/"/g, '&quot;')}"`)
+    .join('');
+  return `<html${attributes}>${root.getHTML({ serializableShadowRoots: true, shadowRoots: openShadowRoots() })}</html>`;
+}
+
+function openShadowRoots() {
+  const roots = [];
+  const visit = (root) => {
+    for (const el of root.querySelectorAll('*')) {
+      if (el.shadowRoot) {
+        roots.push(el.shadowRoot);
+        visit(el.shadowRoot);
+      }
+    }
+  };
+  visit(document);
+  return roots;
 }
 
 async function executeClickCommand(command) {
-  if (!command.selector) {
-    throw new Error('Click command requires selector');
+  if (!command.selector && !command.text) {
+    throw new Error('Click command requires selector or text');
+  }
+
+  // Click by visible label when the backend supplied one
+  if (command.text) {
+    const element = findElementByText(command.selector, command.text, command.selectorType);
+    if (!element) {
+      throw new Error(`No clickable element labelled "${command.text}"`);
+    }
+    await waitForElementReady(element);
+    element.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    await sleep(500);
+    element.click();
+    return {
+      details: `Clicked element labelled "${command.text}"`,
+      elementText: element.textContent?.trim().substring(0, 50) || element.value || '',
+      elementTag: element.tagName.toLowerCase()
+    };
+  }
+
+  // XPath selectors skip the CSS-specific fallbacks below
+  if (command.selectorType === 'xpath') {
+    const element = findElement(command.selector, 'xpath');
+    if (!element) {
+      throw new Error(`Element not found: ${command.selector}`);
+    }
+    await waitForElementReady(element);
+    element.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    await sleep(500);
+    element.click();
+    return {
+      details: `Clicked element: ${command.selector}`,
+      elementText: element.textContent?.trim().substring(0, 50) || element.value || '',
+      elementTag: element.tagName.toLowerCase()
+    };
   }
 
   // Special handling for search button selectors - try multiple strategies
@@ -175,6 +294,284 @@ async function executeClickCommand(command) {
   };
 }
 
+const KEY_CODES = {
+  Enter: { code: 'Enter', keyCode: 13 },
+  Tab: { code: 'Tab', keyCode: 9 },
+  Escape: { code: 'Escape', keyCode: 27 },
+  ' ': { code: 'Space', keyCode: 32 },
+  Backspace: { code: 'Backspace', keyCode: 8 },
+  Delete: { code: 'Delete', keyCode: 46 },
+  Home: { code: 'Home', keyCode: 36 },
+  End: { code: 'End', keyCode: 35 },
+  PageUp: { code: 'PageUp', keyCode: 33 },
+  PageDown: { code: 'PageDown', keyCode: 34 },
+  ArrowUp: { code: 'ArrowUp', keyCode: 38 },
+  ArrowDown: { code: 'ArrowDown', keyCode: 40 },
+  ArrowLeft: { code: 'ArrowLeft', keyCode: 37 },
+  ArrowRight: { code: 'ArrowRight', keyCode: 39 }
+};
+
+// Send command.text as a key press to the element matching command.selector,
+// or to the focused element. Synthetic key events have no default action, so
+// Enter submits the surrounding form and Tab moves focus by hand.
+async function executePressKeyCommand(command) {
+  const key = command.text || 'Enter';
+  const codes = KEY_CODES[key] || { code: key, keyCode: 0 };
+
+  let target = document.activeElement && document.activeElement !== document.body ? document.activeElement : document.body;
+  if (command.selector) {
+    const element = findElement(command.selector, command.selectorType);
+    if (!element) {
+      throw new Error(`Element not found: ${command.selector}`);
+    }
+    element.focus();
+    target = element;
+  }
+
+  const init = { key, code: codes.code, keyCode: codes.keyCode, which: codes.keyCode, bubbles: true, cancelable: true };
+  const proceed = target.dispatchEvent(new KeyboardEvent('keydown', init));
+  if (key === 'Enter') {
+    target.dispatchEvent(new KeyboardEvent('keypress', init));
+  }
+  target.dispatchEvent(new KeyboardEvent('keyup', init));
+
+  if (proceed && key === 'Enter' && target.form && target.tagName !== 'TEXTAREA') {
+    target.form.requestSubmit ? target.form.requestSubmit() : target.form.submit();
+  } else if (proceed && key === 'Tab') {
+    const focusable = Array.from(document.querySelectorAll('a[href], button, input, select, textarea, [tabindex]:not([tabindex="-1"])'))
+      .filter(el => !el.disabled && isElementInteractable(el));
+    const next = focusable[focusable.indexOf(target) + 1];
+    if (next) next.focus();
+  }
+
+  return {
+    details: `Pressed ${key === ' ' ? 'Space' : key}${command.selector ? ` in ${command.selector}` : ''}`,
+    elementTag: target.tagName.toLowerCase()
+  };
+}
+
+const MODIFIER_FLAGS = { Control: 'ctrlKey', Shift: 'shiftKey', Alt: 'altKey', Meta: 'metaKey' };
+
+// Send command.keys, a shortcut like "Control+Shift+P" or a single key like
+// "c", to the element matching command.selector or to the focused element.
+// A key without modifiers goes through executePressKeyCommand so Enter and
+// Tab keep their default actions.
+async function executeKeyCommand(command) {
+  const parts = (command.keys || '').split('+');
+  const key = parts.pop();
+  if (!key) {
+    throw new Error('Key command requires keys');
+  }
+  if (parts.length === 0) {
+    return executePressKeyCommand({ ...command, text: key });
+  }
+
+  let target = document.activeElement && document.activeElement !== document.body ? document.activeElement : document.body;
+  if (command.selector) {
+    const element = findElement(command.selector, command.selectorType);
+    if (!element) {
+      throw new Error(`Element not found: ${command.selector}`);
+    }
+    element.focus();
+    target = element;
+  }
+
+  const codes = KEY_CODES[key] || (key.length === 1
+    ? { code: /[a-z]/i.test(key) ? `Key${key.toUpperCase()}` : /\d/.test(key) ? `Digit${key}` : key, keyCode: key.toUpperCase().charCodeAt(0) }
+    : { code: key, keyCode: 0 });
+  const init = { key, code: codes.code, keyCode: codes.keyCode, which: codes.keyCode, bubbles: true, cancelable: true };
+  for (const modifier of parts) {
+    if (!MODIFIER_FLAGS[modifier]) {
+      throw new Error(`Unknown modifier: ${modifier}`);
+    }
+    init[MODIFIER_FLAGS[modifier]] = true;
+  }
+
+  // Modifiers go down first and come up last, as on a real keyboard
+  for (const modifier of parts) {
+    target.dispatchEvent(new KeyboardEvent('keydown', { ...init, key: modifier, code: `${modifier}Left`, keyCode: 0, which: 0 }));
+  }
+  target.dispatchEvent(new KeyboardEvent('keydown', init));
+  target.dispatchEvent(new KeyboardEvent('keyup', init));
+  for (const modifier of parts.reverse()) {
+    target.dispatchEvent(new KeyboardEvent('keyup', { ...init, key: modifier, code: `${modifier}Left`, keyCode: 0, which: 0 }));
+  }
+
+  return {
+    details: `Pressed ${command.keys}${command.selector ? ` in ${command.selector}` : ''}`,
+    elementTag: target.tagName.toLowerCase()
+  };
+}
+
+// Hover the element matching command.selector (narrowed by label when
+// command.text is set) so hover menus and tooltips open. Pointer and mouse
+// events both fire, as frameworks listen for either.
+async function executeHoverCommand(command) {
+  const element = command.text
+    ? findElementByText(command.selector, command.text, command.selectorType)
+    : findElement(command.selector, command.selectorType);
+  if (!element) {
+    throw new Error(`Hover target not found: ${command.text || command.selector}`);
+  }
+
+  element.scrollIntoView({ behavior: 'smooth', block: 'center' });
+  await sleep(300);
+
+  const rect = element.getBoundingClientRect();
+  const init = { bubbles: true, cancelable: true, view: window, clientX: rect.left + rect.width / 2, clientY: rect.top + rect.height / 2 };
+  element.dispatchEvent(new PointerEvent('pointerover', init));
+  element.dispatchEvent(new PointerEvent('pointerenter', { ...init, bubbles: false }));
+  element.dispatchEvent(new MouseEvent('mouseover', init));
+  element.dispatchEvent(new MouseEvent('mouseenter', { ...init, bubbles: false }));
+  element.dispatchEvent(new MouseEvent('mousemove', init));
+
+  // Give the menu time to render before the next step looks for it
+  await sleep(300);
+
+  return {
+    details: `Hovered ${command.text ? `"${command.text}"` : command.selector}`,
+    elementTag: element.tagName.toLowerCase()
+  };
+}
+
+// Drag the element matching command.sourceSelector onto the one matching
+// command.targetSelector. HTML5 drag-and-drop listens for drag events sharing
+// one DataTransfer; libraries built on mouse events get those too.
+async function executeDragCommand(command) {
+  if (!command.sourceSelector || !command.targetSelector) {
+    throw new Error('Drag command requires sourceSelector and targetSelector');
+  }
+  const source = document.querySelector(command.sourceSelector);
+  if (!source) {
+    throw new Error(`Drag source not found: ${command.sourceSelector}`);
+  }
+  const target = document.querySelector(command.targetSelector);
+  if (!target) {
+    throw new Error(`Drop target not found: ${command.targetSelector}`);
+  }
+
+  source.scrollIntoView({ behavior: 'smooth', block: 'center' });
+  await sleep(300);
+
+  const center = (el) => {
+    const rect = el.getBoundingClientRect();
+    return { clientX: rect.left + rect.width / 2, clientY: rect.top + rect.height / 2 };
+  };
+  const dataTransfer = new DataTransfer();
+  dataTransfer.setData('text/plain', source.id || source.innerText?.trim().substring(0, 100) || '');
+  const drag = (el, type) => el.dispatchEvent(new DragEvent(type, { bubbles: true, cancelable: true, dataTransfer, ...center(el) }));
+  const mouse = (el, type) => el.dispatchEvent(new MouseEvent(type, { bubbles: true, cancelable: true, view: window, ...center(el) }));
+
+  mouse(source, 'mousedown');
+  drag(source, 'dragstart');
+  drag(target, 'dragenter');
+  drag(target, 'dragover');
+  mouse(target, 'mousemove');
+  await sleep(100);
+  drag(target, 'drop');
+  drag(source, 'dragend');
+  mouse(target, 'mouseup');
+
+  return {
+    details: `Dragged ${command.sourceSelector} to ${command.targetSelector}`,
+    elementTag: source.tagName.toLowerCase()
+  };
+}
+
+// Click the visible element whose innerText or aria-label contains
+// command.matchText. Exact matches beat partial ones, clickable elements beat
+// plain ones, and ties go to the first element in document order.
+async function executeClickTextCommand(command) {
+  const wanted = (command.matchText || '').trim().toLowerCase();
+  if (!wanted) {
+    throw new Error('click_text command requires matchText');
+  }
+
+  const labelsOf = (el) => [el.innerText, el.getAttribute('aria-label')]
+    .filter(Boolean)
+    .map(label => label.trim().toLowerCase());
+  // Only the innermost element containing the text counts, so <body> never
+  // wins a partial match over the button inside it
+  const isInnermost = (el) => !Array.from(el.children).some(child => (child.innerText || '').toLowerCase().includes(wanted));
+
+  const search = (candidates) => {
+    let partial = null;
+    for (const el of candidates) {
+      if (!isElementInteractable(el)) continue;
+      const labels = labelsOf(el);
+      if (labels.includes(wanted)) return el;
+      if (!partial && labels.some(label => label.includes(wanted)) && isInnermost(el)) partial = el;
+    }
+    return partial;
+  };
+
+  const element = search(document.querySelectorAll(CLICKABLE_SELECTOR)) || search(document.body.querySelectorAll('*'));
+  if (!element) {
+    throw new Error(`No visible element contains "${command.matchText}"`);
+  }
+
+  await waitForElementReady(element);
+  element.scrollIntoView({ behavior: 'smooth', block: 'center' });
+  await sleep(500);
+  element.click();
+  return {
+    details: `Clicked element containing "${command.matchText}"`,
+    elementText: element.innerText?.trim().substring(0, 50) || '',
+    elementTag: element.tagName.toLowerCase()
+  };
+}
+
+const CLICKABLE_SELECTOR = 'a, button, [role="button"], [role="link"], [role="tab"], [role="menuitem"], input[type="submit"], input[type="button"], summary, label';
+
+// Find the visible element labelled text among those matching selector,
+// falling back to any clickable element. Exact label matches win over
+// partial ones; ties go to the first element in document order.
+function findElementByText(selector, text, selectorType) {
+  const wanted = text.trim().toLowerCase();
+  const labelOf = (el) => (el.innerText || el.value || el.getAttribute('aria-label') || el.title || '').trim().toLowerCase();
+
+  const search = (sel, type) => {
+    let candidates;
+    try {
+      candidates = querySelectorAllByType(sel, type);
+    } catch (error) {
+      console.error('Invalid selector:', sel, error);
+      return null;
+    }
+
+    let partial = null;
+    for (const el of candidates) {
+      if (!isElementInteractable(el)) continue;
+      const label = labelOf(el);
+      if (label === wanted) return el;
+      if (!partial && label.includes(wanted)) partial = el;
+    }
+    return partial;
+  };
+
+  if (selectorType === 'xpath' && selector) {
+    return search(selector, 'xpath') || search(CLICKABLE_SELECTOR);
+  }
+  const primary = selector && selector !== '*' ? selector : CLICKABLE_SELECTOR;
+  return search(primary) || (primary !== CLICKABLE_SELECTOR ? search(CLICKABLE_SELECTOR) : null);
+}
+
+// Match selector as CSS, or as an XPath expression when selectorType is
+// "xpath"; throws on an invalid selector either way
+function querySelectorAllByType(selector, selectorType) {
+  if (selectorType !== 'xpath') {
+    return Array.from(queryRoot.querySelectorAll(selector));
+  }
+
+  const snapshot = document.evaluate(selector, document, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+  const elements = [];
+  for (let i = 0; i < snapshot.snapshotLength; i++) {
+    const node = snapshot.snapshotItem(i);
+    if (node.nodeType === Node.ELEMENT_NODE) elements.push(node);
+  }
+  return elements;
+}
+
 // Find search button with multiple fallback strategies
 function findSearchButton(selector) {
   // Try comma-separated selectors
@@ -252,7 +649,7 @@ async function executeInputCommand(command) {
     });
   });
 
-  const element = findElement(command.selector);
+  const element = findElement(command.selector, command.selectorType);
   if (!element) {
     throw new Error(`Input element not found with selector: ${command.selector}. Found ${allInputs.length} total input elements.`);
   }
@@ -291,6 +688,291 @@ async function executeInputCommand(command) {
   };
 }
 
+// check_element never fails: details is "true" or "false" and the backend
+// decides whether to run the conditional step that follows
+function executeCheckElementCommand(command) {
+  if (!command.selector) {
+    throw new Error('Check element command requires selector');
+  }
+
+  const count = querySelectorAllByType(command.selector, command.selectorType).length;
+  return { details: String(count > 0), elementsFound: count };
+}
+
+// Compare a property of the element with the expected value; throwing makes
+// the backend retry, which covers pages that haven't re-rendered yet
+function executeAssertCommand(command) {
+  if (!command.assertSelector) {
+    throw new Error('Assert command requires assertSelector');
+  }
+
+  const element = document.querySelector(command.assertSelector);
+  if (!element) {
+    throw new Error(`Assertion failed: no element matches ${command.assertSelector}`);
+  }
+
+  const property = command.assertProperty || 'innerText';
+  let actual;
+  if (property in element) {
+    actual = element[property];
+  } else {
+    actual = element.getAttribute(property);
+  }
+  actual = actual == null ? '' : String(actual).trim();
+
+  const expected = (command.assertExpected ?? '').trim();
+  if (actual !== expected) {
+    throw new Error(`Assertion failed: ${command.assertSelector} ${property} is "${actual.substring(0, 100)}", expected "${expected}"`);
+  }
+
+  return { details: `${command.assertSelector} ${property} is "${expected}"` };
+}
+
+// Choose the option whose value or visible label matches command.text, then
+// fire the events a user's choice would so frameworks pick up the change
+function executeSelectOptionCommand(command) {
+  const element = command.selector
+    ? querySelectorAllByType(command.selector, command.selectorType)[0]
+    : document.querySelector('select');
+  if (!element || element.tagName.toLowerCase() !== 'select') {
+    throw new Error(`No select element matches ${command.selector || 'select'}`);
+  }
+
+  const wanted = (command.text || '').trim();
+  const options = Array.from(element.options);
+  const normalize = text => text.replace(/\s+/g, ' ').trim().toLowerCase();
+  const option = options.find(o => o.value === wanted) ||
+    options.find(o => normalize(o.label || o.text) === normalize(wanted)) ||
+    options.find(o => normalize(o.label || o.text).includes(normalize(wanted)));
+  if (!option) {
+    throw new Error(`No option "${wanted}" in ${command.selector || 'select'}`);
+  }
+
+  element.value = option.value;
+  option.selected = true;
+  element.dispatchEvent(new Event('input', { bubbles: true }));
+  element.dispatchEvent(new Event('change', { bubbles: true }));
+
+  return { details: `Selected "${(option.label || option.text).trim()}" (value "${option.value}")` };
+}
+
+// Tick or clear a checkbox (or pick a radio button) matching command.selector,
+// narrowed to the one whose label contains command.text when given. Clicking
+// rather than setting .checked keeps framework state in sync.
+function executeToggleCommand(command, checked) {
+  const candidates = querySelectorAllByType(command.selector || 'input[type="checkbox"], input[type="radio"]', command.selectorType);
+  const wanted = (command.text || '').trim().toLowerCase();
+  const labelOf = (el) => [
+    ...Array.from(el.labels || []).map(label => label.innerText),
+    el.getAttribute('aria-label'),
+    el.closest('label')?.innerText,
+    el.name,
+    el.id,
+    el.value
+  ].filter(Boolean).join(' ').toLowerCase();
+
+  const element = wanted ? candidates.find(el => labelOf(el).includes(wanted)) : candidates[0];
+  if (!element) {
+    throw new Error(`No checkbox or radio button${wanted ? ` labelled "${command.text}"` : ''} matches ${command.selector}`);
+  }
+
+  const isChecked = () => element.checked ?? element.getAttribute('aria-checked') === 'true';
+  if (!checked && element.type === 'radio') {
+    throw new Error('Radio buttons cannot be unchecked; check another option instead');
+  }
+  if (isChecked() !== checked) {
+    element.click();
+  }
+  if (isChecked() !== checked && 'checked' in element) {
+    element.checked = checked;
+    element.dispatchEvent(new Event('change', { bubbles: true }));
+  }
+
+  return { details: `${checked ? 'Checked' : 'Unchecked'} ${labelOf(element).substring(0, 50) || command.selector}` };
+}
+
+// Copy the text of the element matching command.selector. The async Clipboard
+// API needs the page focused, so fall back to execCommand, which the
+// clipboardWrite permission allows from a content script.
+async function executeCopyCommand(command) {
+  const element = findElement(command.selector, command.selectorType);
+  if (!element) {
+    throw new Error(`Copy target not found: ${command.selector}`);
+  }
+  const text = ('value' in element && typeof element.value === 'string' ? element.value : element.innerText).trim();
+
+  try {
+    await navigator.clipboard.writeText(text);
+  } catch (error) {
+    const scratch = document.createElement('textarea');
+    scratch.value = text;
+    scratch.style.position = 'fixed';
+    scratch.style.opacity = '0';
+    document.body.appendChild(scratch);
+    scratch.select();
+    const copied = document.execCommand('copy');
+    scratch.remove();
+    if (!copied) {
+      throw new Error(`Clipboard write failed: ${error.message}`);
+    }
+  }
+
+  return { details: text };
+}
+
+// Paste the clipboard into the field matching command.selector, firing the
+// same events as typing into it
+async function executePasteCommand(command) {
+  let text;
+  try {
+    text = await navigator.clipboard.readText();
+  } catch (error) {
+    const scratch = document.createElement('textarea');
+    scratch.style.position = 'fixed';
+    scratch.style.opacity = '0';
+    document.body.appendChild(scratch);
+    scratch.focus();
+    const pasted = document.execCommand('paste');
+    text = scratch.value;
+    scratch.remove();
+    if (!pasted) {
+      throw new Error(`Clipboard read failed: ${error.message}`);
+    }
+  }
+  if (!text) {
+    throw new Error('Clipboard is empty');
+  }
+
+  await executeInputCommand({ ...command, action: 'input', text });
+  return { details: `Pasted ${text.length} characters into ${command.selector}` };
+}
+
+// Attach the file the background script read from the user's downloads to
+// the <input type="file"> matching command.selector. Scripts can't open the
+// file picker, but a FileList built through DataTransfer can be assigned to
+// input.files directly.
+function executeUploadCommand(command) {
+  if (!command.fileData) {
+    throw new Error('Upload command requires file data');
+  }
+  const input = findElement(command.selector || "input[type='file']", command.selectorType);
+  if (!input) {
+    throw new Error(`File input not found: ${command.selector}`);
+  }
+  if (input.tagName !== 'INPUT' || input.type !== 'file') {
+    throw new Error(`Element is not a file input: ${command.selector}`);
+  }
+
+  const bytes = Uint8Array.from(atob(command.fileData), c => c.charCodeAt(0));
+  const file = new File([bytes], command.fileName, { type: command.mimeType || 'application/octet-stream' });
+  const transfer = new DataTransfer();
+  transfer.items.add(file);
+  input.files = transfer.files;
+
+  input.dispatchEvent(new Event('input', { bubbles: true }));
+  input.dispatchEvent(new Event('change', { bubbles: true }));
+  return { details: `Attached ${command.fileName} (${bytes.length} bytes)` };
+}
+
+async function executeExtractCommand(command) {
+  if (!command.extractSelector) {
+    throw new Error('Extract command requires extractSelector');
+  }
+
+  const element = document.querySelector(command.extractSelector);
+  if (!element) {
+    throw new Error(`Extract target not found: ${command.extractSelector}`);
+  }
+
+  // Form fields hold their text in value; everything else in innerText
+  const value = 'value' in element && typeof element.value === 'string'
+    ? element.value
+    : element.innerText;
+
+  return { details: (value || '').trim() };
+}
+
+async function executeScrollCommand(command) {
+  if (command.scrollSelector) {
+    const element = document.querySelector(command.scrollSelector);
+    if (!element) {
+      throw new Error(`Scroll target not found: ${command.scrollSelector}`);
+    }
+    element.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    await sleep(500);
+    return { details: `Scrolled to ${command.scrollSelector}` };
+  }
+
+  let left = command.scrollX || 0;
+  let top = command.scrollY || 0;
+  if (!left && !top) {
+    [left, top] = scrollOffsetsFromText(command.text);
+  }
+
+  // The browser clamps oversized offsets, so huge values mean "to the edge"
+  window.scrollBy({ left, top, behavior: 'smooth' });
+  await sleep(500);
+  return {
+    details: `Scrolled by (${left}, ${top})`,
+    scrollY: window.scrollY
+  };
+}
+
+function scrollOffsetsFromText(text) {
+  const step = Math.round(window.innerHeight * 0.8);
+  const edge = document.documentElement.scrollHeight;
+
+  switch ((text || 'down').trim().toLowerCase()) {
+    case 'down': return [0, step];
+    case 'up': return [0, -step];
+    case 'left': return [-step, 0];
+    case 'right': return [step, 0];
+    case 'bottom': return [0, edge];
+    case 'top': return [0, -edge];
+  }
+
+  const pixels = parseInt(text, 10);
+  if (Number.isNaN(pixels)) {
+    throw new Error(`Unknown scroll direction: ${text}`);
+  }
+  return [0, pixels];
+}
+
+async function executeWaitForElementCommand(command) {
+  if (!command.waitSelector) {
+    return await executeWaitForReadyStateCommand(command);
+  }
+
+  const timeout = command.waitTimeoutMs || 10000;
+  const startTime = Date.now();
+
+  while (Date.now() - startTime < timeout) {
+    const element = document.querySelector(command.waitSelector);
+    if (element) {
+      return { details: `Found ${command.waitSelector} after ${Date.now() - startTime}ms` };
+    }
+    await sleep(100);
+  }
+
+  throw new Error(`Timed out after ${timeout}ms waiting for ${command.waitSelector}`);
+}
+
+// Polls document.readyState every 100ms until it is "complete"
+async function executeWaitForReadyStateCommand(command) {
+  const timeout = command.waitTimeoutMs || 10000;
+  const startTime = Date.now();
+
+  while (Date.now() - startTime < timeout) {
+    if (document.readyState === 'complete') {
+      return { details: `Page loaded after ${Date.now() - startTime}ms` };
+    }
+    await sleep(100);
+  }
+
+  // A page that never reaches "complete" (long-polling, streaming) is usually still usable
+  return { details: `Page still ${document.readyState} after ${timeout}ms, continuing` };
+}
+
 async function executeGetContentCommand(command) {
   const content = getPageContent();
   
@@ -315,15 +997,22 @@ async function executeGetContentCommand(command) {
   };
 }
 
-function findElement(selector) {
+function findElement(selector, selectorType) {
   try {
+    // XPath: prefer the first interactable match, with no CSS fallbacks
+    if (selectorType === 'xpath') {
+      const matches = querySelectorAllByType(selector, 'xpath');
+      return matches.find(isElementInteractable) || matches[0] || null;
+    }
+
+
     // Handle comma-separated selectors (try each one individually)
     if (selector.includes(',')) {
       const selectors = selector.split(',').map(s => s.trim());
       console.log('Trying multiple selectors:', selectors);
       
       for (const sel of selectors) {
-        const element = document.querySelector(sel);
+        const element = queryRoot.querySelector(sel);
         if (element && isElementInteractable(element)) {
           console.log(`Found element with selector: ${sel}`);
           return element;
@@ -332,7 +1021,7 @@ function findElement(selector) {
     }
     
     // First try exact selector
-    let element = document.querySelector(selector);
+    let element = queryRoot.querySelector(selector);
     if (element && isElementInteractable(element)) {
       return element;
     }
@@ -472,6 +1161,7 @@ function getPageContent() {
     return {
       title: document.title || '',
       url: window.location.href || '',
+      html: serializeDocument(),
       text: pageText,
       readyState: document.readyState || 'unknown',
       interactiveElements: interactiveElements,
@@ -242,6 +242,36 @@ chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
             }, 1000);
             break;
             
+        case 'TASK_PROGRESS':
+            updateStatus('Planning...');
+            break;
+            
+        case 'LLM_THINKING':
+            updateStatus(`Thinking... (${message.payload.chars} chars)`);
+            break;
+            
+        case 'COMMAND_RETRY':
+            console.warn('Retrying command:', message.payload);
+            updateStatus(`Retrying ${message.payload.action} (${message.payload.attempt}/${message.payload.maxRetries})...`);
+            break;
+            
+        case 'CAPTCHA_DETECTED':
+            console.warn('CAPTCHA detected:', message.payload);
+            updateStatus(`CAPTCHA detected (${message.payload.provider}). Solve it in the page to continue`);
+            break;
+            
+        case 'BATCH_COMPLETE':
+            console.log('Batch complete:', message.payload);
+            updateStatus(`Batch done: ${message.payload.completed} completed, ${message.payload.failed} failed, ${message.payload.skipped} skipped`);
+            setExecutionState(false);
+            break;
+            
+        case 'EXECUTION_CANCELLED':
+            console.log('Execution cancelled:', message.payload);
+            updateStatus('Cancelled');
+            setExecutionState(false);
+            break;
+            
         case 'CONTENT_ANALYSIS':
             break;
             